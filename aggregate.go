@@ -0,0 +1,39 @@
+// Copyright (c) 2015-2021, NVIDIA CORPORATION.
+// SPDX-License-Identifier: Apache-2.0
+
+package sortedmap
+
+// Subtree aggregates
+//
+// An Aggregator summarizes Key:Value pairs as a monoid: Lift() maps a single pair to an
+// Aggregate, Combine() merges the Aggregates of two adjacent runs of pairs (the first
+// preceding the second), and Identity() is the Aggregate of an empty run. Combine() must be
+// associative and Identity() must be its identity element, but Combine() need not be
+// commutative (e.g. "first Key" is a valid Aggregate). Examples include the sum of Value
+// sizes, the maximum of a Value's timestamp, or a count of Values matching some predicate.
+//
+// When supplied with an Aggregator, an LLRBTree maintains the Aggregate of each subtree in
+// its nodes (through rotations just as len is) and a BPlusTree maintains the Aggregate of
+// each node (recording it alongside Items in each child reference it posts). Aggregate()
+// and AggregateByIndex() then compute the Aggregate of any range of pairs while visiting
+// only O(log n) nodes (each B+Tree node visited contributes up to maxKeysPerNode Combines).
+// Absent an Aggregator, both return an error.
+//
+// An Aggregator's methods must not fail for any Key:Value pair stored in the tree.
+
+// Aggregate is the summary of a run of Key:Value pairs computed by an Aggregator
+type Aggregate interface{}
+
+// Aggregator specifies the monoid used to summarize runs of Key:Value pairs
+type Aggregator interface {
+	Identity() (aggregate Aggregate)
+	Lift(key Key, value Value) (aggregate Aggregate)
+	Combine(aggregate1 Aggregate, aggregate2 Aggregate) (combinedAggregate Aggregate)
+}
+
+// AggregatedSortedMap is implemented by each SortedMap able to be supplied an Aggregator
+type AggregatedSortedMap interface {
+	SortedMap
+	Aggregate(loKey Key, hiKey Key) (aggregate Aggregate, err error)            // Returns the Aggregate of key:value pairs where loKey <= key < hiKey
+	AggregateByIndex(loIndex int, hiIndex int) (aggregate Aggregate, err error) // Returns the Aggregate of key:value pairs at loIndex <= index < hiIndex
+}
@@ -0,0 +1,304 @@
+// Copyright (c) 2015-2021, NVIDIA CORPORATION.
+// SPDX-License-Identifier: Apache-2.0
+
+package sortedmap
+
+import (
+	"errors"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+// aggregateTestSumStruct sums int Values
+type aggregateTestSumStruct struct{}
+
+// aggregateTestLastStruct returns the last int Key (or -1 if there are none)
+//
+// Unlike aggregateTestSumStruct, its Combine() is not commutative.
+type aggregateTestLastStruct struct{}
+
+func (aggregator *aggregateTestSumStruct) Identity() (aggregate Aggregate) {
+	return 0
+}
+
+func (aggregator *aggregateTestSumStruct) Lift(key Key, value Value) (aggregate Aggregate) {
+	return value.(int)
+}
+
+func (aggregator *aggregateTestSumStruct) Combine(aggregate1 Aggregate, aggregate2 Aggregate) (combinedAggregate Aggregate) {
+	return aggregate1.(int) + aggregate2.(int)
+}
+
+func (aggregator *aggregateTestLastStruct) Identity() (aggregate Aggregate) {
+	return -1
+}
+
+func (aggregator *aggregateTestLastStruct) Lift(key Key, value Value) (aggregate Aggregate) {
+	return key.(int)
+}
+
+func (aggregator *aggregateTestLastStruct) Combine(aggregate1 Aggregate, aggregate2 Aggregate) (combinedAggregate Aggregate) {
+	if -1 == aggregate2.(int) {
+		return aggregate1
+	}
+	return aggregate2
+}
+
+// aggregateTestMutate applies random Puts, Deletes, & Patches to both tree & model
+func aggregateTestMutate(t *testing.T, tree SortedMap, model map[int]int, operations int) {
+	for operation := 0; operation < operations; operation++ {
+		key := rand.Intn(1000)
+		value := rand.Intn(100)
+
+		switch rand.Intn(4) {
+		case 0, 1:
+			ok, err := tree.Put(key, value)
+			if nil != err {
+				t.Fatalf("tree.Put(%v,) failed: %v", key, err)
+			}
+			_, present := model[key]
+			if ok == present {
+				t.Fatalf("tree.Put(%v,) returned ok == %v", key, ok)
+			}
+			if ok {
+				model[key] = value
+			}
+		case 2:
+			ok, err := tree.DeleteByKey(key)
+			if nil != err {
+				t.Fatalf("tree.DeleteByKey(%v) failed: %v", key, err)
+			}
+			if ok {
+				delete(model, key)
+			}
+		case 3:
+			ok, err := tree.PatchByKey(key, value)
+			if nil != err {
+				t.Fatalf("tree.PatchByKey(%v,) failed: %v", key, err)
+			}
+			if ok {
+				model[key] = value
+			}
+		}
+	}
+}
+
+// aggregateTestVerify compares Aggregate() & AggregateByIndex() against model for random ranges
+func aggregateTestVerify(t *testing.T, tree AggregatedSortedMap, model map[int]int, aggregator Aggregator) {
+	keys := make([]int, 0, len(model))
+	for key := range model {
+		keys = append(keys, key)
+	}
+	sort.Ints(keys)
+
+	expectedAggregate := func(loIndex int, hiIndex int) (aggregate Aggregate) {
+		aggregate = aggregator.Identity()
+		for index := loIndex; index < hiIndex; index++ {
+			if (0 <= index) && (index < len(keys)) {
+				aggregate = aggregator.Combine(aggregate, aggregator.Lift(keys[index], model[keys[index]]))
+			}
+		}
+		return
+	}
+
+	aggregate, err := tree.AggregateByIndex(0, len(keys))
+	if nil != err {
+		t.Fatalf("tree.AggregateByIndex() failed: %v", err)
+	}
+	if expectedAggregate(0, len(keys)) != aggregate {
+		t.Fatalf("tree.AggregateByIndex(0, %v) returned %v but should have returned %v", len(keys), aggregate, expectedAggregate(0, len(keys)))
+	}
+
+	for query := 0; query < 100; query++ {
+		loIndex := rand.Intn(len(keys)+10) - 5
+		hiIndex := loIndex + rand.Intn(len(keys)/2+2) - 1
+
+		aggregate, err = tree.AggregateByIndex(loIndex, hiIndex)
+		if nil != err {
+			t.Fatalf("tree.AggregateByIndex(%v, %v) failed: %v", loIndex, hiIndex, err)
+		}
+		if expectedAggregate(loIndex, hiIndex) != aggregate {
+			t.Fatalf("tree.AggregateByIndex(%v, %v) returned %v but should have returned %v", loIndex, hiIndex, aggregate, expectedAggregate(loIndex, hiIndex))
+		}
+
+		loKey := rand.Intn(1100) - 50
+		hiKey := loKey + rand.Intn(500)
+
+		aggregate, err = tree.Aggregate(loKey, hiKey)
+		if nil != err {
+			t.Fatalf("tree.Aggregate(%v, %v) failed: %v", loKey, hiKey, err)
+		}
+		expected := expectedAggregate(sort.SearchInts(keys, loKey), sort.SearchInts(keys, hiKey))
+		if expected != aggregate {
+			t.Fatalf("tree.Aggregate(%v, %v) returned %v but should have returned %v", loKey, hiKey, aggregate, expected)
+		}
+	}
+}
+
+func TestLLRBTreeAggregate(t *testing.T) {
+	_, err := NewLLRBTree(CompareInt, CodecInt).Aggregate(0, 1)
+	if nil == err {
+		t.Fatalf("Aggregate() without an Aggregator should have failed")
+	}
+
+	rand.Seed(1)
+
+	for _, aggregator := range []Aggregator{&aggregateTestSumStruct{}, &aggregateTestLastStruct{}} {
+		tree := NewLLRBTreeWithAggregator(CompareInt, CodecInt, aggregator)
+		model := make(map[int]int)
+
+		for round := 0; round < 4; round++ {
+			aggregateTestMutate(t, tree, model, 500)
+			aggregateTestVerify(t, tree, model, aggregator)
+		}
+
+		for index := 0; index < 50; index++ {
+			_, err = tree.PatchByIndex(index, index)
+			if nil != err {
+				t.Fatalf("tree.PatchByIndex(%v,) failed: %v", index, err)
+			}
+			key, _, _, _ := tree.GetByIndex(index)
+			model[key.(int)] = index
+		}
+
+		aggregateTestVerify(t, tree, model, aggregator)
+
+		err = tree.Validate()
+		if nil != err {
+			t.Fatalf("tree.Validate() failed: %v", err)
+		}
+	}
+}
+
+func TestBPlusTreeAggregate(t *testing.T) {
+	nodeStore := &callbacksTestNodeStoreStruct{lastObjectNumberGenerated: 0, objectMap: make(map[uint64][]byte)}
+	callbacks := Callbacks(CodecInt, CodecInt, nodeStore)
+
+	_, err := NewBPlusTree(4, CompareInt, callbacks, nil).Aggregate(0, 1)
+	if nil == err {
+		t.Fatalf("Aggregate() without an Aggregator should have failed")
+	}
+
+	_, err = OldBPlusTreeWithOptions(0, 0, 0, CompareInt, callbacks, nil, &BPlusTreeOptions{Aggregator: &aggregateTestSumStruct{}})
+	if nil == err {
+		t.Fatalf("OldBPlusTreeWithOptions() with Aggregator but no AggregateCodec should have failed")
+	}
+
+	rand.Seed(2)
+
+	for _, options := range []*BPlusTreeOptions{
+		{Aggregator: &aggregateTestSumStruct{}, AggregateCodec: CodecInt},
+		{Aggregator: &aggregateTestLastStruct{}, AggregateCodec: CodecInt, VarintEncoding: true},
+		{Aggregator: &aggregateTestSumStruct{}, AggregateCodec: CodecInt, OrderedKeyEncoding: OrderedInt, MaxNodeBytes: 64},
+	} {
+		tree, err := NewBPlusTreeWithOptions(4, CompareInt, callbacks, nil, options)
+		if nil != err {
+			t.Fatalf("NewBPlusTreeWithOptions() failed: %v", err)
+		}
+		model := make(map[int]int)
+
+		aggregateTestMutate(t, tree, model, 500)
+		aggregateTestVerify(t, tree, model, options.Aggregator)
+
+		_, _, _, err = tree.Flush(true)
+		if nil != err {
+			t.Fatalf("tree.Flush(true) failed: %v", err)
+		}
+
+		aggregateTestVerify(t, tree, model, options.Aggregator)
+		aggregateTestMutate(t, tree, model, 500)
+		aggregateTestVerify(t, tree, model, options.Aggregator)
+
+		rootObjectNumber, rootObjectOffset, rootObjectLength, err := tree.Flush(false)
+		if nil != err {
+			t.Fatalf("tree.Flush(false) failed: %v", err)
+		}
+
+		bPlusTreeCache := NewBPlusTreeCache(1000, 2000)
+
+		tree, err = OldBPlusTreeWithOptions(rootObjectNumber, rootObjectOffset, rootObjectLength, CompareInt, callbacks, bPlusTreeCache, options)
+		if nil != err {
+			t.Fatalf("OldBPlusTreeWithOptions() failed: %v", err)
+		}
+
+		// The Aggregate of every item is computable from the child references in the root node
+
+		cacheMisses := bPlusTreeCache.Stats().CacheMisses
+
+		_, err = tree.AggregateByIndex(0, len(model))
+		if nil != err {
+			t.Fatalf("tree.AggregateByIndex() failed: %v", err)
+		}
+		if cacheMisses != bPlusTreeCache.Stats().CacheMisses {
+			t.Fatalf("tree.AggregateByIndex() of every item should not have loaded any nodes")
+		}
+
+		aggregateTestVerify(t, tree, model, options.Aggregator)
+		aggregateTestMutate(t, tree, model, 500)
+		aggregateTestVerify(t, tree, model, options.Aggregator)
+
+		err = tree.Validate()
+		if nil != err {
+			t.Fatalf("tree.Validate() failed: %v", err)
+		}
+	}
+}
+
+func TestBPlusTreeAggregateOptionMismatch(t *testing.T) {
+	nodeStore := &callbacksTestNodeStoreStruct{lastObjectNumberGenerated: 0, objectMap: make(map[uint64][]byte)}
+	callbacks := Callbacks(CodecInt, CodecInt, nodeStore)
+	aggregatedOptions := &BPlusTreeOptions{Aggregator: &aggregateTestSumStruct{}, AggregateCodec: CodecInt}
+
+	for _, postOptions := range []*BPlusTreeOptions{nil, aggregatedOptions} {
+		tree, err := NewBPlusTreeWithOptions(4, CompareInt, callbacks, nil, postOptions)
+		if nil != err {
+			t.Fatalf("NewBPlusTreeWithOptions() failed: %v", err)
+		}
+
+		for i := 0; i < 100; i++ {
+			_, err := tree.Put(i, i)
+			if nil != err {
+				t.Fatalf("tree.Put() failed: %v", err)
+			}
+		}
+
+		rootObjectNumber, rootObjectOffset, rootObjectLength, err := tree.Flush(false)
+		if nil != err {
+			t.Fatalf("tree.Flush(false) failed: %v", err)
+		}
+
+		for _, loadOptions := range []*BPlusTreeOptions{nil, aggregatedOptions} {
+			_, err = OldBPlusTreeWithOptions(rootObjectNumber, rootObjectOffset, rootObjectLength, CompareInt, callbacks, nil, loadOptions)
+			if (nil == postOptions) == (nil == loadOptions) {
+				if nil != err {
+					t.Fatalf("OldBPlusTreeWithOptions() with matching Aggregator failed: %v", err)
+				}
+			} else if !errors.Is(err, ErrOptionMismatch) {
+				t.Fatalf("OldBPlusTreeWithOptions() with mismatched Aggregator should have failed with ErrOptionMismatch (got %v)", err)
+			}
+		}
+	}
+}
+
+func TestBPlusTreeMultimapAggregate(t *testing.T) {
+	nodeStore := &callbacksTestNodeStoreStruct{lastObjectNumberGenerated: 0, objectMap: make(map[uint64][]byte)}
+	options := &BPlusTreeOptions{Aggregator: &aggregateTestLastStruct{}, AggregateCodec: CodecInt}
+
+	multimap, err := NewBPlusTreeMultimap(4, CompareInt, Callbacks(CodecInt, CodecInt, nodeStore), nil, options)
+	if nil != err {
+		t.Fatalf("NewBPlusTreeMultimap() failed: %v", err)
+	}
+
+	multimapTestPopulate(t, multimap)
+
+	for _, aggregateTestCase := range [][3]int{{0, 40, 30}, {0, 30, 20}, {5, 20, 10}, {21, 30, -1}} {
+		aggregate, err := multimap.Aggregate(aggregateTestCase[0], aggregateTestCase[1])
+		if nil != err {
+			t.Fatalf("multimap.Aggregate(%v, %v) failed: %v", aggregateTestCase[0], aggregateTestCase[1], err)
+		}
+		if aggregateTestCase[2] != aggregate.(int) {
+			t.Fatalf("multimap.Aggregate(%v, %v) returned %v but should have returned %v", aggregateTestCase[0], aggregateTestCase[1], aggregate, aggregateTestCase[2])
+		}
+	}
+}
@@ -4,8 +4,13 @@
 package sortedmap
 
 import (
+	"context"
+	"crypto/cipher"
+	"encoding/binary"
 	"fmt"
+	"log/slog"
 	"runtime"
+	"runtime/trace"
 	"sync"
 
 	"github.com/NVIDIA/cstruct"
@@ -40,6 +45,8 @@ type btreeNodeCacheStruct struct {
 	drainerActive  bool //   if true, btreeNodeCacheDrainer() is already attempting to evict cleanLRU elements
 	cacheHits      uint64
 	cacheMisses    uint64
+	metrics        Metrics                       // receives MetricCache* updates (see metrics.go)
+	trees          map[*btreeTreeStruct]struct{} // btreeTreeStruct's with at least one node on cleanLRU or dirtyLRU
 }
 
 type btreeNodeStruct struct {
@@ -64,6 +71,14 @@ type btreeNodeStruct struct {
 	prefixSumParent     *btreeNodeStruct //                   nil if this is also the rootPrefixSumChild
 	prefixSumLeftChild  *btreeNodeStruct //                   nil if no left  child btreeNodeStruct
 	prefixSumRightChild *btreeNodeStruct //                   nil if no right child btreeNodeStruct
+	aggregate           Aggregate        //                   if aggregateValid == true, Aggregate of item's at all leaf btreeNodeStructs at or below this btreeNodeStruct
+	aggregateValid      bool             //                   if false, aggregate must be recomputed (see btree_aggregate.go)
+	weight              uint64           //                   if weightValid == true, total weight of item's at all leaf btreeNodeStructs at or below this btreeNodeStruct
+	weightValid         bool             //                   if false, weight (& prefixSumWeight of any children) must be recomputed (see btree_weight.go)
+	prefixSumWeight     uint64           //  if root == false, total weight of child btreeNodeStruct's in prefix sum binary tree "rooted" by this btreeNodeStruct
+	nodeBytes           uint64           //                   if nodeBytesValid == true, bound on the length of this btreeNodeStruct once posted
+	nodeBytesValid      bool             //                   if false, nodeBytes must be recomputed (see btree_nodebytes.go)
+	residentBytes       uint64           //                   length as loaded via GetNode() attributed to tree.cacheStats.residentBytes (protected by nodeCache.Mutex)
 }
 
 type onDiskUint64Struct struct {
@@ -104,11 +119,48 @@ type btreeTreeStruct struct {
 	root                      *btreeNodeStruct                        // should never be nil
 	staleOnDiskReferencesList map[staleOnDiskReferenceStruct]struct{} // previously posted node locations (staleOnDiskReferenceStruct) yet to be discarded
 	nodeCache                 *btreeNodeCacheStruct                   // likely shared with other btreeTreeStruct's
+	nodeChecksum              NodeChecksum                            // if non-nil, each posted node is followed by its checksum
+	onDiskNodeVersion         uint8                                   // version of the on-disk node format used by postNode()
+	upgradeOnDiskNodeVersion  bool                                    // if false, onDiskNodeVersion tracks that of the root node when loaded
+	nodeCodec                 NodeCodec                               // if non-nil, used to encode each posted node
+	postedNodes               uint64                                  // number of nodes posted since this btreeTreeStruct was instantiated
+	postedRawBytes            uint64                                  // sum of the packed onDiskNodeStruct sizes of those nodes
+	postedBytes               uint64                                  // sum of the sizes of those nodes as passed to PutNode()
+	nodeKeyring               NodeKeyring                             // if non-nil, used to encrypt each posted node
+	nodeLocator               BPlusTreeNodeLocationCallbacks          // non-nil if nodeKeyring is non-nil
+	nodeAEADs                 map[uint32]cipher.AEAD                  // key == keyID (one per keyID encountered; see fetchNodeAEAD())
+	requireEncryptedNodes     bool                                    // if true, loadNode() rejects unencrypted nodes
+	requireChecksummedNodes   bool                                    // if true, loadNode() rejects nodes lacking a checksum
+	legacyNodesChecksummed    bool                                    // if true, legacy nodes are followed by a checksum (otherwise they must not be)
+	keyPrefixCompression      bool                                    // if true, Keys are front-coded in posted (non-legacy) nodes
+	varintEncoding            bool                                    // if true, integers are varint-encoded in posted (non-legacy) nodes
+	onDiskByteOrder           binary.ByteOrder                        // either cstruct.LittleEndian or cstruct.BigEndian (tracks that of the root node when loaded)
+	minNodeBytes              uint64                                  // only applies to non-Root nodes if maxNodeBytes != 0
+	maxNodeBytes              uint64                                  // if non-zero, splits & merges are driven by node size (see btree_nodebytes.go)
+	valueLogThreshold         uint64                                  // if non-zero, larger packed Values are posted via valueLog (see btree_valuelog.go)
+	valueLog                  BPlusTreeValueLogCallbacks              // non-nil if valueLogThreshold is non-zero
+	staleValueLogReferences   map[valueLogReferenceStruct]struct{}    // previously logged Values yet to be discarded
+	postedValues              uint64                                  // number of Values posted via valueLog since this btreeTreeStruct was instantiated
+	postedValueBytes          uint64                                  // sum of the sizes of those Values
+	orderedKeyEncoding        OrderedKeyEncoding                      // if non-nil, Keys are held encoded & compared via CompareByteSlice (see btree_orderedkey.go)
+	aggregator                Aggregator                              // if non-nil, each node caches (& each child reference records) an Aggregate (see btree_aggregate.go)
+	aggregateCodec            ValueCodec                              // non-nil if aggregator is non-nil
+	weigher                   Weigher                                 // if non-nil, each node caches (& each child reference records) a total weight (see btree_weight.go)
+	itemWaiter                itemWaiterStruct                        // wakes Pop*Wait() callers upon Put() (see priority_queue.go)
+	metrics                   Metrics                                 // receives MetricNode*, MetricStaleReferences*, & Metric*NodeBytes updates (see metrics.go)
+	name                      string                                  // identifies this btreeTreeStruct in BPlusTreeCacheTreeStats & logged events
+	logger                    *slog.Logger                            // if non-nil, receives structural events (see btree_logging.go)
+	cacheStats                btreeNodeCacheTreeStatsStruct           // this btreeTreeStruct's share of nodeCache (protected by nodeCache.Mutex)
 }
 
 // API functions (see api.go)
 
 func (tree *btreeTreeStruct) BisectLeft(key Key) (index int, found bool, err error) {
+	key, err = tree.encodeKey(key)
+	if nil != err {
+		return
+	}
+
 	tree.Lock()
 	defer tree.Unlock()
 
@@ -225,9 +277,20 @@ func (tree *btreeTreeStruct) BisectLeft(key Key) (index int, found bool, err err
 }
 
 func (tree *btreeTreeStruct) BisectRight(key Key) (index int, found bool, err error) {
+	key, err = tree.encodeKey(key)
+	if nil != err {
+		return
+	}
+
 	tree.Lock()
 	defer tree.Unlock()
 
+	index, found, err = tree.bisectRightWhileLocked(key)
+
+	return
+}
+
+func (tree *btreeTreeStruct) bisectRightWhileLocked(key Key) (index int, found bool, err error) {
 	node := tree.root
 	indexDelta := uint64(0)
 
@@ -341,13 +404,19 @@ func (tree *btreeTreeStruct) BisectRight(key Key) (index int, found bool, err er
 }
 
 func (tree *btreeTreeStruct) DeleteByIndex(index int) (ok bool, err error) {
+	tree.Lock()
+	defer tree.Unlock()
+
+	ok, err = tree.deleteByIndexWhileLocked(index)
+
+	return
+}
+
+func (tree *btreeTreeStruct) deleteByIndexWhileLocked(index int) (ok bool, err error) {
 	var (
 		leftChildPrefixSumItems uint64
 	)
 
-	tree.Lock()
-	defer tree.Unlock()
-
 	node := tree.root
 
 	parentIndexStack := []int{} // when not at the root,
@@ -376,10 +445,16 @@ func (tree *btreeTreeStruct) DeleteByIndex(index int) (ok bool, err error) {
 		}
 
 		if node.leaf {
+			_, value, _, nonShadowingErr := node.kvLLRB.GetByIndex(int(netIndex))
+			if nil != nonShadowingErr {
+				err = nonShadowingErr
+				return
+			}
 			_, err = node.kvLLRB.DeleteByIndex(int(netIndex))
 			if nil != err {
 				return
 			}
+			tree.releaseValue(value)
 			tree.markNodeDirty(node)
 			tree.updatePrefixSumTreeLeafToRoot(node)
 			err = tree.rebalanceHere(node, parentIndexStack) // will also mark affected nodes dirty/used in LRU
@@ -415,6 +490,11 @@ func (tree *btreeTreeStruct) DeleteByIndex(index int) (ok bool, err error) {
 }
 
 func (tree *btreeTreeStruct) DeleteByKey(key Key) (ok bool, err error) {
+	key, err = tree.encodeKey(key)
+	if nil != err {
+		return
+	}
+
 	tree.Lock()
 	defer tree.Unlock()
 
@@ -438,11 +518,17 @@ func (tree *btreeTreeStruct) DeleteByKey(key Key) (ok bool, err error) {
 		}
 
 		if node.leaf {
+			value, _, nonShadowingErr := node.kvLLRB.GetByKey(key)
+			if nil != nonShadowingErr {
+				err = nonShadowingErr
+				return
+			}
 			ok, err = node.kvLLRB.DeleteByKey(key)
 			if nil != err {
 				return
 			}
 			if ok {
+				tree.releaseValue(value)
 				tree.markNodeDirty(node)
 				tree.updatePrefixSumTreeLeafToRoot(node)
 				err = tree.rebalanceHere(node, parentIndexStack) // will also mark affected nodes dirty/used in LRU
@@ -493,13 +579,19 @@ func (tree *btreeTreeStruct) DeleteByKey(key Key) (ok bool, err error) {
 }
 
 func (tree *btreeTreeStruct) GetByIndex(index int) (key Key, value Value, ok bool, err error) {
+	tree.Lock()
+	defer tree.Unlock()
+
+	key, value, ok, err = tree.getByIndexWhileLocked(index)
+
+	return
+}
+
+func (tree *btreeTreeStruct) getByIndexWhileLocked(index int) (key Key, value Value, ok bool, err error) {
 	var (
 		leftChildPrefixSumItems uint64
 	)
 
-	tree.Lock()
-	defer tree.Unlock()
-
 	node := tree.root
 
 	if (0 > index) || (uint64(index) >= node.items) {
@@ -527,6 +619,14 @@ func (tree *btreeTreeStruct) GetByIndex(index int) (key Key, value Value, ok boo
 			if nil != err {
 				return
 			}
+			key, err = tree.decodeKey(key)
+			if nil != err {
+				return
+			}
+			value, err = tree.resolveValue(value)
+			if nil != err {
+				return
+			}
 			ok = true
 			err = nil
 			return
@@ -555,6 +655,11 @@ func (tree *btreeTreeStruct) GetByIndex(index int) (key Key, value Value, ok boo
 }
 
 func (tree *btreeTreeStruct) GetByKey(key Key) (value Value, ok bool, err error) {
+	key, err = tree.encodeKey(key)
+	if nil != err {
+		return
+	}
+
 	tree.Lock()
 	defer tree.Unlock()
 
@@ -574,6 +679,9 @@ func (tree *btreeTreeStruct) GetByKey(key Key) (value Value, ok bool, err error)
 
 		if node.leaf {
 			value, ok, err = node.kvLLRB.GetByKey(key)
+			if (nil == err) && ok {
+				value, err = tree.resolveValue(value)
+			}
 			return
 		}
 
@@ -663,7 +771,23 @@ func (tree *btreeTreeStruct) PatchByIndex(index int, value Value) (ok bool, err
 
 		if node.leaf {
 			tree.touchLoadedNodeToRoot(node) // will also mark node dirty/used in LRU
+			_, oldValue, _, nonShadowingErr := node.kvLLRB.GetByIndex(int(netIndex))
+			if nil != nonShadowingErr {
+				err = nonShadowingErr
+				return
+			}
 			_, err = node.kvLLRB.PatchByIndex(int(netIndex), value)
+			if nil != err {
+				return
+			}
+			tree.invalidateNodeBytes(node)
+			tree.releaseValue(oldValue)
+			if tree.byteBounded() {
+				err = tree.splitHere(node) // value may have grown
+				if nil != err {
+					return
+				}
+			}
 			ok = true
 			return
 		}
@@ -691,6 +815,11 @@ func (tree *btreeTreeStruct) PatchByIndex(index int, value Value) (ok bool, err
 }
 
 func (tree *btreeTreeStruct) PatchByKey(key Key, value Value) (ok bool, err error) {
+	key, err = tree.encodeKey(key)
+	if nil != err {
+		return
+	}
+
 	tree.Lock()
 	defer tree.Unlock()
 
@@ -709,7 +838,19 @@ func (tree *btreeTreeStruct) PatchByKey(key Key, value Value) (ok bool, err erro
 
 		if node.leaf {
 			tree.touchLoadedNodeToRoot(node) // will also mark node dirty/used in LRU
+			oldValue, _, nonShadowingErr := node.kvLLRB.GetByKey(key)
+			if nil != nonShadowingErr {
+				err = nonShadowingErr
+				return
+			}
 			ok, err = node.kvLLRB.PatchByKey(key, value)
+			if (nil == err) && ok {
+				tree.invalidateNodeBytes(node)
+				tree.releaseValue(oldValue)
+				if tree.byteBounded() {
+					err = tree.splitHere(node) // value may have grown
+				}
+			}
 			return
 		}
 
@@ -748,6 +889,11 @@ func (tree *btreeTreeStruct) PatchByKey(key Key, value Value) (ok bool, err erro
 }
 
 func (tree *btreeTreeStruct) Put(key Key, value Value) (ok bool, err error) {
+	key, err = tree.encodeKey(key)
+	if nil != err {
+		return
+	}
+
 	tree.Lock()
 	defer tree.Unlock()
 
@@ -776,6 +922,9 @@ func (tree *btreeTreeStruct) Put(key Key, value Value) (ok bool, err error) {
 			} else {
 				err = tree.insertHere(node, key, value) // will also mark affected nodes dirty/used in LRU
 				ok = true
+				if nil == err {
+					tree.itemWaiter.signal()
+				}
 				return
 			}
 
@@ -860,10 +1009,17 @@ func (tree *btreeTreeStruct) FetchDimensionsReport() (dimensionsReport Dimension
 
 		if node.root {
 			dimensionsReport = DimensionsReport{
-				MinKeysPerNode: tree.minKeysPerNode,
-				MaxKeysPerNode: tree.maxKeysPerNode,
-				Items:          node.items,
-				Height:         1,
+				MinKeysPerNode:   tree.minKeysPerNode,
+				MaxKeysPerNode:   tree.maxKeysPerNode,
+				MinNodeBytes:     tree.minNodeBytes,
+				MaxNodeBytes:     tree.maxNodeBytes,
+				Items:            node.items,
+				Height:           1,
+				PostedNodes:      tree.postedNodes,
+				PostedRawBytes:   tree.postedRawBytes,
+				PostedBytes:      tree.postedBytes,
+				PostedValues:     tree.postedValues,
+				PostedValueBytes: tree.postedValueBytes,
 			}
 		}
 
@@ -879,6 +1035,8 @@ func (tree *btreeTreeStruct) FetchDimensionsReport() (dimensionsReport Dimension
 }
 
 func (tree *btreeTreeStruct) Flush(andPurge bool) (rootObjectNumber uint64, rootObjectOffset uint64, rootObjectLength uint64, err error) {
+	defer trace.StartRegion(context.Background(), TraceRegionFlush).End()
+
 	tree.Lock()
 	defer tree.Unlock()
 
@@ -1029,6 +1187,7 @@ func (tree *btreeTreeStruct) Discard() (err error) {
 	tree.BPlusTreeCallbacks = nil
 	tree.root = nil
 	tree.staleOnDiskReferencesList = nil
+	tree.staleValueLogReferences = nil
 	tree.nodeCache = nil
 
 	// All done
@@ -1064,11 +1223,138 @@ func (bPlusTreeCache *btreeNodeCacheStruct) UpdateLimits(evictLowLimit uint64, e
 
 // Helper functions
 
+func (tree *btreeTreeStruct) applyOptions(options *BPlusTreeOptions) (err error) {
+	var (
+		ok bool
+	)
+
+	tree.metrics = NoMetrics
+
+	if nil == options {
+		err = nil
+		return
+	}
+
+	if nil != options.Metrics {
+		tree.metrics = options.Metrics
+	}
+
+	tree.name = options.Name
+	tree.logger = tree.loggerFromOptions(options.Logger)
+
+	tree.nodeChecksum = options.NodeChecksum
+	tree.requireChecksummedNodes = (nil != options.NodeChecksum) && !options.AllowMissingChecksums
+	tree.legacyNodesChecksummed = tree.requireChecksummedNodes
+	tree.upgradeOnDiskNodeVersion = options.UpgradeOnDiskFormat || ((nil != options.NodeChecksum) && options.AllowMissingChecksums) // legacy on-disk format cannot express which nodes are checksummed
+	tree.nodeCodec = options.NodeCodec
+	tree.keyPrefixCompression = options.KeyPrefixCompression
+	tree.varintEncoding = options.VarintEncoding
+
+	if nil != options.OrderedKeyEncoding {
+		tree.orderedKeyEncoding = options.OrderedKeyEncoding
+		tree.Compare = CompareByteSlice
+	}
+
+	if nil != options.ByteOrder {
+		if (cstruct.LittleEndian != options.ByteOrder) && (cstruct.BigEndian != options.ByteOrder) {
+			err = fmt.Errorf("ByteOrder must be either cstruct.LittleEndian or cstruct.BigEndian")
+			return
+		}
+
+		tree.onDiskByteOrder = options.ByteOrder
+	}
+
+	if 0 != options.MaxNodeBytes {
+		tree.maxNodeBytes = options.MaxNodeBytes
+
+		if 0 == options.MinNodeBytes {
+			tree.minNodeBytes = options.MaxNodeBytes >> 2
+		} else {
+			tree.minNodeBytes = options.MinNodeBytes
+		}
+
+		if tree.minNodeBytes > (tree.maxNodeBytes >> 1) {
+			err = fmt.Errorf("MinNodeBytes (%v) invalid - must not exceed half of MaxNodeBytes (%v)", tree.minNodeBytes, tree.maxNodeBytes)
+			return
+		}
+	} else if 0 != options.MinNodeBytes {
+		err = fmt.Errorf("MinNodeBytes requires MaxNodeBytes")
+		return
+	}
+
+	if nil != options.NodeKeyring {
+		tree.nodeLocator, ok = tree.BPlusTreeCallbacks.(BPlusTreeNodeLocationCallbacks)
+		if !ok {
+			err = fmt.Errorf("NodeKeyring requires callbacks to implement BPlusTreeNodeLocationCallbacks")
+			return
+		}
+
+		tree.nodeKeyring = options.NodeKeyring
+		tree.requireEncryptedNodes = !options.AllowUnencryptedNodes
+		tree.upgradeOnDiskNodeVersion = true // legacy on-disk format cannot express encryption
+	}
+
+	if 0 != options.ValueLogThreshold {
+		if nil != options.NodeKeyring {
+			err = fmt.Errorf("ValueLogThreshold may not be combined with NodeKeyring as logged Values are not encrypted")
+			return
+		}
+		if (nil != options.Aggregator) || (nil != options.Weigher) {
+			err = fmt.Errorf("ValueLogThreshold may not be combined with Aggregator or Weigher as logged Values would need to be fetched to be lifted or weighed")
+			return
+		}
+
+		tree.valueLog, ok = tree.BPlusTreeCallbacks.(BPlusTreeValueLogCallbacks)
+		if !ok {
+			err = fmt.Errorf("ValueLogThreshold requires callbacks to implement BPlusTreeValueLogCallbacks")
+			return
+		}
+
+		tree.valueLogThreshold = options.ValueLogThreshold
+		tree.upgradeOnDiskNodeVersion = true // legacy on-disk format cannot express logged Values
+	}
+
+	if nil != options.Aggregator {
+		if nil == options.AggregateCodec {
+			err = fmt.Errorf("Aggregator requires AggregateCodec")
+			return
+		}
+
+		tree.aggregator = options.Aggregator
+		tree.aggregateCodec = options.AggregateCodec
+		tree.upgradeOnDiskNodeVersion = true // only onDiskNodeVersion2 (or later) can record onDiskNodeExtFlagAggregates
+	} else if nil != options.AggregateCodec {
+		err = fmt.Errorf("AggregateCodec requires Aggregator")
+		return
+	}
+
+	if nil != options.Weigher {
+		tree.weigher = options.Weigher
+		tree.upgradeOnDiskNodeVersion = true // only onDiskNodeVersion2 (or later) can record onDiskNodeExtFlagWeights
+	}
+
+	err = nil
+	return
+}
+
+func (node *btreeNodeStruct) corruptNodeError(format string, args ...interface{}) (err error) {
+	err = &CorruptNodeError{
+		ObjectNumber: node.objectNumber,
+		ObjectOffset: node.objectOffset,
+		ObjectLength: node.objectLength,
+		Reason:       fmt.Sprintf(format, args...),
+	}
+	return
+}
+
 func (tree *btreeTreeStruct) pruneWhileLocked() (err error) {
 	var (
-		staleOnDiskReference staleOnDiskReferenceStruct
+		staleOnDiskReference   staleOnDiskReferenceStruct
+		staleValueLogReference valueLogReferenceStruct
 	)
 
+	tree.logPrune()
+
 	// Discard all stale OnDisk node references
 
 	if nil != tree.staleOnDiskReferencesList {
@@ -1077,11 +1363,26 @@ func (tree *btreeTreeStruct) pruneWhileLocked() (err error) {
 			if nil != err {
 				return
 			}
+
+			tree.metrics.AddToCounter(MetricStaleReferencesPruned, 1)
 		}
 
 		tree.staleOnDiskReferencesList = nil
 	}
 
+	// Discard all stale logged Values
+
+	if nil != tree.staleValueLogReferences {
+		for staleValueLogReference = range tree.staleValueLogReferences {
+			err = tree.valueLog.DiscardValue(staleValueLogReference.objectNumber, staleValueLogReference.objectOffset, staleValueLogReference.objectLength)
+			if nil != err {
+				return
+			}
+		}
+
+		tree.staleValueLogReferences = nil
+	}
+
 	// All done
 
 	err = nil
@@ -1132,6 +1433,11 @@ func (tree *btreeTreeStruct) discardNode(node *btreeNodeStruct) (err error) {
 		}
 	}
 
+	err = tree.releaseNodeValues(node)
+	if nil != err {
+		return
+	}
+
 	tree.markNodeToBeDiscarded(node)
 
 	err = nil
@@ -1139,31 +1445,58 @@ func (tree *btreeTreeStruct) discardNode(node *btreeNodeStruct) (err error) {
 }
 
 func (tree *btreeTreeStruct) insertHere(insertNode *btreeNodeStruct, key Key, value Value) (err error) {
+	insertNode.kvLLRB.Put(key, value)
+
+	tree.invalidateNodeBytes(insertNode)
+
+	if insertNode.leaf {
+		tree.updatePrefixSumTreeLeafToRoot(insertNode) // will also mark affected nodes dirty/used in LRU
+	}
+
+	err = tree.splitHere(insertNode)
+
+	return
+}
+
+func (tree *btreeTreeStruct) splitHere(insertNode *btreeNodeStruct) (err error) {
 	var (
+		entryBytes          uint64
 		llrbLen             int
+		movedBytes          uint64
+		movedKeys           int
 		newRightSiblingNode *btreeNodeStruct
 		ok                  bool
+		overfull            bool
+		remainingBytes      uint64
 		splitKey            Key
 		splitValue          Value
 		splitValueAsNode    *btreeNodeStruct
 	)
 
-	insertNode.kvLLRB.Put(key, value)
-
-	if insertNode.leaf {
-		tree.updatePrefixSumTreeLeafToRoot(insertNode) // will also mark affected nodes dirty/used in LRU
+	llrbLen, err = insertNode.kvLLRB.Len()
+	if nil != err {
+		return
 	}
 
-	llrbLen, err = insertNode.kvLLRB.Len()
+	overfull, err = tree.nodeOverfull(insertNode, llrbLen)
 	if nil != err {
 		return
 	}
 
-	if tree.maxKeysPerNode >= uint64(llrbLen) {
+	if !overfull {
 		err = nil
 		return
 	}
 
+	if tree.byteBounded() {
+		remainingBytes, err = tree.nodeBytes(insertNode)
+		if nil != err {
+			return
+		}
+
+		tree.invalidateNodeBytes(insertNode)
+	}
+
 	newRightSiblingNode = &btreeNodeStruct{
 		objectNumber:        0, //                                               To be filled in once node is posted
 		objectOffset:        0, //                                               To be filled in once node is posted
@@ -1184,6 +1517,8 @@ func (tree *btreeTreeStruct) insertHere(insertNode *btreeNodeStruct, key Key, va
 		prefixSumRightChild: nil, //                                             Not applicable to root node
 	}
 
+	tree.metrics.AddToCounter(MetricNodeSplits, 1)
+
 	for {
 		splitKey, splitValue, ok, err = insertNode.kvLLRB.GetByIndex(llrbLen - 1)
 		if nil != err {
@@ -1204,6 +1539,17 @@ func (tree *btreeTreeStruct) insertHere(insertNode *btreeNodeStruct, key Key, va
 		}
 
 		llrbLen--
+		movedKeys++
+
+		if tree.byteBounded() {
+			entryBytes, err = tree.entryBytes(insertNode, splitKey, splitValue)
+			if nil != err {
+				return
+			}
+
+			movedBytes += entryBytes
+			remainingBytes -= entryBytes
+		}
 
 		if insertNode.leaf {
 			insertNode.items--
@@ -1218,7 +1564,7 @@ func (tree *btreeTreeStruct) insertHere(insertNode *btreeNodeStruct, key Key, va
 				return
 			}
 
-			if tree.minKeysPerNode == uint64(llrbLen) {
+			if tree.nodeSplitComplete(insertNode, llrbLen, movedKeys, movedBytes, remainingBytes) {
 				break
 			}
 		} else {
@@ -1229,7 +1575,7 @@ func (tree *btreeTreeStruct) insertHere(insertNode *btreeNodeStruct, key Key, va
 			insertNode.items -= splitValueAsNode.items
 			newRightSiblingNode.items += splitValueAsNode.items
 
-			if tree.minKeysPerNode == uint64(llrbLen) {
+			if tree.nodeSplitComplete(insertNode, llrbLen, movedKeys, movedBytes, remainingBytes) {
 				newRightSiblingNode.nonLeafLeftChild = splitValue.(*btreeNodeStruct)
 
 				break
@@ -1257,6 +1603,8 @@ func (tree *btreeTreeStruct) insertHere(insertNode *btreeNodeStruct, key Key, va
 		}
 	}
 
+	tree.logNodeEvent("split", insertNode, newRightSiblingNode, slog.Int("movedKeys", movedKeys))
+
 	if insertNode.root {
 		insertNode.root = false
 
@@ -1291,13 +1639,13 @@ func (tree *btreeTreeStruct) insertHere(insertNode *btreeNodeStruct, key Key, va
 		tree.initNodeAsEvicted(tree.root)
 		tree.markNodeDirty(tree.root)
 	} else {
-		err = tree.insertHere(insertNode.parentNode, splitKey, newRightSiblingNode)
+		tree.initNodeAsEvicted(newRightSiblingNode)
+		tree.markNodeDirty(newRightSiblingNode)
+
+		err = tree.insertHere(insertNode.parentNode, splitKey, newRightSiblingNode) // may size insertNode.parentNode (see nodeBytes())
 		if nil != err {
 			return
 		}
-
-		tree.initNodeAsEvicted(newRightSiblingNode)
-		tree.markNodeDirty(newRightSiblingNode)
 	}
 
 	err = tree.rearrangePrefixSumTreeToRoot(insertNode.parentNode)
@@ -1305,12 +1653,27 @@ func (tree *btreeTreeStruct) insertHere(insertNode *btreeNodeStruct, key Key, va
 		return
 	}
 
+	if tree.byteBounded() {
+		// Either half may still exceed MaxNodeBytes (e.g. following a merge)
+
+		err = tree.splitHere(insertNode)
+		if nil != err {
+			return
+		}
+
+		err = tree.splitHere(newRightSiblingNode)
+		if nil != err {
+			return
+		}
+	}
+
 	err = nil
 	return
 }
 
 func (tree *btreeTreeStruct) rebalanceHere(rebalanceNode *btreeNodeStruct, parentIndexStack []int) (err error) {
 	var (
+		canLend                                    bool
 		i                                          int
 		leftSiblingNode                            *btreeNodeStruct
 		leftSiblingNodeAsValue                     Value
@@ -1331,6 +1694,7 @@ func (tree *btreeTreeStruct) rebalanceHere(rebalanceNode *btreeNodeStruct, paren
 		parentNodeIndex                            int
 		rightSiblingNode                           *btreeNodeStruct
 		rightSiblingNodeAsValue                    Value
+		underfull                                  bool
 	)
 
 	if rebalanceNode.root {
@@ -1343,7 +1707,12 @@ func (tree *btreeTreeStruct) rebalanceHere(rebalanceNode *btreeNodeStruct, paren
 		return
 	}
 
-	if uint64(llrbLen) >= tree.minKeysPerNode {
+	underfull, err = tree.nodeUnderfull(rebalanceNode, llrbLen)
+	if nil != err {
+		return
+	}
+
+	if !underfull {
 		err = nil
 		return
 	}
@@ -1387,7 +1756,18 @@ func (tree *btreeTreeStruct) rebalanceHere(rebalanceNode *btreeNodeStruct, paren
 			return
 		}
 
-		if uint64(llrbLen) > tree.minKeysPerNode {
+		canLend, err = tree.nodeCanLend(leftSiblingNode, llrbLen, llrbLen-1, rebalanceNode)
+		if nil != err {
+			return
+		}
+
+		if canLend {
+			tree.metrics.AddToCounter(MetricNodeBorrows, 1)
+			tree.logNodeEvent("borrow", rebalanceNode, leftSiblingNode)
+
+			tree.invalidateNodeBytes(leftSiblingNode)
+			tree.invalidateNodeBytes(rebalanceNode)
+
 			// leftSiblingNode can give up a key
 
 			leftSiblingNode.items--
@@ -1501,6 +1881,11 @@ func (tree *btreeTreeStruct) rebalanceHere(rebalanceNode *btreeNodeStruct, paren
 
 			tree.markNodeDirty(leftSiblingNode)
 
+			if tree.byteBounded() {
+				err = tree.rebalanceBorrower(rebalanceNode, parentIndexStack)
+				return
+			}
+
 			err = nil
 			return
 		}
@@ -1540,7 +1925,18 @@ func (tree *btreeTreeStruct) rebalanceHere(rebalanceNode *btreeNodeStruct, paren
 			return
 		}
 
-		if uint64(llrbLen) > tree.minKeysPerNode {
+		canLend, err = tree.nodeCanLend(rightSiblingNode, llrbLen, 0, rebalanceNode)
+		if nil != err {
+			return
+		}
+
+		if canLend {
+			tree.metrics.AddToCounter(MetricNodeBorrows, 1)
+			tree.logNodeEvent("borrow", rebalanceNode, rightSiblingNode)
+
+			tree.invalidateNodeBytes(rightSiblingNode)
+			tree.invalidateNodeBytes(rebalanceNode)
+
 			// rightSiblingNode can give up a key
 
 			rebalanceNode.items++
@@ -1660,6 +2056,11 @@ func (tree *btreeTreeStruct) rebalanceHere(rebalanceNode *btreeNodeStruct, paren
 
 			tree.markNodeDirty(rightSiblingNode)
 
+			if tree.byteBounded() {
+				err = tree.rebalanceBorrower(rebalanceNode, parentIndexStack)
+				return
+			}
+
 			err = nil
 			return
 		}
@@ -1667,11 +2068,20 @@ func (tree *btreeTreeStruct) rebalanceHere(rebalanceNode *btreeNodeStruct, paren
 
 	// no simple move was possible, so we have to merge sibling nodes (always possible since we are not at the root)
 
+	tree.metrics.AddToCounter(MetricNodeMerges, 1)
+	if nil != leftSiblingNode {
+		tree.logNodeEvent("merge", rebalanceNode, leftSiblingNode)
+	} else {
+		tree.logNodeEvent("merge", rebalanceNode, rightSiblingNode)
+	}
+
 	if nil != leftSiblingNode {
 		// move keys from rebalanceNode to leftSiblingNode (along with former splitKey for non-leaf case)
 
 		leftSiblingNode.items += rebalanceNode.items
 
+		tree.invalidateNodeBytes(leftSiblingNode)
+
 		oldSplitKey, _, ok, err = parentNode.kvLLRB.GetByIndex(parentNodeIndex)
 		if nil != err {
 			return
@@ -1762,11 +2172,18 @@ func (tree *btreeTreeStruct) rebalanceHere(rebalanceNode *btreeNodeStruct, paren
 
 		tree.markNodeToBeDiscarded(rebalanceNode)
 		tree.markNodeDirty(leftSiblingNode)
+
+		err = tree.splitHere(leftSiblingNode) // only possibly needed if tree.byteBounded()
+		if nil != err {
+			return
+		}
 	} else if nil != rightSiblingNode {
 		// move keys from rightSiblingNode to rebalanceNode (along with former splitKey for non-leaf case)
 
 		rebalanceNode.items += rightSiblingNode.items
 
+		tree.invalidateNodeBytes(rebalanceNode)
+
 		oldSplitKey, _, ok, err = parentNode.kvLLRB.GetByIndex(parentNodeIndex + 1)
 		if nil != err {
 			return
@@ -1857,6 +2274,11 @@ func (tree *btreeTreeStruct) rebalanceHere(rebalanceNode *btreeNodeStruct, paren
 
 		tree.markNodeToBeDiscarded(rightSiblingNode)
 		tree.markNodeDirty(rebalanceNode)
+
+		err = tree.splitHere(rebalanceNode) // only possibly needed if tree.byteBounded()
+		if nil != err {
+			return
+		}
 	} else {
 		// since non-root minKeysPerNode >= 2, this node was required to have had a sibling,
 		// so if we reach here, we have a logic problem
@@ -1999,6 +2421,8 @@ func (tree *btreeTreeStruct) incCacheHits() {
 	if nil != tree.nodeCache {
 		tree.nodeCache.Lock()
 		tree.nodeCache.cacheHits++
+		tree.cacheStats.cacheHits++
+		tree.nodeCache.metrics.AddToCounter(MetricCacheHits, 1)
 		tree.nodeCache.Unlock()
 	}
 }
@@ -2007,6 +2431,8 @@ func (tree *btreeTreeStruct) incCacheMisses() {
 	if nil != tree.nodeCache {
 		tree.nodeCache.Lock()
 		tree.nodeCache.cacheMisses++
+		tree.cacheStats.cacheMisses++
+		tree.nodeCache.metrics.AddToCounter(MetricCacheMisses, 1)
 		tree.nodeCache.Unlock()
 	}
 }
@@ -2074,6 +2500,7 @@ func (tree *btreeTreeStruct) markNodeClean(node *btreeNodeStruct) {
 
 	if nil != tree.nodeCache {
 		tree.nodeCache.Lock()
+		oldBTreeNodeCacheTag := node.btreeNodeCacheTag
 		switch node.btreeNodeCacheTag {
 		case noLRU:
 			// Place node at the MRU end of tree.nodeCache's cleanLRU
@@ -2163,6 +2590,7 @@ func (tree *btreeTreeStruct) markNodeClean(node *btreeNodeStruct) {
 			tree.nodeCache.drainerActive = true
 			go tree.nodeCache.btreeNodeCacheDrainer()
 		}
+		tree.nodeCache.updateTreeStats(tree, oldBTreeNodeCacheTag, node.btreeNodeCacheTag)
 		tree.nodeCache.Unlock()
 	}
 }
@@ -2170,10 +2598,15 @@ func (tree *btreeTreeStruct) markNodeClean(node *btreeNodeStruct) {
 func (tree *btreeTreeStruct) markNodeDirty(node *btreeNodeStruct) {
 	node.dirty = true
 
+	tree.invalidateNodeAggregate(node)
+	tree.invalidateNodeWeight(node)
+	tree.invalidateNodeBytes(node)
+
 	tree.placeNodeOnStaleOnDiskReferenceList(node)
 
 	if nil != tree.nodeCache {
 		tree.nodeCache.Lock()
+		oldBTreeNodeCacheTag := node.btreeNodeCacheTag
 		switch node.btreeNodeCacheTag {
 		case noLRU:
 			// Place node at the MRU end of tree.nodeCache's dirtyLRU
@@ -2259,13 +2692,17 @@ func (tree *btreeTreeStruct) markNodeDirty(node *btreeNodeStruct) {
 				}
 			}
 		}
+		tree.nodeCache.updateTreeStats(tree, oldBTreeNodeCacheTag, node.btreeNodeCacheTag)
 		tree.nodeCache.Unlock()
 	}
 }
 
 func (tree *btreeTreeStruct) markNodeEvicted(node *btreeNodeStruct) {
+	tree.logNodeEvent("evict", node, nil)
+
 	if nil != tree.nodeCache {
 		tree.nodeCache.Lock()
+		oldBTreeNodeCacheTag := node.btreeNodeCacheTag
 		switch node.btreeNodeCacheTag {
 		case noLRU:
 			err := fmt.Errorf("Logic error in markNodeEvicted() with node.btreeNodeCacheTag == noLRU (%v)", noLRU)
@@ -2341,6 +2778,10 @@ func (tree *btreeTreeStruct) markNodeEvicted(node *btreeNodeStruct) {
 				}
 			}
 		}
+		tree.cacheStats.cacheEvictions++
+		tree.nodeCache.metrics.AddToCounter(MetricCacheEvictions, 1)
+		tree.subtractFromCacheResidentBytes(node)
+		tree.nodeCache.updateTreeStats(tree, oldBTreeNodeCacheTag, node.btreeNodeCacheTag)
 		tree.nodeCache.Unlock()
 	}
 }
@@ -2350,6 +2791,7 @@ func (tree *btreeTreeStruct) markNodeToBeDiscarded(node *btreeNodeStruct) {
 
 	if nil != tree.nodeCache {
 		tree.nodeCache.Lock()
+		oldBTreeNodeCacheTag := node.btreeNodeCacheTag
 		switch node.btreeNodeCacheTag {
 		case noLRU:
 			err := fmt.Errorf("Logic error in markNodeToBeDiscarded() with node.btreeNodeCacheTag == noLRU (%v)", noLRU)
@@ -2425,6 +2867,8 @@ func (tree *btreeTreeStruct) markNodeToBeDiscarded(node *btreeNodeStruct) {
 				}
 			}
 		}
+		tree.subtractFromCacheResidentBytes(node)
+		tree.nodeCache.updateTreeStats(tree, oldBTreeNodeCacheTag, node.btreeNodeCacheTag)
 		tree.nodeCache.Unlock()
 	}
 }
@@ -2448,6 +2892,8 @@ func (tree *btreeTreeStruct) placeNodeOnStaleOnDiskReferenceList(node *btreeNode
 
 		tree.staleOnDiskReferencesList[staleOnDiskReference] = struct{}{}
 
+		tree.metrics.AddToCounter(MetricStaleReferencesQueued, 1)
+
 		// Zero-out on-disk reference so that the above is only done once for this node
 
 		node.objectNumber = 0
@@ -2716,21 +3162,35 @@ func (tree *btreeTreeStruct) updatePrefixSumTreeLeafToRoot(leafNode *btreeNodeSt
 
 func (tree *btreeTreeStruct) loadNode(node *btreeNodeStruct) (err error) {
 	var (
-		maxKeysPerNodeStruct  onDiskUint64Struct
-		numChildrenStruct     onDiskUint64Struct
-		numKeysStruct         onDiskUint64Struct
-		onDiskNode            onDiskNodeStruct
-		onDiskReferenceToNode onDiskReferenceToNodeStruct
+		onDiskNode                onDiskNodeStruct
+		onDiskReferenceToNode     onDiskReferenceToNodeStruct
+		prevOnDiskReferenceToNode onDiskReferenceToNodeStruct
 	)
 
+	traceRegion := trace.StartRegion(context.Background(), TraceRegionGetNode)
 	nodeByteSlice, err := tree.BPlusTreeCallbacks.GetNode(node.objectNumber, node.objectOffset, node.objectLength)
+	traceRegion.End()
+	if nil != err {
+		return
+	}
+
+	if uint64(len(nodeByteSlice)) != node.objectLength {
+		err = node.corruptNodeError("GetNode() returned %v bytes", len(nodeByteSlice))
+		return
+	}
+
+	nodeByteSlice, onDiskNodeVersion, payloadFlags, err := tree.decodeNode(node, nodeByteSlice)
 	if nil != err {
 		return
 	}
 
+	node.nodeBytesValid = false
+
+	keyCoder := newNodeKeyCoder(payloadFlags)
+
 	node.kvLLRB = NewLLRBTree(node.tree.Compare, node.tree.BPlusTreeCallbacks)
 
-	_, err = cstruct.Unpack(nodeByteSlice, &onDiskNode, OnDiskByteOrder)
+	err = unpackNodeStruct(payloadFlags, node, nodeByteSlice, &onDiskNode)
 	if nil != err {
 		return
 	}
@@ -2739,10 +3199,19 @@ func (tree *btreeTreeStruct) loadNode(node *btreeNodeStruct) (err error) {
 	node.root = onDiskNode.Root
 	node.leaf = onDiskNode.Leaf
 
+	if node.root {
+		if !tree.upgradeOnDiskNodeVersion {
+			tree.onDiskNodeVersion = onDiskNodeVersion
+		}
+		if onDiskNodeVersionLegacy != onDiskNodeVersion {
+			tree.onDiskByteOrder = nodeByteOrder(payloadFlags)
+		}
+	}
+
 	payload := onDiskNode.Payload
 
 	if node.root {
-		bytesConsumed, unpackErr := cstruct.Unpack(payload, &maxKeysPerNodeStruct, OnDiskByteOrder)
+		maxKeysPerNode, bytesConsumed, unpackErr := unpackNodeUint64(payloadFlags, node, payload)
 		if nil != unpackErr {
 			err = unpackErr
 			return
@@ -2750,26 +3219,49 @@ func (tree *btreeTreeStruct) loadNode(node *btreeNodeStruct) (err error) {
 
 		payload = payload[bytesConsumed:]
 
-		tree.minKeysPerNode = maxKeysPerNodeStruct.U64 >> 1
-		tree.maxKeysPerNode = maxKeysPerNodeStruct.U64
+		tree.minKeysPerNode = maxKeysPerNode >> 1
+		tree.maxKeysPerNode = maxKeysPerNode
+
+		if 0 != (payloadFlags & onDiskNodeFlagNodeBytes) {
+			minNodeBytes, bytesConsumed, unpackErr := unpackNodeUint64(payloadFlags, node, payload)
+			if nil != unpackErr {
+				err = unpackErr
+				return
+			}
+
+			payload = payload[bytesConsumed:]
+
+			maxNodeBytes, bytesConsumed, unpackErr := unpackNodeUint64(payloadFlags, node, payload)
+			if nil != unpackErr {
+				err = unpackErr
+				return
+			}
+
+			payload = payload[bytesConsumed:]
+
+			if 0 == tree.maxNodeBytes { // i.e. unless overridden by BPlusTreeOptions
+				tree.minNodeBytes = minNodeBytes
+				tree.maxNodeBytes = maxNodeBytes
+			}
+		}
 	}
 
 	if node.leaf {
-		bytesConsumed, unpackErr := cstruct.Unpack(payload, &numKeysStruct, OnDiskByteOrder)
+		numKeys, bytesConsumed, unpackErr := unpackNodeUint64(payloadFlags, node, payload)
 		if nil != unpackErr {
 			err = unpackErr
 			return
 		}
 
 		payload = payload[bytesConsumed:]
-		for i := uint64(0); i < numKeysStruct.U64; i++ {
-			key, bytesConsumed, unpackKeyErr := tree.BPlusTreeCallbacks.UnpackKey(payload)
+		for i := uint64(0); i < numKeys; i++ {
+			key, bytesConsumed, unpackKeyErr := tree.unpackNodeKey(keyCoder, node, payload)
 			if nil != unpackKeyErr {
 				err = unpackKeyErr
 				return
 			}
 			payload = payload[bytesConsumed:]
-			value, bytesConsumed, unpackValueErr := tree.BPlusTreeCallbacks.UnpackValue(payload)
+			value, bytesConsumed, unpackValueErr := tree.unpackNodeValue(payloadFlags, node, payload)
 			if nil != unpackValueErr {
 				err = unpackValueErr
 				return
@@ -2782,14 +3274,14 @@ func (tree *btreeTreeStruct) loadNode(node *btreeNodeStruct) (err error) {
 				return
 			}
 			if !ok {
-				err = fmt.Errorf("Logic error: loadNode() call to Put() should have worked")
+				err = node.corruptNodeError("duplicate Key found in leaf node")
 				return
 			}
 		}
 
 		node.rootPrefixSumChild = nil
 	} else {
-		bytesConsumed, unpackErr := cstruct.Unpack(payload, &numChildrenStruct, OnDiskByteOrder)
+		numChildren, bytesConsumed, unpackErr := unpackNodeUint64(payloadFlags, node, payload)
 		if nil != unpackErr {
 			err = unpackErr
 			return
@@ -2797,10 +3289,10 @@ func (tree *btreeTreeStruct) loadNode(node *btreeNodeStruct) (err error) {
 
 		payload = payload[bytesConsumed:]
 
-		if 0 == numChildrenStruct.U64 {
+		if 0 == numChildren {
 			node.nonLeafLeftChild = nil
 		} else {
-			bytesConsumed, unpackErr := cstruct.Unpack(payload, &onDiskReferenceToNode, OnDiskByteOrder)
+			bytesConsumed, unpackErr := unpackNodeReference(payloadFlags, node, payload, &prevOnDiskReferenceToNode, &onDiskReferenceToNode)
 			if nil != unpackErr {
 				err = unpackErr
 				return
@@ -2819,12 +3311,32 @@ func (tree *btreeTreeStruct) loadNode(node *btreeNodeStruct) (err error) {
 				kvLLRB:       nil,
 			}
 
+			if tree.weighted() {
+				bytesConsumed, unpackErr = tree.unpackNodeWeight(payloadFlags, node, payload, childNode)
+				if nil != unpackErr {
+					err = unpackErr
+					return
+				}
+
+				payload = payload[bytesConsumed:]
+			}
+
+			if tree.aggregated() {
+				bytesConsumed, unpackErr = tree.unpackNodeAggregate(payloadFlags, node, payload, childNode)
+				if nil != unpackErr {
+					err = unpackErr
+					return
+				}
+
+				payload = payload[bytesConsumed:]
+			}
+
 			node.nonLeafLeftChild = childNode
 
 			tree.initNodeAsEvicted(childNode)
 
-			for i := uint64(1); i < numChildrenStruct.U64; i++ {
-				key, bytesConsumed, unpackKeyErr := node.tree.BPlusTreeCallbacks.UnpackKey(payload)
+			for i := uint64(1); i < numChildren; i++ {
+				key, bytesConsumed, unpackKeyErr := tree.unpackNodeKey(keyCoder, node, payload)
 				if nil != unpackKeyErr {
 					err = unpackKeyErr
 					return
@@ -2832,7 +3344,7 @@ func (tree *btreeTreeStruct) loadNode(node *btreeNodeStruct) (err error) {
 
 				payload = payload[bytesConsumed:]
 
-				bytesConsumed, unpackErr = cstruct.Unpack(payload, &onDiskReferenceToNode, OnDiskByteOrder)
+				bytesConsumed, unpackErr = unpackNodeReference(payloadFlags, node, payload, &prevOnDiskReferenceToNode, &onDiskReferenceToNode)
 				if nil != unpackErr {
 					err = unpackErr
 					return
@@ -2851,20 +3363,51 @@ func (tree *btreeTreeStruct) loadNode(node *btreeNodeStruct) (err error) {
 					kvLLRB:       nil,
 				}
 
+				if tree.weighted() {
+					bytesConsumed, unpackErr = tree.unpackNodeWeight(payloadFlags, node, payload, childNode)
+					if nil != unpackErr {
+						err = unpackErr
+						return
+					}
+
+					payload = payload[bytesConsumed:]
+				}
+
+				if tree.aggregated() {
+					bytesConsumed, unpackErr = tree.unpackNodeAggregate(payloadFlags, node, payload, childNode)
+					if nil != unpackErr {
+						err = unpackErr
+						return
+					}
+
+					payload = payload[bytesConsumed:]
+				}
+
 				node.kvLLRB.Put(key, childNode)
 
 				tree.initNodeAsEvicted(childNode)
 			}
 
 			tree.arrangePrefixSumTree(node)
+
+			if tree.weighted() {
+				tree.arrangePrefixSumWeight(node.rootPrefixSumChild)
+			}
 		}
 	}
 
 	if 0 != len(payload) {
-		err = fmt.Errorf("Logic error: load() should have exhausted payload")
+		err = node.corruptNodeError("%v bytes remain after payload unpacked", len(payload))
 		return
 	}
 
+	// Only account for nodes that were successfully decoded & unpacked
+
+	tree.metrics.AddToCounter(MetricNodeLoads, 1)
+	tree.metrics.ObserveHistogram(MetricGetNodeBytes, node.objectLength)
+	tree.addToCacheResidentBytes(node)
+	tree.logNodeEvent("load", node, nil)
+
 	node.loaded = true
 
 	tree.markNodeClean(node)
@@ -2874,16 +3417,89 @@ func (tree *btreeTreeStruct) loadNode(node *btreeNodeStruct) (err error) {
 }
 
 func (tree *btreeTreeStruct) postNode(node *btreeNodeStruct) (err error) {
-	var (
-		numChildren           int
-		onDiskReferenceToNode onDiskReferenceToNodeStruct
-	)
-
 	if !node.dirty {
 		err = nil
 		return
 	}
 
+	if tree.aggregated() {
+		_, err = tree.nodeAggregate(node) // computed before any Values are logged for node's parent to record
+		if nil != err {
+			return
+		}
+	}
+	if tree.weighted() {
+		_, err = tree.nodeWeight(node) // computed before any Values are logged for node's parent to record
+		if nil != err {
+			return
+		}
+	}
+
+	onDiskNodeBuf, err := tree.packNode(node, false)
+	if nil != err {
+		return
+	}
+
+	onDiskNodeRawLength := uint64(len(onDiskNodeBuf))
+
+	onDiskNodeBuf, boundLocation, err := tree.encodeNode(onDiskNodeBuf, tree.payloadFlags())
+	if nil != err {
+		return
+	}
+
+	traceRegion := trace.StartRegion(context.Background(), TraceRegionPutNode)
+	objectNumber, objectOffset, err := tree.BPlusTreeCallbacks.PutNode(onDiskNodeBuf)
+	traceRegion.End()
+	if nil != err {
+		return
+	}
+
+	tree.metrics.AddToCounter(MetricNodePosts, 1)
+	tree.metrics.ObserveHistogram(MetricPutNodeBytes, uint64(len(onDiskNodeBuf)))
+
+	if (nil != boundLocation) && ((boundLocation.ObjectNumber != objectNumber) || (boundLocation.ObjectOffset != objectOffset)) {
+		// The node just posted is bound to the wrong location (and hence unreadable)...
+		//   so discard it & leave node dirty such that a subsequent Flush() may retry
+
+		err = fmt.Errorf("PutNode() returned ObjectNumber 0x%016X ObjectOffset 0x%016X but NextNodeLocation() predicted ObjectNumber 0x%016X ObjectOffset 0x%016X", objectNumber, objectOffset, boundLocation.ObjectNumber, boundLocation.ObjectOffset)
+
+		discardErr := tree.BPlusTreeCallbacks.DiscardNode(objectNumber, objectOffset, uint64(len(onDiskNodeBuf)))
+		if nil != discardErr {
+			err = fmt.Errorf("%v (and DiscardNode() failed: %v)", err, discardErr)
+		}
+
+		return
+	}
+
+	node.objectNumber = objectNumber
+	node.objectOffset = objectOffset
+	node.objectLength = uint64(len(onDiskNodeBuf))
+
+	tree.postedNodes++
+	tree.postedRawBytes += onDiskNodeRawLength
+	tree.postedBytes += node.objectLength
+
+	tree.markNodeClean(node)
+
+	err = nil
+	return
+}
+
+// packNode packs node's onDiskNodeStruct as postNode() will post it
+//
+// Unless sizing, Values exceeding ValueLogThreshold are written to the value log (and replaced
+// in node's kvLLRB by their valueLogReferenceStruct) and every child node must have already
+// been posted. If sizing, nothing is written and the result is instead (at least) as long
+// as that produced once any such Values & child nodes have been posted.
+func (tree *btreeTreeStruct) packNode(node *btreeNodeStruct, sizing bool) (onDiskNodeBuf []byte, err error) {
+	var (
+		loggedValue               Value
+		numChildren               int
+		onDiskReferenceToNode     onDiskReferenceToNodeStruct
+		prevChildNodeDirty        bool
+		prevOnDiskReferenceToNode onDiskReferenceToNodeStruct
+	)
+
 	onDiskNode := onDiskNodeStruct{
 		Items:   node.items,
 		Root:    node.root,
@@ -2891,16 +3507,25 @@ func (tree *btreeTreeStruct) postNode(node *btreeNodeStruct) (err error) {
 		Payload: []byte{},
 	}
 
-	if node.root {
-		maxKeysPerNodeStruct := onDiskUint64Struct{U64: tree.maxKeysPerNode}
+	payloadFlags := tree.payloadFlags()
+	keyCoder := newNodeKeyCoder(payloadFlags)
 
-		maxKeysPerNodeBuf, packErr := cstruct.Pack(maxKeysPerNodeStruct, OnDiskByteOrder)
-		if nil != packErr {
-			err = packErr
+	if node.root {
+		onDiskNode.Payload, err = packNodeUint64(payloadFlags, onDiskNode.Payload, tree.maxKeysPerNode)
+		if nil != err {
 			return
 		}
 
-		onDiskNode.Payload = append(onDiskNode.Payload, maxKeysPerNodeBuf...)
+		if 0 != (payloadFlags & onDiskNodeFlagNodeBytes) {
+			onDiskNode.Payload, err = packNodeUint64(payloadFlags, onDiskNode.Payload, tree.minNodeBytes)
+			if nil != err {
+				return
+			}
+			onDiskNode.Payload, err = packNodeUint64(payloadFlags, onDiskNode.Payload, tree.maxNodeBytes)
+			if nil != err {
+				return
+			}
+		}
 	}
 
 	if node.leaf {
@@ -2910,16 +3535,11 @@ func (tree *btreeTreeStruct) postNode(node *btreeNodeStruct) (err error) {
 			return
 		}
 
-		kvLLRBLenStruct := onDiskUint64Struct{U64: uint64(kvLLRBLen)}
-
-		kvLLRBLenBuf, packErr := cstruct.Pack(kvLLRBLenStruct, OnDiskByteOrder)
-		if nil != packErr {
-			err = packErr
+		onDiskNode.Payload, err = packNodeUint64(payloadFlags, onDiskNode.Payload, uint64(kvLLRBLen))
+		if nil != err {
 			return
 		}
 
-		onDiskNode.Payload = append(onDiskNode.Payload, kvLLRBLenBuf...)
-
 		for i := 0; i < kvLLRBLen; i++ {
 			key, value, ok, nonShadowingErr := node.kvLLRB.GetByIndex(i)
 			if nil != nonShadowingErr {
@@ -2927,22 +3547,31 @@ func (tree *btreeTreeStruct) postNode(node *btreeNodeStruct) (err error) {
 				return
 			}
 			if !ok {
-				err = fmt.Errorf("Logic error: postNode() call to GetByIndex() should have worked")
+				err = fmt.Errorf("Logic error: packNode() call to GetByIndex() should have worked")
 				return
 			}
 
-			packedKey, packKeyErr := tree.BPlusTreeCallbacks.PackKey(key)
-			if nil != packKeyErr {
-				err = packKeyErr
+			onDiskNode.Payload, err = tree.packNodeKey(keyCoder, onDiskNode.Payload, key)
+			if nil != err {
 				return
 			}
-			onDiskNode.Payload = append(onDiskNode.Payload, packedKey...)
-			packedValue, packValueErr := tree.BPlusTreeCallbacks.PackValue(value)
-			if nil != packValueErr {
-				err = packValueErr
+			if sizing {
+				onDiskNode.Payload, err = tree.packNodeValueBound(payloadFlags, onDiskNode.Payload, value)
+				if nil != err {
+					return
+				}
+				continue
+			}
+			onDiskNode.Payload, loggedValue, err = tree.packNodeValue(payloadFlags, onDiskNode.Payload, value)
+			if nil != err {
 				return
 			}
-			onDiskNode.Payload = append(onDiskNode.Payload, packedValue...)
+			if nil != loggedValue {
+				_, err = node.kvLLRB.PatchByIndex(i, loggedValue)
+				if nil != err {
+					return
+				}
+			}
 		}
 	} else {
 		llrbLen, nonShadowingErr := node.kvLLRB.Len()
@@ -2955,27 +3584,22 @@ func (tree *btreeTreeStruct) postNode(node *btreeNodeStruct) (err error) {
 			numChildren = 0
 
 			if 0 != llrbLen {
-				err = fmt.Errorf("Logic error: postNode() found no nonLeafLeftChild but elements in kvLLRB")
+				err = fmt.Errorf("Logic error: packNode() found no nonLeafLeftChild but elements in kvLLRB")
 				return
 			}
 		} else {
 			numChildren = 1 + llrbLen
 		}
 
-		numChildrenStruct := onDiskUint64Struct{U64: uint64(numChildren)}
-
-		numChildrenBuf, packErr := cstruct.Pack(numChildrenStruct, OnDiskByteOrder)
-		if nil != packErr {
-			err = packErr
+		onDiskNode.Payload, err = packNodeUint64(payloadFlags, onDiskNode.Payload, uint64(numChildren))
+		if nil != err {
 			return
 		}
 
-		onDiskNode.Payload = append(onDiskNode.Payload, numChildrenBuf...)
-
 		for i := 0; i < numChildren; i++ {
 			if 0 == i {
-				if node.nonLeafLeftChild.dirty {
-					err = fmt.Errorf("Logic error: postNode() found nonLeafLeftChild dirty")
+				if node.nonLeafLeftChild.dirty && !sizing {
+					err = fmt.Errorf("Logic error: packNode() found nonLeafLeftChild dirty")
 					return
 				}
 
@@ -2984,13 +3608,34 @@ func (tree *btreeTreeStruct) postNode(node *btreeNodeStruct) (err error) {
 				onDiskReferenceToNode.ObjectLength = node.nonLeafLeftChild.objectLength
 				onDiskReferenceToNode.Items = node.nonLeafLeftChild.items
 
-				onDiskReferenceToNodeBuf, packErr := cstruct.Pack(onDiskReferenceToNode, OnDiskByteOrder)
-				if nil != packErr {
-					err = packErr
+				onDiskNode.Payload, err = packNodeReferenceBound(payloadFlags, onDiskNode.Payload, &prevOnDiskReferenceToNode, &onDiskReferenceToNode, node.nonLeafLeftChild.dirty)
+				if nil != err {
 					return
 				}
 
-				onDiskNode.Payload = append(onDiskNode.Payload, onDiskReferenceToNodeBuf...)
+				prevChildNodeDirty = node.nonLeafLeftChild.dirty
+
+				if tree.weighted() {
+					_, err = tree.nodeWeight(node.nonLeafLeftChild) // only needed if sizing (as postNode() computed it already)
+					if nil != err {
+						return
+					}
+					onDiskNode.Payload, err = tree.packNodeWeight(payloadFlags, onDiskNode.Payload, node.nonLeafLeftChild)
+					if nil != err {
+						return
+					}
+				}
+
+				if tree.aggregated() {
+					_, err = tree.nodeAggregate(node.nonLeafLeftChild) // only needed if sizing (as postNode() computed it already)
+					if nil != err {
+						return
+					}
+					onDiskNode.Payload, err = tree.packNodeAggregate(payloadFlags, onDiskNode.Payload, node.nonLeafLeftChild)
+					if nil != err {
+						return
+					}
+				}
 			} else {
 				key, value, ok, nonShadowingErr := node.kvLLRB.GetByIndex(i - 1)
 				if nil != nonShadowingErr {
@@ -2998,21 +3643,19 @@ func (tree *btreeTreeStruct) postNode(node *btreeNodeStruct) (err error) {
 					return
 				}
 				if !ok {
-					err = fmt.Errorf("Logic error: postNode() call to GetByIndex() should have worked")
+					err = fmt.Errorf("Logic error: packNode() call to GetByIndex() should have worked")
 					return
 				}
 
-				packedKey, packKeyErr := tree.BPlusTreeCallbacks.PackKey(key)
-				if nil != packKeyErr {
-					err = packKeyErr
+				onDiskNode.Payload, err = tree.packNodeKey(keyCoder, onDiskNode.Payload, key)
+				if nil != err {
 					return
 				}
-				onDiskNode.Payload = append(onDiskNode.Payload, packedKey...)
 
 				childNode := value.(*btreeNodeStruct)
 
-				if childNode.dirty {
-					err = fmt.Errorf("Logic error: postNode() found childNode dirty")
+				if childNode.dirty && !sizing {
+					err = fmt.Errorf("Logic error: packNode() found childNode dirty")
 					return
 				}
 
@@ -3021,34 +3664,40 @@ func (tree *btreeTreeStruct) postNode(node *btreeNodeStruct) (err error) {
 				onDiskReferenceToNode.ObjectLength = childNode.objectLength
 				onDiskReferenceToNode.Items = childNode.items
 
-				onDiskReferenceToNodeBuf, packErr := cstruct.Pack(onDiskReferenceToNode, OnDiskByteOrder)
-				if nil != packErr {
-					err = packErr
+				onDiskNode.Payload, err = packNodeReferenceBound(payloadFlags, onDiskNode.Payload, &prevOnDiskReferenceToNode, &onDiskReferenceToNode, childNode.dirty || prevChildNodeDirty)
+				if nil != err {
 					return
 				}
 
-				onDiskNode.Payload = append(onDiskNode.Payload, onDiskReferenceToNodeBuf...)
-			}
-		}
-	}
+				prevChildNodeDirty = childNode.dirty
 
-	onDiskNodeBuf, err := cstruct.Pack(onDiskNode, OnDiskByteOrder)
-	if nil != err {
-		return
-	}
+				if tree.weighted() {
+					_, err = tree.nodeWeight(childNode) // only needed if sizing (as postNode() computed it already)
+					if nil != err {
+						return
+					}
+					onDiskNode.Payload, err = tree.packNodeWeight(payloadFlags, onDiskNode.Payload, childNode)
+					if nil != err {
+						return
+					}
+				}
 
-	objectNumber, objectOffset, err := tree.BPlusTreeCallbacks.PutNode(onDiskNodeBuf)
-	if nil != err {
-		return
+				if tree.aggregated() {
+					_, err = tree.nodeAggregate(childNode) // only needed if sizing (as postNode() computed it already)
+					if nil != err {
+						return
+					}
+					onDiskNode.Payload, err = tree.packNodeAggregate(payloadFlags, onDiskNode.Payload, childNode)
+					if nil != err {
+						return
+					}
+				}
+			}
+		}
 	}
 
-	node.objectNumber = objectNumber
-	node.objectOffset = objectOffset
-	node.objectLength = uint64(len(onDiskNodeBuf))
+	onDiskNodeBuf, err = packNodeStruct(payloadFlags, &onDiskNode)
 
-	tree.markNodeClean(node)
-
-	err = nil
 	return
 }
 
@@ -0,0 +1,363 @@
+// Copyright (c) 2015-2021, NVIDIA CORPORATION.
+// SPDX-License-Identifier: Apache-2.0
+
+package sortedmap
+
+import (
+	"fmt"
+)
+
+// B+Tree subtree aggregates
+//
+// When BPlusTreeOptions.Aggregator is non-nil, each node caches the Aggregate of the items
+// at or below it. The cached Aggregate is invalidated whenever the node (or any node below
+// it) is marked dirty and is recomputed on demand from the node's children (or, for a leaf
+// node, its Key:Value pairs). A node's Aggregate is always computed as it is posted, so that
+// its parent may record it alongside Items in its reference to the node:
+//
+//	uint64   length of the packed Aggregate (see packNodeUint64())
+//	bytes    Aggregate as returned by AggregateCodec.PackValue()
+//
+// As such, an Aggregate() spanning many nodes need load only the (at most two per level)
+// nodes straddling either end of the range. Each posted node sets onDiskNodeExtFlagAggregates
+// (requiring onDiskNodeVersion2 or later), so OldBPlusTree() must be supplied an Aggregator
+// precisely when the B+Tree was posted with one (see checkPayloadExtFlags()). Note that the
+// Aggregator itself is not recorded, so the same Aggregator & AggregateCodec must be supplied.
+
+func (tree *btreeTreeStruct) aggregated() (aggregated bool) {
+	aggregated = (nil != tree.aggregator)
+	return
+}
+
+// invalidateNodeAggregate discards the cached Aggregate of node & its ancestors
+//
+// As a node's Aggregate is only ever computed once those of its children are, a node
+// with no cached Aggregate has no ancestor with one.
+func (tree *btreeTreeStruct) invalidateNodeAggregate(node *btreeNodeStruct) {
+	for (nil != node) && node.aggregateValid {
+		node.aggregate = nil
+		node.aggregateValid = false
+
+		node = node.parentNode
+	}
+}
+
+// nodeAggregate returns the Aggregate of the items at or below node (loading node if necessary)
+func (tree *btreeTreeStruct) nodeAggregate(node *btreeNodeStruct) (aggregate Aggregate, err error) {
+	if node.aggregateValid {
+		aggregate = node.aggregate
+		err = nil
+		return
+	}
+
+	if node.loaded {
+		tree.incCacheHits()
+		tree.markNodeUsed(node)
+	} else {
+		tree.incCacheMisses()
+		err = tree.loadNode(node) // will also mark node clean/used in LRU
+		if nil != err {
+			return
+		}
+	}
+
+	aggregate = tree.aggregator.Identity()
+
+	if node.leaf {
+		llrbLen, nonShadowingErr := node.kvLLRB.Len()
+		if nil != nonShadowingErr {
+			err = nonShadowingErr
+			return
+		}
+
+		for i := 0; i < llrbLen; i++ {
+			liftedAggregate, liftErr := tree.liftItem(node, i)
+			if nil != liftErr {
+				err = liftErr
+				return
+			}
+
+			aggregate = tree.aggregator.Combine(aggregate, liftedAggregate)
+		}
+	} else {
+		childNodes, childNodesErr := tree.childNodes(node)
+		if nil != childNodesErr {
+			err = childNodesErr
+			return
+		}
+
+		for _, childNode := range childNodes {
+			childAggregate, childAggregateErr := tree.nodeAggregate(childNode)
+			if nil != childAggregateErr {
+				err = childAggregateErr
+				return
+			}
+
+			aggregate = tree.aggregator.Combine(aggregate, childAggregate)
+		}
+	}
+
+	node.aggregate = aggregate
+	node.aggregateValid = true
+
+	err = nil
+	return
+}
+
+// liftItem returns the Aggregate of the i'th Key:Value pair in (loaded) leaf node
+func (tree *btreeTreeStruct) liftItem(node *btreeNodeStruct, i int) (aggregate Aggregate, err error) {
+	key, value, ok, err := node.kvLLRB.GetByIndex(i)
+	if nil != err {
+		return
+	}
+	if !ok {
+		err = fmt.Errorf("Logic error: liftItem() call to GetByIndex() should have worked")
+		return
+	}
+
+	key, err = tree.decodeKey(key)
+	if nil != err {
+		return
+	}
+
+	aggregate = tree.aggregator.Lift(key, value)
+
+	err = nil
+	return
+}
+
+// childNodes returns, in order, the children of (loaded) non-leaf node
+func (tree *btreeTreeStruct) childNodes(node *btreeNodeStruct) (childNodes []*btreeNodeStruct, err error) {
+	if nil == node.nonLeafLeftChild {
+		childNodes = make([]*btreeNodeStruct, 0)
+		err = nil
+		return
+	}
+
+	llrbLen, err := node.kvLLRB.Len()
+	if nil != err {
+		return
+	}
+
+	childNodes = make([]*btreeNodeStruct, 0, 1+llrbLen)
+	childNodes = append(childNodes, node.nonLeafLeftChild)
+
+	for i := 0; i < llrbLen; i++ {
+		_, childNodeAsValue, ok, nonShadowingErr := node.kvLLRB.GetByIndex(i)
+		if nil != nonShadowingErr {
+			err = nonShadowingErr
+			return
+		}
+		if !ok {
+			err = fmt.Errorf("Logic error: childNodes() had indexing problem in kvLLRB")
+			return
+		}
+
+		childNodes = append(childNodes, childNodeAsValue.(*btreeNodeStruct))
+	}
+
+	err = nil
+	return
+}
+
+// packNodeAggregate appends the (already computed) Aggregate of childNode to payload
+func (tree *btreeTreeStruct) packNodeAggregate(payloadFlags uint8, payload []byte, childNode *btreeNodeStruct) (updatedPayload []byte, err error) {
+	if !childNode.aggregateValid {
+		err = fmt.Errorf("Logic error: packNodeAggregate() found childNode Aggregate not computed")
+		return
+	}
+
+	packedAggregate, err := tree.aggregateCodec.PackValue(childNode.aggregate)
+	if nil != err {
+		return
+	}
+
+	updatedPayload, err = packNodeUint64(payloadFlags, payload, uint64(len(packedAggregate)))
+	if nil != err {
+		return
+	}
+
+	updatedPayload = append(updatedPayload, packedAggregate...)
+
+	err = nil
+	return
+}
+
+// unpackNodeAggregate reverses packNodeAggregate(), caching the Aggregate in childNode
+func (tree *btreeTreeStruct) unpackNodeAggregate(payloadFlags uint8, node *btreeNodeStruct, payload []byte, childNode *btreeNodeStruct) (bytesConsumed uint64, err error) {
+	packedAggregateLength, bytesConsumed, err := unpackNodeUint64(payloadFlags, node, payload)
+	if nil != err {
+		return
+	}
+
+	if packedAggregateLength > (uint64(len(payload)) - bytesConsumed) {
+		err = node.corruptNodeError("child Aggregate length %v exceeds remaining payload", packedAggregateLength)
+		return
+	}
+
+	aggregate, aggregateBytesConsumed, err := tree.aggregateCodec.UnpackValue(payload[bytesConsumed : bytesConsumed+packedAggregateLength])
+	if nil != err {
+		return
+	}
+
+	if aggregateBytesConsumed != packedAggregateLength {
+		err = node.corruptNodeError("UnpackValue() consumed %v of %v bytes of child Aggregate", aggregateBytesConsumed, packedAggregateLength)
+		return
+	}
+
+	childNode.aggregate = aggregate
+	childNode.aggregateValid = true
+
+	bytesConsumed += packedAggregateLength
+
+	err = nil
+	return
+}
+
+// API functions (see api.go)
+
+func (tree *btreeTreeStruct) Aggregate(loKey Key, hiKey Key) (aggregate Aggregate, err error) {
+	loKey, err = tree.encodeKey(loKey)
+	if nil != err {
+		return
+	}
+	hiKey, err = tree.encodeKey(hiKey)
+	if nil != err {
+		return
+	}
+
+	tree.Lock()
+	defer tree.Unlock()
+
+	if !tree.aggregated() {
+		err = fmt.Errorf("Aggregate() requires an Aggregator")
+		return
+	}
+
+	loIndex, _, err := tree.bisectRightWhileLocked(loKey)
+	if nil != err {
+		return
+	}
+	hiIndex, _, err := tree.bisectRightWhileLocked(hiKey)
+	if nil != err {
+		return
+	}
+
+	aggregate, err = tree.aggregateByIndexWhileLocked(loIndex, hiIndex)
+
+	return
+}
+
+func (tree *btreeTreeStruct) AggregateByIndex(loIndex int, hiIndex int) (aggregate Aggregate, err error) {
+	tree.Lock()
+	defer tree.Unlock()
+
+	if !tree.aggregated() {
+		err = fmt.Errorf("AggregateByIndex() requires an Aggregator")
+		return
+	}
+
+	aggregate, err = tree.aggregateByIndexWhileLocked(loIndex, hiIndex)
+
+	return
+}
+
+func (tree *btreeTreeStruct) aggregateByIndexWhileLocked(loIndex int, hiIndex int) (aggregate Aggregate, err error) {
+	if 0 > loIndex {
+		loIndex = 0
+	}
+	if (0 > hiIndex) || (loIndex >= hiIndex) {
+		aggregate = tree.aggregator.Identity()
+		err = nil
+		return
+	}
+
+	aggregate, err = tree.aggregateRange(tree.root, uint64(loIndex), uint64(hiIndex))
+
+	return
+}
+
+// aggregateRange returns the Aggregate of the items at [loIndex,hiIndex) below node
+//
+// Only those children straddling loIndex or hiIndex are descended (and hence loaded), as
+// the Aggregate of any child wholly within [loIndex,hiIndex) is cached in (or, if node was
+// posted, recorded in node's reference to) that child.
+func (tree *btreeTreeStruct) aggregateRange(node *btreeNodeStruct, loIndex uint64, hiIndex uint64) (aggregate Aggregate, err error) {
+	if (loIndex >= hiIndex) || (loIndex >= node.items) {
+		aggregate = tree.aggregator.Identity()
+		err = nil
+		return
+	}
+
+	if (0 == loIndex) && (hiIndex >= node.items) {
+		aggregate, err = tree.nodeAggregate(node)
+		return
+	}
+
+	if node.loaded {
+		tree.incCacheHits()
+		tree.markNodeUsed(node)
+	} else {
+		tree.incCacheMisses()
+		err = tree.loadNode(node) // will also mark node clean/used in LRU
+		if nil != err {
+			return
+		}
+	}
+
+	aggregate = tree.aggregator.Identity()
+
+	if node.leaf {
+		if hiIndex > node.items {
+			hiIndex = node.items
+		}
+
+		for i := loIndex; i < hiIndex; i++ {
+			liftedAggregate, liftErr := tree.liftItem(node, int(i))
+			if nil != liftErr {
+				err = liftErr
+				return
+			}
+
+			aggregate = tree.aggregator.Combine(aggregate, liftedAggregate)
+		}
+
+		err = nil
+		return
+	}
+
+	childNodes, err := tree.childNodes(node)
+	if nil != err {
+		return
+	}
+
+	childLoIndex := uint64(0) // index of the first item below childNode
+
+	for _, childNode := range childNodes {
+		if childLoIndex >= hiIndex {
+			break
+		}
+
+		childHiIndex := childLoIndex + childNode.items
+
+		if childHiIndex > loIndex {
+			var childRangeLoIndex uint64
+			if loIndex > childLoIndex {
+				childRangeLoIndex = loIndex - childLoIndex
+			}
+
+			childAggregate, childAggregateErr := tree.aggregateRange(childNode, childRangeLoIndex, hiIndex-childLoIndex)
+			if nil != childAggregateErr {
+				err = childAggregateErr
+				return
+			}
+
+			aggregate = tree.aggregator.Combine(aggregate, childAggregate)
+		}
+
+		childLoIndex = childHiIndex
+	}
+
+	err = nil
+	return
+}
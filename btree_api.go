@@ -4,27 +4,112 @@
 package sortedmap
 
 import (
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"hash"
+	"hash/crc32"
+	"log/slog"
 
 	"github.com/NVIDIA/cstruct"
 )
 
-// OnDiskByteOrder specifies the endian-ness expected to be used to persist B+Tree data structures
-var OnDiskByteOrder = cstruct.LittleEndian
+// OnDiskByteOrder specifies the default endian-ness used to persist B+Tree data structures
+//
+// Each B+Tree captures OnDiskByteOrder (unless overridden by BPlusTreeOptions.ByteOrder)
+// when constructed. As the byte order is recorded in each posted node, OldBPlusTree()
+// adopts that of the root node rather than OnDiskByteOrder. Only nodes in the legacy
+// on-disk format, which cannot record it, rely on the captured value to be loaded. Hence,
+// changing OnDiskByteOrder has no effect on B+Trees already constructed.
+var OnDiskByteOrder binary.ByteOrder = cstruct.LittleEndian
+
+// ErrCorruptNode is matched (via errors.Is) by the error returned when a node fetched via GetNode() fails verification
+var ErrCorruptNode = errors.New("corrupt B+Tree node")
+
+// ErrOptionMismatch is matched (via errors.Is) by the error returned when a node fetched via GetNode() was posted with incompatible BPlusTreeOptions
+var ErrOptionMismatch = errors.New("B+Tree node posted with incompatible options")
+
+// CorruptNodeError identifies the on-disk location of a node that failed verification upon being loaded
+type CorruptNodeError struct {
+	ObjectNumber uint64
+	ObjectOffset uint64
+	ObjectLength uint64
+	Reason       string
+}
+
+func (corruptNodeError *CorruptNodeError) Error() string {
+	return fmt.Sprintf("%v @ ObjectNumber 0x%016X ObjectOffset 0x%016X ObjectLength 0x%016X: %s", ErrCorruptNode, corruptNodeError.ObjectNumber, corruptNodeError.ObjectOffset, corruptNodeError.ObjectLength, corruptNodeError.Reason)
+}
+
+func (corruptNodeError *CorruptNodeError) Unwrap() error {
+	return ErrCorruptNode
+}
+
+// NodeChecksum returns a fresh hash.Hash32 used to compute the checksum appended to each posted node
+type NodeChecksum func() hash.Hash32
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// NodeChecksumCRC32C computes a CRC32C (Castagnoli) checksum
+func NodeChecksumCRC32C() hash.Hash32 {
+	return crc32.New(crc32cTable)
+}
+
+// BPlusTreeOptions specifies optional B+Tree behavior (a nil *BPlusTreeOptions selects the defaults)
+//
+// Nodes record the version of the on-disk format used to post them, so an OldBPlusTree
+// may contain a mix of versions. Unless UpgradeOnDiskFormat is set, an OldBPlusTree will
+// continue to post dirtied nodes in the version found in its root node, such that older
+// readers retain access to the tree. Note that no node records which NodeChecksum computed
+// its checksum (and nodes posted in the original, unversioned format do not even record its
+// presence), so NodeChecksum must be supplied identically to OldBPlusTreeWithOptions() to
+// read a tree posted with it.
+type BPlusTreeOptions struct {
+	NodeChecksum          NodeChecksum       // if non-nil, a checksum computed over each posted node is appended to it and verified upon load
+	UpgradeOnDiskFormat   bool               // if true, nodes of an OldBPlusTree are rewritten in the current on-disk format as they are dirtied
+	NodeCodec             NodeCodec          // if non-nil, used to compress each posted node (see NodeCodecNone, NodeCodecFlate, NodeCodecGzip, & NodeCodecZlib)
+	NodeKeyring           NodeKeyring        // if non-nil, each posted node is encrypted (requires callbacks to implement BPlusTreeNodeLocationCallbacks)
+	AllowUnencryptedNodes bool               // if true (and NodeKeyring is non-nil), unencrypted nodes (e.g. posted prior to enabling encryption) may be loaded
+	AllowMissingChecksums bool               // if true (and NodeChecksum is non-nil), nodes lacking a checksum (e.g. posted prior to enabling checksums) may be loaded & legacy nodes are presumed to lack one
+	KeyPrefixCompression  bool               // if true, Keys are front-coded within each posted node (UnpackKey() must consume exactly what PackKey() produced)
+	VarintEncoding        bool               // if true, counts & child references within each posted node are varint-encoded rather than fixed-size
+	ByteOrder             binary.ByteOrder   // if non-nil, either cstruct.LittleEndian or cstruct.BigEndian overriding OnDiskByteOrder (OldBPlusTree() only applies this to legacy root nodes)
+	MaxNodeBytes          uint64             // if non-zero, nodes are split based on their length as passed to PutNode() rather than solely on maxKeysPerNode
+	MinNodeBytes          uint64             // if MaxNodeBytes is non-zero, non-Root nodes smaller than this are rebalanced (defaults to MaxNodeBytes/4; may not exceed MaxNodeBytes/2)
+	ValueLogThreshold     uint64             // if non-zero, packed Values larger than this are posted out-of-line (requires callbacks to implement BPlusTreeValueLogCallbacks & precludes NodeKeyring, Aggregator, & Weigher)
+	OrderedKeyEncoding    OrderedKeyEncoding // if non-nil, Keys are held in this encoding & compared bytewise (compare is ignored & PackKey()/UnpackKey() are not called)
+	Aggregator            Aggregator         // if non-nil, each node maintains (& each child reference records) the Aggregate of the items below it (see aggregate.go)
+	AggregateCodec        ValueCodec         // if Aggregator is non-nil, used to pack & unpack recorded Aggregates
+	Weigher               Weigher            // if non-nil, each node maintains (& each child reference records) the total weight of the items below it (see weight.go)
+	Metrics               Metrics            // if non-nil, receives counters & histograms of node loads, posts, splits, merges, & more (see metrics.go)
+	Name                  string             // identifies the B+Tree in the BPlusTreeCacheTreeStats reported by CacheStats(), TreeStats(), & TopConsumers() & in logged events
+	Logger                *slog.Logger       // if non-nil, receives structured events upon node splits, merges, borrows, loads, & evictions as well as Prune() calls (see btree_logging.go)
+}
 
 // LayoutReport is a map where key is an objectNumber and value is objectBytes used in that objectNumber
 type LayoutReport map[uint64]uint64
 
 type DimensionsReport struct {
-	MinKeysPerNode uint64 // only applies to non-Root nodes
-	MaxKeysPerNode uint64
-	Items          uint64
-	Height         uint64
+	MinKeysPerNode   uint64 // only applies to non-Root nodes
+	MaxKeysPerNode   uint64
+	MinNodeBytes     uint64 // only applies to non-Root nodes (and only if MaxNodeBytes != 0)
+	MaxNodeBytes     uint64 // if non-zero, node sizes rather than MinKeysPerNode drive rebalancing
+	Items            uint64
+	Height           uint64
+	PostedNodes      uint64 // number of nodes posted (via PutNode()) since this B+Tree was instantiated
+	PostedRawBytes   uint64 // sum of the sizes of those nodes prior to encoding by a NodeCodec
+	PostedBytes      uint64 // sum of the sizes of those nodes as passed to PutNode()
+	PostedValues     uint64 // number of Values posted (via PutValue()) since this B+Tree was instantiated
+	PostedValueBytes uint64 // sum of the sizes of those Values
 }
 
 // BPlusTree interface declares the available methods available for a B+Tree
 type BPlusTree interface {
 	SortedMap
+	AggregatedSortedMap
+	WeightedSortedMap
+	PriorityQueue
+	BPlusTreeCacheStatsReporter
 	FetchLocation() (rootObjectNumber uint64, rootObjectOffset uint64, rootObjectLength uint64)
 	FetchLayoutReport() (layoutReport LayoutReport, err error)
 	FetchDimensionsReport() (dimensionsReport DimensionsReport, err error)
@@ -57,12 +142,35 @@ type BPlusTreeCacheStats struct {
 	CacheMisses    uint64
 }
 
+// BPlusTreeCacheTreeStats reports the share of a BPlusTreeCache attributable to one of the B+Trees sharing it
+type BPlusTreeCacheTreeStats struct {
+	Name           string    // as supplied via BPlusTreeOptions.Name
+	Tree           BPlusTree // the B+Tree itself
+	CleanLRUItems  uint64    // clean nodes currently resident
+	DirtyLRUItems  uint64    // dirty nodes currently resident
+	CacheHits      uint64    // node accesses finding the node loaded
+	CacheMisses    uint64    // node accesses requiring the node be loaded
+	CacheEvictions uint64    // nodes evicted (by the cache's drainer or by Purge())
+	ResidentBytes  uint64    // sum of the sizes (as loaded via GetNode()) of loaded nodes currently resident
+}
+
 type BPlusTreeCache interface {
+	BPlusTreeCacheTreeStatsReporter
 	Stats() (bPlusTreeCacheStats *BPlusTreeCacheStats)
 	UpdateLimits(evictLowLimit uint64, evictHighLimit uint64)
 }
 
 func NewBPlusTreeCache(evictLowLimit uint64, evictHighLimit uint64) (bPlusTreeCache BPlusTreeCache) {
+	bPlusTreeCache = NewBPlusTreeCacheWithMetrics(evictLowLimit, evictHighLimit, nil)
+	return
+}
+
+// NewBPlusTreeCacheWithMetrics is identical to NewBPlusTreeCache() except that cache hits, misses, & evictions are reported to metrics
+func NewBPlusTreeCacheWithMetrics(evictLowLimit uint64, evictHighLimit uint64, metrics Metrics) (bPlusTreeCache BPlusTreeCache) {
+	if nil == metrics {
+		metrics = NoMetrics
+	}
+
 	bPlusTreeCache = &btreeNodeCacheStruct{
 		evictLowLimit:  evictLowLimit,
 		evictHighLimit: evictHighLimit,
@@ -75,6 +183,8 @@ func NewBPlusTreeCache(evictLowLimit uint64, evictHighLimit uint64) (bPlusTreeCa
 		drainerActive:  false,
 		cacheHits:      0,
 		cacheMisses:    0,
+		metrics:        metrics,
+		trees:          make(map[*btreeTreeStruct]struct{}),
 	}
 	return
 }
@@ -91,10 +201,24 @@ func NewBPlusTreeCache(evictLowLimit uint64, evictHighLimit uint64) (bPlusTreeCa
 // That said, there is no advantage to using a B+Tree for an in-memory collection over
 // the llrb-provided collection implementing the same APIs.
 func NewBPlusTree(maxKeysPerNode uint64, compare Compare, callbacks BPlusTreeCallbacks, bPlusTreeCache BPlusTreeCache) (tree BPlusTree) {
+	tree, err := NewBPlusTreeWithOptions(maxKeysPerNode, compare, callbacks, bPlusTreeCache, nil)
+	if nil != err {
+		panic(err)
+	}
+	return
+}
+
+// NewBPlusTreeWithOptions is identical to NewBPlusTree() except that it accepts a *BPlusTreeOptions
+//
+// If options.MaxNodeBytes is non-zero, maxKeysPerNode serves only as an upper bound on
+// the number of Keys in a node. Splits and merges are otherwise driven by node size.
+//
+// Unlike NewBPlusTree(), an invalid maxKeysPerNode or options is reported via err.
+func NewBPlusTreeWithOptions(maxKeysPerNode uint64, compare Compare, callbacks BPlusTreeCallbacks, bPlusTreeCache BPlusTreeCache, options *BPlusTreeOptions) (tree BPlusTree, err error) {
 	minKeysPerNode := maxKeysPerNode >> 1
 	if (4 > maxKeysPerNode) || ((2 * minKeysPerNode) != maxKeysPerNode) {
-		err := fmt.Errorf("maxKeysPerNode (%v) invalid - must be an even positive number greater than 3", maxKeysPerNode)
-		panic(err)
+		err = fmt.Errorf("maxKeysPerNode (%v) invalid - must be an even positive number greater than 3", maxKeysPerNode)
+		return
 	}
 
 	rootNode := &btreeNodeStruct{
@@ -108,7 +232,7 @@ func NewBPlusTree(maxKeysPerNode uint64, compare Compare, callbacks BPlusTreeCal
 		leaf:                true,
 		tree:                nil, //                          To be set just below
 		parentNode:          nil,
-		kvLLRB:              NewLLRBTree(compare, callbacks),
+		kvLLRB:              nil, //                          To be set just below (once Compare is known)
 		nonLeafLeftChild:    nil,
 		rootPrefixSumChild:  nil,
 		prefixSumItems:      0,   //                          Not applicable to root node
@@ -126,8 +250,17 @@ func NewBPlusTree(maxKeysPerNode uint64, compare Compare, callbacks BPlusTreeCal
 		BPlusTreeCallbacks:        callbacks,
 		root:                      rootNode,
 		staleOnDiskReferencesList: nil,
+		onDiskNodeVersion:         onDiskNodeVersionCurrent,
+		onDiskByteOrder:           OnDiskByteOrder,
+	}
+
+	err = treePtr.applyOptions(options)
+	if nil != err {
+		return
 	}
 
+	rootNode.kvLLRB = NewLLRBTree(treePtr.Compare, callbacks)
+
 	if nil == bPlusTreeCache {
 		treePtr.nodeCache = nil
 	} else {
@@ -141,11 +274,18 @@ func NewBPlusTree(maxKeysPerNode uint64, compare Compare, callbacks BPlusTreeCal
 
 	tree = treePtr
 
+	err = nil
 	return
 }
 
 // OldBPlusTree is used to re-construct a B+Tree previously persisted
 func OldBPlusTree(rootObjectNumber uint64, rootObjectOffset uint64, rootObjectLength uint64, compare Compare, callbacks BPlusTreeCallbacks, bPlusTreeCache BPlusTreeCache) (tree BPlusTree, err error) {
+	tree, err = OldBPlusTreeWithOptions(rootObjectNumber, rootObjectOffset, rootObjectLength, compare, callbacks, bPlusTreeCache, nil)
+	return
+}
+
+// OldBPlusTreeWithOptions is identical to OldBPlusTree() except that it accepts a *BPlusTreeOptions
+func OldBPlusTreeWithOptions(rootObjectNumber uint64, rootObjectOffset uint64, rootObjectLength uint64, compare Compare, callbacks BPlusTreeCallbacks, bPlusTreeCache BPlusTreeCache, options *BPlusTreeOptions) (tree BPlusTree, err error) {
 	rootNode := &btreeNodeStruct{
 		objectNumber:        rootObjectNumber,
 		objectOffset:        rootObjectOffset,
@@ -175,6 +315,13 @@ func OldBPlusTree(rootObjectNumber uint64, rootObjectOffset uint64, rootObjectLe
 		BPlusTreeCallbacks:        callbacks,
 		root:                      rootNode,
 		staleOnDiskReferencesList: nil,
+		onDiskNodeVersion:         onDiskNodeVersionCurrent, // To be updated once root node is loaded (unless upgrading)
+		onDiskByteOrder:           OnDiskByteOrder,          // To be updated once root node is loaded (unless legacy)
+	}
+
+	err = treePtr.applyOptions(options)
+	if nil != err {
+		return
 	}
 
 	if nil == bPlusTreeCache {
@@ -0,0 +1,121 @@
+// Copyright (c) 2015-2021, NVIDIA CORPORATION.
+// SPDX-License-Identifier: Apache-2.0
+
+package sortedmap
+
+import (
+	"testing"
+
+	"github.com/NVIDIA/cstruct"
+)
+
+func TestBPlusTreeByteOrder(t *testing.T) {
+	var (
+		bigEndianContext       *specificBPlusTreeTestContextStruct
+		bigEndianRootLength    uint64
+		bigEndianRootNumber    uint64
+		bigEndianRootOffset    uint64
+		btree                  BPlusTree
+		err                    error
+		legacyContext          *specificBPlusTreeTestContextStruct
+		legacyRootLength       uint64
+		legacyRootNumber       uint64
+		legacyRootOffset       uint64
+		littleEndianContext    *specificBPlusTreeTestContextStruct
+		littleEndianRootLength uint64
+		littleEndianRootNumber uint64
+		littleEndianRootOffset uint64
+		savedOnDiskByteOrder   = OnDiskByteOrder
+	)
+
+	defer func() {
+		OnDiskByteOrder = savedOnDiskByteOrder
+	}()
+
+	// Two B+Trees of differing byte order coexisting in one process
+
+	littleEndianContext = &specificBPlusTreeTestContextStruct{t: t, lastLogSegmentNumberGenerated: 0, lastLogOffsetGenerated: 0, logSegmentChunkMap: make(map[uint64]*logSegmentChunkStruct)}
+	bigEndianContext = &specificBPlusTreeTestContextStruct{t: t, lastLogSegmentNumberGenerated: 0, lastLogOffsetGenerated: 0, logSegmentChunkMap: make(map[uint64]*logSegmentChunkStruct)}
+
+	btree = NewBPlusTree(specificBPlusTreeTestNumKeysMaxSmall, CompareUint32, littleEndianContext, nil)
+	testFormatPutKeys(t, btree, 0, 100)
+	littleEndianRootNumber, littleEndianRootOffset, littleEndianRootLength, err = btree.Flush(false)
+	if nil != err {
+		t.Fatalf("btree.Flush(false) [little-endian] failed: %v", err)
+	}
+
+	btree, err = NewBPlusTreeWithOptions(specificBPlusTreeTestNumKeysMaxSmall, CompareUint32, bigEndianContext, nil, &BPlusTreeOptions{ByteOrder: cstruct.BigEndian, NodeChecksum: NodeChecksumCRC32C})
+	if nil != err {
+		t.Fatalf("NewBPlusTreeWithOptions() failed: %v", err)
+	}
+	testFormatPutKeys(t, btree, 0, 100)
+	bigEndianRootNumber, bigEndianRootOffset, bigEndianRootLength, err = btree.Flush(false)
+	if nil != err {
+		t.Fatalf("btree.Flush(false) [big-endian] failed: %v", err)
+	}
+
+	for _, logSegmentChunk := range bigEndianContext.logSegmentChunkMap {
+		if 0 == (logSegmentChunk.chunkByteSlice[len(onDiskNodeMagic)+1] & onDiskNodeFlagBigEndian) {
+			t.Fatalf("big-endian B+Tree posted node lacking onDiskNodeFlagBigEndian")
+		}
+	}
+
+	// Changing OnDiskByteOrder must not affect reading either B+Tree back
+
+	OnDiskByteOrder = cstruct.BigEndian
+
+	btree, err = OldBPlusTree(littleEndianRootNumber, littleEndianRootOffset, littleEndianRootLength, CompareUint32, littleEndianContext, nil)
+	if nil != err {
+		t.Fatalf("OldBPlusTree() [little-endian] failed: %v", err)
+	}
+	testFormatVerifyKeys(t, btree, 100)
+
+	// ...nor should it affect nodes subsequently posted by such a B+Tree
+
+	testFormatPutKeys(t, btree, 100, 110)
+	littleEndianRootNumber, littleEndianRootOffset, littleEndianRootLength, err = btree.Flush(false)
+	if nil != err {
+		t.Fatalf("btree.Flush(false) [little-endian] failed: %v", err)
+	}
+
+	for _, logSegmentChunk := range littleEndianContext.logSegmentChunkMap {
+		if 0 != (logSegmentChunk.chunkByteSlice[len(onDiskNodeMagic)+1] & onDiskNodeFlagBigEndian) {
+			t.Fatalf("little-endian B+Tree posted node having onDiskNodeFlagBigEndian")
+		}
+	}
+
+	OnDiskByteOrder = cstruct.LittleEndian
+
+	btree, err = OldBPlusTree(littleEndianRootNumber, littleEndianRootOffset, littleEndianRootLength, CompareUint32, littleEndianContext, nil)
+	if nil != err {
+		t.Fatalf("OldBPlusTree() [little-endian] failed: %v", err)
+	}
+	testFormatVerifyKeys(t, btree, 110)
+
+	btree, err = OldBPlusTreeWithOptions(bigEndianRootNumber, bigEndianRootOffset, bigEndianRootLength, CompareUint32, bigEndianContext, nil, &BPlusTreeOptions{NodeChecksum: NodeChecksumCRC32C})
+	if nil != err {
+		t.Fatalf("OldBPlusTreeWithOptions() [big-endian] failed: %v", err)
+	}
+	testFormatVerifyKeys(t, btree, 100)
+
+	// Legacy nodes cannot record their byte order, so it must be supplied
+
+	legacyContext = &specificBPlusTreeTestContextStruct{t: t, lastLogSegmentNumberGenerated: 0, lastLogOffsetGenerated: 0, logSegmentChunkMap: make(map[uint64]*logSegmentChunkStruct)}
+
+	btree, err = NewBPlusTreeWithOptions(specificBPlusTreeTestNumKeysMaxSmall, CompareUint32, legacyContext, nil, &BPlusTreeOptions{ByteOrder: cstruct.BigEndian})
+	if nil != err {
+		t.Fatalf("NewBPlusTreeWithOptions() failed: %v", err)
+	}
+	btree.(*btreeTreeStruct).onDiskNodeVersion = onDiskNodeVersionLegacy
+	testFormatPutKeys(t, btree, 0, 100)
+	legacyRootNumber, legacyRootOffset, legacyRootLength, err = btree.Flush(false)
+	if nil != err {
+		t.Fatalf("btree.Flush(false) [legacy] failed: %v", err)
+	}
+
+	btree, err = OldBPlusTreeWithOptions(legacyRootNumber, legacyRootOffset, legacyRootLength, CompareUint32, legacyContext, nil, &BPlusTreeOptions{ByteOrder: cstruct.BigEndian})
+	if nil != err {
+		t.Fatalf("OldBPlusTreeWithOptions() [legacy] failed: %v", err)
+	}
+	testFormatVerifyKeys(t, btree, 100)
+}
@@ -0,0 +1,149 @@
+// Copyright (c) 2015-2021, NVIDIA CORPORATION.
+// SPDX-License-Identifier: Apache-2.0
+
+package sortedmap
+
+import (
+	"sort"
+)
+
+// Per-tree BPlusTreeCache statistics
+//
+// Alongside the totals reported by BPlusTreeCache.Stats(), a BPlusTreeCache attributes its
+// resident nodes, hits, misses, evictions, and resident bytes to the B+Tree responsible for
+// each. Resident bytes are the lengths (as loaded via GetNode()) of those resident nodes that
+// were loaded, added as each is loaded and subtracted as it is evicted (by the cache's
+// drainer or by Purge()) or discarded (by Discard() or as the B+Tree shrinks). Nodes created
+// in memory (rather than loaded) contribute nothing. A B+Tree reports its own share via
+// CacheStats(). The BPlusTreeCache reports that of every B+Tree with resident nodes via
+// TreeStats() and, ordered by resident nodes, via TopConsumers(). A B+Tree is only tracked by the BPlusTreeCache while it has resident
+// nodes (e.g. not once it has been fully Purge()'d or Discard()'d), though its counters
+// continue to accumulate should it again load nodes.
+
+// BPlusTreeCacheStatsReporter is implemented by each BPlusTree to report its share of its BPlusTreeCache
+type BPlusTreeCacheStatsReporter interface {
+	CacheStats() (treeStats *BPlusTreeCacheTreeStats) // Returns this B+Tree's share of its BPlusTreeCache (nil if none)
+}
+
+// BPlusTreeCacheTreeStatsReporter is implemented by each BPlusTreeCache to report the share of each B+Tree sharing it
+type BPlusTreeCacheTreeStatsReporter interface {
+	TreeStats() (treeStats []*BPlusTreeCacheTreeStats)         // Returns the BPlusTreeCacheTreeStats of each B+Tree with resident nodes (ordered by Name)
+	TopConsumers(n int) (treeStats []*BPlusTreeCacheTreeStats) // Returns (up to) the n BPlusTreeCacheTreeStats with the most resident nodes (most first)
+}
+
+type btreeNodeCacheTreeStatsStruct struct {
+	cleanLRUItems  uint64
+	dirtyLRUItems  uint64
+	cacheHits      uint64
+	cacheMisses    uint64
+	cacheEvictions uint64
+	residentBytes  uint64
+}
+
+// updateTreeStats accounts for a node of tree moving from oldTag's LRU to newTag's LRU
+//
+// Called while holding bPlusTreeCache.Mutex.
+func (bPlusTreeCache *btreeNodeCacheStruct) updateTreeStats(tree *btreeTreeStruct, oldTag btreeNodeCacheTag, newTag btreeNodeCacheTag) {
+	if oldTag == newTag {
+		return
+	}
+
+	switch oldTag {
+	case cleanLRU:
+		tree.cacheStats.cleanLRUItems--
+	case dirtyLRU:
+		tree.cacheStats.dirtyLRUItems--
+	}
+
+	switch newTag {
+	case cleanLRU:
+		tree.cacheStats.cleanLRUItems++
+	case dirtyLRU:
+		tree.cacheStats.dirtyLRUItems++
+	}
+
+	if 0 == (tree.cacheStats.cleanLRUItems + tree.cacheStats.dirtyLRUItems) {
+		delete(bPlusTreeCache.trees, tree)
+	} else {
+		bPlusTreeCache.trees[tree] = struct{}{}
+	}
+}
+
+// addToCacheResidentBytes attributes the length of node, just loaded via GetNode(), to tree
+func (tree *btreeTreeStruct) addToCacheResidentBytes(node *btreeNodeStruct) {
+	if nil != tree.nodeCache {
+		tree.nodeCache.Lock()
+		tree.cacheStats.residentBytes += node.objectLength - node.residentBytes
+		node.residentBytes = node.objectLength
+		tree.nodeCache.Unlock()
+	}
+}
+
+// subtractFromCacheResidentBytes releases the length attributed to node as it leaves the cache
+//
+// Called while holding tree.nodeCache.Mutex.
+func (tree *btreeTreeStruct) subtractFromCacheResidentBytes(node *btreeNodeStruct) {
+	tree.cacheStats.residentBytes -= node.residentBytes
+	node.residentBytes = 0
+}
+
+// treeStats returns a snapshot of tree's cacheStats
+//
+// Called while holding tree.nodeCache.Mutex.
+func (tree *btreeTreeStruct) treeStats() (treeStats *BPlusTreeCacheTreeStats) {
+	treeStats = &BPlusTreeCacheTreeStats{
+		Name:           tree.name,
+		Tree:           tree,
+		CleanLRUItems:  tree.cacheStats.cleanLRUItems,
+		DirtyLRUItems:  tree.cacheStats.dirtyLRUItems,
+		CacheHits:      tree.cacheStats.cacheHits,
+		CacheMisses:    tree.cacheStats.cacheMisses,
+		CacheEvictions: tree.cacheStats.cacheEvictions,
+		ResidentBytes:  tree.cacheStats.residentBytes,
+	}
+
+	return
+}
+
+func (tree *btreeTreeStruct) CacheStats() (treeStats *BPlusTreeCacheTreeStats) {
+	tree.Lock()
+	defer tree.Unlock()
+
+	if nil == tree.nodeCache {
+		treeStats = nil
+		return
+	}
+
+	tree.nodeCache.Lock()
+	treeStats = tree.treeStats()
+	tree.nodeCache.Unlock()
+
+	return
+}
+
+func (bPlusTreeCache *btreeNodeCacheStruct) TreeStats() (treeStats []*BPlusTreeCacheTreeStats) {
+	bPlusTreeCache.Lock()
+	treeStats = make([]*BPlusTreeCacheTreeStats, 0, len(bPlusTreeCache.trees))
+	for tree := range bPlusTreeCache.trees {
+		treeStats = append(treeStats, tree.treeStats())
+	}
+	bPlusTreeCache.Unlock()
+
+	sort.SliceStable(treeStats, func(i, j int) bool { return treeStats[i].Name < treeStats[j].Name })
+
+	return
+}
+
+func (bPlusTreeCache *btreeNodeCacheStruct) TopConsumers(n int) (treeStats []*BPlusTreeCacheTreeStats) {
+	treeStats = bPlusTreeCache.TreeStats()
+
+	sort.SliceStable(treeStats, func(i, j int) bool {
+		return (treeStats[i].CleanLRUItems + treeStats[i].DirtyLRUItems) > (treeStats[j].CleanLRUItems + treeStats[j].DirtyLRUItems)
+	})
+
+	if (0 <= n) && (n < len(treeStats)) {
+		treeStats = treeStats[:n]
+	}
+
+	return
+}
@@ -0,0 +1,147 @@
+// Copyright (c) 2015-2021, NVIDIA CORPORATION.
+// SPDX-License-Identifier: Apache-2.0
+
+package sortedmap
+
+import (
+	"testing"
+)
+
+func TestBPlusTreeCacheTreeStats(t *testing.T) {
+	treeContext := &cacheBPlusTreeTestContextStruct{
+		nextObjectNumber: uint64(0),
+		objectMap:        make(map[uint64][]byte),
+	}
+
+	treeCache := NewBPlusTreeCache(1000, 1000)
+
+	uncachedTree := NewBPlusTree(4, CompareUint16, treeContext, nil)
+	if nil != uncachedTree.CacheStats() {
+		t.Fatalf("uncachedTree.CacheStats() should have returned nil")
+	}
+
+	smallTree, err := NewBPlusTreeWithOptions(4, CompareUint16, treeContext, treeCache, &BPlusTreeOptions{Name: "small"})
+	if nil != err {
+		t.Fatalf("NewBPlusTreeWithOptions() failed: %v", err)
+	}
+	largeTree, err := NewBPlusTreeWithOptions(4, CompareUint16, treeContext, treeCache, &BPlusTreeOptions{Name: "large"})
+	if nil != err {
+		t.Fatalf("NewBPlusTreeWithOptions() failed: %v", err)
+	}
+
+	for key := uint16(0); key < 4; key++ {
+		_, _ = smallTree.Put(key, uint32(key))
+	}
+	for key := uint16(0); key < 64; key++ {
+		_, _ = largeTree.Put(key, uint32(key))
+	}
+
+	// Per-tree resident nodes, hits, & misses should sum to the cache-wide totals
+
+	treeStats := treeCache.TreeStats()
+	if (2 != len(treeStats)) || ("large" != treeStats[0].Name) || ("small" != treeStats[1].Name) {
+		t.Fatalf("treeCache.TreeStats() should have returned stats for \"large\" & \"small\"")
+	}
+	if (largeTree != treeStats[0].Tree) || (smallTree != treeStats[1].Tree) {
+		t.Fatalf("treeCache.TreeStats() should have identified each Tree")
+	}
+
+	cacheStats := treeCache.Stats()
+	if (treeStats[0].CleanLRUItems + treeStats[1].CleanLRUItems) != cacheStats.CleanLRUItems {
+		t.Fatalf("CleanLRUItems of each tree should have summed to %v", cacheStats.CleanLRUItems)
+	}
+	if (treeStats[0].DirtyLRUItems + treeStats[1].DirtyLRUItems) != cacheStats.DirtyLRUItems {
+		t.Fatalf("DirtyLRUItems of each tree should have summed to %v", cacheStats.DirtyLRUItems)
+	}
+	if (treeStats[0].CacheHits + treeStats[1].CacheHits) != cacheStats.CacheHits {
+		t.Fatalf("CacheHits of each tree should have summed to %v", cacheStats.CacheHits)
+	}
+	if (treeStats[0].CacheMisses + treeStats[1].CacheMisses) != cacheStats.CacheMisses {
+		t.Fatalf("CacheMisses of each tree should have summed to %v", cacheStats.CacheMisses)
+	}
+
+	smallTreeStats := smallTree.CacheStats()
+	if (1 != smallTreeStats.DirtyLRUItems) || (0 != smallTreeStats.CleanLRUItems) {
+		t.Fatalf("smallTree.CacheStats() should have reported a single dirty node")
+	}
+
+	topConsumers := treeCache.TopConsumers(1)
+	if (1 != len(topConsumers)) || ("large" != topConsumers[0].Name) {
+		t.Fatalf("treeCache.TopConsumers(1) should have returned only \"large\"")
+	}
+
+	// Flushing converts dirty nodes to clean ones... & purging evicts them
+
+	_, _, _, _ = largeTree.Flush(false)
+
+	largeTreeStats := largeTree.CacheStats()
+	if (0 != largeTreeStats.DirtyLRUItems) || (0 == largeTreeStats.CleanLRUItems) {
+		t.Fatalf("largeTree.CacheStats() should have reported only clean nodes after Flush(false)")
+	}
+	if 0 != largeTreeStats.ResidentBytes {
+		t.Fatalf("largeTree.CacheStats() should have reported no resident bytes before any node was loaded")
+	}
+
+	residentNodes := largeTreeStats.CleanLRUItems
+
+	_ = largeTree.Purge(true)
+
+	largeTreeStats = largeTree.CacheStats()
+	if (0 != largeTreeStats.CleanLRUItems) || (residentNodes != largeTreeStats.CacheEvictions) {
+		t.Fatalf("largeTree.CacheStats() should have reported %v evictions after Purge(true)", residentNodes)
+	}
+
+	topConsumers = treeCache.TopConsumers(2)
+	if (1 != len(topConsumers)) || ("small" != topConsumers[0].Name) {
+		t.Fatalf("treeCache.TopConsumers(2) should have returned only \"small\" once \"large\" was purged")
+	}
+
+	// Reloading nodes accumulates misses & resident bytes... which purging releases
+
+	misses := largeTreeStats.CacheMisses
+
+	_, _, _ = largeTree.GetByKey(uint16(63))
+
+	largeTreeStats = largeTree.CacheStats()
+	if (misses >= largeTreeStats.CacheMisses) || (0 == largeTreeStats.ResidentBytes) {
+		t.Fatalf("largeTree.CacheStats() should have reported additional misses & resident bytes")
+	}
+	if 2 != len(treeCache.TreeStats()) {
+		t.Fatalf("treeCache.TreeStats() should again have included \"large\"")
+	}
+
+	residentBytes := largeTreeStats.ResidentBytes
+
+	_, _, _ = largeTree.GetByKey(uint16(63))
+
+	largeTreeStats = largeTree.CacheStats()
+	if residentBytes != largeTreeStats.ResidentBytes {
+		t.Fatalf("largeTree.CacheStats() should have reported unchanged resident bytes after a cache hit")
+	}
+
+	_ = largeTree.Purge(true)
+
+	largeTreeStats = largeTree.CacheStats()
+	if 0 != largeTreeStats.ResidentBytes {
+		t.Fatalf("largeTree.CacheStats() should have reported no resident bytes after Purge(true)")
+	}
+
+	_, _, _ = largeTree.GetByKey(uint16(0))
+
+	// A discarded tree is no longer tracked
+
+	_ = smallTree.Discard()
+
+	treeStats = treeCache.TreeStats()
+	if (1 != len(treeStats)) || ("large" != treeStats[0].Name) {
+		t.Fatalf("treeCache.TreeStats() should have returned only \"large\" once \"small\" was discarded")
+	}
+
+	// Discarding a tree releases its resident bytes
+
+	_ = largeTree.Discard()
+
+	if 0 != largeTree.(*btreeTreeStruct).cacheStats.residentBytes {
+		t.Fatalf("largeTree should have had no resident bytes after Discard()")
+	}
+}
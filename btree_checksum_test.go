@@ -0,0 +1,258 @@
+// Copyright (c) 2015-2021, NVIDIA CORPORATION.
+// SPDX-License-Identifier: Apache-2.0
+
+package sortedmap
+
+import (
+	"errors"
+	"hash"
+	"hash/crc32"
+	"testing"
+)
+
+func TestBPlusTreeChecksum(t *testing.T) {
+	var (
+		btree             BPlusTree
+		corruptNodeError  *CorruptNodeError
+		err               error
+		key               uint32
+		ok                bool
+		options           *BPlusTreeOptions
+		persistentContext *specificBPlusTreeTestContextStruct
+		rootObjectLength  uint64
+		rootObjectNumber  uint64
+		rootObjectOffset  uint64
+		valueAsValue      Value
+	)
+
+	persistentContext = &specificBPlusTreeTestContextStruct{t: t, lastLogSegmentNumberGenerated: 0, lastLogOffsetGenerated: 0, logSegmentChunkMap: make(map[uint64]*logSegmentChunkStruct)}
+
+	options = &BPlusTreeOptions{NodeChecksum: NodeChecksumCRC32C}
+
+	btree, err = NewBPlusTreeWithOptions(specificBPlusTreeTestNumKeysMaxSmall, CompareUint32, persistentContext, nil, options)
+	if nil != err {
+		t.Fatalf("NewBPlusTreeWithOptions() failed: %v", err)
+	}
+
+	for key = 0; key < 20; key++ {
+		ok, err = btree.Put(key, valueStruct{u32: key, s8: uint32To8ReplicaByteArray(key)})
+		if nil != err {
+			t.Fatalf("btree.Put(%v,) failed: %v", key, err)
+		}
+		if !ok {
+			t.Fatalf("btree.Put(%v,).ok should have been true", key)
+		}
+	}
+
+	rootObjectNumber, rootObjectOffset, rootObjectLength, err = btree.Flush(true)
+	if nil != err {
+		t.Fatalf("btree.Flush(true) failed: %v", err)
+	}
+
+	btree, err = OldBPlusTreeWithOptions(rootObjectNumber, rootObjectOffset, rootObjectLength, CompareUint32, persistentContext, nil, options)
+	if nil != err {
+		t.Fatalf("OldBPlusTreeWithOptions() failed: %v", err)
+	}
+
+	for key = 0; key < 20; key++ {
+		valueAsValue, ok, err = btree.GetByKey(key)
+		if nil != err {
+			t.Fatalf("btree.GetByKey(%v) failed: %v", key, err)
+		}
+		if !ok {
+			t.Fatalf("btree.GetByKey(%v).ok should have been true", key)
+		}
+		if valueAsValue.(valueStruct).u32 != key {
+			t.Fatalf("btree.GetByKey(%v) returned unexpected value", key)
+		}
+	}
+
+	err = btree.Validate()
+	if nil != err {
+		t.Fatalf("btree.Validate() failed: %v", err)
+	}
+
+	_, err = OldBPlusTreeWithOptions(rootObjectNumber, rootObjectOffset, rootObjectLength, CompareUint32, persistentContext, nil, &BPlusTreeOptions{NodeChecksum: func() hash.Hash32 { return crc32.NewIEEE() }})
+	if !errors.Is(err, ErrCorruptNode) {
+		t.Fatalf("OldBPlusTreeWithOptions() with mismatched NodeChecksum should have failed with ErrCorruptNode")
+	}
+
+	persistentContext.logSegmentChunkMap[rootObjectNumber].chunkByteSlice[0] ^= 0x01
+
+	_, err = OldBPlusTreeWithOptions(rootObjectNumber, rootObjectOffset, rootObjectLength, CompareUint32, persistentContext, nil, options)
+	if !errors.Is(err, ErrCorruptNode) {
+		t.Fatalf("OldBPlusTreeWithOptions() of corrupted root should have failed with ErrCorruptNode")
+	}
+	if !errors.As(err, &corruptNodeError) {
+		t.Fatalf("OldBPlusTreeWithOptions() of corrupted root should have returned a *CorruptNodeError")
+	}
+	if (corruptNodeError.ObjectNumber != rootObjectNumber) || (corruptNodeError.ObjectOffset != rootObjectOffset) || (corruptNodeError.ObjectLength != rootObjectLength) {
+		t.Fatalf("OldBPlusTreeWithOptions() of corrupted root returned unexpected location in *CorruptNodeError")
+	}
+}
+
+func TestBPlusTreeMissingChecksums(t *testing.T) {
+	var (
+		btree             BPlusTree
+		err               error
+		persistentContext *specificBPlusTreeTestContextStruct
+		rootObjectLength  uint64
+		rootObjectNumber  uint64
+		rootObjectOffset  uint64
+	)
+
+	for _, legacy := range []bool{false, true} {
+		persistentContext = &specificBPlusTreeTestContextStruct{t: t, lastLogSegmentNumberGenerated: 0, lastLogOffsetGenerated: 0, logSegmentChunkMap: make(map[uint64]*logSegmentChunkStruct)}
+
+		// Post a tree lacking checksums
+
+		btree = NewBPlusTree(specificBPlusTreeTestNumKeysMaxSmall, CompareUint32, persistentContext, nil)
+		if legacy {
+			btree.(*btreeTreeStruct).onDiskNodeVersion = onDiskNodeVersionLegacy
+		}
+
+		testFormatPutKeys(t, btree, 0, 20)
+
+		rootObjectNumber, rootObjectOffset, rootObjectLength, err = btree.Flush(true)
+		if nil != err {
+			t.Fatalf("btree.Flush(true) failed: %v", err)
+		}
+
+		// A reader requiring checksums must reject it
+
+		_, err = OldBPlusTreeWithOptions(rootObjectNumber, rootObjectOffset, rootObjectLength, CompareUint32, persistentContext, nil, &BPlusTreeOptions{NodeChecksum: NodeChecksumCRC32C})
+		if nil == err {
+			t.Fatalf("OldBPlusTreeWithOptions() of tree lacking checksums [legacy == %v] should have failed", legacy)
+		}
+
+		// Unless AllowMissingChecksums permits it to be upgraded
+
+		btree, err = OldBPlusTreeWithOptions(rootObjectNumber, rootObjectOffset, rootObjectLength, CompareUint32, persistentContext, nil, &BPlusTreeOptions{NodeChecksum: NodeChecksumCRC32C, AllowMissingChecksums: true, UpgradeOnDiskFormat: true})
+		if nil != err {
+			t.Fatalf("OldBPlusTreeWithOptions() with AllowMissingChecksums [legacy == %v] failed: %v", legacy, err)
+		}
+
+		testFormatVerifyKeys(t, btree, 20)
+
+		err = btree.Touch()
+		if nil != err {
+			t.Fatalf("btree.Touch() [legacy == %v] failed: %v", legacy, err)
+		}
+
+		rootObjectNumber, rootObjectOffset, rootObjectLength, err = btree.Flush(true)
+		if nil != err {
+			t.Fatalf("btree.Flush(true) [legacy == %v] failed: %v", legacy, err)
+		}
+
+		btree, err = OldBPlusTreeWithOptions(rootObjectNumber, rootObjectOffset, rootObjectLength, CompareUint32, persistentContext, nil, &BPlusTreeOptions{NodeChecksum: NodeChecksumCRC32C})
+		if nil != err {
+			t.Fatalf("OldBPlusTreeWithOptions() of upgraded tree [legacy == %v] failed: %v", legacy, err)
+		}
+
+		testFormatVerifyKeys(t, btree, 20)
+	}
+
+	// Clearing the checksum flag of a checksummed node must not bypass verification
+
+	persistentContext.logSegmentChunkMap[rootObjectNumber].chunkByteSlice[onDiskNodeFlagsOffset] &^= onDiskNodeFlagChecksum
+
+	_, err = OldBPlusTreeWithOptions(rootObjectNumber, rootObjectOffset, rootObjectLength, CompareUint32, persistentContext, nil, &BPlusTreeOptions{NodeChecksum: NodeChecksumCRC32C})
+	if !errors.Is(err, ErrCorruptNode) {
+		t.Fatalf("OldBPlusTreeWithOptions() of node with cleared checksum flag should have failed with ErrCorruptNode")
+	}
+
+	_, err = OldBPlusTreeWithOptions(rootObjectNumber, rootObjectOffset, rootObjectLength, CompareUint32, persistentContext, nil, &BPlusTreeOptions{NodeChecksum: NodeChecksumCRC32C, AllowMissingChecksums: true})
+	if !errors.Is(err, ErrCorruptNode) {
+		t.Fatalf("OldBPlusTreeWithOptions() with AllowMissingChecksums of node with cleared checksum flag should have failed with ErrCorruptNode")
+	}
+}
+
+func TestBPlusTreeCorruptHeader(t *testing.T) {
+	for _, options := range []*BPlusTreeOptions{nil, {NodeChecksum: NodeChecksumCRC32C}} {
+		persistentContext := &specificBPlusTreeTestContextStruct{t: t, lastLogSegmentNumberGenerated: 0, lastLogOffsetGenerated: 0, logSegmentChunkMap: make(map[uint64]*logSegmentChunkStruct)}
+
+		btree, err := NewBPlusTreeWithOptions(specificBPlusTreeTestNumKeysMaxSmall, CompareUint32, persistentContext, nil, options)
+		if nil != err {
+			t.Fatalf("NewBPlusTreeWithOptions() failed: %v", err)
+		}
+
+		testFormatPutKeys(t, btree, 0, 20)
+
+		rootObjectNumber, rootObjectOffset, rootObjectLength, err := btree.Flush(true)
+		if nil != err {
+			t.Fatalf("btree.Flush(true) failed: %v", err)
+		}
+
+		rootByteSlice := persistentContext.logSegmentChunkMap[rootObjectNumber].chunkByteSlice
+
+		// Each corruption of the header (whether or not detected by a checksum) is reported as ErrCorruptNode
+
+		for _, corruption := range []struct {
+			offset int
+			value  uint8
+		}{
+			{onDiskNodeFlagsOffset - 1, onDiskNodeVersionCurrent + 1}, // Version
+			{onDiskNodeFlagsOffset - 1, onDiskNodeVersionLegacy},      // Version
+			{onDiskNodeHeaderSize, 0x80},                              // ExtFlags
+		} {
+			originalValue := rootByteSlice[corruption.offset]
+			rootByteSlice[corruption.offset] = corruption.value
+
+			_, err = OldBPlusTreeWithOptions(rootObjectNumber, rootObjectOffset, rootObjectLength, CompareUint32, persistentContext, nil, options)
+			if !errors.Is(err, ErrCorruptNode) {
+				t.Fatalf("OldBPlusTreeWithOptions() of root with byte %v set to 0x%02X should have failed with ErrCorruptNode (got %v)", corruption.offset, corruption.value, err)
+			}
+
+			rootByteSlice[corruption.offset] = originalValue
+		}
+
+		_, err = OldBPlusTreeWithOptions(rootObjectNumber, rootObjectOffset, rootObjectLength, CompareUint32, persistentContext, nil, options)
+		if nil != err {
+			t.Fatalf("OldBPlusTreeWithOptions() of restored root failed: %v", err)
+		}
+	}
+}
+
+func TestBPlusTreeLegacyChecksums(t *testing.T) {
+	persistentContext := &specificBPlusTreeTestContextStruct{t: t, lastLogSegmentNumberGenerated: 0, lastLogOffsetGenerated: 0, logSegmentChunkMap: make(map[uint64]*logSegmentChunkStruct)}
+
+	// Post a checksummed tree in the legacy on-disk format
+
+	btree, err := NewBPlusTreeWithOptions(specificBPlusTreeTestNumKeysMaxSmall, CompareUint32, persistentContext, nil, &BPlusTreeOptions{NodeChecksum: NodeChecksumCRC32C})
+	if nil != err {
+		t.Fatalf("NewBPlusTreeWithOptions() failed: %v", err)
+	}
+
+	btree.(*btreeTreeStruct).onDiskNodeVersion = onDiskNodeVersionLegacy
+
+	testFormatPutKeys(t, btree, 0, 20)
+
+	rootObjectNumber, rootObjectOffset, rootObjectLength, err := btree.Flush(true)
+	if nil != err {
+		t.Fatalf("btree.Flush(true) failed: %v", err)
+	}
+
+	// AllowMissingChecksums presumes legacy nodes lack a checksum, so must not be used to read it
+
+	_, err = OldBPlusTreeWithOptions(rootObjectNumber, rootObjectOffset, rootObjectLength, CompareUint32, persistentContext, nil, &BPlusTreeOptions{NodeChecksum: NodeChecksumCRC32C, AllowMissingChecksums: true})
+	if !errors.Is(err, ErrOptionMismatch) {
+		t.Fatalf("OldBPlusTreeWithOptions() with AllowMissingChecksums of checksummed legacy tree should have failed with ErrOptionMismatch (got %v)", err)
+	}
+
+	btree, err = OldBPlusTreeWithOptions(rootObjectNumber, rootObjectOffset, rootObjectLength, CompareUint32, persistentContext, nil, &BPlusTreeOptions{NodeChecksum: NodeChecksumCRC32C})
+	if nil != err {
+		t.Fatalf("OldBPlusTreeWithOptions() of checksummed legacy tree failed: %v", err)
+	}
+
+	testFormatVerifyKeys(t, btree, 20)
+
+	// A corrupted checksum of a legacy node must be reported
+
+	rootByteSlice := persistentContext.logSegmentChunkMap[rootObjectNumber].chunkByteSlice
+	rootByteSlice[len(rootByteSlice)-1] ^= 0xFF
+
+	_, err = OldBPlusTreeWithOptions(rootObjectNumber, rootObjectOffset, rootObjectLength, CompareUint32, persistentContext, nil, &BPlusTreeOptions{NodeChecksum: NodeChecksumCRC32C})
+	if !errors.Is(err, ErrCorruptNode) {
+		t.Fatalf("OldBPlusTreeWithOptions() of corrupted legacy root should have failed with ErrCorruptNode (got %v)", err)
+	}
+}
@@ -0,0 +1,286 @@
+// Copyright (c) 2015-2021, NVIDIA CORPORATION.
+// SPDX-License-Identifier: Apache-2.0
+
+package sortedmap
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+)
+
+// NodeCodec specifies the interface to a compression codec applied to posted nodes
+//
+// Each posted node records the CodecID() of the NodeCodec used to encode it, so a B+Tree
+// may contain nodes encoded by differing codecs. Nodes encoded by any of the built-in
+// codecs may always be decoded. Nodes encoded by a client-provided codec (which should
+// use a CodecID() of at least NodeCodecIDClientMin) may only be decoded if that codec is
+// supplied via BPlusTreeOptions.NodeCodec.
+//
+// Beginning with onDiskNodeVersion2, each encoded node also records its raw (decoded) length.
+// A NodeCodec that additionally implements NodeCodecBoundedDecoder (as do the built-in codecs)
+// is then asked to decode no more than that many bytes such that a corrupted (or hostile) node
+// cannot expand without bound. Otherwise, Decode() is called and its result rejected if of the
+// wrong length.
+type NodeCodec interface {
+	CodecID() uint8
+	Encode(rawByteSlice []byte) (encodedByteSlice []byte, err error)
+	Decode(encodedByteSlice []byte) (rawByteSlice []byte, err error)
+}
+
+// NodeCodecBoundedDecoder may optionally be implemented by a NodeCodec
+//
+// DecodeBounded() must fail rather than return (or even produce) other than rawLength bytes.
+type NodeCodecBoundedDecoder interface {
+	DecodeBounded(encodedByteSlice []byte, rawLength uint64) (rawByteSlice []byte, err error)
+}
+
+const (
+	NodeCodecIDNone      = uint8(0)
+	NodeCodecIDFlate     = uint8(1)
+	NodeCodecIDGzip      = uint8(2)
+	NodeCodecIDZlib      = uint8(3)
+	NodeCodecIDClientMin = uint8(0x80)
+)
+
+var (
+	NodeCodecNone  NodeCodec = &nodeCodecNoneStruct{}
+	NodeCodecFlate NodeCodec = &nodeCodecFlateStruct{}
+	NodeCodecGzip  NodeCodec = &nodeCodecGzipStruct{}
+	NodeCodecZlib  NodeCodec = &nodeCodecZlibStruct{}
+)
+
+var builtInNodeCodecs = map[uint8]NodeCodec{
+	NodeCodecIDNone:  NodeCodecNone,
+	NodeCodecIDFlate: NodeCodecFlate,
+	NodeCodecIDGzip:  NodeCodecGzip,
+	NodeCodecIDZlib:  NodeCodecZlib,
+}
+
+type nodeCodecNoneStruct struct{}
+
+func (codec *nodeCodecNoneStruct) CodecID() uint8 {
+	return NodeCodecIDNone
+}
+
+func (codec *nodeCodecNoneStruct) Encode(rawByteSlice []byte) (encodedByteSlice []byte, err error) {
+	encodedByteSlice = rawByteSlice
+	err = nil
+	return
+}
+
+func (codec *nodeCodecNoneStruct) Decode(encodedByteSlice []byte) (rawByteSlice []byte, err error) {
+	rawByteSlice = encodedByteSlice
+	err = nil
+	return
+}
+
+func (codec *nodeCodecNoneStruct) DecodeBounded(encodedByteSlice []byte, rawLength uint64) (rawByteSlice []byte, err error) {
+	if uint64(len(encodedByteSlice)) != rawLength {
+		err = fmt.Errorf("decoded %v bytes but expected %v", len(encodedByteSlice), rawLength)
+		return
+	}
+
+	rawByteSlice = encodedByteSlice
+
+	err = nil
+	return
+}
+
+type nodeCodecFlateStruct struct{}
+
+func (codec *nodeCodecFlateStruct) CodecID() uint8 {
+	return NodeCodecIDFlate
+}
+
+func (codec *nodeCodecFlateStruct) Encode(rawByteSlice []byte) (encodedByteSlice []byte, err error) {
+	var (
+		encodedBuffer bytes.Buffer
+		flateWriter   *flate.Writer
+	)
+
+	flateWriter, err = flate.NewWriter(&encodedBuffer, flate.DefaultCompression)
+	if nil != err {
+		return
+	}
+
+	encodedByteSlice, err = encodeWithWriter(&encodedBuffer, flateWriter, rawByteSlice)
+
+	return
+}
+
+func (codec *nodeCodecFlateStruct) Decode(encodedByteSlice []byte) (rawByteSlice []byte, err error) {
+	rawByteSlice, err = decodeWithReader(flate.NewReader(bytes.NewReader(encodedByteSlice)))
+	return
+}
+
+func (codec *nodeCodecFlateStruct) DecodeBounded(encodedByteSlice []byte, rawLength uint64) (rawByteSlice []byte, err error) {
+	rawByteSlice, err = decodeWithReaderBounded(flate.NewReader(bytes.NewReader(encodedByteSlice)), rawLength)
+	return
+}
+
+type nodeCodecGzipStruct struct{}
+
+func (codec *nodeCodecGzipStruct) CodecID() uint8 {
+	return NodeCodecIDGzip
+}
+
+func (codec *nodeCodecGzipStruct) Encode(rawByteSlice []byte) (encodedByteSlice []byte, err error) {
+	var (
+		encodedBuffer bytes.Buffer
+	)
+
+	encodedByteSlice, err = encodeWithWriter(&encodedBuffer, gzip.NewWriter(&encodedBuffer), rawByteSlice)
+
+	return
+}
+
+func (codec *nodeCodecGzipStruct) Decode(encodedByteSlice []byte) (rawByteSlice []byte, err error) {
+	gzipReader, err := gzip.NewReader(bytes.NewReader(encodedByteSlice))
+	if nil != err {
+		return
+	}
+
+	rawByteSlice, err = decodeWithReader(gzipReader)
+
+	return
+}
+
+func (codec *nodeCodecGzipStruct) DecodeBounded(encodedByteSlice []byte, rawLength uint64) (rawByteSlice []byte, err error) {
+	gzipReader, err := gzip.NewReader(bytes.NewReader(encodedByteSlice))
+	if nil != err {
+		return
+	}
+
+	rawByteSlice, err = decodeWithReaderBounded(gzipReader, rawLength)
+
+	return
+}
+
+type nodeCodecZlibStruct struct{}
+
+func (codec *nodeCodecZlibStruct) CodecID() uint8 {
+	return NodeCodecIDZlib
+}
+
+func (codec *nodeCodecZlibStruct) Encode(rawByteSlice []byte) (encodedByteSlice []byte, err error) {
+	var (
+		encodedBuffer bytes.Buffer
+	)
+
+	encodedByteSlice, err = encodeWithWriter(&encodedBuffer, zlib.NewWriter(&encodedBuffer), rawByteSlice)
+
+	return
+}
+
+func (codec *nodeCodecZlibStruct) Decode(encodedByteSlice []byte) (rawByteSlice []byte, err error) {
+	zlibReader, err := zlib.NewReader(bytes.NewReader(encodedByteSlice))
+	if nil != err {
+		return
+	}
+
+	rawByteSlice, err = decodeWithReader(zlibReader)
+
+	return
+}
+
+func (codec *nodeCodecZlibStruct) DecodeBounded(encodedByteSlice []byte, rawLength uint64) (rawByteSlice []byte, err error) {
+	zlibReader, err := zlib.NewReader(bytes.NewReader(encodedByteSlice))
+	if nil != err {
+		return
+	}
+
+	rawByteSlice, err = decodeWithReaderBounded(zlibReader, rawLength)
+
+	return
+}
+
+func encodeWithWriter(encodedBuffer *bytes.Buffer, writeCloser io.WriteCloser, rawByteSlice []byte) (encodedByteSlice []byte, err error) {
+	_, err = writeCloser.Write(rawByteSlice)
+	if nil != err {
+		return
+	}
+
+	err = writeCloser.Close()
+	if nil != err {
+		return
+	}
+
+	encodedByteSlice = encodedBuffer.Bytes()
+
+	err = nil
+	return
+}
+
+func decodeWithReader(readCloser io.ReadCloser) (rawByteSlice []byte, err error) {
+	rawByteSlice, err = io.ReadAll(readCloser)
+	if nil != err {
+		_ = readCloser.Close()
+		return
+	}
+
+	err = readCloser.Close()
+
+	return
+}
+
+// decodeWithReaderBounded reads at most one byte more than rawLength in order to detect a longer stream
+func decodeWithReaderBounded(readCloser io.ReadCloser, rawLength uint64) (rawByteSlice []byte, err error) {
+	rawByteSlice, err = io.ReadAll(io.LimitReader(readCloser, int64(rawLength)+1))
+	if nil != err {
+		_ = readCloser.Close()
+		return
+	}
+
+	err = readCloser.Close()
+	if nil != err {
+		return
+	}
+
+	if uint64(len(rawByteSlice)) != rawLength {
+		err = fmt.Errorf("decoded more or less than the expected %v bytes", rawLength)
+		rawByteSlice = nil
+		return
+	}
+
+	err = nil
+	return
+}
+
+// decodeNodeBounded decodes encodedByteSlice via nodeCodec ensuring exactly rawLength bytes result
+func decodeNodeBounded(nodeCodec NodeCodec, encodedByteSlice []byte, rawLength uint64) (rawByteSlice []byte, err error) {
+	boundedDecoder, ok := nodeCodec.(NodeCodecBoundedDecoder)
+	if ok {
+		rawByteSlice, err = boundedDecoder.DecodeBounded(encodedByteSlice, rawLength)
+		return
+	}
+
+	rawByteSlice, err = nodeCodec.Decode(encodedByteSlice)
+	if nil != err {
+		return
+	}
+
+	if uint64(len(rawByteSlice)) != rawLength {
+		err = fmt.Errorf("decoded %v bytes but expected %v", len(rawByteSlice), rawLength)
+		rawByteSlice = nil
+		return
+	}
+
+	err = nil
+	return
+}
+
+func (tree *btreeTreeStruct) lookupNodeCodec(codecID uint8) (nodeCodec NodeCodec, ok bool) {
+	if (nil != tree.nodeCodec) && (codecID == tree.nodeCodec.CodecID()) {
+		nodeCodec = tree.nodeCodec
+		ok = true
+		return
+	}
+
+	nodeCodec, ok = builtInNodeCodecs[codecID]
+
+	return
+}
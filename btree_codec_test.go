@@ -0,0 +1,178 @@
+// Copyright (c) 2015-2021, NVIDIA CORPORATION.
+// SPDX-License-Identifier: Apache-2.0
+
+package sortedmap
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestNodeCodecRoundTrip(t *testing.T) {
+	rawByteSlice := bytes.Repeat([]byte("sortedmap"), 100)
+
+	for _, nodeCodec := range []NodeCodec{NodeCodecNone, NodeCodecFlate, NodeCodecGzip, NodeCodecZlib} {
+		encodedByteSlice, err := nodeCodec.Encode(rawByteSlice)
+		if nil != err {
+			t.Fatalf("NodeCodec (CodecID %v) Encode() failed: %v", nodeCodec.CodecID(), err)
+		}
+		if (NodeCodecIDNone != nodeCodec.CodecID()) && (len(encodedByteSlice) >= len(rawByteSlice)) {
+			t.Fatalf("NodeCodec (CodecID %v) Encode() failed to compress", nodeCodec.CodecID())
+		}
+		decodedByteSlice, err := nodeCodec.Decode(encodedByteSlice)
+		if nil != err {
+			t.Fatalf("NodeCodec (CodecID %v) Decode() failed: %v", nodeCodec.CodecID(), err)
+		}
+		if !bytes.Equal(rawByteSlice, decodedByteSlice) {
+			t.Fatalf("NodeCodec (CodecID %v) Decode() returned unexpected []byte", nodeCodec.CodecID())
+		}
+
+		decodedByteSlice, err = nodeCodec.(NodeCodecBoundedDecoder).DecodeBounded(encodedByteSlice, uint64(len(rawByteSlice)))
+		if (nil != err) || !bytes.Equal(rawByteSlice, decodedByteSlice) {
+			t.Fatalf("NodeCodec (CodecID %v) DecodeBounded() failed: %v", nodeCodec.CodecID(), err)
+		}
+		_, err = nodeCodec.(NodeCodecBoundedDecoder).DecodeBounded(encodedByteSlice, uint64(len(rawByteSlice)-1))
+		if nil == err {
+			t.Fatalf("NodeCodec (CodecID %v) DecodeBounded() of too short a rawLength should have failed", nodeCodec.CodecID())
+		}
+	}
+}
+
+func TestBPlusTreeNodeCodec(t *testing.T) {
+	var (
+		btree             BPlusTree
+		dimensionsReport  DimensionsReport
+		err               error
+		persistentContext *specificBPlusTreeTestContextStruct
+		rootObjectLength  uint64
+		rootObjectNumber  uint64
+		rootObjectOffset  uint64
+	)
+
+	persistentContext = &specificBPlusTreeTestContextStruct{t: t, lastLogSegmentNumberGenerated: 0, lastLogOffsetGenerated: 0, logSegmentChunkMap: make(map[uint64]*logSegmentChunkStruct)}
+
+	btree, err = NewBPlusTreeWithOptions(100, CompareUint32, persistentContext, nil, &BPlusTreeOptions{NodeCodec: NodeCodecGzip, NodeChecksum: NodeChecksumCRC32C})
+	if nil != err {
+		t.Fatalf("NewBPlusTreeWithOptions() failed: %v", err)
+	}
+
+	testFormatPutKeys(t, btree, 0, 200)
+
+	rootObjectNumber, rootObjectOffset, rootObjectLength, err = btree.Flush(true)
+	if nil != err {
+		t.Fatalf("btree.Flush(true) [case 1] failed: %v", err)
+	}
+
+	dimensionsReport, err = btree.FetchDimensionsReport()
+	if nil != err {
+		t.Fatalf("btree.FetchDimensionsReport() [case 1] failed: %v", err)
+	}
+	if 0 == dimensionsReport.PostedNodes {
+		t.Fatalf("btree.FetchDimensionsReport() [case 1] reported no PostedNodes")
+	}
+	if dimensionsReport.PostedBytes >= dimensionsReport.PostedRawBytes {
+		t.Fatalf("btree.FetchDimensionsReport() [case 1] reported PostedBytes (%v) >= PostedRawBytes (%v)", dimensionsReport.PostedBytes, dimensionsReport.PostedRawBytes)
+	}
+
+	// Re-open with a different NodeCodec, rewrite only some nodes, and verify the mixed tree
+
+	btree, err = OldBPlusTreeWithOptions(rootObjectNumber, rootObjectOffset, rootObjectLength, CompareUint32, persistentContext, nil, &BPlusTreeOptions{NodeCodec: NodeCodecZlib, NodeChecksum: NodeChecksumCRC32C})
+	if nil != err {
+		t.Fatalf("OldBPlusTreeWithOptions() [case 2] failed: %v", err)
+	}
+
+	testFormatVerifyKeys(t, btree, 200)
+
+	_, err = btree.TouchItem(0)
+	if nil != err {
+		t.Fatalf("btree.TouchItem(0) failed: %v", err)
+	}
+
+	rootObjectNumber, rootObjectOffset, rootObjectLength, err = btree.Flush(true)
+	if nil != err {
+		t.Fatalf("btree.Flush(true) [case 2] failed: %v", err)
+	}
+
+	// Finally, re-open with no NodeCodec at all (built-in codecs are always decodable)
+
+	btree, err = OldBPlusTreeWithOptions(rootObjectNumber, rootObjectOffset, rootObjectLength, CompareUint32, persistentContext, nil, &BPlusTreeOptions{NodeChecksum: NodeChecksumCRC32C})
+	if nil != err {
+		t.Fatalf("OldBPlusTreeWithOptions() [case 3] failed: %v", err)
+	}
+
+	testFormatVerifyKeys(t, btree, 200)
+
+	err = btree.Touch()
+	if nil != err {
+		t.Fatalf("btree.Touch() failed: %v", err)
+	}
+
+	_, _, _, err = btree.Flush(false)
+	if nil != err {
+		t.Fatalf("btree.Flush(false) [case 3] failed: %v", err)
+	}
+
+	dimensionsReport, err = btree.FetchDimensionsReport()
+	if nil != err {
+		t.Fatalf("btree.FetchDimensionsReport() [case 3] failed: %v", err)
+	}
+	if dimensionsReport.PostedBytes <= dimensionsReport.PostedRawBytes {
+		t.Fatalf("btree.FetchDimensionsReport() [case 3] reported PostedBytes (%v) <= PostedRawBytes (%v)", dimensionsReport.PostedBytes, dimensionsReport.PostedRawBytes)
+	}
+}
+
+func TestBPlusTreeNodeCodecRawLength(t *testing.T) {
+	for _, onDiskNodeVersion := range []uint8{onDiskNodeVersion1, onDiskNodeVersion2} {
+		persistentContext := &specificBPlusTreeTestContextStruct{t: t, lastLogSegmentNumberGenerated: 0, lastLogOffsetGenerated: 0, logSegmentChunkMap: make(map[uint64]*logSegmentChunkStruct)}
+
+		btree, err := NewBPlusTreeWithOptions(100, CompareUint32, persistentContext, nil, &BPlusTreeOptions{NodeCodec: NodeCodecFlate})
+		if nil != err {
+			t.Fatalf("NewBPlusTreeWithOptions() failed: %v", err)
+		}
+		btree.(*btreeTreeStruct).onDiskNodeVersion = onDiskNodeVersion
+
+		testFormatPutKeys(t, btree, 0, 50) // a single (compressible) leaf root node
+
+		rootObjectNumber, rootObjectOffset, rootObjectLength, err := btree.Flush(true)
+		if nil != err {
+			t.Fatalf("btree.Flush(true) [version %v] failed: %v", onDiskNodeVersion, err)
+		}
+
+		btree, err = OldBPlusTree(rootObjectNumber, rootObjectOffset, rootObjectLength, CompareUint32, persistentContext, nil)
+		if nil != err {
+			t.Fatalf("OldBPlusTree() [version %v] failed: %v", onDiskNodeVersion, err)
+		}
+
+		testFormatVerifyKeys(t, btree, 50)
+	}
+
+	// Understating the recorded raw length of an onDiskNodeVersion2 node must bound its decoding
+
+	persistentContext := &specificBPlusTreeTestContextStruct{t: t, lastLogSegmentNumberGenerated: 0, lastLogOffsetGenerated: 0, logSegmentChunkMap: make(map[uint64]*logSegmentChunkStruct)}
+
+	btree, err := NewBPlusTreeWithOptions(100, CompareUint32, persistentContext, nil, &BPlusTreeOptions{NodeCodec: NodeCodecFlate})
+	if nil != err {
+		t.Fatalf("NewBPlusTreeWithOptions() failed: %v", err)
+	}
+
+	testFormatPutKeys(t, btree, 0, 50)
+
+	rootObjectNumber, rootObjectOffset, rootObjectLength, err := btree.Flush(true)
+	if nil != err {
+		t.Fatalf("btree.Flush(true) failed: %v", err)
+	}
+
+	rootNodeByteSlice := persistentContext.logSegmentChunkMap[rootObjectNumber].chunkByteSlice
+	if 0 == (rootNodeByteSlice[len(onDiskNodeMagic)+1] & onDiskNodeFlagCodec) {
+		t.Fatalf("root node should have been encoded by NodeCodecFlate")
+	}
+
+	rawLengthOffset := onDiskNodeHeaderSize + onDiskNodeExtHeaderSize + onDiskNodeCodecSize
+	OnDiskByteOrder.PutUint64(rootNodeByteSlice[rawLengthOffset:], OnDiskByteOrder.Uint64(rootNodeByteSlice[rawLengthOffset:])-1)
+
+	_, err = OldBPlusTree(rootObjectNumber, rootObjectOffset, rootObjectLength, CompareUint32, persistentContext, nil)
+	if !errors.Is(err, ErrCorruptNode) {
+		t.Fatalf("OldBPlusTree() of node with understated raw length should have failed with ErrCorruptNode (got %v)", err)
+	}
+}
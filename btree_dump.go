@@ -142,6 +142,11 @@ func (tree *btreeTreeStruct) dumpNode(node *btreeNodeStruct, indent string) (err
 			err = getByIndexErr
 			return
 		}
+		key, nonShadowingErr := tree.decodeKey(key)
+		if nil != nonShadowingErr {
+			err = nonShadowingErr
+			return
+		}
 		keyAsString, nonShadowingErr := tree.DumpKey(key)
 		if nil != nonShadowingErr {
 			err = nonShadowingErr
@@ -149,6 +154,10 @@ func (tree *btreeTreeStruct) dumpNode(node *btreeNodeStruct, indent string) (err
 		}
 		fmt.Printf("%v  .kvLLRB[%v].Key       = %v\n", indent, i, keyAsString)
 		if node.leaf {
+			if valueLogReference, ok := value.(valueLogReferenceStruct); ok {
+				fmt.Printf("%v  .kvLLRB[%v].Value     = logged @ 0x%016x/0x%016x (0x%016x bytes)\n", indent, i, valueLogReference.objectNumber, valueLogReference.objectOffset, valueLogReference.objectLength)
+				continue
+			}
 			valueAsString, nonShadowingErr := tree.DumpValue(value)
 			if nil != nonShadowingErr {
 				err = nonShadowingErr
@@ -0,0 +1,197 @@
+// Copyright (c) 2015-2021, NVIDIA CORPORATION.
+// SPDX-License-Identifier: Apache-2.0
+
+package sortedmap
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/NVIDIA/cstruct"
+)
+
+// NodeKeyring specifies the interface to the AES keys used to encrypt and decrypt posted nodes
+//
+// Each posted node records the keyID of the key used to encrypt it. New nodes are always
+// encrypted using the key identified by CurrentKeyID(). Hence, after changing the value
+// returned by CurrentKeyID(), Touch() or TouchItem() may be used to re-encrypt existing
+// nodes such that the prior key may eventually be retired.
+type NodeKeyring interface {
+	CurrentKeyID() (keyID uint32)
+	GetKey(keyID uint32) (key []byte, err error) // key must be 16, 24, or 32 bytes long selecting AES-128, AES-192, or AES-256
+}
+
+// BPlusTreeNodeLocationCallbacks may optionally be implemented by a BPlusTreeCallbacks
+//
+// NextNodeLocation() must return the objectNumber and objectOffset that the immediately
+// following PutNode() call will return. This enables the location of a node to be bound
+// to its encrypted form prior to it being posted so that nodes cannot be swapped. It is
+// required if BPlusTreeOptions.NodeKeyring is specified.
+//
+// As a B+Tree calls NextNodeLocation() and then PutNode() while holding only its own lock,
+// callbacks shared with other B+Trees (or other writers) must themselves hold a common
+// lock across that pair of calls (e.g. acquired in NextNodeLocation() and released in
+// PutNode()). Should PutNode() nevertheless return a different location, the just posted
+// node is passed to DiscardNode(), an error is returned, and the node remains dirty such
+// that a subsequent Flush() may retry.
+type BPlusTreeNodeLocationCallbacks interface {
+	NextNodeLocation() (objectNumber uint64, objectOffset uint64, err error)
+}
+
+type onDiskNodeEncryptionStruct struct {
+	KeyID uint32
+	Nonce [12]uint8
+}
+
+type onDiskNodeLocationStruct struct {
+	ObjectNumber uint64
+	ObjectOffset uint64
+}
+
+// fetchNodeAEAD returns the (cached) cipher.AEAD for the key identified by keyID
+//
+// Entries in tree.nodeAEADs are never evicted. As one is added per distinct keyID used to
+// encrypt a loaded or posted node, its growth is proportional to the number of keys ever
+// in use (by this B+Tree) rather than to the number of nodes.
+func (tree *btreeTreeStruct) fetchNodeAEAD(keyID uint32) (nodeAEAD cipher.AEAD, err error) {
+	var (
+		key       []byte
+		nodeBlock cipher.Block
+		ok        bool
+	)
+
+	nodeAEAD, ok = tree.nodeAEADs[keyID]
+	if ok {
+		err = nil
+		return
+	}
+
+	key, err = tree.nodeKeyring.GetKey(keyID)
+	if nil != err {
+		return
+	}
+
+	nodeBlock, err = aes.NewCipher(key)
+	if nil != err {
+		return
+	}
+
+	nodeAEAD, err = cipher.NewGCM(nodeBlock)
+	if nil != err {
+		return
+	}
+
+	if nil == tree.nodeAEADs {
+		tree.nodeAEADs = make(map[uint32]cipher.AEAD)
+	}
+
+	tree.nodeAEADs[keyID] = nodeAEAD
+
+	err = nil
+	return
+}
+
+// nodeAdditionalData binds a node's location as well as all header bytes preceding the ciphertext
+func nodeAdditionalData(byteOrder binary.ByteOrder, objectNumber uint64, objectOffset uint64, nodeHeaderBuf []byte) (additionalData []byte, err error) {
+	onDiskNodeLocation := onDiskNodeLocationStruct{
+		ObjectNumber: objectNumber,
+		ObjectOffset: objectOffset,
+	}
+
+	additionalData, err = cstruct.Pack(onDiskNodeLocation, byteOrder)
+	if nil != err {
+		return
+	}
+
+	additionalData = append(additionalData, nodeHeaderBuf...)
+
+	err = nil
+	return
+}
+
+func (tree *btreeTreeStruct) encryptNode(byteOrder binary.ByteOrder, nodeHeaderBuf []byte, plaintext []byte) (nodeByteSlice []byte, boundLocation *onDiskNodeLocationStruct, err error) {
+	var (
+		additionalData      []byte
+		nodeAEAD            cipher.AEAD
+		onDiskNodeEncBuf    []byte
+		onDiskNodeEncrypted onDiskNodeEncryptionStruct
+	)
+
+	onDiskNodeEncrypted.KeyID = tree.nodeKeyring.CurrentKeyID()
+
+	nodeAEAD, err = tree.fetchNodeAEAD(onDiskNodeEncrypted.KeyID)
+	if nil != err {
+		return
+	}
+
+	_, err = rand.Read(onDiskNodeEncrypted.Nonce[:])
+	if nil != err {
+		return
+	}
+
+	onDiskNodeEncBuf, err = cstruct.Pack(onDiskNodeEncrypted, byteOrder)
+	if nil != err {
+		return
+	}
+
+	nodeByteSlice = append(nodeHeaderBuf, onDiskNodeEncBuf...)
+
+	boundLocation = &onDiskNodeLocationStruct{}
+
+	boundLocation.ObjectNumber, boundLocation.ObjectOffset, err = tree.nodeLocator.NextNodeLocation()
+	if nil != err {
+		return
+	}
+
+	additionalData, err = nodeAdditionalData(byteOrder, boundLocation.ObjectNumber, boundLocation.ObjectOffset, nodeByteSlice)
+	if nil != err {
+		return
+	}
+
+	nodeByteSlice = nodeAEAD.Seal(nodeByteSlice, onDiskNodeEncrypted.Nonce[:], plaintext, additionalData)
+
+	err = nil
+	return
+}
+
+func (tree *btreeTreeStruct) decryptNode(byteOrder binary.ByteOrder, node *btreeNodeStruct, nodeHeaderBuf []byte, onDiskNodeBuf []byte) (plaintext []byte, err error) {
+	var (
+		additionalData      []byte
+		bytesConsumed       uint64
+		nodeAEAD            cipher.AEAD
+		onDiskNodeEncrypted onDiskNodeEncryptionStruct
+	)
+
+	if nil == tree.nodeKeyring {
+		err = fmt.Errorf("%w: node @ ObjectNumber 0x%016X ObjectOffset 0x%016X is encrypted but no NodeKeyring was supplied", ErrOptionMismatch, node.objectNumber, node.objectOffset)
+		return
+	}
+
+	bytesConsumed, err = cstruct.Unpack(onDiskNodeBuf, &onDiskNodeEncrypted, byteOrder)
+	if nil != err {
+		err = node.corruptNodeError("%v", err)
+		return
+	}
+
+	nodeAEAD, err = tree.fetchNodeAEAD(onDiskNodeEncrypted.KeyID)
+	if nil != err {
+		return
+	}
+
+	additionalData, err = nodeAdditionalData(byteOrder, node.objectNumber, node.objectOffset, append(nodeHeaderBuf[:len(nodeHeaderBuf):len(nodeHeaderBuf)], onDiskNodeBuf[:bytesConsumed]...))
+	if nil != err {
+		return
+	}
+
+	plaintext, err = nodeAEAD.Open(nil, onDiskNodeEncrypted.Nonce[:], onDiskNodeBuf[bytesConsumed:], additionalData)
+	if nil != err {
+		err = node.corruptNodeError("decryption (KeyID %v) failed: %v", onDiskNodeEncrypted.KeyID, err)
+		return
+	}
+
+	err = nil
+	return
+}
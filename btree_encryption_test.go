@@ -0,0 +1,224 @@
+// Copyright (c) 2015-2021, NVIDIA CORPORATION.
+// SPDX-License-Identifier: Apache-2.0
+
+package sortedmap
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+type encryptionTestKeyringStruct struct {
+	currentKeyID uint32
+	keys         map[uint32][]byte
+}
+
+func (keyring *encryptionTestKeyringStruct) CurrentKeyID() (keyID uint32) {
+	keyID = keyring.currentKeyID
+	return
+}
+
+func (keyring *encryptionTestKeyringStruct) GetKey(keyID uint32) (key []byte, err error) {
+	key, ok := keyring.keys[keyID]
+	if !ok {
+		err = fmt.Errorf("keyID %v not found", keyID)
+		return
+	}
+	err = nil
+	return
+}
+
+type encryptionTestContextStruct struct {
+	*specificBPlusTreeTestContextStruct
+	mispredictions int // if > 0, the number of subsequent NextNodeLocation() calls that should be wrong
+}
+
+func (context *encryptionTestContextStruct) NextNodeLocation() (logSegmentNumber uint64, logOffset uint64, err error) {
+	logSegmentNumber = context.lastLogSegmentNumberGenerated + 1
+	logOffset = 0
+	if 0 < context.mispredictions {
+		context.mispredictions--
+		logSegmentNumber++
+	}
+	err = nil
+	return
+}
+
+func (context *encryptionTestContextStruct) PutNode(nodeByteSlice []byte) (logSegmentNumber uint64, logOffset uint64, err error) {
+	context.lastLogSegmentNumberGenerated++
+	logSegmentNumber = context.lastLogSegmentNumberGenerated
+	logOffset = 0
+
+	context.logSegmentChunkMap[logSegmentNumber] = &logSegmentChunkStruct{
+		startingOffset: logOffset,
+		chunkByteSlice: nodeByteSlice,
+	}
+
+	err = nil
+	return
+}
+
+func TestBPlusTreeEncryption(t *testing.T) {
+	var (
+		btree             BPlusTree
+		err               error
+		keyring           *encryptionTestKeyringStruct
+		options           *BPlusTreeOptions
+		persistentContext *encryptionTestContextStruct
+		plaintextContext  *specificBPlusTreeTestContextStruct
+		rootObjectLength  uint64
+		rootObjectNumber  uint64
+		rootObjectOffset  uint64
+		swappedNumbers    []uint64
+	)
+
+	keyring = &encryptionTestKeyringStruct{
+		currentKeyID: 1,
+		keys: map[uint32][]byte{
+			1: bytes.Repeat([]byte{0x11}, 32),
+			2: bytes.Repeat([]byte{0x22}, 16),
+		},
+	}
+
+	persistentContext = &encryptionTestContextStruct{specificBPlusTreeTestContextStruct: &specificBPlusTreeTestContextStruct{t: t, lastLogSegmentNumberGenerated: 0, lastLogOffsetGenerated: 0, logSegmentChunkMap: make(map[uint64]*logSegmentChunkStruct)}}
+
+	options = &BPlusTreeOptions{NodeKeyring: keyring}
+
+	// Callbacks lacking NextNodeLocation() cannot support encryption
+
+	_, err = OldBPlusTreeWithOptions(1, 0, 0, CompareUint32, persistentContext.specificBPlusTreeTestContextStruct, nil, options)
+	if nil == err {
+		t.Fatalf("OldBPlusTreeWithOptions() [case 0] should have failed")
+	}
+
+	// Round trip
+
+	btree, err = NewBPlusTreeWithOptions(specificBPlusTreeTestNumKeysMaxSmall, CompareUint32, persistentContext, nil, options)
+	if nil != err {
+		t.Fatalf("NewBPlusTreeWithOptions() failed: %v", err)
+	}
+
+	testFormatPutKeys(t, btree, 0, 100)
+
+	// A mispredicted NextNodeLocation() fails the Flush() without leaking the posted node
+
+	persistentContext.mispredictions = 1
+
+	_, _, _, err = btree.Flush(false)
+	if nil == err {
+		t.Fatalf("btree.Flush(false) [case 1] with mispredicted NextNodeLocation() should have failed")
+	}
+	if 0 != len(persistentContext.logSegmentChunkMap) {
+		t.Fatalf("btree.Flush(false) [case 1] with mispredicted NextNodeLocation() should have discarded the posted node")
+	}
+
+	rootObjectNumber, rootObjectOffset, rootObjectLength, err = btree.Flush(true)
+	if nil != err {
+		t.Fatalf("btree.Flush(true) [case 1] failed: %v", err)
+	}
+
+	plaintextValue := uint32To8ReplicaByteArray(42)
+
+	for _, logSegmentChunk := range persistentContext.logSegmentChunkMap {
+		if bytes.Contains(logSegmentChunk.chunkByteSlice, plaintextValue[:]) {
+			t.Fatalf("posted node [case 1] should not have contained plaintext value")
+		}
+	}
+
+	btree, err = OldBPlusTreeWithOptions(rootObjectNumber, rootObjectOffset, rootObjectLength, CompareUint32, persistentContext, nil, options)
+	if nil != err {
+		t.Fatalf("OldBPlusTreeWithOptions() [case 1] failed: %v", err)
+	}
+
+	testFormatVerifyKeys(t, btree, 100)
+
+	// Key rotation
+
+	keyring.currentKeyID = 2
+
+	err = btree.Touch()
+	if nil != err {
+		t.Fatalf("btree.Touch() failed: %v", err)
+	}
+
+	rootObjectNumber, rootObjectOffset, rootObjectLength, err = btree.Flush(true)
+	if nil != err {
+		t.Fatalf("btree.Flush(true) [case 2] failed: %v", err)
+	}
+	err = btree.Prune()
+	if nil != err {
+		t.Fatalf("btree.Prune() [case 2] failed: %v", err)
+	}
+
+	delete(keyring.keys, 1)
+
+	btree, err = OldBPlusTreeWithOptions(rootObjectNumber, rootObjectOffset, rootObjectLength, CompareUint32, persistentContext, nil, options)
+	if nil != err {
+		t.Fatalf("OldBPlusTreeWithOptions() [case 2] failed: %v", err)
+	}
+
+	testFormatVerifyKeys(t, btree, 100)
+
+	// Swapping two (equally sized) non-root nodes must be detected
+
+	lengthMap := make(map[int]uint64)
+
+	for logSegmentNumber, logSegmentChunk := range persistentContext.logSegmentChunkMap {
+		if logSegmentNumber == rootObjectNumber {
+			continue
+		}
+		otherLogSegmentNumber, ok := lengthMap[len(logSegmentChunk.chunkByteSlice)]
+		if ok {
+			swappedNumbers = []uint64{logSegmentNumber, otherLogSegmentNumber}
+			break
+		}
+		lengthMap[len(logSegmentChunk.chunkByteSlice)] = logSegmentNumber
+	}
+	if nil == swappedNumbers {
+		t.Fatalf("failed to find two equally sized non-root nodes to swap")
+	}
+
+	persistentContext.logSegmentChunkMap[swappedNumbers[0]], persistentContext.logSegmentChunkMap[swappedNumbers[1]] =
+		persistentContext.logSegmentChunkMap[swappedNumbers[1]], persistentContext.logSegmentChunkMap[swappedNumbers[0]]
+
+	btree, err = OldBPlusTreeWithOptions(rootObjectNumber, rootObjectOffset, rootObjectLength, CompareUint32, persistentContext, nil, options)
+	if nil != err {
+		t.Fatalf("OldBPlusTreeWithOptions() [case 3] failed: %v", err)
+	}
+
+	err = btree.Validate()
+	if !errors.Is(err, ErrCorruptNode) {
+		t.Fatalf("btree.Validate() [case 3] should have failed with ErrCorruptNode (got %v)", err)
+	}
+
+	// Unencrypted nodes are rejected unless AllowUnencryptedNodes is specified
+
+	plaintextContext = &specificBPlusTreeTestContextStruct{t: t, lastLogSegmentNumberGenerated: 0, lastLogOffsetGenerated: 0, logSegmentChunkMap: make(map[uint64]*logSegmentChunkStruct)}
+
+	btree = NewBPlusTree(specificBPlusTreeTestNumKeysMaxSmall, CompareUint32, plaintextContext, nil)
+
+	testFormatPutKeys(t, btree, 0, 100)
+
+	rootObjectNumber, rootObjectOffset, rootObjectLength, err = btree.Flush(true)
+	if nil != err {
+		t.Fatalf("btree.Flush(true) [case 4] failed: %v", err)
+	}
+
+	persistentContext.specificBPlusTreeTestContextStruct = plaintextContext
+
+	_, err = OldBPlusTreeWithOptions(rootObjectNumber, rootObjectOffset, rootObjectLength, CompareUint32, persistentContext, nil, options)
+	if nil == err {
+		t.Fatalf("OldBPlusTreeWithOptions() [case 4] should have failed")
+	}
+
+	options.AllowUnencryptedNodes = true
+
+	btree, err = OldBPlusTreeWithOptions(rootObjectNumber, rootObjectOffset, rootObjectLength, CompareUint32, persistentContext, nil, options)
+	if nil != err {
+		t.Fatalf("OldBPlusTreeWithOptions() [case 5] failed: %v", err)
+	}
+
+	testFormatVerifyKeys(t, btree, 100)
+}
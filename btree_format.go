@@ -0,0 +1,577 @@
+// Copyright (c) 2015-2021, NVIDIA CORPORATION.
+// SPDX-License-Identifier: Apache-2.0
+
+package sortedmap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/NVIDIA/cstruct"
+)
+
+// On-disk node framing
+//
+// Originally, a posted node consisted solely of a packed onDiskNodeStruct (followed by
+// a checksum if the B+Tree was configured with a NodeChecksum). This format is now termed
+// onDiskNodeVersionLegacy. Beginning with onDiskNodeVersion1, every posted node starts
+// with an onDiskNodeHeaderStruct whose Magic distinguishes it from the legacy format (the
+// leading uint64 Items of a legacy node could never plausibly match it) and whose Flags
+// describe how the remainder of the node was encoded. As all eight Flags were then in use,
+// onDiskNodeVersion2 follows the onDiskNodeHeaderStruct with an onDiskNodeExtHeaderStruct
+// providing eight more ExtFlags. It also records the raw length of a node encoded by a
+// NodeCodec (see onDiskNodeCodecV2Struct) to bound its decoding:
+//
+//	onDiskNodeHeaderStruct
+//	onDiskNodeExtHeaderStruct   (if Version >= onDiskNodeVersion2)
+//	onDiskNodeCodecStruct       (if Flags & onDiskNodeFlagCodec and Version == onDiskNodeVersion1)
+//	onDiskNodeCodecV2Struct     (if Flags & onDiskNodeFlagCodec and Version >= onDiskNodeVersion2)
+//	onDiskNodeEncryptionStruct  (if Flags & onDiskNodeFlagEncrypted)
+//	packed onDiskNodeStruct     (encoded by the indicated NodeCodec if Flags & onDiskNodeFlagCodec)
+//	                            (then sealed by AES-GCM if Flags & onDiskNodeFlagEncrypted)
+//	checksum                    (if Flags & onDiskNodeFlagChecksum) computed over all preceding bytes
+//
+// As the Flags only record the presence of a checksum (not the NodeChecksum that computed it),
+// a checksummed node can only be loaded by a B+Tree supplied the same NodeChecksum. Conversely,
+// a B+Tree supplied a NodeChecksum rejects nodes lacking a checksum (as a corrupted Flags
+// could otherwise bypass verification) unless BPlusTreeOptions.AllowMissingChecksums is set.
+// Legacy nodes cannot record the presence of a checksum at all, so a B+Tree decides this once:
+// they are checksummed if a NodeChecksum is supplied without AllowMissingChecksums, and not
+// otherwise (such a B+Tree always posts dirtied nodes in the current on-disk format).
+//
+// When encrypted, the additional data authenticated along with the sealed onDiskNodeStruct
+// is the node's objectNumber & objectOffset followed by all bytes preceding the ciphertext.
+//
+// Other Flags (see onDiskNodeFlagsPayload) describe how the onDiskNodeStruct and its Payload
+// were themselves packed and are simply passed along to loadNode().
+//
+// ExtFlags (see onDiskNodeExtFlagsPayload) record what each child reference in a non-leaf
+// node's Payload is followed by. As that depends on the BPlusTreeOptions a B+Tree was posted
+// with, a node whose ExtFlags disagree with those supplied to OldBPlusTree() fails to load
+// with an error matching ErrOptionMismatch. Nodes lacking an onDiskNodeExtHeaderStruct are
+// treated as having no ExtFlags set.
+//
+// Readers reject any version or flag they do not understand.
+
+type onDiskNodeHeaderStruct struct {
+	Magic   [8]uint8
+	Version uint8
+	Flags   uint8
+}
+
+type onDiskNodeExtHeaderStruct struct {
+	ExtFlags uint8
+}
+
+type onDiskNodeCodecStruct struct {
+	CodecID uint8
+}
+
+type onDiskNodeCodecV2Struct struct {
+	CodecID   uint8
+	RawLength uint64 // length of the packed onDiskNodeStruct prior to encoding
+}
+
+type onDiskUint32Struct struct {
+	U32 uint32
+}
+
+const (
+	onDiskNodeVersionLegacy  = uint8(0)
+	onDiskNodeVersion1       = uint8(1)
+	onDiskNodeVersion2       = uint8(2)
+	onDiskNodeVersionCurrent = onDiskNodeVersion2
+)
+
+const (
+	onDiskNodeFlagChecksum   = uint8(0x01) // node is followed by a checksum
+	onDiskNodeFlagCodec      = uint8(0x02) // packed onDiskNodeStruct has been encoded by a NodeCodec
+	onDiskNodeFlagEncrypted  = uint8(0x04) // (possibly encoded) packed onDiskNodeStruct has been encrypted
+	onDiskNodeFlagKeyPrefix  = uint8(0x08) // Keys in Payload are front-coded (see packNodeKey())
+	onDiskNodeFlagVarint     = uint8(0x10) // integers in onDiskNodeStruct & Payload are varint-encoded (see packNodeUint64())
+	onDiskNodeFlagBigEndian  = uint8(0x20) // all fixed-size integers following onDiskNodeHeaderStruct are big-endian
+	onDiskNodeFlagNodeBytes  = uint8(0x40) // Root node Payload records minNodeBytes & maxNodeBytes following maxKeysPerNode
+	onDiskNodeFlagValueLog   = uint8(0x80) // Values in leaf node Payload are preceded by a marker & may be logged (see packNodeValue())
+	onDiskNodeFlagsPayload   = onDiskNodeFlagKeyPrefix | onDiskNodeFlagVarint | onDiskNodeFlagBigEndian | onDiskNodeFlagNodeBytes | onDiskNodeFlagValueLog
+	onDiskNodeFlagsSupported = onDiskNodeFlagChecksum | onDiskNodeFlagCodec | onDiskNodeFlagEncrypted | onDiskNodeFlagsPayload
+)
+
+const (
+	onDiskNodeExtFlagAggregates = uint8(0x01) // child references in non-leaf node Payload are followed by an Aggregate (see packNodeAggregate())
+	onDiskNodeExtFlagWeights    = uint8(0x02) // child references in non-leaf node Payload are followed by a weight (see packNodeWeight())
+	onDiskNodeExtFlagsPayload   = onDiskNodeExtFlagAggregates | onDiskNodeExtFlagWeights
+	onDiskNodeExtFlagsSupported = onDiskNodeExtFlagsPayload
+)
+
+const (
+	onDiskNodeHeaderSize         = 10
+	onDiskNodeFlagsOffset        = 9 // offset of Flags within onDiskNodeHeaderStruct
+	onDiskNodeExtHeaderSize      = 1
+	onDiskNodeCodecSize          = 1
+	onDiskNodeCodecV2Size        = 9
+	onDiskNodeEncryptionSize     = 16
+	onDiskNodeEncryptionOverhead = 16 // AES-GCM tag
+	onDiskUint32Size             = 4
+)
+
+var onDiskNodeMagic = [8]uint8{'S', 'M', 'B', '+', 'T', 'r', 'e', 'e'}
+
+// nodeByteOrder returns the byte order indicated by onDiskNodeFlagBigEndian
+func nodeByteOrder(payloadFlags uint8) (byteOrder binary.ByteOrder) {
+	if 0 == (payloadFlags & onDiskNodeFlagBigEndian) {
+		byteOrder = cstruct.LittleEndian
+	} else {
+		byteOrder = cstruct.BigEndian
+	}
+	return
+}
+
+// byteOrderFlags returns the onDiskNodeFlagBigEndian setting for the B+Tree's byte order
+func (tree *btreeTreeStruct) byteOrderFlags() (payloadFlags uint8) {
+	if cstruct.BigEndian == tree.onDiskByteOrder {
+		payloadFlags = onDiskNodeFlagBigEndian
+	} else {
+		payloadFlags = 0
+	}
+	return
+}
+
+// payloadFlags returns the onDiskNodeFlagsPayload that postNode() should apply
+//
+// Note that legacy nodes, lacking an onDiskNodeHeaderStruct, cannot record any of these
+// other than (implicitly) the B+Tree's byte order.
+func (tree *btreeTreeStruct) payloadFlags() (payloadFlags uint8) {
+	payloadFlags = tree.byteOrderFlags()
+
+	if onDiskNodeVersionLegacy == tree.onDiskNodeVersion {
+		return
+	}
+
+	if tree.keyPrefixCompression {
+		payloadFlags |= onDiskNodeFlagKeyPrefix
+	}
+
+	if tree.varintEncoding {
+		payloadFlags |= onDiskNodeFlagVarint
+	}
+
+	if tree.byteBounded() {
+		payloadFlags |= onDiskNodeFlagNodeBytes
+	}
+
+	if tree.valueLogged() {
+		payloadFlags |= onDiskNodeFlagValueLog
+	}
+
+	return
+}
+
+// payloadExtFlags returns the onDiskNodeExtFlagsPayload that postNode() should apply
+//
+// Note that only onDiskNodeVersion2 (or later) nodes can record any of these.
+func (tree *btreeTreeStruct) payloadExtFlags() (payloadExtFlags uint8) {
+	payloadExtFlags = 0
+
+	if onDiskNodeVersion2 > tree.onDiskNodeVersion {
+		return
+	}
+
+	if tree.aggregated() {
+		payloadExtFlags |= onDiskNodeExtFlagAggregates
+	}
+
+	if tree.weighted() {
+		payloadExtFlags |= onDiskNodeExtFlagWeights
+	}
+
+	return
+}
+
+// checkPayloadExtFlags verifies that a loaded node's onDiskNodeExtFlagsPayload match the B+Tree's options
+func (tree *btreeTreeStruct) checkPayloadExtFlags(node *btreeNodeStruct, payloadExtFlags uint8) (err error) {
+	if tree.aggregated() != (0 != (payloadExtFlags & onDiskNodeExtFlagAggregates)) {
+		if tree.aggregated() {
+			err = fmt.Errorf("%w: node @ ObjectNumber 0x%016X ObjectOffset 0x%016X does not record child Aggregates but an Aggregator was supplied", ErrOptionMismatch, node.objectNumber, node.objectOffset)
+		} else {
+			err = fmt.Errorf("%w: node @ ObjectNumber 0x%016X ObjectOffset 0x%016X records child Aggregates but no Aggregator was supplied", ErrOptionMismatch, node.objectNumber, node.objectOffset)
+		}
+		return
+	}
+
+	if tree.weighted() != (0 != (payloadExtFlags & onDiskNodeExtFlagWeights)) {
+		if tree.weighted() {
+			err = fmt.Errorf("%w: node @ ObjectNumber 0x%016X ObjectOffset 0x%016X does not record child weights but a Weigher was supplied", ErrOptionMismatch, node.objectNumber, node.objectOffset)
+		} else {
+			err = fmt.Errorf("%w: node @ ObjectNumber 0x%016X ObjectOffset 0x%016X records child weights but no Weigher was supplied", ErrOptionMismatch, node.objectNumber, node.objectOffset)
+		}
+		return
+	}
+
+	err = nil
+	return
+}
+
+// encodedNodeLength returns the length encodeNode() would produce for rawLength bytes absent a NodeCodec
+//
+// As encodeNode() only applies a NodeCodec if doing so shortens the node, this also bounds its
+// length otherwise.
+func (tree *btreeTreeStruct) encodedNodeLength(rawLength uint64) (encodedLength uint64) {
+	encodedLength = rawLength
+
+	if nil != tree.nodeChecksum {
+		encodedLength += onDiskUint32Size
+	}
+
+	if onDiskNodeVersionLegacy == tree.onDiskNodeVersion {
+		return
+	}
+
+	encodedLength += onDiskNodeHeaderSize
+
+	if onDiskNodeVersion2 <= tree.onDiskNodeVersion {
+		encodedLength += onDiskNodeExtHeaderSize
+	}
+
+	if nil != tree.nodeKeyring {
+		encodedLength += onDiskNodeEncryptionSize + onDiskNodeEncryptionOverhead
+	}
+
+	return
+}
+
+func (tree *btreeTreeStruct) encodeNode(onDiskNodeBuf []byte, payloadFlags uint8) (nodeByteSlice []byte, boundLocation *onDiskNodeLocationStruct, err error) {
+	var (
+		encodedOnDiskNodeBuf   []byte
+		onDiskNodeCodecBuf     []byte
+		onDiskNodeCodecID      uint8
+		codecStructSize        int
+		onDiskNodeExtHeaderBuf []byte
+		onDiskNodeHeaderBuf    []byte
+	)
+
+	byteOrder := nodeByteOrder(payloadFlags)
+	rawLength := uint64(len(onDiskNodeBuf))
+
+	if onDiskNodeVersionLegacy == tree.onDiskNodeVersion {
+		nodeByteSlice = onDiskNodeBuf
+
+		if nil != tree.nodeChecksum {
+			nodeByteSlice, err = appendNodeChecksum(tree.nodeChecksum, byteOrder, nodeByteSlice)
+			if nil != err {
+				return
+			}
+		}
+
+		err = nil
+		return
+	}
+
+	onDiskNodeHeader := onDiskNodeHeaderStruct{
+		Magic:   onDiskNodeMagic,
+		Version: tree.onDiskNodeVersion,
+		Flags:   payloadFlags,
+	}
+
+	if nil != tree.nodeChecksum {
+		onDiskNodeHeader.Flags |= onDiskNodeFlagChecksum
+	}
+
+	if nil != tree.nodeKeyring {
+		onDiskNodeHeader.Flags |= onDiskNodeFlagEncrypted
+	}
+
+	if onDiskNodeVersion2 <= tree.onDiskNodeVersion {
+		codecStructSize = onDiskNodeCodecV2Size
+	} else {
+		codecStructSize = onDiskNodeCodecSize
+	}
+
+	if (nil != tree.nodeCodec) && (NodeCodecIDNone != tree.nodeCodec.CodecID()) {
+		encodedOnDiskNodeBuf, err = tree.nodeCodec.Encode(onDiskNodeBuf)
+		if nil != err {
+			return
+		}
+
+		// Only retain the encoded form if it actually saves space
+
+		if (len(encodedOnDiskNodeBuf) + codecStructSize) < len(onDiskNodeBuf) {
+			onDiskNodeHeader.Flags |= onDiskNodeFlagCodec
+			onDiskNodeCodecID = tree.nodeCodec.CodecID()
+			onDiskNodeBuf = encodedOnDiskNodeBuf
+		}
+	}
+
+	onDiskNodeHeaderBuf, err = cstruct.Pack(onDiskNodeHeader, byteOrder)
+	if nil != err {
+		return
+	}
+
+	nodeByteSlice = make([]byte, 0, len(onDiskNodeHeaderBuf)+onDiskNodeExtHeaderSize+codecStructSize+onDiskNodeEncryptionSize+len(onDiskNodeBuf)+onDiskNodeEncryptionOverhead+onDiskUint32Size)
+	nodeByteSlice = append(nodeByteSlice, onDiskNodeHeaderBuf...)
+
+	if onDiskNodeVersion2 <= tree.onDiskNodeVersion {
+		onDiskNodeExtHeaderBuf, err = cstruct.Pack(onDiskNodeExtHeaderStruct{ExtFlags: tree.payloadExtFlags()}, byteOrder)
+		if nil != err {
+			return
+		}
+
+		nodeByteSlice = append(nodeByteSlice, onDiskNodeExtHeaderBuf...)
+	}
+
+	if 0 != (onDiskNodeHeader.Flags & onDiskNodeFlagCodec) {
+		if onDiskNodeVersion2 <= tree.onDiskNodeVersion {
+			onDiskNodeCodecBuf, err = cstruct.Pack(onDiskNodeCodecV2Struct{CodecID: onDiskNodeCodecID, RawLength: rawLength}, byteOrder)
+		} else {
+			onDiskNodeCodecBuf, err = cstruct.Pack(onDiskNodeCodecStruct{CodecID: onDiskNodeCodecID}, byteOrder)
+		}
+		if nil != err {
+			return
+		}
+
+		nodeByteSlice = append(nodeByteSlice, onDiskNodeCodecBuf...)
+	}
+
+	if 0 == (onDiskNodeHeader.Flags & onDiskNodeFlagEncrypted) {
+		nodeByteSlice = append(nodeByteSlice, onDiskNodeBuf...)
+	} else {
+		nodeByteSlice, boundLocation, err = tree.encryptNode(byteOrder, nodeByteSlice, onDiskNodeBuf)
+		if nil != err {
+			return
+		}
+	}
+
+	if 0 != (onDiskNodeHeader.Flags & onDiskNodeFlagChecksum) {
+		nodeByteSlice, err = appendNodeChecksum(tree.nodeChecksum, byteOrder, nodeByteSlice)
+		if nil != err {
+			return
+		}
+	}
+
+	err = nil
+	return
+}
+
+func (tree *btreeTreeStruct) decodeNode(node *btreeNodeStruct, nodeByteSlice []byte) (onDiskNodeBuf []byte, onDiskNodeVersion uint8, payloadFlags uint8, err error) {
+	var (
+		bytesConsumed       uint64
+		nodeCodec           NodeCodec
+		ok                  bool
+		onDiskNodeCodec     onDiskNodeCodecV2Struct
+		onDiskNodeCodecV1   onDiskNodeCodecStruct
+		onDiskNodeExtHeader onDiskNodeExtHeaderStruct
+		onDiskNodeHeader    onDiskNodeHeaderStruct
+	)
+
+	if (onDiskNodeHeaderSize > len(nodeByteSlice)) || !bytes.Equal(nodeByteSlice[:len(onDiskNodeMagic)], onDiskNodeMagic[:]) {
+		onDiskNodeVersion = onDiskNodeVersionLegacy
+		payloadFlags = tree.byteOrderFlags()
+
+		if tree.requireEncryptedNodes {
+			err = node.corruptNodeError("legacy node is not encrypted")
+			return
+		}
+
+		// Legacy nodes cannot indicate the presence of a checksum, so that is decided once per B+Tree
+
+		if tree.legacyNodesChecksummed {
+			onDiskNodeBuf, err = verifyNodeChecksum(tree.nodeChecksum, nodeByteOrder(payloadFlags), node, nodeByteSlice)
+			if nil != err {
+				return
+			}
+		} else {
+			if (nil != tree.nodeChecksum) && checksumVerifies(tree.nodeChecksum, nodeByteOrder(payloadFlags), nodeByteSlice) {
+				err = fmt.Errorf("%w: legacy node @ ObjectNumber 0x%016X ObjectOffset 0x%016X is checksummed but AllowMissingChecksums treats legacy nodes as lacking one", ErrOptionMismatch, node.objectNumber, node.objectOffset)
+				return
+			}
+
+			onDiskNodeBuf = nodeByteSlice
+		}
+
+		err = tree.checkPayloadExtFlags(node, 0)
+		return
+	}
+
+	_, err = cstruct.Unpack(nodeByteSlice, &onDiskNodeHeader, cstruct.LittleEndian) // onDiskNodeHeaderStruct contains no multi-byte integers
+	if nil != err {
+		err = node.corruptNodeError("%v", err)
+		return
+	}
+
+	onDiskNodeVersion = onDiskNodeHeader.Version
+	payloadFlags = onDiskNodeHeader.Flags & onDiskNodeFlagsPayload
+	byteOrder := nodeByteOrder(payloadFlags)
+
+	// Verify any checksum before trusting the rest of onDiskNodeHeaderStruct
+
+	if 0 != (onDiskNodeHeader.Flags & onDiskNodeFlagChecksum) {
+		if nil == tree.nodeChecksum {
+			// The checksum algorithm is not recorded, so it cannot be assumed
+
+			err = fmt.Errorf("%w: node @ ObjectNumber 0x%016X ObjectOffset 0x%016X is checksummed but no NodeChecksum was supplied", ErrOptionMismatch, node.objectNumber, node.objectOffset)
+			return
+		}
+
+		nodeByteSlice, err = verifyNodeChecksum(tree.nodeChecksum, byteOrder, node, nodeByteSlice)
+		if nil != err {
+			return
+		}
+	} else if nil != tree.nodeChecksum {
+		if tree.requireChecksummedNodes {
+			err = node.corruptNodeError("node is not checksummed")
+			return
+		}
+		if checksumFlagCleared(tree.nodeChecksum, byteOrder, nodeByteSlice) {
+			err = node.corruptNodeError("checksummed node has had its checksum flag cleared")
+			return
+		}
+	}
+
+	if (onDiskNodeVersionLegacy == onDiskNodeVersion) || (onDiskNodeVersionCurrent < onDiskNodeVersion) {
+		err = node.corruptNodeError("unsupported on-disk format version %v", onDiskNodeVersion)
+		return
+	}
+	if 0 != (onDiskNodeHeader.Flags & ^onDiskNodeFlagsSupported) {
+		err = node.corruptNodeError("unsupported on-disk format flags 0x%02X", onDiskNodeHeader.Flags)
+		return
+	}
+
+	onDiskNodeBuf = nodeByteSlice[onDiskNodeHeaderSize:]
+
+	if onDiskNodeVersion2 <= onDiskNodeVersion {
+		bytesConsumed, err = cstruct.Unpack(onDiskNodeBuf, &onDiskNodeExtHeader, byteOrder)
+		if nil != err {
+			err = node.corruptNodeError("%v", err)
+			return
+		}
+
+		if 0 != (onDiskNodeExtHeader.ExtFlags & ^onDiskNodeExtFlagsSupported) {
+			err = node.corruptNodeError("unsupported on-disk format extended flags 0x%02X", onDiskNodeExtHeader.ExtFlags)
+			return
+		}
+
+		onDiskNodeBuf = onDiskNodeBuf[bytesConsumed:]
+	}
+
+	if 0 != (onDiskNodeHeader.Flags & onDiskNodeFlagCodec) {
+		if onDiskNodeVersion2 <= onDiskNodeVersion {
+			bytesConsumed, err = cstruct.Unpack(onDiskNodeBuf, &onDiskNodeCodec, byteOrder)
+		} else {
+			bytesConsumed, err = cstruct.Unpack(onDiskNodeBuf, &onDiskNodeCodecV1, byteOrder)
+			onDiskNodeCodec.CodecID = onDiskNodeCodecV1.CodecID
+		}
+		if nil != err {
+			err = node.corruptNodeError("%v", err)
+			return
+		}
+
+		onDiskNodeBuf = onDiskNodeBuf[bytesConsumed:]
+	}
+
+	if 0 != (onDiskNodeHeader.Flags & onDiskNodeFlagEncrypted) {
+		onDiskNodeBuf, err = tree.decryptNode(byteOrder, node, nodeByteSlice[:(len(nodeByteSlice)-len(onDiskNodeBuf))], onDiskNodeBuf)
+		if nil != err {
+			return
+		}
+	} else if tree.requireEncryptedNodes {
+		err = node.corruptNodeError("node is not encrypted")
+		return
+	}
+
+	if 0 != (onDiskNodeHeader.Flags & onDiskNodeFlagCodec) {
+		nodeCodec, ok = tree.lookupNodeCodec(onDiskNodeCodec.CodecID)
+		if !ok {
+			err = fmt.Errorf("%w: node @ ObjectNumber 0x%016X ObjectOffset 0x%016X encoded by unknown NodeCodec (CodecID %v)", ErrOptionMismatch, node.objectNumber, node.objectOffset, onDiskNodeCodec.CodecID)
+			return
+		}
+
+		if onDiskNodeVersion2 <= onDiskNodeVersion {
+			onDiskNodeBuf, err = decodeNodeBounded(nodeCodec, onDiskNodeBuf, onDiskNodeCodec.RawLength)
+		} else {
+			onDiskNodeBuf, err = nodeCodec.Decode(onDiskNodeBuf) // raw length not recorded in onDiskNodeVersion1
+		}
+		if nil != err {
+			err = node.corruptNodeError("NodeCodec (CodecID %v) Decode() failed: %v", onDiskNodeCodec.CodecID, err)
+			return
+		}
+	}
+
+	err = tree.checkPayloadExtFlags(node, onDiskNodeExtHeader.ExtFlags) // zero unless onDiskNodeVersion2 or later
+	return
+}
+
+func computeNodeChecksum(nodeChecksum NodeChecksum, nodeByteSlice []byte) (checksum uint32) {
+	nodeHash := nodeChecksum()
+	_, _ = nodeHash.Write(nodeByteSlice)
+	checksum = nodeHash.Sum32()
+	return
+}
+
+func appendNodeChecksum(nodeChecksum NodeChecksum, byteOrder binary.ByteOrder, nodeByteSlice []byte) (checksummedNodeByteSlice []byte, err error) {
+	checksumStruct := onDiskUint32Struct{U32: computeNodeChecksum(nodeChecksum, nodeByteSlice)}
+
+	checksumBuf, err := cstruct.Pack(checksumStruct, byteOrder)
+	if nil != err {
+		return
+	}
+
+	checksummedNodeByteSlice = append(nodeByteSlice, checksumBuf...)
+
+	err = nil
+	return
+}
+
+func verifyNodeChecksum(nodeChecksum NodeChecksum, byteOrder binary.ByteOrder, node *btreeNodeStruct, checksummedNodeByteSlice []byte) (nodeByteSlice []byte, err error) {
+	var (
+		checksumStruct onDiskUint32Struct
+	)
+
+	checksumOffset := len(checksummedNodeByteSlice) - onDiskUint32Size
+	if 0 > checksumOffset {
+		err = node.corruptNodeError("node too short (%v bytes) to contain a checksum", len(checksummedNodeByteSlice))
+		return
+	}
+
+	_, err = cstruct.Unpack(checksummedNodeByteSlice[checksumOffset:], &checksumStruct, byteOrder)
+	if nil != err {
+		err = node.corruptNodeError("%v", err)
+		return
+	}
+
+	nodeByteSlice = checksummedNodeByteSlice[:checksumOffset]
+
+	computedChecksum := computeNodeChecksum(nodeChecksum, nodeByteSlice)
+	if computedChecksum != checksumStruct.U32 {
+		err = node.corruptNodeError("checksum mismatch (stored 0x%08X, computed 0x%08X)", checksumStruct.U32, computedChecksum)
+		return
+	}
+
+	err = nil
+	return
+}
+
+// checksumVerifies reports whether nodeByteSlice ends with a checksum of its preceding bytes
+//
+// This is used to detect nodes whose presumed lack of a checksum is contradicted by their
+// contents. The chance of an unchecksummed node happening to end with the checksum of its
+// preceding bytes is negligible.
+func checksumVerifies(nodeChecksum NodeChecksum, byteOrder binary.ByteOrder, nodeByteSlice []byte) (verifies bool) {
+	_, err := verifyNodeChecksum(nodeChecksum, byteOrder, &btreeNodeStruct{}, nodeByteSlice)
+	verifies = (nil == err)
+	return
+}
+
+// checksumFlagCleared reports whether nodeByteSlice would verify had its onDiskNodeFlagChecksum been set
+//
+// Absent this check, flipping that one bit of a checksummed node would disable its verification
+// whenever BPlusTreeOptions.AllowMissingChecksums is set.
+func checksumFlagCleared(nodeChecksum NodeChecksum, byteOrder binary.ByteOrder, nodeByteSlice []byte) (cleared bool) {
+	if (onDiskNodeHeaderSize + onDiskUint32Size) > len(nodeByteSlice) {
+		cleared = false
+		return
+	}
+	restoredNodeByteSlice := append([]byte{}, nodeByteSlice...)
+	restoredNodeByteSlice[onDiskNodeFlagsOffset] |= onDiskNodeFlagChecksum
+	cleared = checksumVerifies(nodeChecksum, byteOrder, restoredNodeByteSlice)
+	return
+}
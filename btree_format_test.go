@@ -0,0 +1,193 @@
+// Copyright (c) 2015-2021, NVIDIA CORPORATION.
+// SPDX-License-Identifier: Apache-2.0
+
+package sortedmap
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func testFormatPutKeys(t *testing.T, btree BPlusTree, keyStart uint32, keyEnd uint32) {
+	for key := keyStart; key < keyEnd; key++ {
+		ok, err := btree.Put(key, valueStruct{u32: key, s8: uint32To8ReplicaByteArray(key)})
+		if nil != err {
+			t.Fatalf("btree.Put(%v,) failed: %v", key, err)
+		}
+		if !ok {
+			t.Fatalf("btree.Put(%v,).ok should have been true", key)
+		}
+	}
+}
+
+func testFormatVerifyKeys(t *testing.T, btree BPlusTree, keyEnd uint32) {
+	for key := uint32(0); key < keyEnd; key++ {
+		valueAsValue, ok, err := btree.GetByKey(key)
+		if nil != err {
+			t.Fatalf("btree.GetByKey(%v) failed: %v", key, err)
+		}
+		if !ok {
+			t.Fatalf("btree.GetByKey(%v).ok should have been true", key)
+		}
+		if valueAsValue.(valueStruct).u32 != key {
+			t.Fatalf("btree.GetByKey(%v) returned unexpected value", key)
+		}
+	}
+
+	err := btree.Validate()
+	if nil != err {
+		t.Fatalf("btree.Validate() failed: %v", err)
+	}
+}
+
+func testFormatCountVersions(persistentContext *specificBPlusTreeTestContextStruct) (legacyNodes int, versionedNodes int) {
+	for _, logSegmentChunk := range persistentContext.logSegmentChunkMap {
+		if bytes.HasPrefix(logSegmentChunk.chunkByteSlice, onDiskNodeMagic[:]) {
+			versionedNodes++
+		} else {
+			legacyNodes++
+		}
+	}
+	return
+}
+
+func TestBPlusTreeOnDiskFormatVersion(t *testing.T) {
+	var (
+		btree             BPlusTree
+		err               error
+		legacyNodes       int
+		options           *BPlusTreeOptions
+		persistentContext *specificBPlusTreeTestContextStruct
+		rootObjectLength  uint64
+		rootObjectNumber  uint64
+		rootObjectOffset  uint64
+		versionedNodes    int
+	)
+
+	persistentContext = &specificBPlusTreeTestContextStruct{t: t, lastLogSegmentNumberGenerated: 0, lastLogOffsetGenerated: 0, logSegmentChunkMap: make(map[uint64]*logSegmentChunkStruct)}
+
+	options = &BPlusTreeOptions{NodeChecksum: NodeChecksumCRC32C}
+
+	// Simulate a tree written before on-disk format versioning was introduced
+
+	btree, err = NewBPlusTreeWithOptions(specificBPlusTreeTestNumKeysMaxSmall, CompareUint32, persistentContext, nil, options)
+	if nil != err {
+		t.Fatalf("NewBPlusTreeWithOptions() failed: %v", err)
+	}
+	btree.(*btreeTreeStruct).onDiskNodeVersion = onDiskNodeVersionLegacy
+
+	testFormatPutKeys(t, btree, 0, 20)
+
+	rootObjectNumber, rootObjectOffset, rootObjectLength, err = btree.Flush(true)
+	if nil != err {
+		t.Fatalf("btree.Flush(true) [case 1] failed: %v", err)
+	}
+	err = btree.Prune()
+	if nil != err {
+		t.Fatalf("btree.Prune() [case 1] failed: %v", err)
+	}
+
+	legacyNodes, versionedNodes = testFormatCountVersions(persistentContext)
+	if (0 == legacyNodes) || (0 != versionedNodes) {
+		t.Fatalf("expected only legacy nodes [case 1]... found %v legacy & %v versioned", legacyNodes, versionedNodes)
+	}
+
+	// Without UpgradeOnDiskFormat, dirtied nodes should remain in the legacy format
+
+	btree, err = OldBPlusTreeWithOptions(rootObjectNumber, rootObjectOffset, rootObjectLength, CompareUint32, persistentContext, nil, options)
+	if nil != err {
+		t.Fatalf("OldBPlusTreeWithOptions() [case 2] failed: %v", err)
+	}
+
+	testFormatVerifyKeys(t, btree, 20)
+	testFormatPutKeys(t, btree, 20, 30)
+
+	rootObjectNumber, rootObjectOffset, rootObjectLength, err = btree.Flush(true)
+	if nil != err {
+		t.Fatalf("btree.Flush(true) [case 2] failed: %v", err)
+	}
+	err = btree.Prune()
+	if nil != err {
+		t.Fatalf("btree.Prune() [case 2] failed: %v", err)
+	}
+
+	legacyNodes, versionedNodes = testFormatCountVersions(persistentContext)
+	if (0 == legacyNodes) || (0 != versionedNodes) {
+		t.Fatalf("expected only legacy nodes [case 2]... found %v legacy & %v versioned", legacyNodes, versionedNodes)
+	}
+
+	// With UpgradeOnDiskFormat, dirtied nodes should be posted in the current format
+
+	options.UpgradeOnDiskFormat = true
+
+	btree, err = OldBPlusTreeWithOptions(rootObjectNumber, rootObjectOffset, rootObjectLength, CompareUint32, persistentContext, nil, options)
+	if nil != err {
+		t.Fatalf("OldBPlusTreeWithOptions() [case 3] failed: %v", err)
+	}
+
+	testFormatVerifyKeys(t, btree, 30)
+
+	_, err = btree.TouchItem(0)
+	if nil != err {
+		t.Fatalf("btree.TouchItem(0) failed: %v", err)
+	}
+
+	rootObjectNumber, rootObjectOffset, rootObjectLength, err = btree.Flush(true)
+	if nil != err {
+		t.Fatalf("btree.Flush(true) [case 3] failed: %v", err)
+	}
+	err = btree.Prune()
+	if nil != err {
+		t.Fatalf("btree.Prune() [case 3] failed: %v", err)
+	}
+
+	legacyNodes, versionedNodes = testFormatCountVersions(persistentContext)
+	if (0 == legacyNodes) || (0 == versionedNodes) {
+		t.Fatalf("expected mix of legacy & versioned nodes [case 3]... found %v legacy & %v versioned", legacyNodes, versionedNodes)
+	}
+
+	testFormatVerifyKeys(t, btree, 30)
+
+	err = btree.Touch()
+	if nil != err {
+		t.Fatalf("btree.Touch() failed: %v", err)
+	}
+
+	rootObjectNumber, rootObjectOffset, rootObjectLength, err = btree.Flush(true)
+	if nil != err {
+		t.Fatalf("btree.Flush(true) [case 4] failed: %v", err)
+	}
+	err = btree.Prune()
+	if nil != err {
+		t.Fatalf("btree.Prune() [case 4] failed: %v", err)
+	}
+
+	legacyNodes, versionedNodes = testFormatCountVersions(persistentContext)
+	if (0 != legacyNodes) || (0 == versionedNodes) {
+		t.Fatalf("expected only versioned nodes [case 4]... found %v legacy & %v versioned", legacyNodes, versionedNodes)
+	}
+
+	// Versioned nodes do not record the checksum algorithm, so a reader lacking the NodeChecksum option must fail
+
+	_, err = OldBPlusTree(rootObjectNumber, rootObjectOffset, rootObjectLength, CompareUint32, persistentContext, nil)
+	if (nil == err) || errors.Is(err, ErrCorruptNode) {
+		t.Fatalf("OldBPlusTree() [case 5] should have failed (but not with ErrCorruptNode)")
+	}
+
+	btree, err = OldBPlusTreeWithOptions(rootObjectNumber, rootObjectOffset, rootObjectLength, CompareUint32, persistentContext, nil, &BPlusTreeOptions{NodeChecksum: NodeChecksumCRC32C})
+	if nil != err {
+		t.Fatalf("OldBPlusTreeWithOptions() [case 5] failed: %v", err)
+	}
+
+	testFormatVerifyKeys(t, btree, 30)
+
+	// Readers must reject versions they do not understand
+
+	persistentContext.logSegmentChunkMap[rootObjectNumber].chunkByteSlice[len(onDiskNodeMagic)] = onDiskNodeVersionCurrent + 1
+
+	_, err = OldBPlusTree(rootObjectNumber, rootObjectOffset, rootObjectLength, CompareUint32, persistentContext, nil)
+	if nil == err {
+		t.Fatalf("OldBPlusTree() [case 6] should have failed")
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright (c) 2015-2021, NVIDIA CORPORATION.
+// SPDX-License-Identifier: Apache-2.0
+
+package sortedmap
+
+import (
+	"encoding/binary"
+)
+
+// Key prefix compression (front-coding)
+//
+// When a node is posted with onDiskNodeFlagKeyPrefix, each Key in its Payload is not
+// recorded as returned by PackKey() but rather as:
+//
+//	uvarint  number of leading bytes shared with the preceding packed Key in this node
+//	uvarint  number of bytes in the suffix that follows
+//	suffix   remaining bytes of this packed Key
+//
+// Every nodeKeyPrefixRestartInterval'th Key is a restart point sharing no bytes with its
+// predecessor, bounding how far a single damaged Key can propagate. As Keys within a node
+// are sorted, any PackKey() encoding that preserves the ordering of Keys bytewise (e.g.
+// []byte or string Keys packed verbatim) shares long prefixes between neighbors. Since the
+// reconstructed packed Key is handed to UnpackKey() in isolation, UnpackKey() must consume
+// it entirely.
+
+const nodeKeyPrefixRestartInterval = 16
+
+type nodeKeyCoderStruct struct {
+	keyPrefix     bool
+	keysCoded     uint64
+	prevPackedKey []byte
+}
+
+func newNodeKeyCoder(payloadFlags uint8) (keyCoder *nodeKeyCoderStruct) {
+	keyCoder = &nodeKeyCoderStruct{
+		keyPrefix:     0 != (payloadFlags & onDiskNodeFlagKeyPrefix),
+		keysCoded:     0,
+		prevPackedKey: nil,
+	}
+	return
+}
+
+func (tree *btreeTreeStruct) packNodeKey(keyCoder *nodeKeyCoderStruct, payload []byte, key Key) (updatedPayload []byte, err error) {
+	var (
+		sharedLen int
+	)
+
+	packedKey, err := tree.packKey(key, keyCoder.keyPrefix)
+	if nil != err {
+		return
+	}
+
+	if !keyCoder.keyPrefix {
+		updatedPayload = append(payload, packedKey...)
+		err = nil
+		return
+	}
+
+	if 0 != (keyCoder.keysCoded % nodeKeyPrefixRestartInterval) {
+		for (sharedLen < len(packedKey)) && (sharedLen < len(keyCoder.prevPackedKey)) && (packedKey[sharedLen] == keyCoder.prevPackedKey[sharedLen]) {
+			sharedLen++
+		}
+	}
+
+	updatedPayload = binary.AppendUvarint(payload, uint64(sharedLen))
+	updatedPayload = binary.AppendUvarint(updatedPayload, uint64(len(packedKey)-sharedLen))
+	updatedPayload = append(updatedPayload, packedKey[sharedLen:]...)
+
+	keyCoder.keysCoded++
+	keyCoder.prevPackedKey = packedKey
+
+	err = nil
+	return
+}
+
+func (tree *btreeTreeStruct) unpackNodeKey(keyCoder *nodeKeyCoderStruct, node *btreeNodeStruct, payload []byte) (key Key, bytesConsumed uint64, err error) {
+	if !keyCoder.keyPrefix {
+		key, bytesConsumed, err = tree.unpackKey(node, payload, false)
+		return
+	}
+
+	sharedLen, sharedLenSize := binary.Uvarint(payload)
+	if 0 >= sharedLenSize {
+		err = node.corruptNodeError("unable to decode front-coded Key shared length")
+		return
+	}
+
+	suffixLen, suffixLenSize := binary.Uvarint(payload[sharedLenSize:])
+	if 0 >= suffixLenSize {
+		err = node.corruptNodeError("unable to decode front-coded Key suffix length")
+		return
+	}
+
+	suffixStart := uint64(sharedLenSize + suffixLenSize)
+
+	if (sharedLen > uint64(len(keyCoder.prevPackedKey))) || ((0 == (keyCoder.keysCoded % nodeKeyPrefixRestartInterval)) && (0 != sharedLen)) {
+		err = node.corruptNodeError("front-coded Key shared length (%v) invalid", sharedLen)
+		return
+	}
+	if suffixLen > (uint64(len(payload)) - suffixStart) {
+		err = node.corruptNodeError("front-coded Key suffix length (%v) exceeds remaining payload", suffixLen)
+		return
+	}
+
+	packedKey := make([]byte, 0, sharedLen+suffixLen)
+	packedKey = append(packedKey, keyCoder.prevPackedKey[:sharedLen]...)
+	packedKey = append(packedKey, payload[suffixStart:suffixStart+suffixLen]...)
+
+	key, packedKeyConsumed, err := tree.unpackKey(node, packedKey, true)
+	if nil != err {
+		return
+	}
+	if packedKeyConsumed != uint64(len(packedKey)) {
+		err = node.corruptNodeError("UnpackKey() consumed %v of %v bytes of front-coded Key", packedKeyConsumed, len(packedKey))
+		return
+	}
+
+	keyCoder.keysCoded++
+	keyCoder.prevPackedKey = packedKey
+
+	bytesConsumed = suffixStart + suffixLen
+
+	err = nil
+	return
+}
@@ -0,0 +1,123 @@
+// Copyright (c) 2015-2021, NVIDIA CORPORATION.
+// SPDX-License-Identifier: Apache-2.0
+
+package sortedmap
+
+import (
+	"encoding/binary"
+	"fmt"
+	"testing"
+)
+
+type keyPrefixTestContextStruct struct {
+	*specificBPlusTreeTestContextStruct
+}
+
+func (context *keyPrefixTestContextStruct) DumpKey(key Key) (keyAsString string, err error) {
+	keyAsString, ok := key.(string)
+	if !ok {
+		context.t.Fatalf("DumpKey() argument not a string")
+	}
+	err = nil
+	return
+}
+
+func (context *keyPrefixTestContextStruct) PackKey(key Key) (packedKey []byte, err error) {
+	keyAsString, ok := key.(string)
+	if !ok {
+		context.t.Fatalf("PackKey() argument not a string")
+	}
+	packedKey = binary.BigEndian.AppendUint16(nil, uint16(len(keyAsString)))
+	packedKey = append(packedKey, keyAsString...)
+	err = nil
+	return
+}
+
+func (context *keyPrefixTestContextStruct) UnpackKey(packedKey []byte) (key Key, bytesConsumed uint64, err error) {
+	if 2 > len(packedKey) {
+		err = fmt.Errorf("packedKey too short")
+		return
+	}
+	keyLen := uint64(binary.BigEndian.Uint16(packedKey))
+	if (2 + keyLen) > uint64(len(packedKey)) {
+		err = fmt.Errorf("packedKey too short")
+		return
+	}
+	key = string(packedKey[2 : 2+keyLen])
+	bytesConsumed = 2 + keyLen
+	err = nil
+	return
+}
+
+func keyPrefixTestKey(i uint32) (key string) {
+	key = fmt.Sprintf("/tenant-0123456789/volume-abcdef/directory/file-%06d", i)
+	return
+}
+
+func keyPrefixTestPosted(t *testing.T, keyPrefixCompression bool) (persistentContext *keyPrefixTestContextStruct, rootObjectNumber uint64, rootObjectOffset uint64, rootObjectLength uint64, postedRawBytes uint64) {
+	persistentContext = &keyPrefixTestContextStruct{&specificBPlusTreeTestContextStruct{t: t, lastLogSegmentNumberGenerated: 0, lastLogOffsetGenerated: 0, logSegmentChunkMap: make(map[uint64]*logSegmentChunkStruct)}}
+
+	btree, err := NewBPlusTreeWithOptions(20, CompareString, persistentContext, nil, &BPlusTreeOptions{KeyPrefixCompression: keyPrefixCompression})
+	if nil != err {
+		t.Fatalf("NewBPlusTreeWithOptions() failed: %v", err)
+	}
+
+	for i := uint32(0); i < 500; i++ {
+		ok, err := btree.Put(keyPrefixTestKey(i), valueStruct{u32: i, s8: uint32To8ReplicaByteArray(i & 0xFF)})
+		if nil != err {
+			t.Fatalf("btree.Put(%v,) failed: %v", i, err)
+		}
+		if !ok {
+			t.Fatalf("btree.Put(%v,).ok should have been true", i)
+		}
+	}
+
+	rootObjectNumber, rootObjectOffset, rootObjectLength, err = btree.Flush(false)
+	if nil != err {
+		t.Fatalf("btree.Flush(false) failed: %v", err)
+	}
+
+	dimensionsReport, err := btree.FetchDimensionsReport()
+	if nil != err {
+		t.Fatalf("btree.FetchDimensionsReport() failed: %v", err)
+	}
+
+	postedRawBytes = dimensionsReport.PostedRawBytes
+
+	return
+}
+
+func TestBPlusTreeKeyPrefixCompression(t *testing.T) {
+	_, _, _, _, uncompressedRawBytes := keyPrefixTestPosted(t, false)
+
+	persistentContext, rootObjectNumber, rootObjectOffset, rootObjectLength, compressedRawBytes := keyPrefixTestPosted(t, true)
+
+	if (2 * compressedRawBytes) > uncompressedRawBytes {
+		t.Fatalf("KeyPrefixCompression should have at least halved PostedRawBytes (%v vs %v)", compressedRawBytes, uncompressedRawBytes)
+	}
+
+	// Front-coded nodes are self-describing, so no option is needed to read them back
+
+	btree, err := OldBPlusTree(rootObjectNumber, rootObjectOffset, rootObjectLength, CompareString, persistentContext, nil)
+	if nil != err {
+		t.Fatalf("OldBPlusTree() failed: %v", err)
+	}
+
+	for i := uint32(0); i < 500; i++ {
+		valueAsValue, ok, err := btree.GetByKey(keyPrefixTestKey(i))
+		if nil != err {
+			t.Fatalf("btree.GetByKey(%v) failed: %v", i, err)
+		}
+		if !ok {
+			t.Fatalf("btree.GetByKey(%v).ok should have been true", i)
+		}
+		if valueAsValue.(valueStruct).u32 != i {
+			t.Fatalf("btree.GetByKey(%v) returned unexpected value", i)
+		}
+	}
+
+	err = btree.Validate()
+	if nil != err {
+		t.Fatalf("btree.Validate() failed: %v", err)
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright (c) 2015-2021, NVIDIA CORPORATION.
+// SPDX-License-Identifier: Apache-2.0
+
+package sortedmap
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+)
+
+// Structured logging & runtime/trace instrumentation
+//
+// If BPlusTreeOptions.Logger is non-nil, a B+Tree emits a structured event at slog.LevelDebug
+// whenever a node is split, merged into a sibling, replenished by borrowing from a sibling,
+// successfully loaded via GetNode(), or evicted (whether by the BPlusTreeCache's drainer or
+// by Purge()), as well as whenever stale node locations & logged Values are discarded by
+// Prune(). Each event carries a "tree" attribute (BPlusTreeOptions.Name or, if empty, the
+// B+Tree's address) and a "node" group of attributes (objectNumber, objectOffset,
+// objectLength, level, leaf, & items) where level counts down from the root at level 0.
+// Events involving a second node carry a "sibling" group of the same attributes. Attributes
+// are only computed if the Logger is enabled for slog.LevelDebug, so a nil or less verbose
+// Logger costs next to nothing.
+//
+// Independently, calls to GetNode() & PutNode() as well as each Flush() are enclosed in
+// runtime/trace regions named TraceRegionGetNode, TraceRegionPutNode, & TraceRegionFlush.
+// These only have effect while an execution trace is being collected (see trace.Start()).
+
+const (
+	TraceRegionGetNode = "sortedmap.GetNode" // encloses each call to BPlusTreeCallbacks.GetNode()
+	TraceRegionPutNode = "sortedmap.PutNode" // encloses each call to BPlusTreeCallbacks.PutNode()
+	TraceRegionFlush   = "sortedmap.Flush"   // encloses each call to BPlusTree.Flush()
+)
+
+// loggerFromOptions returns logger annotated with the id of tree (or nil if logger is nil)
+func (tree *btreeTreeStruct) loggerFromOptions(logger *slog.Logger) (treeLogger *slog.Logger) {
+	if nil == logger {
+		treeLogger = nil
+		return
+	}
+
+	if "" == tree.name {
+		treeLogger = logger.With(slog.String("tree", fmt.Sprintf("%p", tree)))
+	} else {
+		treeLogger = logger.With(slog.String("tree", tree.name))
+	}
+
+	return
+}
+
+// loggingEnabled indicates whether events are to be emitted via tree.logger
+func (tree *btreeTreeStruct) loggingEnabled() (enabled bool) {
+	enabled = (nil != tree.logger) && tree.logger.Enabled(context.Background(), slog.LevelDebug)
+	return
+}
+
+// nodeLevel returns the distance of node from the root node (which is at level 0)
+func (tree *btreeTreeStruct) nodeLevel(node *btreeNodeStruct) (level uint64) {
+	level = 0
+
+	for !node.root && (nil != node.parentNode) {
+		level++
+		node = node.parentNode
+	}
+
+	return
+}
+
+// nodeAttr returns the attributes of node grouped under key
+func (tree *btreeTreeStruct) nodeAttr(key string, node *btreeNodeStruct) (attr slog.Attr) {
+	attr = slog.Group(key,
+		slog.Uint64("objectNumber", node.objectNumber),
+		slog.Uint64("objectOffset", node.objectOffset),
+		slog.Uint64("objectLength", node.objectLength),
+		slog.Uint64("level", tree.nodeLevel(node)),
+		slog.Bool("leaf", node.leaf),
+		slog.Uint64("items", node.items),
+	)
+
+	return
+}
+
+// logNodeEvent emits msg describing node (and, if non-nil, siblingNode) along with any additional attrs
+func (tree *btreeTreeStruct) logNodeEvent(msg string, node *btreeNodeStruct, siblingNode *btreeNodeStruct, attrs ...slog.Attr) {
+	if !tree.loggingEnabled() {
+		return
+	}
+
+	attrs = append(attrs, tree.nodeAttr("node", node))
+	if nil != siblingNode {
+		attrs = append(attrs, tree.nodeAttr("sibling", siblingNode))
+	}
+
+	tree.logger.LogAttrs(context.Background(), slog.LevelDebug, msg, attrs...)
+}
+
+// logPrune emits an event recording the number of stale node locations & logged Values about to be discarded
+func (tree *btreeTreeStruct) logPrune() {
+	if !tree.loggingEnabled() {
+		return
+	}
+
+	tree.logger.LogAttrs(context.Background(), slog.LevelDebug, "prune",
+		slog.Int("staleNodes", len(tree.staleOnDiskReferencesList)),
+		slog.Int("staleValues", len(tree.staleValueLogReferences)),
+	)
+}
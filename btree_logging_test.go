@@ -0,0 +1,115 @@
+// Copyright (c) 2015-2021, NVIDIA CORPORATION.
+// SPDX-License-Identifier: Apache-2.0
+
+package sortedmap
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"runtime/trace"
+	"testing"
+)
+
+func TestBPlusTreeLogging(t *testing.T) {
+	var (
+		logBuf   bytes.Buffer
+		traceBuf bytes.Buffer
+	)
+
+	nodeStore := &callbacksTestNodeStoreStruct{lastObjectNumberGenerated: 0, objectMap: make(map[uint64][]byte)}
+	callbacks := Callbacks(CodecInt, CodecInt, nodeStore)
+
+	err := trace.Start(&traceBuf)
+	if nil != err {
+		t.Fatalf("trace.Start() failed: %v", err)
+	}
+
+	logger := slog.New(slog.NewJSONHandler(&logBuf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	tree, err := NewBPlusTreeWithOptions(4, CompareInt, callbacks, nil, &BPlusTreeOptions{Name: "logged", Logger: logger})
+	if nil != err {
+		t.Fatalf("NewBPlusTreeWithOptions() failed: %v", err)
+	}
+
+	for key := 0; key < 100; key++ {
+		_, err = tree.Put(key, key)
+		if nil != err {
+			t.Fatalf("tree.Put(%v,) failed: %v", key, err)
+		}
+	}
+
+	_, _, _, err = tree.Flush(true)
+	if nil != err {
+		t.Fatalf("tree.Flush(true) failed: %v", err)
+	}
+
+	for key := 0; key < 90; key++ {
+		_, err = tree.DeleteByKey(key)
+		if nil != err {
+			t.Fatalf("tree.DeleteByKey(%v) failed: %v", key, err)
+		}
+	}
+
+	err = tree.Prune()
+	if nil != err {
+		t.Fatalf("tree.Prune() failed: %v", err)
+	}
+
+	trace.Stop()
+
+	if 0 == traceBuf.Len() {
+		t.Fatalf("trace.Start() should have collected an execution trace")
+	}
+
+	// Each event should identify the tree & each structural change should have been logged
+
+	eventCounts := make(map[string]int)
+
+	for _, line := range bytes.Split(bytes.TrimSpace(logBuf.Bytes()), []byte("\n")) {
+		var event struct {
+			Msg  string
+			Tree string
+			Node *struct {
+				Level uint64
+				Items uint64
+			}
+		}
+
+		err = json.Unmarshal(line, &event)
+		if nil != err {
+			t.Fatalf("json.Unmarshal(%s) failed: %v", line, err)
+		}
+		if "logged" != event.Tree {
+			t.Fatalf("event %s should have had tree == \"logged\"", line)
+		}
+		if ("prune" != event.Msg) && (nil == event.Node) {
+			t.Fatalf("event %s should have had a node group", line)
+		}
+
+		eventCounts[event.Msg]++
+	}
+
+	for _, msg := range []string{"split", "merge", "borrow", "load", "evict", "prune"} {
+		if 0 == eventCounts[msg] {
+			t.Fatalf("tree should have logged at least one \"%s\" event (logged %v)", msg, eventCounts)
+		}
+	}
+
+	// A Logger not enabled for slog.LevelDebug should receive nothing
+
+	logBuf.Reset()
+
+	logger = slog.New(slog.NewJSONHandler(&logBuf, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	tree, err = NewBPlusTreeWithOptions(4, CompareInt, callbacks, nil, &BPlusTreeOptions{Logger: logger})
+	if nil != err {
+		t.Fatalf("NewBPlusTreeWithOptions() failed: %v", err)
+	}
+
+	for key := 0; key < 100; key++ {
+		_, _ = tree.Put(key, key)
+	}
+
+	if 0 != logBuf.Len() {
+		t.Fatalf("tree should not have logged to a Logger at slog.LevelInfo")
+	}
+}
@@ -0,0 +1,271 @@
+// Copyright (c) 2015-2021, NVIDIA CORPORATION.
+// SPDX-License-Identifier: Apache-2.0
+
+package sortedmap
+
+// Byte-size-bounded nodes
+//
+// By default, a node is split when it holds more than maxKeysPerNode Keys and is merged
+// with (or borrows from) a sibling when it holds fewer than minKeysPerNode Keys. When
+// BPlusTreeOptions.MaxNodeBytes is non-zero, these decisions are instead driven by the
+// length of a node as it would be passed to PutNode(). In this mode:
+//
+//	a node is split when it exceeds MaxNodeBytes (or holds more than maxKeysPerNode Keys)
+//	a non-Root node is rebalanced when it falls below MinNodeBytes (or holds no Keys)
+//
+// A node's length is computed by packing it just as postNode() would (see packNode()) and
+// adding the framing encodeNode() would apply. As the length of anything yet to be posted
+// (a Value bound for the value log, a dirty child node's location) is not yet known, its
+// maximum length is counted instead. Similarly, any NodeCodec is ignored as encodeNode()
+// only applies one if it shortens the node. Hence, the computed length bounds (and, once a
+// node's Values & children have been posted, absent a NodeCodec, equals) that actually posted.
+//
+// Splits divide a node's bytes roughly in half. As a single Key borrowed from a sibling may
+// not suffice, borrowing repeats until either the node is no longer below MinNodeBytes or
+// a merge is required. As a merge may then produce a node that exceeds MaxNodeBytes, the
+// merged node is itself split as necessary. Note that a single Key:Value pair larger than
+// MaxNodeBytes is permitted to exceed it alone in its node.
+//
+// A node's length is cached until it (or, as a non-leaf node records the Items & any
+// Aggregate & weight of each child, a descendant) is modified.
+
+func (tree *btreeTreeStruct) byteBounded() (byteBounded bool) {
+	byteBounded = (0 != tree.maxNodeBytes)
+	return
+}
+
+// invalidateNodeBytes discards the cached length of node & its ancestors
+//
+// Unlike an Aggregate, an ancestor's length may have been computed while node's was not,
+// so all ancestors must be visited.
+func (tree *btreeTreeStruct) invalidateNodeBytes(node *btreeNodeStruct) {
+	for nil != node {
+		node.nodeBytesValid = false
+
+		node = node.parentNode
+	}
+}
+
+// entryBytes bounds the bytes the Key:Value at key (in node) adds to node's length
+//
+// This is the length of the Key were it not front-coded (see KeyPrefixCompression) plus the
+// Value (if node is a leaf) or the child node's reference, weight, & Aggregate (otherwise).
+func (tree *btreeTreeStruct) entryBytes(node *btreeNodeStruct, key Key, value Value) (entryBytes uint64, err error) {
+	var (
+		entryByteSlice []byte
+	)
+
+	payloadFlags := tree.payloadFlags()
+
+	entryByteSlice, err = tree.packNodeKey(newNodeKeyCoder(payloadFlags), entryByteSlice, key)
+	if nil != err {
+		return
+	}
+
+	if node.leaf {
+		entryByteSlice, err = tree.packNodeValueBound(payloadFlags, entryByteSlice, value)
+		if nil != err {
+			return
+		}
+	} else {
+		childNode := value.(*btreeNodeStruct)
+
+		entryByteSlice, err = packNodeReferenceBound(payloadFlags, entryByteSlice, &onDiskReferenceToNodeStruct{}, &onDiskReferenceToNodeStruct{Items: childNode.items}, true)
+		if nil != err {
+			return
+		}
+
+		if tree.weighted() {
+			_, err = tree.nodeWeight(childNode)
+			if nil != err {
+				return
+			}
+			entryByteSlice, err = tree.packNodeWeight(payloadFlags, entryByteSlice, childNode)
+			if nil != err {
+				return
+			}
+		}
+
+		if tree.aggregated() {
+			_, err = tree.nodeAggregate(childNode)
+			if nil != err {
+				return
+			}
+			entryByteSlice, err = tree.packNodeAggregate(payloadFlags, entryByteSlice, childNode)
+			if nil != err {
+				return
+			}
+		}
+	}
+
+	entryBytes = uint64(len(entryByteSlice))
+
+	err = nil
+	return
+}
+
+// nodeBytes returns the (cached) bound on node's length once posted
+func (tree *btreeTreeStruct) nodeBytes(node *btreeNodeStruct) (nodeBytes uint64, err error) {
+	if node.nodeBytesValid {
+		nodeBytes = node.nodeBytes
+		err = nil
+		return
+	}
+
+	onDiskNodeBuf, err := tree.packNode(node, true)
+	if nil != err {
+		return
+	}
+
+	nodeBytes = tree.encodedNodeLength(uint64(len(onDiskNodeBuf)))
+
+	node.nodeBytes = nodeBytes
+	node.nodeBytesValid = true
+
+	err = nil
+	return
+}
+
+// nodeOverfull indicates whether or not node must be split
+func (tree *btreeTreeStruct) nodeOverfull(node *btreeNodeStruct, llrbLen int) (overfull bool, err error) {
+	if uint64(llrbLen) > tree.maxKeysPerNode {
+		overfull = true
+		err = nil
+		return
+	}
+
+	// A byte-bounded leaf node split requires two Keys, a non-leaf node split three
+
+	if !tree.byteBounded() || (2 > llrbLen) || (!node.leaf && (3 > llrbLen)) {
+		overfull = false
+		err = nil
+		return
+	}
+
+	nodeBytes, err := tree.nodeBytes(node)
+	if nil != err {
+		return
+	}
+
+	overfull = (nodeBytes > tree.maxNodeBytes)
+
+	return
+}
+
+// nodeSplitComplete indicates whether or not enough Keys have been moved out of the node being split
+func (tree *btreeTreeStruct) nodeSplitComplete(node *btreeNodeStruct, llrbLen int, movedKeys int, movedBytes uint64, remainingBytes uint64) (splitComplete bool) {
+	if !tree.byteBounded() {
+		splitComplete = (tree.minKeysPerNode == uint64(llrbLen))
+		return
+	}
+
+	if 1 == llrbLen {
+		splitComplete = true
+		return
+	}
+
+	// The last Key moved out of a non-leaf node becomes the new sibling's nonLeafLeftChild
+
+	if !node.leaf && (2 > movedKeys) {
+		splitComplete = false
+		return
+	}
+
+	splitComplete = (movedBytes >= remainingBytes)
+
+	return
+}
+
+// nodeUnderfull indicates whether or not a non-Root node must be rebalanced
+func (tree *btreeTreeStruct) nodeUnderfull(node *btreeNodeStruct, llrbLen int) (underfull bool, err error) {
+	if !tree.byteBounded() {
+		underfull = (uint64(llrbLen) < tree.minKeysPerNode)
+		err = nil
+		return
+	}
+
+	if 1 > llrbLen {
+		underfull = true
+		err = nil
+		return
+	}
+
+	nodeBytes, err := tree.nodeBytes(node)
+	if nil != err {
+		return
+	}
+
+	underfull = (nodeBytes < tree.minNodeBytes)
+
+	return
+}
+
+// rebalanceBorrower continues to rebalance a node that has just borrowed a Key from a sibling
+//
+// As one borrowed Key may not have sufficed, rebalancing may need to repeat. Conversely, as
+// inserting a front-coded Key (see KeyPrefixCompression) shifts which of those following it are
+// coded in full, rebalanceNode may have grown by more than entryBytes() and need to be split.
+func (tree *btreeTreeStruct) rebalanceBorrower(rebalanceNode *btreeNodeStruct, parentIndexStack []int) (err error) {
+	llrbLen, err := rebalanceNode.kvLLRB.Len()
+	if nil != err {
+		return
+	}
+
+	overfull, err := tree.nodeOverfull(rebalanceNode, llrbLen)
+	if nil != err {
+		return
+	}
+
+	if overfull {
+		err = tree.splitHere(rebalanceNode)
+	} else {
+		err = tree.rebalanceHere(rebalanceNode, parentIndexStack)
+	}
+
+	return
+}
+
+// nodeCanLend indicates whether or not node may give up the Key at lendIndex to siblingNode
+func (tree *btreeTreeStruct) nodeCanLend(node *btreeNodeStruct, llrbLen int, lendIndex int, siblingNode *btreeNodeStruct) (canLend bool, err error) {
+	if !tree.byteBounded() {
+		canLend = (uint64(llrbLen) > tree.minKeysPerNode)
+		err = nil
+		return
+	}
+
+	if 1 >= llrbLen {
+		canLend = false
+		err = nil
+		return
+	}
+
+	nodeBytes, err := tree.nodeBytes(node)
+	if nil != err {
+		return
+	}
+
+	key, value, _, err := node.kvLLRB.GetByIndex(lendIndex)
+	if nil != err {
+		return
+	}
+
+	entryBytes, err := tree.entryBytes(node, key, value)
+	if nil != err {
+		return
+	}
+
+	if (nodeBytes - entryBytes) < tree.minNodeBytes {
+		canLend = false
+		err = nil
+		return
+	}
+
+	siblingNodeBytes, err := tree.nodeBytes(siblingNode)
+	if nil != err {
+		return
+	}
+
+	canLend = ((siblingNodeBytes + entryBytes) <= tree.maxNodeBytes)
+
+	return
+}
@@ -0,0 +1,230 @@
+// Copyright (c) 2015-2021, NVIDIA CORPORATION.
+// SPDX-License-Identifier: Apache-2.0
+
+package sortedmap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"testing"
+)
+
+const (
+	nodeBytesTestMaxNodeBytes = uint64(32 * 1024)
+	nodeBytesTestMinNodeBytes = uint64(8 * 1024)
+	nodeBytesTestNumKeys      = uint32(2000)
+)
+
+type nodeBytesTestContextStruct struct {
+	*specificBPlusTreeTestContextStruct
+}
+
+func (context *nodeBytesTestContextStruct) DumpValue(value Value) (valueAsString string, err error) {
+	valueAsString = fmt.Sprintf("[%d bytes]", len(value.([]byte)))
+	err = nil
+	return
+}
+
+func (context *nodeBytesTestContextStruct) PackValue(value Value) (packedValue []byte, err error) {
+	valueAsByteSlice, ok := value.([]byte)
+	if !ok {
+		context.t.Fatalf("PackValue() argument not a []byte")
+	}
+	packedValue = binary.LittleEndian.AppendUint32(nil, uint32(len(valueAsByteSlice)))
+	packedValue = append(packedValue, valueAsByteSlice...)
+	err = nil
+	return
+}
+
+func (context *nodeBytesTestContextStruct) UnpackValue(packedValue []byte) (value Value, bytesConsumed uint64, err error) {
+	if 4 > len(packedValue) {
+		err = fmt.Errorf("packedValue too short")
+		return
+	}
+	valueLen := uint64(binary.LittleEndian.Uint32(packedValue))
+	if (4 + valueLen) > uint64(len(packedValue)) {
+		err = fmt.Errorf("packedValue too short")
+		return
+	}
+	value = packedValue[4 : 4+valueLen]
+	bytesConsumed = 4 + valueLen
+	err = nil
+	return
+}
+
+func nodeBytesTestValue(key uint32) (value []byte) {
+	if 0 == (key % 7) {
+		value = bytes.Repeat([]byte{byte(key)}, 8*1024)
+	} else {
+		value = bytes.Repeat([]byte{byte(key)}, 8)
+	}
+	return
+}
+
+// nodeBytesTestCheck verifies the length of each node as posted (and, absent a NodeCodec, as computed)
+func nodeBytesTestCheck(t *testing.T, btree BPlusTree, testCase string) {
+	err := btree.Validate() // also loads every node
+	if nil != err {
+		t.Fatalf("btree.Validate() [%s] failed: %v", testCase, err)
+	}
+
+	_, _, _, err = btree.Flush(false)
+	if nil != err {
+		t.Fatalf("btree.Flush(false) [%s] failed: %v", testCase, err)
+	}
+
+	tree := btree.(*btreeTreeStruct)
+
+	var checkNode func(node *btreeNodeStruct)
+
+	checkNode = func(node *btreeNodeStruct) {
+		llrbLen, err := node.kvLLRB.Len()
+		if nil != err {
+			t.Fatalf("node.kvLLRB.Len() [%s] failed: %v", testCase, err)
+		}
+		tree.invalidateNodeBytes(node)
+		nodeBytes, err := tree.nodeBytes(node)
+		if nil != err {
+			t.Fatalf("tree.nodeBytes() [%s] failed: %v", testCase, err)
+		}
+		if (nil == tree.nodeCodec) && (nodeBytes != node.objectLength) {
+			t.Fatalf("tree.nodeBytes() [%s] returned %v but node was posted with length %v", testCase, nodeBytes, node.objectLength)
+		}
+		if nodeBytes < node.objectLength {
+			t.Fatalf("tree.nodeBytes() [%s] returned %v but node was posted with (larger) length %v", testCase, nodeBytes, node.objectLength)
+		}
+		if (node.objectLength > nodeBytesTestMaxNodeBytes) && ((node.leaf && (1 < llrbLen)) || (!node.leaf && (2 < llrbLen))) {
+			t.Fatalf("node [%s] holding %v Keys should not have been posted with length (%v) exceeding MaxNodeBytes", testCase, llrbLen, node.objectLength)
+		}
+		if !node.root && node.leaf && (nodeBytes < nodeBytesTestMinNodeBytes) {
+			t.Fatalf("non-Root leaf node [%s] should not have been below MinNodeBytes (%v)", testCase, nodeBytes)
+		}
+		if !node.leaf {
+			checkNode(node.nonLeafLeftChild)
+			for i := 0; i < llrbLen; i++ {
+				_, childNodeAsValue, _, _ := node.kvLLRB.GetByIndex(i)
+				checkNode(childNodeAsValue.(*btreeNodeStruct))
+			}
+		}
+	}
+
+	checkNode(tree.root)
+}
+
+func TestBPlusTreeNodeBytes(t *testing.T) {
+	for _, options := range []*BPlusTreeOptions{
+		{},
+		{KeyPrefixCompression: true, VarintEncoding: true, NodeChecksum: NodeChecksumCRC32C},
+		{NodeCodec: NodeCodecFlate},
+		{VarintEncoding: true, Weigher: func(key Key, value Value) (weight uint64) { return uint64(len(value.([]byte))) }},
+	} {
+		options.MaxNodeBytes = nodeBytesTestMaxNodeBytes
+		options.MinNodeBytes = nodeBytesTestMinNodeBytes
+
+		nodeBytesTestRun(t, options)
+	}
+
+	persistentContext := &nodeBytesTestContextStruct{&specificBPlusTreeTestContextStruct{t: t, lastLogSegmentNumberGenerated: 0, lastLogOffsetGenerated: 0, logSegmentChunkMap: make(map[uint64]*logSegmentChunkStruct)}}
+
+	// Validate options
+
+	_, err := NewBPlusTreeWithOptions(1024, CompareUint32, persistentContext, nil, &BPlusTreeOptions{MaxNodeBytes: 1024, MinNodeBytes: 1024})
+	if nil == err {
+		t.Fatalf("NewBPlusTreeWithOptions() with MinNodeBytes > MaxNodeBytes/2 should have failed")
+	}
+
+	_, err = NewBPlusTreeWithOptions(1024, CompareUint32, persistentContext, nil, &BPlusTreeOptions{MinNodeBytes: 256})
+	if nil == err {
+		t.Fatalf("NewBPlusTreeWithOptions() with MinNodeBytes but no MaxNodeBytes should have failed")
+	}
+}
+
+func nodeBytesTestRun(t *testing.T, options *BPlusTreeOptions) {
+	persistentContext := &nodeBytesTestContextStruct{&specificBPlusTreeTestContextStruct{t: t, lastLogSegmentNumberGenerated: 0, lastLogOffsetGenerated: 0, logSegmentChunkMap: make(map[uint64]*logSegmentChunkStruct)}}
+
+	btree, err := NewBPlusTreeWithOptions(1024, CompareUint32, persistentContext, nil, options)
+	if nil != err {
+		t.Fatalf("NewBPlusTreeWithOptions() failed: %v", err)
+	}
+
+	for key := uint32(0); key < nodeBytesTestNumKeys; key++ {
+		ok, err := btree.Put(key, nodeBytesTestValue(key))
+		if nil != err {
+			t.Fatalf("btree.Put(%v,) failed: %v", key, err)
+		}
+		if !ok {
+			t.Fatalf("btree.Put(%v,).ok should have been true", key)
+		}
+	}
+
+	nodeBytesTestCheck(t, btree, "after Put()")
+
+	// Growing a Value must split its leaf node as necessary
+
+	for key := uint32(1); key < nodeBytesTestNumKeys; key += 7 {
+		ok, err := btree.PatchByKey(key, bytes.Repeat([]byte{0xFF}, 8*1024))
+		if nil != err {
+			t.Fatalf("btree.PatchByKey(%v,) failed: %v", key, err)
+		}
+		if !ok {
+			t.Fatalf("btree.PatchByKey(%v,).ok should have been true", key)
+		}
+	}
+
+	nodeBytesTestCheck(t, btree, "after PatchByKey()")
+
+	// Persisted B+Trees remember their MinNodeBytes & MaxNodeBytes
+
+	rootObjectNumber, rootObjectOffset, rootObjectLength, err := btree.Flush(false)
+	if nil != err {
+		t.Fatalf("btree.Flush(false) failed: %v", err)
+	}
+
+	reopenOptions := *options
+	reopenOptions.MaxNodeBytes = 0 // recorded in the root node
+	reopenOptions.MinNodeBytes = 0
+
+	btree, err = OldBPlusTreeWithOptions(rootObjectNumber, rootObjectOffset, rootObjectLength, CompareUint32, persistentContext, nil, &reopenOptions)
+	if nil != err {
+		t.Fatalf("OldBPlusTreeWithOptions() failed: %v", err)
+	}
+
+	dimensionsReport, err := btree.FetchDimensionsReport()
+	if nil != err {
+		t.Fatalf("btree.FetchDimensionsReport() failed: %v", err)
+	}
+	if (nodeBytesTestMinNodeBytes != dimensionsReport.MinNodeBytes) || (nodeBytesTestMaxNodeBytes != dimensionsReport.MaxNodeBytes) {
+		t.Fatalf("btree.FetchDimensionsReport() returned unexpected MinNodeBytes (%v) and/or MaxNodeBytes (%v)", dimensionsReport.MinNodeBytes, dimensionsReport.MaxNodeBytes)
+	}
+
+	// Deletions must rebalance nodes falling below MinNodeBytes
+
+	for key := uint32(0); key < nodeBytesTestNumKeys; key++ {
+		if 0 == (key % 3) {
+			continue
+		}
+		ok, err := btree.DeleteByKey(key)
+		if nil != err {
+			t.Fatalf("btree.DeleteByKey(%v) failed: %v", key, err)
+		}
+		if !ok {
+			t.Fatalf("btree.DeleteByKey(%v).ok should have been true", key)
+		}
+	}
+
+	nodeBytesTestCheck(t, btree, "after DeleteByKey()")
+
+	for key := uint32(0); key < nodeBytesTestNumKeys; key += 3 {
+		valueAsValue, ok, err := btree.GetByKey(key)
+		if nil != err {
+			t.Fatalf("btree.GetByKey(%v) failed: %v", key, err)
+		}
+		if !ok {
+			t.Fatalf("btree.GetByKey(%v).ok should have been true", key)
+		}
+		if (1 == (key % 7)) && (0xFF != valueAsValue.([]byte)[0]) {
+			t.Fatalf("btree.GetByKey(%v) returned unexpected value", key)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright (c) 2015-2021, NVIDIA CORPORATION.
+// SPDX-License-Identifier: Apache-2.0
+
+package sortedmap
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Ordered Keys
+//
+// When BPlusTreeOptions.OrderedKeyEncoding is non-nil, each Key passed to the B+Tree is
+// encoded upon entry and only its encoding is held in (and posted with) the B+Tree's nodes.
+// Keys are then compared via CompareByteSlice rather than the supplied Compare func, and
+// Keys are decoded only when returned (e.g. by GetByIndex()) or passed to DumpKey(). The
+// PackKey() & UnpackKey() callbacks are not used. Instead, each encoded Key is posted as:
+//
+//	uvarint  length of the encoded Key     (omitted if Keys are front-coded)
+//	bytes    encoded Key
+//
+// As encoded Keys sort bytewise, neighboring Keys typically share long prefixes that
+// KeyPrefixCompression is able to elide. Note that the B+Tree does not record the
+// OrderedKeyEncoding it was posted with, so the same one must be supplied to OldBPlusTree().
+
+func (tree *btreeTreeStruct) orderedKeys() (orderedKeys bool) {
+	orderedKeys = (nil != tree.orderedKeyEncoding)
+	return
+}
+
+// encodeKey returns the form of a caller-supplied Key held in the B+Tree's nodes
+func (tree *btreeTreeStruct) encodeKey(key Key) (encodedKey Key, err error) {
+	if !tree.orderedKeys() {
+		encodedKey = key
+		err = nil
+		return
+	}
+
+	encodedKey, err = tree.orderedKeyEncoding.EncodeKey(key)
+
+	return
+}
+
+// decodeKey returns the caller-supplied form of a Key held in the B+Tree's nodes
+func (tree *btreeTreeStruct) decodeKey(encodedKey Key) (key Key, err error) {
+	if !tree.orderedKeys() {
+		key = encodedKey
+		err = nil
+		return
+	}
+
+	encodedKeyAsByteSlice := encodedKey.([]byte)
+
+	key, bytesConsumed, err := tree.orderedKeyEncoding.DecodeKey(encodedKeyAsByteSlice)
+	if nil != err {
+		return
+	}
+
+	if uint64(len(encodedKeyAsByteSlice)) != bytesConsumed {
+		err = fmt.Errorf("DecodeKey() consumed %v of %v bytes of encoded Key", bytesConsumed, len(encodedKeyAsByteSlice))
+		return
+	}
+
+	err = nil
+	return
+}
+
+// packKey returns the packed form of a Key held in the B+Tree's nodes
+//
+// If frontCoded, the packed Key need not be self-delimiting.
+func (tree *btreeTreeStruct) packKey(key Key, frontCoded bool) (packedKey []byte, err error) {
+	if !tree.orderedKeys() {
+		packedKey, err = tree.BPlusTreeCallbacks.PackKey(key)
+		return
+	}
+
+	encodedKey := key.([]byte)
+
+	if frontCoded {
+		packedKey = encodedKey
+	} else {
+		packedKey = binary.AppendUvarint(make([]byte, 0, binary.MaxVarintLen64+len(encodedKey)), uint64(len(encodedKey)))
+		packedKey = append(packedKey, encodedKey...)
+	}
+
+	err = nil
+	return
+}
+
+// unpackKey reverses packKey()
+//
+// If frontCoded, payloadData is the entire packed Key.
+func (tree *btreeTreeStruct) unpackKey(node *btreeNodeStruct, payloadData []byte, frontCoded bool) (key Key, bytesConsumed uint64, err error) {
+	if !tree.orderedKeys() {
+		key, bytesConsumed, err = tree.BPlusTreeCallbacks.UnpackKey(payloadData)
+		return
+	}
+
+	if frontCoded {
+		key = payloadData
+		bytesConsumed = uint64(len(payloadData))
+		err = nil
+		return
+	}
+
+	encodedKey, bytesConsumed, ok := unpackLengthPrefixed(payloadData)
+	if !ok {
+		err = node.corruptNodeError("unable to unpack encoded Key")
+		return
+	}
+
+	key = append([]byte{}, encodedKey...)
+
+	err = nil
+	return
+}
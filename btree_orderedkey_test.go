@@ -0,0 +1,198 @@
+// Copyright (c) 2015-2021, NVIDIA CORPORATION.
+// SPDX-License-Identifier: Apache-2.0
+
+package sortedmap
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestOrderedKeyEncodings(t *testing.T) {
+	testCases := []struct {
+		encoding OrderedKeyEncoding
+		compare  Compare
+		keys     []Key
+	}{
+		{OrderedInt, CompareInt, []Key{int(-1) << 62, int(-256), int(-1), int(0), int(1), int(255), int(1) << 62}},
+		{OrderedUint16, CompareUint16, []Key{uint16(0), uint16(1), uint16(0x100), uint16(0xFFFF)}},
+		{OrderedUint32, CompareUint32, []Key{uint32(0), uint32(1), uint32(0x100), uint32(0xFFFFFFFF)}},
+		{OrderedUint64, CompareUint64, []Key{uint64(0), uint64(1), uint64(0x100), uint64(0xFFFFFFFFFFFFFFFF)}},
+		{OrderedString, CompareString, []Key{"", "\x00", "\x00\x00", "\x00\x01", "a", "a\x00", "a\x00b", "ab", "a\xFF", "b"}},
+		{OrderedByteSlice, CompareByteSlice, []Key{[]byte{}, []byte{0x00}, []byte{0x00, 0xFF}, []byte{0x01}, []byte{0xFF, 0x00}}},
+		{OrderedTime, CompareTime, []Key{time.Unix(-1, 0), time.Unix(0, 0), time.Unix(0, 1), time.Unix(1, 0), time.Date(2021, time.March, 4, 5, 6, 7, 8, time.UTC)}},
+	}
+
+	for _, testCase := range testCases {
+		for i, key1 := range testCase.keys {
+			encodedKey1, err := testCase.encoding.EncodeKey(key1)
+			if nil != err {
+				t.Fatalf("EncodeKey(%v) failed: %v", key1, err)
+			}
+
+			decodedKey1, bytesConsumed, err := testCase.encoding.DecodeKey(append(encodedKey1, 0x5A))
+			if nil != err {
+				t.Fatalf("DecodeKey(EncodeKey(%v)) failed: %v", key1, err)
+			}
+			if uint64(len(encodedKey1)) != bytesConsumed {
+				t.Fatalf("DecodeKey(EncodeKey(%v)) should have consumed %v bytes, not %v", key1, len(encodedKey1), bytesConsumed)
+			}
+			result, err := testCase.compare(key1, decodedKey1)
+			if (nil != err) || (0 != result) {
+				t.Fatalf("DecodeKey(EncodeKey(%v)) returned %v", key1, decodedKey1)
+			}
+
+			for j, key2 := range testCase.keys {
+				encodedKey2, err := testCase.encoding.EncodeKey(key2)
+				if nil != err {
+					t.Fatalf("EncodeKey(%v) failed: %v", key2, err)
+				}
+
+				expectedResult := 0
+				if i < j {
+					expectedResult = -1
+				} else if i > j {
+					expectedResult = 1
+				}
+
+				if expectedResult != bytes.Compare(encodedKey1, encodedKey2) {
+					t.Fatalf("bytes.Compare(EncodeKey(%v), EncodeKey(%v)) should have returned %v", key1, key2, expectedResult)
+				}
+			}
+		}
+	}
+
+	// Tuples order by their leading fields first
+
+	tupleEncoding := OrderedTuple(OrderedString, OrderedInt)
+
+	tuples := []Key{Tuple{"a", int(-1)}, Tuple{"a", int(0)}, Tuple{"a\x00", int(-5)}, Tuple{"ab", int(-9)}}
+
+	for i := 1; i < len(tuples); i++ {
+		prevEncodedTuple, err := tupleEncoding.EncodeKey(tuples[i-1])
+		if nil != err {
+			t.Fatalf("EncodeKey(%v) failed: %v", tuples[i-1], err)
+		}
+		encodedTuple, err := tupleEncoding.EncodeKey(tuples[i])
+		if nil != err {
+			t.Fatalf("EncodeKey(%v) failed: %v", tuples[i], err)
+		}
+		if 0 <= bytes.Compare(prevEncodedTuple, encodedTuple) {
+			t.Fatalf("EncodeKey(%v) should have sorted before EncodeKey(%v)", tuples[i-1], tuples[i])
+		}
+	}
+
+	_, err := tupleEncoding.EncodeKey(Tuple{"a"})
+	if nil == err {
+		t.Fatalf("EncodeKey() of tuple missing a field should have failed")
+	}
+	_, _, err = OrderedString.DecodeKey([]byte{'a', 0x00, 0x02})
+	if nil == err {
+		t.Fatalf("DecodeKey() of malformed escape should have failed")
+	}
+}
+
+func TestBPlusTreeOrderedKeys(t *testing.T) {
+	nodeStore := &callbacksTestNodeStoreStruct{lastObjectNumberGenerated: 0, objectMap: make(map[uint64][]byte)}
+	callbacks := Callbacks(CodecString, CodecUint64, nodeStore)
+
+	// compare is ignored when Keys are held encoded
+
+	options := &BPlusTreeOptions{OrderedKeyEncoding: OrderedTuple(OrderedString, OrderedInt), KeyPrefixCompression: true}
+
+	btree, err := NewBPlusTreeWithOptions(8, nil, callbacks, nil, options)
+	if nil != err {
+		t.Fatalf("NewBPlusTreeWithOptions() failed: %v", err)
+	}
+
+	rand.Seed(1)
+
+	for _, i := range rand.Perm(200) {
+		ok, err := btree.Put(Tuple{fmt.Sprintf("tenant-%d", i%3), i - 100}, uint64(i))
+		if nil != err {
+			t.Fatalf("btree.Put() failed: %v", err)
+		}
+		if !ok {
+			t.Fatalf("btree.Put().ok should have been true")
+		}
+	}
+
+	rootObjectNumber, rootObjectOffset, rootObjectLength, err := btree.Flush(false)
+	if nil != err {
+		t.Fatalf("btree.Flush(false) failed: %v", err)
+	}
+
+	btree, err = OldBPlusTreeWithOptions(rootObjectNumber, rootObjectOffset, rootObjectLength, nil, callbacks, nil, options)
+	if nil != err {
+		t.Fatalf("OldBPlusTreeWithOptions() failed: %v", err)
+	}
+
+	err = btree.Validate()
+	if nil != err {
+		t.Fatalf("btree.Validate() failed: %v", err)
+	}
+
+	// Keys are returned decoded and in tuple order
+
+	prevKey := Tuple{"", int(-1) << 62}
+
+	for index := 0; index < 200; index++ {
+		keyAsKey, valueAsValue, ok, err := btree.GetByIndex(index)
+		if nil != err {
+			t.Fatalf("btree.GetByIndex(%v) failed: %v", index, err)
+		}
+		if !ok {
+			t.Fatalf("btree.GetByIndex(%v).ok should have been true", index)
+		}
+
+		key := keyAsKey.(Tuple)
+
+		if int(valueAsValue.(uint64)) != (key[1].(int) + 100) {
+			t.Fatalf("btree.GetByIndex(%v) returned mismatched Key:Value", index)
+		}
+		if (key[0].(string) < prevKey[0].(string)) || ((key[0].(string) == prevKey[0].(string)) && (key[1].(int) <= prevKey[1].(int))) {
+			t.Fatalf("btree.GetByIndex(%v) returned Key %v out of order following %v", index, key, prevKey)
+		}
+
+		prevKey = key
+	}
+
+	// Prefix queries over leading fields
+
+	index, found, err := btree.BisectLeft(Tuple{"tenant-1", int(-1) << 62})
+	if nil != err {
+		t.Fatalf("btree.BisectLeft() failed: %v", err)
+	}
+	if found {
+		t.Fatalf("btree.BisectLeft().found should have been false")
+	}
+
+	keyAsKey, _, _, err := btree.GetByIndex(index + 1)
+	if nil != err {
+		t.Fatalf("btree.GetByIndex() failed: %v", err)
+	}
+	if "tenant-1" != keyAsKey.(Tuple)[0].(string) {
+		t.Fatalf("btree.BisectLeft() should have found the start of tenant-1's Keys")
+	}
+
+	valueAsValue, ok, err := btree.GetByKey(Tuple{"tenant-2", int(-98)})
+	if nil != err {
+		t.Fatalf("btree.GetByKey() failed: %v", err)
+	}
+	if !ok || (uint64(2) != valueAsValue.(uint64)) {
+		t.Fatalf("btree.GetByKey() returned unexpected value")
+	}
+
+	ok, err = btree.DeleteByKey(Tuple{"tenant-2", int(-98)})
+	if (nil != err) || !ok {
+		t.Fatalf("btree.DeleteByKey() failed: %v", err)
+	}
+
+	_, err = btree.Put("not a tuple", uint64(0))
+	if nil == err {
+		t.Fatalf("btree.Put() of a non-tuple Key should have failed")
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright (c) 2015-2021, NVIDIA CORPORATION.
+// SPDX-License-Identifier: Apache-2.0
+
+package sortedmap
+
+import (
+	"context"
+	"fmt"
+)
+
+// endIndex returns the index of the Key:Value pair with the smallest (or, if max is true, largest) Key
+func (tree *btreeTreeStruct) endIndex(max bool) (index int) {
+	if max {
+		index = int(tree.root.items) - 1
+	} else {
+		index = 0
+	}
+
+	return
+}
+
+// popWhileLocked removes the Key:Value pair with the smallest (or, if max is true, largest) Key
+func (tree *btreeTreeStruct) popWhileLocked(max bool) (key Key, value Value, ok bool, err error) {
+	index := tree.endIndex(max)
+
+	key, value, ok, err = tree.getByIndexWhileLocked(index)
+	if (nil != err) || !ok {
+		return
+	}
+
+	ok, err = tree.deleteByIndexWhileLocked(index)
+	if nil != err {
+		return
+	}
+	if !ok {
+		err = fmt.Errorf("Logic error: popWhileLocked() call to deleteByIndexWhileLocked() should have worked")
+		return
+	}
+
+	err = nil
+	return
+}
+
+func (tree *btreeTreeStruct) PeekMin() (key Key, value Value, ok bool, err error) {
+	tree.Lock()
+	defer tree.Unlock()
+
+	key, value, ok, err = tree.getByIndexWhileLocked(tree.endIndex(false))
+
+	return
+}
+
+func (tree *btreeTreeStruct) PeekMax() (key Key, value Value, ok bool, err error) {
+	tree.Lock()
+	defer tree.Unlock()
+
+	key, value, ok, err = tree.getByIndexWhileLocked(tree.endIndex(true))
+
+	return
+}
+
+func (tree *btreeTreeStruct) PopMin() (key Key, value Value, ok bool, err error) {
+	tree.Lock()
+	defer tree.Unlock()
+
+	key, value, ok, err = tree.popWhileLocked(false)
+
+	return
+}
+
+func (tree *btreeTreeStruct) PopMax() (key Key, value Value, ok bool, err error) {
+	tree.Lock()
+	defer tree.Unlock()
+
+	key, value, ok, err = tree.popWhileLocked(true)
+
+	return
+}
+
+func (tree *btreeTreeStruct) PopMinWait(ctx context.Context) (key Key, value Value, err error) {
+	tree.Lock()
+	defer tree.Unlock()
+
+	key, value, err = tree.itemWaiter.wait(ctx, &tree.Mutex, func() (Key, Value, bool, error) { return tree.popWhileLocked(false) })
+
+	return
+}
+
+func (tree *btreeTreeStruct) PopMaxWait(ctx context.Context) (key Key, value Value, err error) {
+	tree.Lock()
+	defer tree.Unlock()
+
+	key, value, err = tree.itemWaiter.wait(ctx, &tree.Mutex, func() (Key, Value, bool, error) { return tree.popWhileLocked(true) })
+
+	return
+}
@@ -14,6 +14,19 @@ func (tree *btreeTreeStruct) Validate() (err error) {
 	return
 }
 
+// validateMinKeysPerNode returns the fewest Keys a non-Root node may hold
+//
+// For byte-size-bounded B+Trees, MinNodeBytes is not enforced here as patching a Value
+// to be smaller does not trigger rebalancing.
+func (tree *btreeTreeStruct) validateMinKeysPerNode() (minKeysPerNode uint64) {
+	if tree.byteBounded() {
+		minKeysPerNode = 1
+	} else {
+		minKeysPerNode = tree.minKeysPerNode
+	}
+	return
+}
+
 func (node *btreeNodeStruct) validate() (err error) {
 	if !node.loaded {
 		err = node.tree.loadNode(node)
@@ -39,8 +52,8 @@ func (node *btreeNodeStruct) validate() (err error) {
 			return
 		}
 
-		if !node.root && (uint64(numKeysInLLRB) < node.tree.minKeysPerNode) {
-			err = fmt.Errorf("Non-Root Leaf node @%p kvLLRB.Len() [%d] < node.tree.minKeysPerNode [%d]", node, numKeysInLLRB, node.tree.minKeysPerNode)
+		if !node.root && (uint64(numKeysInLLRB) < node.tree.validateMinKeysPerNode()) {
+			err = fmt.Errorf("Non-Root Leaf node @%p kvLLRB.Len() [%d] < node.tree.minKeysPerNode [%d]", node, numKeysInLLRB, node.tree.validateMinKeysPerNode())
 			return
 		}
 		if uint64(numKeysInLLRB) > node.tree.maxKeysPerNode {
@@ -75,8 +88,8 @@ func (node *btreeNodeStruct) validate() (err error) {
 			return
 		}
 
-		if !node.root && (uint64(numChildrenInLLRB) < node.tree.minKeysPerNode) {
-			err = fmt.Errorf("Non-Root Non-Leaf node @%p kvLLRB.Len() [%d] < node.tree.minKeysPerNode [%d]", node, numChildrenInLLRB, node.tree.minKeysPerNode)
+		if !node.root && (uint64(numChildrenInLLRB) < node.tree.validateMinKeysPerNode()) {
+			err = fmt.Errorf("Non-Root Non-Leaf node @%p kvLLRB.Len() [%d] < node.tree.minKeysPerNode [%d]", node, numChildrenInLLRB, node.tree.validateMinKeysPerNode())
 			return
 		}
 		if uint64(numChildrenInLLRB) > node.tree.maxKeysPerNode {
@@ -0,0 +1,294 @@
+// Copyright (c) 2015-2021, NVIDIA CORPORATION.
+// SPDX-License-Identifier: Apache-2.0
+
+package sortedmap
+
+import (
+	"fmt"
+	"math"
+)
+
+// Out-of-line Value storage (value log)
+//
+// When BPlusTreeOptions.ValueLogThreshold is non-zero, a Value whose PackValue() output
+// exceeds it is, upon the first posting of its leaf node, written via PutValue() rather
+// than into the leaf node itself. The leaf node instead records a small reference to it.
+// Subsequent postings of the leaf node (e.g. as other Key:Value pairs in it are modified)
+// simply record the same reference again, so a large Value is written but once.
+//
+// Leaf nodes posted with onDiskNodeFlagValueLog precede each Value in their Payload with:
+//
+//	onDiskValueInline   followed by the Value as returned by PackValue()
+//	onDiskValueLogged   followed by ObjectNumber, ObjectOffset, & ObjectLength (see packNodeUint64())
+//
+// In memory, a logged Value is held in its leaf node's kvLLRB as a valueLogReferenceStruct
+// that GetByIndex() & GetByKey() transparently resolve via GetValue() & UnpackValue(). Once
+// such a Value is replaced or deleted (or the B+Tree is discarded), its reference is held
+// alongside stale node locations until the next Prune() calls DiscardValue().
+//
+// Note that logged Values are neither encoded by a NodeCodec nor followed by a checksum,
+// and, as they would otherwise bypass encryption, a NodeKeyring may not also be specified.
+// Nor may an Aggregator or Weigher, as lifting or weighing each logged Value would require
+// a GetValue() (e.g. whenever its leaf node's Aggregate or weight is recomputed).
+
+// BPlusTreeValueLogCallbacks may optionally be implemented by a BPlusTreeCallbacks
+//
+// It is required if BPlusTreeOptions.ValueLogThreshold is non-zero. GetValue() must return
+// exactly the valueByteSlice previously passed to PutValue().
+type BPlusTreeValueLogCallbacks interface {
+	PutValue(valueByteSlice []byte) (objectNumber uint64, objectOffset uint64, err error)
+	GetValue(objectNumber uint64, objectOffset uint64, objectLength uint64) (valueByteSlice []byte, err error)
+	DiscardValue(objectNumber uint64, objectOffset uint64, objectLength uint64) (err error)
+}
+
+type valueLogReferenceStruct struct {
+	objectNumber uint64
+	objectOffset uint64
+	objectLength uint64
+}
+
+const (
+	onDiskValueInline = uint8(0x00)
+	onDiskValueLogged = uint8(0x01)
+)
+
+const onDiskValueLogReferenceSize = 1 + 8 + 8 + 8 // marker followed by fixed-size ObjectNumber, ObjectOffset, & ObjectLength
+
+func (tree *btreeTreeStruct) valueLogged() (valueLogged bool) {
+	valueLogged = (0 != tree.valueLogThreshold)
+	return
+}
+
+// packNodeValueBound appends value to payload as packNodeValue() would without writing to the value log
+//
+// A Value that packNodeValue() would write to the value log is appended as a valueLogReferenceStruct
+// of maximal length.
+func (tree *btreeTreeStruct) packNodeValueBound(payloadFlags uint8, payload []byte, value Value) (updatedPayload []byte, err error) {
+	_, alreadyLogged := value.(valueLogReferenceStruct)
+
+	if (0 == (payloadFlags & onDiskNodeFlagValueLog)) || alreadyLogged {
+		updatedPayload, _, err = tree.packNodeValue(payloadFlags, payload, value)
+		return
+	}
+
+	packedValue, err := tree.BPlusTreeCallbacks.PackValue(value)
+	if nil != err {
+		return
+	}
+
+	if uint64(len(packedValue)) <= tree.valueLogThreshold {
+		updatedPayload = append(payload, onDiskValueInline)
+		updatedPayload = append(updatedPayload, packedValue...)
+
+		err = nil
+		return
+	}
+
+	updatedPayload = append(payload, onDiskValueLogged)
+
+	for i := 0; i < 3; i++ { // objectNumber, objectOffset, & objectLength
+		updatedPayload, err = packNodeUint64(payloadFlags, updatedPayload, math.MaxUint64)
+		if nil != err {
+			return
+		}
+	}
+
+	err = nil
+	return
+}
+
+// packNodeValue appends value to payload, writing it to the value log if necessary
+//
+// If value was newly written to the value log, loggedValue is the valueLogReferenceStruct
+// that should replace it in the leaf node's kvLLRB.
+func (tree *btreeTreeStruct) packNodeValue(payloadFlags uint8, payload []byte, value Value) (updatedPayload []byte, loggedValue Value, err error) {
+	valueLogReference, alreadyLogged := value.(valueLogReferenceStruct)
+
+	if 0 == (payloadFlags & onDiskNodeFlagValueLog) {
+		if alreadyLogged {
+			err = fmt.Errorf("Logic error: packNodeValue() found logged Value but onDiskNodeFlagValueLog not set")
+			return
+		}
+
+		packedValue, packValueErr := tree.BPlusTreeCallbacks.PackValue(value)
+		if nil != packValueErr {
+			err = packValueErr
+			return
+		}
+
+		updatedPayload = append(payload, packedValue...)
+
+		err = nil
+		return
+	}
+
+	if !alreadyLogged {
+		packedValue, packValueErr := tree.BPlusTreeCallbacks.PackValue(value)
+		if nil != packValueErr {
+			err = packValueErr
+			return
+		}
+
+		if uint64(len(packedValue)) <= tree.valueLogThreshold {
+			updatedPayload = append(payload, onDiskValueInline)
+			updatedPayload = append(updatedPayload, packedValue...)
+
+			err = nil
+			return
+		}
+
+		objectNumber, objectOffset, putValueErr := tree.valueLog.PutValue(packedValue)
+		if nil != putValueErr {
+			err = putValueErr
+			return
+		}
+
+		valueLogReference = valueLogReferenceStruct{
+			objectNumber: objectNumber,
+			objectOffset: objectOffset,
+			objectLength: uint64(len(packedValue)),
+		}
+
+		loggedValue = valueLogReference
+
+		tree.postedValues++
+		tree.postedValueBytes += valueLogReference.objectLength
+	}
+
+	updatedPayload = append(payload, onDiskValueLogged)
+
+	for _, u64 := range []uint64{valueLogReference.objectNumber, valueLogReference.objectOffset, valueLogReference.objectLength} {
+		updatedPayload, err = packNodeUint64(payloadFlags, updatedPayload, u64)
+		if nil != err {
+			return
+		}
+	}
+
+	err = nil
+	return
+}
+
+func (tree *btreeTreeStruct) unpackNodeValue(payloadFlags uint8, node *btreeNodeStruct, payload []byte) (value Value, bytesConsumed uint64, err error) {
+	var (
+		valueLogReference valueLogReferenceStruct
+	)
+
+	if 0 == (payloadFlags & onDiskNodeFlagValueLog) {
+		value, bytesConsumed, err = tree.BPlusTreeCallbacks.UnpackValue(payload)
+		return
+	}
+
+	if 0 == len(payload) {
+		err = node.corruptNodeError("missing Value marker")
+		return
+	}
+
+	switch payload[0] {
+	case onDiskValueInline:
+		value, bytesConsumed, err = tree.BPlusTreeCallbacks.UnpackValue(payload[1:])
+		if nil != err {
+			return
+		}
+		bytesConsumed++
+		return
+	case onDiskValueLogged:
+		if nil == tree.valueLog {
+			err = fmt.Errorf("logged Value encountered - ValueLogThreshold required")
+			return
+		}
+	default:
+		err = node.corruptNodeError("unexpected Value marker 0x%02X", payload[0])
+		return
+	}
+
+	bytesConsumed = 1
+
+	for _, u64 := range []*uint64{&valueLogReference.objectNumber, &valueLogReference.objectOffset, &valueLogReference.objectLength} {
+		u64Value, u64Size, unpackErr := unpackNodeUint64(payloadFlags, node, payload[bytesConsumed:])
+		if nil != unpackErr {
+			err = unpackErr
+			return
+		}
+
+		*u64 = u64Value
+		bytesConsumed += u64Size
+	}
+
+	value = valueLogReference
+
+	err = nil
+	return
+}
+
+// resolveValue returns the Value a leaf node's kvLLRB holds, fetching it if it was logged
+func (tree *btreeTreeStruct) resolveValue(value Value) (resolvedValue Value, err error) {
+	valueLogReference, ok := value.(valueLogReferenceStruct)
+	if !ok {
+		resolvedValue = value
+		err = nil
+		return
+	}
+
+	valueByteSlice, err := tree.valueLog.GetValue(valueLogReference.objectNumber, valueLogReference.objectOffset, valueLogReference.objectLength)
+	if nil != err {
+		return
+	}
+
+	if uint64(len(valueByteSlice)) != valueLogReference.objectLength {
+		err = fmt.Errorf("GetValue(0x%016X, 0x%016X, 0x%016X) returned %v bytes", valueLogReference.objectNumber, valueLogReference.objectOffset, valueLogReference.objectLength, len(valueByteSlice))
+		return
+	}
+
+	resolvedValue, bytesConsumed, err := tree.BPlusTreeCallbacks.UnpackValue(valueByteSlice)
+	if nil != err {
+		return
+	}
+
+	if bytesConsumed != valueLogReference.objectLength {
+		err = fmt.Errorf("UnpackValue() consumed %v of %v bytes of logged Value", bytesConsumed, valueLogReference.objectLength)
+		return
+	}
+
+	err = nil
+	return
+}
+
+// releaseValue notes that a Value is no longer held by a leaf node's kvLLRB
+func (tree *btreeTreeStruct) releaseValue(value Value) {
+	valueLogReference, ok := value.(valueLogReferenceStruct)
+	if !ok {
+		return
+	}
+
+	if nil == tree.staleValueLogReferences {
+		tree.staleValueLogReferences = make(map[valueLogReferenceStruct]struct{})
+	}
+
+	tree.staleValueLogReferences[valueLogReference] = struct{}{}
+}
+
+// releaseNodeValues notes that none of the Values held by a leaf node's kvLLRB are still needed
+func (tree *btreeTreeStruct) releaseNodeValues(node *btreeNodeStruct) (err error) {
+	if !node.leaf || !tree.valueLogged() {
+		err = nil
+		return
+	}
+
+	llrbLen, err := node.kvLLRB.Len()
+	if nil != err {
+		return
+	}
+
+	for i := 0; i < llrbLen; i++ {
+		_, value, _, getByIndexErr := node.kvLLRB.GetByIndex(i)
+		if nil != getByIndexErr {
+			err = getByIndexErr
+			return
+		}
+
+		tree.releaseValue(value)
+	}
+
+	err = nil
+	return
+}
@@ -0,0 +1,274 @@
+// Copyright (c) 2015-2021, NVIDIA CORPORATION.
+// SPDX-License-Identifier: Apache-2.0
+
+package sortedmap
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+const (
+	valueLogTestThreshold = uint64(1024)
+	valueLogTestNumKeys   = uint32(500)
+)
+
+type valueLogTestContextStruct struct {
+	*nodeBytesTestContextStruct
+	lastValueNumberGenerated uint64
+	valueMap                 map[uint64][]byte // Key == objectNumber (objectOffset always 0)
+	putValueCalls            uint64
+}
+
+func (context *valueLogTestContextStruct) PutValue(valueByteSlice []byte) (objectNumber uint64, objectOffset uint64, err error) {
+	context.lastValueNumberGenerated++
+	objectNumber = context.lastValueNumberGenerated
+	objectOffset = 0
+
+	context.valueMap[objectNumber] = valueByteSlice
+	context.putValueCalls++
+
+	err = nil
+	return
+}
+
+func (context *valueLogTestContextStruct) GetValue(objectNumber uint64, objectOffset uint64, objectLength uint64) (valueByteSlice []byte, err error) {
+	valueByteSlice, ok := context.valueMap[objectNumber]
+	if !ok || (0 != objectOffset) || (uint64(len(valueByteSlice)) != objectLength) {
+		err = fmt.Errorf("logged Value 0x%016X/0x%016X/0x%016X not found", objectNumber, objectOffset, objectLength)
+		return
+	}
+
+	err = nil
+	return
+}
+
+func (context *valueLogTestContextStruct) DiscardValue(objectNumber uint64, objectOffset uint64, objectLength uint64) (err error) {
+	_, err = context.GetValue(objectNumber, objectOffset, objectLength)
+	if nil != err {
+		return
+	}
+
+	delete(context.valueMap, objectNumber)
+
+	err = nil
+	return
+}
+
+func valueLogTestGetByKey(t *testing.T, btree BPlusTree, key uint32, expectedValue []byte) {
+	valueAsValue, ok, err := btree.GetByKey(key)
+	if nil != err {
+		t.Fatalf("btree.GetByKey(%v) failed: %v", key, err)
+	}
+	if !ok {
+		t.Fatalf("btree.GetByKey(%v).ok should have been true", key)
+	}
+	if !bytes.Equal(expectedValue, valueAsValue.([]byte)) {
+		t.Fatalf("btree.GetByKey(%v) returned unexpected value", key)
+	}
+}
+
+func TestBPlusTreeValueLog(t *testing.T) {
+	nodeBytesContext := &nodeBytesTestContextStruct{&specificBPlusTreeTestContextStruct{t: t, lastLogSegmentNumberGenerated: 0, lastLogOffsetGenerated: 0, logSegmentChunkMap: make(map[uint64]*logSegmentChunkStruct)}}
+	persistentContext := &valueLogTestContextStruct{nodeBytesTestContextStruct: nodeBytesContext, valueMap: make(map[uint64][]byte)}
+
+	options := &BPlusTreeOptions{ValueLogThreshold: valueLogTestThreshold}
+
+	btree, err := NewBPlusTreeWithOptions(32, CompareUint32, persistentContext, nil, options)
+	if nil != err {
+		t.Fatalf("NewBPlusTreeWithOptions() failed: %v", err)
+	}
+
+	for key := uint32(0); key < valueLogTestNumKeys; key++ {
+		ok, err := btree.Put(key, nodeBytesTestValue(key))
+		if nil != err {
+			t.Fatalf("btree.Put(%v,) failed: %v", key, err)
+		}
+		if !ok {
+			t.Fatalf("btree.Put(%v,).ok should have been true", key)
+		}
+	}
+
+	_, _, _, err = btree.Flush(false)
+	if nil != err {
+		t.Fatalf("btree.Flush(false) failed: %v", err)
+	}
+
+	numLoggedValues := uint64((valueLogTestNumKeys + 6) / 7) // only every 7th Value exceeds valueLogTestThreshold
+
+	if numLoggedValues != persistentContext.putValueCalls {
+		t.Fatalf("PutValue() called %v times but should have been called %v times", persistentContext.putValueCalls, numLoggedValues)
+	}
+
+	dimensionsReport, err := btree.FetchDimensionsReport()
+	if nil != err {
+		t.Fatalf("btree.FetchDimensionsReport() failed: %v", err)
+	}
+	if numLoggedValues != dimensionsReport.PostedValues {
+		t.Fatalf("btree.FetchDimensionsReport().PostedValues should have been %v", numLoggedValues)
+	}
+	if dimensionsReport.PostedBytes >= dimensionsReport.PostedValueBytes {
+		t.Fatalf("btree.FetchDimensionsReport().PostedBytes should have been well below PostedValueBytes")
+	}
+
+	// Logged Values are resolved transparently
+
+	valueLogTestGetByKey(t, btree, uint32(7), nodeBytesTestValue(7))
+
+	_, valueAsValue, ok, err := btree.GetByIndex(14)
+	if nil != err {
+		t.Fatalf("btree.GetByIndex(14) failed: %v", err)
+	}
+	if !ok || !bytes.Equal(nodeBytesTestValue(14), valueAsValue.([]byte)) {
+		t.Fatalf("btree.GetByIndex(14) returned unexpected value")
+	}
+
+	// Reposting a leaf node must not rewrite its logged Values
+
+	ok, err = btree.PatchByKey(uint32(8), []byte{0x08})
+	if nil != err {
+		t.Fatalf("btree.PatchByKey(8,) failed: %v", err)
+	}
+	if !ok {
+		t.Fatalf("btree.PatchByKey(8,).ok should have been true")
+	}
+
+	err = btree.Touch()
+	if nil != err {
+		t.Fatalf("btree.Touch() failed: %v", err)
+	}
+
+	_, _, _, err = btree.Flush(false)
+	if nil != err {
+		t.Fatalf("btree.Flush(false) failed: %v", err)
+	}
+
+	if numLoggedValues != persistentContext.putValueCalls {
+		t.Fatalf("PutValue() should not have been called again")
+	}
+
+	// Replaced and deleted logged Values are discarded by Prune()
+
+	ok, err = btree.PatchByKey(uint32(21), []byte{0x21})
+	if nil != err {
+		t.Fatalf("btree.PatchByKey(21,) failed: %v", err)
+	}
+	if !ok {
+		t.Fatalf("btree.PatchByKey(21,).ok should have been true")
+	}
+
+	ok, err = btree.DeleteByKey(uint32(28))
+	if nil != err {
+		t.Fatalf("btree.DeleteByKey(28) failed: %v", err)
+	}
+	if !ok {
+		t.Fatalf("btree.DeleteByKey(28).ok should have been true")
+	}
+
+	rootObjectNumber, rootObjectOffset, rootObjectLength, err := btree.Flush(false)
+	if nil != err {
+		t.Fatalf("btree.Flush(false) failed: %v", err)
+	}
+
+	err = btree.Prune()
+	if nil != err {
+		t.Fatalf("btree.Prune() failed: %v", err)
+	}
+
+	if (numLoggedValues - 2) != uint64(len(persistentContext.valueMap)) {
+		t.Fatalf("Prune() should have discarded 2 logged Values but %v remain", len(persistentContext.valueMap))
+	}
+
+	// Logged Values survive reloading the B+Tree
+
+	btree, err = OldBPlusTreeWithOptions(rootObjectNumber, rootObjectOffset, rootObjectLength, CompareUint32, persistentContext, nil, options)
+	if nil != err {
+		t.Fatalf("OldBPlusTreeWithOptions() failed: %v", err)
+	}
+
+	valueLogTestGetByKey(t, btree, uint32(0), nodeBytesTestValue(0))
+	valueLogTestGetByKey(t, btree, uint32(8), []byte{0x08})
+	valueLogTestGetByKey(t, btree, uint32(21), []byte{0x21})
+	valueLogTestGetByKey(t, btree, uint32(35), nodeBytesTestValue(35))
+
+	_, ok, err = btree.GetByKey(uint32(28))
+	if nil != err {
+		t.Fatalf("btree.GetByKey(28) failed: %v", err)
+	}
+	if ok {
+		t.Fatalf("btree.GetByKey(28).ok should have been false")
+	}
+
+	// Discard() releases logged Values alongside nodes
+
+	err = btree.Discard()
+	if nil != err {
+		t.Fatalf("btree.Discard() failed: %v", err)
+	}
+
+	if 0 != len(persistentContext.valueMap) {
+		t.Fatalf("Discard() should have discarded all logged Values but %v remain", len(persistentContext.valueMap))
+	}
+	if 0 != len(persistentContext.logSegmentChunkMap) {
+		t.Fatalf("Discard() should have discarded all nodes but %v remain", len(persistentContext.logSegmentChunkMap))
+	}
+
+	// ValueLogThreshold requires BPlusTreeValueLogCallbacks
+
+	_, err = OldBPlusTreeWithOptions(rootObjectNumber, rootObjectOffset, rootObjectLength, CompareUint32, nodeBytesContext, nil, options)
+	if nil == err {
+		t.Fatalf("OldBPlusTreeWithOptions() without BPlusTreeValueLogCallbacks should have failed")
+	}
+
+	// ValueLogThreshold precludes an Aggregator or Weigher
+
+	_, err = NewBPlusTreeWithOptions(32, CompareUint32, persistentContext, nil, &BPlusTreeOptions{ValueLogThreshold: valueLogTestThreshold, Weigher: func(key Key, value Value) (weight uint64) { return 1 }})
+	if nil == err {
+		t.Fatalf("NewBPlusTreeWithOptions() with ValueLogThreshold & Weigher should have failed")
+	}
+
+	// Values bound for the value log only count their logged reference toward MaxNodeBytes
+
+	btree, err = NewBPlusTreeWithOptions(1024, CompareUint32, persistentContext, nil, &BPlusTreeOptions{ValueLogThreshold: valueLogTestThreshold, MaxNodeBytes: nodeBytesTestMaxNodeBytes, MinNodeBytes: nodeBytesTestMinNodeBytes})
+	if nil != err {
+		t.Fatalf("NewBPlusTreeWithOptions() failed: %v", err)
+	}
+
+	for key := uint32(0); key < nodeBytesTestNumKeys; key++ {
+		_, err = btree.Put(key, nodeBytesTestValue(key))
+		if nil != err {
+			t.Fatalf("btree.Put(%v,) failed: %v", key, err)
+		}
+	}
+
+	var sumNodeBytes func(node *btreeNodeStruct) (nodeBytes uint64)
+
+	sumNodeBytes = func(node *btreeNodeStruct) (nodeBytes uint64) {
+		nodeBytes, err := btree.(*btreeTreeStruct).nodeBytes(node)
+		if nil != err {
+			t.Fatalf("tree.nodeBytes() failed: %v", err)
+		}
+		if !node.leaf {
+			nodeBytes += sumNodeBytes(node.nonLeafLeftChild)
+			llrbLen, _ := node.kvLLRB.Len()
+			for i := 0; i < llrbLen; i++ {
+				_, childNodeAsValue, _, _ := node.kvLLRB.GetByIndex(i)
+				nodeBytes += sumNodeBytes(childNodeAsValue.(*btreeNodeStruct))
+			}
+		}
+		return
+	}
+
+	unpostedNodeBytes := sumNodeBytes(btree.(*btreeTreeStruct).root)
+
+	nodeBytesTestCheck(t, btree, "with ValueLogThreshold")
+
+	dimensionsReport, err = btree.FetchDimensionsReport()
+	if nil != err {
+		t.Fatalf("btree.FetchDimensionsReport() failed: %v", err)
+	}
+	if unpostedNodeBytes != dimensionsReport.PostedBytes {
+		t.Fatalf("tree.nodeBytes() summed to %v prior to posting but PostedBytes was %v", unpostedNodeBytes, dimensionsReport.PostedBytes)
+	}
+}
@@ -0,0 +1,236 @@
+// Copyright (c) 2015-2021, NVIDIA CORPORATION.
+// SPDX-License-Identifier: Apache-2.0
+
+package sortedmap
+
+import (
+	"encoding/binary"
+
+	"github.com/NVIDIA/cstruct"
+)
+
+// Variable-length integer encoding
+//
+// When a node is posted with onDiskNodeFlagVarint, the fixed-size integers otherwise
+// packed via cstruct are instead recorded as:
+//
+//	onDiskNodeStruct            uvarint Items, uint8 (Root ? 0x01 : 0) | (Leaf ? 0x02 : 0), Payload
+//	maxKeysPerNode              uvarint
+//	number of Keys or children  uvarint
+//	onDiskReferenceToNodeStruct varint  ObjectNumber delta from the preceding child reference
+//	                            varint  ObjectOffset delta from the preceding child reference
+//	                            uvarint ObjectLength
+//	                            uvarint Items
+//
+// Deltas for the first child reference in a node are relative to zero. As children of a
+// node tend to have been posted together, their ObjectNumbers and ObjectOffsets are often
+// close to one another, so their deltas are typically small.
+
+const (
+	onDiskNodeVarintRoot = uint8(0x01)
+	onDiskNodeVarintLeaf = uint8(0x02)
+)
+
+func packNodeStruct(payloadFlags uint8, onDiskNode *onDiskNodeStruct) (onDiskNodeBuf []byte, err error) {
+	var (
+		rootLeaf uint8
+	)
+
+	if 0 == (payloadFlags & onDiskNodeFlagVarint) {
+		onDiskNodeBuf, err = cstruct.Pack(*onDiskNode, nodeByteOrder(payloadFlags))
+		return
+	}
+
+	if onDiskNode.Root {
+		rootLeaf |= onDiskNodeVarintRoot
+	}
+	if onDiskNode.Leaf {
+		rootLeaf |= onDiskNodeVarintLeaf
+	}
+
+	onDiskNodeBuf = make([]byte, 0, binary.MaxVarintLen64+1+len(onDiskNode.Payload))
+	onDiskNodeBuf = binary.AppendUvarint(onDiskNodeBuf, onDiskNode.Items)
+	onDiskNodeBuf = append(onDiskNodeBuf, rootLeaf)
+	onDiskNodeBuf = append(onDiskNodeBuf, onDiskNode.Payload...)
+
+	err = nil
+	return
+}
+
+func unpackNodeStruct(payloadFlags uint8, node *btreeNodeStruct, onDiskNodeBuf []byte, onDiskNode *onDiskNodeStruct) (err error) {
+	if 0 == (payloadFlags & onDiskNodeFlagVarint) {
+		_, err = cstruct.Unpack(onDiskNodeBuf, onDiskNode, nodeByteOrder(payloadFlags))
+		if nil != err {
+			err = node.corruptNodeError("%v", err)
+		}
+		return
+	}
+
+	items, bytesConsumed := binary.Uvarint(onDiskNodeBuf)
+	if (0 >= bytesConsumed) || (bytesConsumed >= len(onDiskNodeBuf)) {
+		err = node.corruptNodeError("unable to decode varint node header")
+		return
+	}
+
+	rootLeaf := onDiskNodeBuf[bytesConsumed]
+	if 0 != (rootLeaf & ^(onDiskNodeVarintRoot | onDiskNodeVarintLeaf)) {
+		err = node.corruptNodeError("unexpected varint node header Root/Leaf byte 0x%02X", rootLeaf)
+		return
+	}
+
+	onDiskNode.Items = items
+	onDiskNode.Root = (0 != (rootLeaf & onDiskNodeVarintRoot))
+	onDiskNode.Leaf = (0 != (rootLeaf & onDiskNodeVarintLeaf))
+	onDiskNode.Payload = onDiskNodeBuf[bytesConsumed+1:]
+
+	err = nil
+	return
+}
+
+func packNodeUint64(payloadFlags uint8, payload []byte, u64 uint64) (updatedPayload []byte, err error) {
+	if 0 != (payloadFlags & onDiskNodeFlagVarint) {
+		updatedPayload = binary.AppendUvarint(payload, u64)
+		err = nil
+		return
+	}
+
+	u64Buf, err := cstruct.Pack(onDiskUint64Struct{U64: u64}, nodeByteOrder(payloadFlags))
+	if nil != err {
+		return
+	}
+
+	updatedPayload = append(payload, u64Buf...)
+
+	err = nil
+	return
+}
+
+func unpackNodeUint64(payloadFlags uint8, node *btreeNodeStruct, payload []byte) (u64 uint64, bytesConsumed uint64, err error) {
+	var (
+		u64Struct onDiskUint64Struct
+	)
+
+	if 0 != (payloadFlags & onDiskNodeFlagVarint) {
+		u64, bytesConsumed, err = unpackNodeUvarint(node, payload)
+		return
+	}
+
+	bytesConsumed, err = cstruct.Unpack(payload, &u64Struct, nodeByteOrder(payloadFlags))
+	if nil != err {
+		err = node.corruptNodeError("%v", err)
+		return
+	}
+
+	u64 = u64Struct.U64
+
+	err = nil
+	return
+}
+
+func packNodeReference(payloadFlags uint8, payload []byte, prevReferenceToNode *onDiskReferenceToNodeStruct, referenceToNode *onDiskReferenceToNodeStruct) (updatedPayload []byte, err error) {
+	if 0 == (payloadFlags & onDiskNodeFlagVarint) {
+		referenceToNodeBuf, packErr := cstruct.Pack(*referenceToNode, nodeByteOrder(payloadFlags))
+		if nil != packErr {
+			err = packErr
+			return
+		}
+
+		updatedPayload = append(payload, referenceToNodeBuf...)
+
+		err = nil
+		return
+	}
+
+	updatedPayload = binary.AppendVarint(payload, int64(referenceToNode.ObjectNumber-prevReferenceToNode.ObjectNumber))
+	updatedPayload = binary.AppendVarint(updatedPayload, int64(referenceToNode.ObjectOffset-prevReferenceToNode.ObjectOffset))
+	updatedPayload = binary.AppendUvarint(updatedPayload, referenceToNode.ObjectLength)
+	updatedPayload = binary.AppendUvarint(updatedPayload, referenceToNode.Items)
+
+	*prevReferenceToNode = *referenceToNode
+
+	err = nil
+	return
+}
+
+// packNodeReferenceBound appends referenceToNode as packNodeReference() would once posted
+//
+// If unposted, referenceToNode (or prevReferenceToNode relative to which it is varint-encoded)
+// has yet to be posted, so each field whose varint encoding would then change is instead
+// appended at its maximum length.
+func packNodeReferenceBound(payloadFlags uint8, payload []byte, prevReferenceToNode *onDiskReferenceToNodeStruct, referenceToNode *onDiskReferenceToNodeStruct, unposted bool) (updatedPayload []byte, err error) {
+	if !unposted || (0 == (payloadFlags & onDiskNodeFlagVarint)) {
+		updatedPayload, err = packNodeReference(payloadFlags, payload, prevReferenceToNode, referenceToNode)
+		return
+	}
+
+	updatedPayload = append(payload, make([]byte, 3*binary.MaxVarintLen64)...) // ObjectNumber, ObjectOffset, & ObjectLength
+	updatedPayload = binary.AppendUvarint(updatedPayload, referenceToNode.Items)
+
+	*prevReferenceToNode = *referenceToNode
+
+	err = nil
+	return
+}
+
+func unpackNodeReference(payloadFlags uint8, node *btreeNodeStruct, payload []byte, prevReferenceToNode *onDiskReferenceToNodeStruct, referenceToNode *onDiskReferenceToNodeStruct) (bytesConsumed uint64, err error) {
+	if 0 == (payloadFlags & onDiskNodeFlagVarint) {
+		bytesConsumed, err = cstruct.Unpack(payload, referenceToNode, nodeByteOrder(payloadFlags))
+		if nil != err {
+			err = node.corruptNodeError("%v", err)
+		}
+		return
+	}
+
+	objectNumberDelta, objectNumberDeltaSize := binary.Varint(payload)
+	if 0 >= objectNumberDeltaSize {
+		err = node.corruptNodeError("unable to decode varint child ObjectNumber")
+		return
+	}
+
+	bytesConsumed = uint64(objectNumberDeltaSize)
+
+	objectOffsetDelta, objectOffsetDeltaSize := binary.Varint(payload[bytesConsumed:])
+	if 0 >= objectOffsetDeltaSize {
+		err = node.corruptNodeError("unable to decode varint child ObjectOffset")
+		return
+	}
+
+	bytesConsumed += uint64(objectOffsetDeltaSize)
+
+	objectLength, objectLengthSize, err := unpackNodeUvarint(node, payload[bytesConsumed:])
+	if nil != err {
+		return
+	}
+
+	bytesConsumed += objectLengthSize
+
+	items, itemsSize, err := unpackNodeUvarint(node, payload[bytesConsumed:])
+	if nil != err {
+		return
+	}
+
+	bytesConsumed += itemsSize
+
+	referenceToNode.ObjectNumber = prevReferenceToNode.ObjectNumber + uint64(objectNumberDelta)
+	referenceToNode.ObjectOffset = prevReferenceToNode.ObjectOffset + uint64(objectOffsetDelta)
+	referenceToNode.ObjectLength = objectLength
+	referenceToNode.Items = items
+
+	*prevReferenceToNode = *referenceToNode
+
+	err = nil
+	return
+}
+
+func unpackNodeUvarint(node *btreeNodeStruct, payload []byte) (u64 uint64, bytesConsumed uint64, err error) {
+	u64, uvarintSize := binary.Uvarint(payload)
+	if 0 >= uvarintSize {
+		err = node.corruptNodeError("unable to decode uvarint")
+		return
+	}
+
+	bytesConsumed = uint64(uvarintSize)
+
+	err = nil
+	return
+}
@@ -0,0 +1,73 @@
+// Copyright (c) 2015-2021, NVIDIA CORPORATION.
+// SPDX-License-Identifier: Apache-2.0
+
+package sortedmap
+
+import (
+	"testing"
+)
+
+func varintTestPosted(t *testing.T, options *BPlusTreeOptions) (persistentContext *specificBPlusTreeTestContextStruct, rootObjectNumber uint64, rootObjectOffset uint64, rootObjectLength uint64, postedRawBytes uint64) {
+	persistentContext = &specificBPlusTreeTestContextStruct{t: t, lastLogSegmentNumberGenerated: 0, lastLogOffsetGenerated: 0, logSegmentChunkMap: make(map[uint64]*logSegmentChunkStruct)}
+
+	btree, err := NewBPlusTreeWithOptions(specificBPlusTreeTestNumKeysMaxSmall, CompareUint32, persistentContext, nil, options)
+	if nil != err {
+		t.Fatalf("NewBPlusTreeWithOptions() failed: %v", err)
+	}
+
+	testFormatPutKeys(t, btree, 0, 200)
+
+	rootObjectNumber, rootObjectOffset, rootObjectLength, err = btree.Flush(false)
+	if nil != err {
+		t.Fatalf("btree.Flush(false) failed: %v", err)
+	}
+
+	dimensionsReport, err := btree.FetchDimensionsReport()
+	if nil != err {
+		t.Fatalf("btree.FetchDimensionsReport() failed: %v", err)
+	}
+
+	postedRawBytes = dimensionsReport.PostedRawBytes
+
+	return
+}
+
+func TestBPlusTreeVarintEncoding(t *testing.T) {
+	_, _, _, _, fixedRawBytes := varintTestPosted(t, nil)
+
+	persistentContext, rootObjectNumber, rootObjectOffset, rootObjectLength, varintRawBytes := varintTestPosted(t, &BPlusTreeOptions{VarintEncoding: true})
+
+	if varintRawBytes >= fixedRawBytes {
+		t.Fatalf("VarintEncoding should have reduced PostedRawBytes (%v vs %v)", varintRawBytes, fixedRawBytes)
+	}
+
+	// Varint-encoded nodes are self-describing, so no option is needed to read them back
+
+	btree, err := OldBPlusTree(rootObjectNumber, rootObjectOffset, rootObjectLength, CompareUint32, persistentContext, nil)
+	if nil != err {
+		t.Fatalf("OldBPlusTree() [case 1] failed: %v", err)
+	}
+
+	testFormatVerifyKeys(t, btree, 200)
+
+	// Rewriting only some nodes in the fixed-size encoding yields a readable mixed tree
+
+	_, err = btree.TouchItem(0)
+	if nil != err {
+		t.Fatalf("btree.TouchItem(0) failed: %v", err)
+	}
+
+	testFormatPutKeys(t, btree, 200, 210)
+
+	rootObjectNumber, rootObjectOffset, rootObjectLength, err = btree.Flush(false)
+	if nil != err {
+		t.Fatalf("btree.Flush(false) failed: %v", err)
+	}
+
+	btree, err = OldBPlusTreeWithOptions(rootObjectNumber, rootObjectOffset, rootObjectLength, CompareUint32, persistentContext, nil, &BPlusTreeOptions{VarintEncoding: true, KeyPrefixCompression: true})
+	if nil != err {
+		t.Fatalf("OldBPlusTreeWithOptions() [case 2] failed: %v", err)
+	}
+
+	testFormatVerifyKeys(t, btree, 210)
+}
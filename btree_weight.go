@@ -0,0 +1,382 @@
+// Copyright (c) 2015-2021, NVIDIA CORPORATION.
+// SPDX-License-Identifier: Apache-2.0
+
+package sortedmap
+
+import (
+	"fmt"
+)
+
+// B+Tree weights
+//
+// When BPlusTreeOptions.Weigher is non-nil, each node caches the total weight of the items
+// at or below it and each child of a non-leaf node caches, as prefixSumWeight, the total
+// weight of the children in the prefix sum binary tree "rooted" by it (i.e. just as
+// prefixSumItems does for items). These are invalidated whenever the node (or any node below
+// it) is marked dirty and are recomputed on demand. A node's weight is always computed as it
+// is posted, so that its parent may record it following Items in its reference to the node:
+//
+//	uint64   total weight of the items at or below the child node (see packNodeUint64())
+//
+// As such, GetByWeight() & WeightBefore() need load only the nodes along a single path from
+// the Root node to a leaf node. Each posted node sets onDiskNodeExtFlagWeights (requiring
+// onDiskNodeVersion2 or later), so OldBPlusTree() must be supplied a Weigher precisely when
+// the B+Tree was posted with one (see checkPayloadExtFlags()). Note that the Weigher itself
+// is not recorded, so the same Weigher must be supplied.
+
+func (tree *btreeTreeStruct) weighted() (weighted bool) {
+	weighted = (nil != tree.weigher)
+	return
+}
+
+// invalidateNodeWeight discards the cached weight of node & its ancestors
+//
+// As a node's weight is only ever computed once those of its children are, a node with no
+// cached weight has no ancestor with one.
+func (tree *btreeTreeStruct) invalidateNodeWeight(node *btreeNodeStruct) {
+	for (nil != node) && node.weightValid {
+		node.weightValid = false
+
+		node = node.parentNode
+	}
+}
+
+// nodeWeight returns the total weight of the items at or below node (loading node if necessary)
+//
+// Upon return, if node is loaded and is not a leaf, the prefixSumWeight of each of its
+// children is also valid.
+func (tree *btreeTreeStruct) nodeWeight(node *btreeNodeStruct) (weight uint64, err error) {
+	if node.weightValid {
+		weight = node.weight
+		err = nil
+		return
+	}
+
+	if node.loaded {
+		tree.incCacheHits()
+		tree.markNodeUsed(node)
+	} else {
+		tree.incCacheMisses()
+		err = tree.loadNode(node) // will also mark node clean/used in LRU
+		if nil != err {
+			return
+		}
+	}
+
+	weight = 0
+
+	if node.leaf {
+		llrbLen, nonShadowingErr := node.kvLLRB.Len()
+		if nil != nonShadowingErr {
+			err = nonShadowingErr
+			return
+		}
+
+		for i := 0; i < llrbLen; i++ {
+			itemWeight, itemWeightErr := tree.itemWeight(node, i)
+			if nil != itemWeightErr {
+				err = itemWeightErr
+				return
+			}
+
+			weight += itemWeight
+		}
+	} else {
+		childNodes, childNodesErr := tree.childNodes(node)
+		if nil != childNodesErr {
+			err = childNodesErr
+			return
+		}
+
+		for _, childNode := range childNodes {
+			childWeight, childWeightErr := tree.nodeWeight(childNode)
+			if nil != childWeightErr {
+				err = childWeightErr
+				return
+			}
+
+			weight += childWeight
+		}
+
+		tree.arrangePrefixSumWeight(node.rootPrefixSumChild)
+	}
+
+	node.weight = weight
+	node.weightValid = true
+
+	err = nil
+	return
+}
+
+// itemWeight returns the weight of the i'th Key:Value pair in (loaded) leaf node
+func (tree *btreeTreeStruct) itemWeight(node *btreeNodeStruct, i int) (weight uint64, err error) {
+	key, value, ok, err := node.kvLLRB.GetByIndex(i)
+	if nil != err {
+		return
+	}
+	if !ok {
+		err = fmt.Errorf("Logic error: itemWeight() call to GetByIndex() should have worked")
+		return
+	}
+
+	key, err = tree.decodeKey(key)
+	if nil != err {
+		return
+	}
+
+	weight = tree.weigher(key, value)
+
+	err = nil
+	return
+}
+
+// arrangePrefixSumWeight computes prefixSumWeight for the prefix sum binary tree "rooted" by prefixSumNode
+//
+// The weight of each child btreeNodeStruct in the prefix sum binary tree must be valid.
+func (tree *btreeTreeStruct) arrangePrefixSumWeight(prefixSumNode *btreeNodeStruct) (prefixSumWeight uint64) {
+	if nil == prefixSumNode {
+		prefixSumWeight = 0
+		return
+	}
+
+	prefixSumWeight = prefixSumNode.weight
+	prefixSumWeight += tree.arrangePrefixSumWeight(prefixSumNode.prefixSumLeftChild)
+	prefixSumWeight += tree.arrangePrefixSumWeight(prefixSumNode.prefixSumRightChild)
+
+	prefixSumNode.prefixSumWeight = prefixSumWeight
+
+	return
+}
+
+// prefixSumWeightBefore returns the total weight of the children of childNode's parent preceding childNode
+func prefixSumWeightBefore(childNode *btreeNodeStruct) (weight uint64) {
+	if nil != childNode.prefixSumLeftChild {
+		weight = childNode.prefixSumLeftChild.prefixSumWeight
+	}
+
+	for prefixSumNode := childNode; nil != prefixSumNode.prefixSumParent; prefixSumNode = prefixSumNode.prefixSumParent {
+		prefixSumParent := prefixSumNode.prefixSumParent
+
+		if prefixSumNode == prefixSumParent.prefixSumRightChild {
+			weight += prefixSumParent.weight
+			if nil != prefixSumParent.prefixSumLeftChild {
+				weight += prefixSumParent.prefixSumLeftChild.prefixSumWeight
+			}
+		}
+	}
+
+	return
+}
+
+// packNodeWeight appends the (already computed) weight of childNode to payload
+func (tree *btreeTreeStruct) packNodeWeight(payloadFlags uint8, payload []byte, childNode *btreeNodeStruct) (updatedPayload []byte, err error) {
+	if !childNode.weightValid {
+		err = fmt.Errorf("Logic error: packNodeWeight() found childNode weight not computed")
+		return
+	}
+
+	updatedPayload, err = packNodeUint64(payloadFlags, payload, childNode.weight)
+
+	return
+}
+
+// unpackNodeWeight reverses packNodeWeight(), caching the weight in childNode
+func (tree *btreeTreeStruct) unpackNodeWeight(payloadFlags uint8, node *btreeNodeStruct, payload []byte, childNode *btreeNodeStruct) (bytesConsumed uint64, err error) {
+	weight, bytesConsumed, err := unpackNodeUint64(payloadFlags, node, payload)
+	if nil != err {
+		return
+	}
+
+	childNode.weight = weight
+	childNode.weightValid = true
+
+	err = nil
+	return
+}
+
+// API functions (see api.go)
+
+func (tree *btreeTreeStruct) GetByWeight(offset uint64) (key Key, value Value, offsetWithinItem uint64, ok bool, err error) {
+	var (
+		leftChildPrefixSumWeight uint64
+	)
+
+	tree.Lock()
+	defer tree.Unlock()
+
+	if !tree.weighted() {
+		err = fmt.Errorf("GetByWeight() requires a Weigher")
+		return
+	}
+
+	node := tree.root
+
+	weight, err := tree.nodeWeight(node)
+	if nil != err {
+		return
+	}
+
+	if offset >= weight {
+		ok = false
+		err = nil
+		return
+	}
+
+	for {
+		if node.loaded {
+			tree.incCacheHits()
+			tree.markNodeUsed(node)
+		} else {
+			tree.incCacheMisses()
+			err = tree.loadNode(node) // will also mark node clean/used in LRU
+			if nil != err {
+				return
+			}
+		}
+
+		if node.leaf {
+			llrbLen, nonShadowingErr := node.kvLLRB.Len()
+			if nil != nonShadowingErr {
+				err = nonShadowingErr
+				return
+			}
+
+			for i := 0; i < llrbLen; i++ {
+				itemWeight, itemWeightErr := tree.itemWeight(node, i)
+				if nil != itemWeightErr {
+					err = itemWeightErr
+					return
+				}
+
+				if offset < itemWeight {
+					key, value, _, err = node.kvLLRB.GetByIndex(i)
+					if nil != err {
+						return
+					}
+					key, err = tree.decodeKey(key)
+					if nil != err {
+						return
+					}
+					value, err = tree.resolveValue(value)
+					if nil != err {
+						return
+					}
+					offsetWithinItem = offset
+					ok = true
+					err = nil
+					return
+				}
+
+				offset -= itemWeight
+			}
+
+			err = fmt.Errorf("Logic error: GetByWeight() found leaf node lighter than its recorded weight")
+			return
+		}
+
+		_, err = tree.nodeWeight(node) // ensures prefixSumWeight of node's children are valid
+		if nil != err {
+			return
+		}
+
+		node = node.rootPrefixSumChild
+
+		for {
+			if nil == node.prefixSumLeftChild {
+				leftChildPrefixSumWeight = 0
+			} else {
+				leftChildPrefixSumWeight = node.prefixSumLeftChild.prefixSumWeight
+			}
+
+			if offset < leftChildPrefixSumWeight {
+				node = node.prefixSumLeftChild
+			} else if offset < (leftChildPrefixSumWeight + node.weight) {
+				offset -= leftChildPrefixSumWeight
+				break
+			} else {
+				offset -= (leftChildPrefixSumWeight + node.weight)
+				node = node.prefixSumRightChild
+			}
+		}
+	}
+}
+
+func (tree *btreeTreeStruct) WeightBefore(key Key) (weight uint64, err error) {
+	key, err = tree.encodeKey(key)
+	if nil != err {
+		return
+	}
+
+	tree.Lock()
+	defer tree.Unlock()
+
+	if !tree.weighted() {
+		err = fmt.Errorf("WeightBefore() requires a Weigher")
+		return
+	}
+
+	node := tree.root
+
+	for {
+		if node.loaded {
+			tree.incCacheHits()
+			tree.markNodeUsed(node)
+		} else {
+			tree.incCacheMisses()
+			err = tree.loadNode(node) // will also mark node clean/used in LRU
+			if nil != err {
+				return
+			}
+		}
+
+		if node.leaf {
+			index, found, nonShadowingErr := node.kvLLRB.BisectLeft(key)
+			if nil != nonShadowingErr {
+				err = nonShadowingErr
+				return
+			}
+
+			if !found {
+				index++ // items at [0,index] precede key
+			}
+
+			for i := 0; i < index; i++ {
+				itemWeight, itemWeightErr := tree.itemWeight(node, i)
+				if nil != itemWeightErr {
+					err = itemWeightErr
+					return
+				}
+
+				weight += itemWeight
+			}
+
+			err = nil
+			return
+		}
+
+		_, err = tree.nodeWeight(node) // ensures prefixSumWeight of node's children are valid
+		if nil != err {
+			return
+		}
+
+		index, _, nonShadowingErr := node.kvLLRB.BisectLeft(key)
+		if nil != nonShadowingErr {
+			err = nonShadowingErr
+			return
+		}
+
+		if 0 > index {
+			node = node.nonLeafLeftChild
+		} else {
+			_, childNodeAsValue, _, nonShadowingErr := node.kvLLRB.GetByIndex(index)
+			if nil != nonShadowingErr {
+				err = nonShadowingErr
+				return
+			}
+
+			node = childNodeAsValue.(*btreeNodeStruct)
+		}
+
+		weight += prefixSumWeightBefore(node)
+	}
+}
@@ -0,0 +1,365 @@
+// Copyright (c) 2015-2021, NVIDIA CORPORATION.
+// SPDX-License-Identifier: Apache-2.0
+
+package sortedmap
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// Built-in Key & Value codecs
+//
+// Each of CodecInt, CodecUint16, CodecUint32, CodecUint64, CodecString, CodecByteSlice, &
+// CodecTime packs, unpacks, & dumps the same type supported by the correspondingly named
+// Compare func (e.g. CodecUint32 & CompareUint32). Integers are packed as fixed-size
+// little-endian values. Strings & []byte slices are packed as a uvarint length followed by
+// their bytes. A time.Time is packed as a uvarint length followed by its MarshalBinary()
+// output. As such, each codec's Unpack{Key|Value}() consumes exactly what its Pack{Key|Value}()
+// produced (as required by BPlusTreeOptions.KeyPrefixCompression).
+//
+// Since a Codec implements DumpCallbacks, a Codec (or any KeyCodec/ValueCodec pair combined
+// via Callbacks()) may also be supplied as an LLRBTreeCallbacks.
+
+// KeyCodec specifies the Key-related subset of BPlusTreeCallbacks
+type KeyCodec interface {
+	DumpKey(key Key) (keyAsString string, err error)
+	PackKey(key Key) (packedKey []byte, err error)
+	UnpackKey(payloadData []byte) (key Key, bytesConsumed uint64, err error)
+}
+
+// ValueCodec specifies the Value-related subset of BPlusTreeCallbacks
+type ValueCodec interface {
+	DumpValue(value Value) (valueAsString string, err error)
+	PackValue(value Value) (packedValue []byte, err error)
+	UnpackValue(payloadData []byte) (value Value, bytesConsumed uint64, err error)
+}
+
+// Codec may serve as either a KeyCodec or a ValueCodec
+type Codec interface {
+	KeyCodec
+	ValueCodec
+}
+
+// BPlusTreeNodeStore specifies the storage-related subset of BPlusTreeCallbacks
+type BPlusTreeNodeStore interface {
+	GetNode(objectNumber uint64, objectOffset uint64, objectLength uint64) (nodeByteSlice []byte, err error)
+	PutNode(nodeByteSlice []byte) (objectNumber uint64, objectOffset uint64, err error)
+	DiscardNode(objectNumber uint64, objectOffset uint64, objectLength uint64) (err error)
+}
+
+var (
+	CodecByteSlice Codec = &codecStruct{name: "CodecByteSlice", pack: packByteSlice, unpack: unpackByteSlice, dump: dumpByteSlice}
+	CodecInt       Codec = &codecStruct{name: "CodecInt", pack: packInt, unpack: unpackInt, dump: dumpDefault}
+	CodecString    Codec = &codecStruct{name: "CodecString", pack: packString, unpack: unpackString, dump: dumpString}
+	CodecTime      Codec = &codecStruct{name: "CodecTime", pack: packTime, unpack: unpackTime, dump: dumpTime}
+	CodecUint16    Codec = &codecStruct{name: "CodecUint16", pack: packUint16, unpack: unpackUint16, dump: dumpDefault}
+	CodecUint32    Codec = &codecStruct{name: "CodecUint32", pack: packUint32, unpack: unpackUint32, dump: dumpDefault}
+	CodecUint64    Codec = &codecStruct{name: "CodecUint64", pack: packUint64, unpack: unpackUint64, dump: dumpDefault}
+)
+
+// Callbacks assembles a BPlusTreeCallbacks from a KeyCodec, a ValueCodec, & a BPlusTreeNodeStore
+//
+// If nodeStore also implements BPlusTreeNodeLocationCallbacks and/or BPlusTreeValueLogCallbacks,
+// so will the returned callbacks.
+func Callbacks(keyCodec KeyCodec, valueCodec ValueCodec, nodeStore BPlusTreeNodeStore) (callbacks BPlusTreeCallbacks) {
+	baseCallbacks := &callbacksStruct{
+		KeyCodec:           keyCodec,
+		ValueCodec:         valueCodec,
+		BPlusTreeNodeStore: nodeStore,
+	}
+
+	nodeLocator, implementsNodeLocation := nodeStore.(BPlusTreeNodeLocationCallbacks)
+	valueLog, implementsValueLog := nodeStore.(BPlusTreeValueLogCallbacks)
+
+	switch {
+	case implementsNodeLocation && implementsValueLog:
+		callbacks = &callbacksWithNodeLocationAndValueLogStruct{baseCallbacks, nodeLocator, valueLog}
+	case implementsNodeLocation:
+		callbacks = &callbacksWithNodeLocationStruct{baseCallbacks, nodeLocator}
+	case implementsValueLog:
+		callbacks = &callbacksWithValueLogStruct{baseCallbacks, valueLog}
+	default:
+		callbacks = baseCallbacks
+	}
+
+	return
+}
+
+type callbacksStruct struct {
+	KeyCodec
+	ValueCodec
+	BPlusTreeNodeStore
+}
+
+type callbacksWithNodeLocationStruct struct {
+	*callbacksStruct
+	BPlusTreeNodeLocationCallbacks
+}
+
+type callbacksWithValueLogStruct struct {
+	*callbacksStruct
+	BPlusTreeValueLogCallbacks
+}
+
+type callbacksWithNodeLocationAndValueLogStruct struct {
+	*callbacksStruct
+	BPlusTreeNodeLocationCallbacks
+	BPlusTreeValueLogCallbacks
+}
+
+type codecStruct struct {
+	name   string
+	pack   func(item interface{}) (packedItem []byte, ok bool)
+	unpack func(payloadData []byte) (item interface{}, bytesConsumed uint64, ok bool)
+	dump   func(item interface{}) (itemAsString string, ok bool)
+}
+
+func (codec *codecStruct) DumpKey(key Key) (keyAsString string, err error) {
+	keyAsString, err = codec.dumpItem("DumpKey", key)
+	return
+}
+
+func (codec *codecStruct) PackKey(key Key) (packedKey []byte, err error) {
+	packedKey, err = codec.packItem("PackKey", key)
+	return
+}
+
+func (codec *codecStruct) UnpackKey(payloadData []byte) (key Key, bytesConsumed uint64, err error) {
+	key, bytesConsumed, err = codec.unpackItem("UnpackKey", payloadData)
+	return
+}
+
+func (codec *codecStruct) DumpValue(value Value) (valueAsString string, err error) {
+	valueAsString, err = codec.dumpItem("DumpValue", value)
+	return
+}
+
+func (codec *codecStruct) PackValue(value Value) (packedValue []byte, err error) {
+	packedValue, err = codec.packItem("PackValue", value)
+	return
+}
+
+func (codec *codecStruct) UnpackValue(payloadData []byte) (value Value, bytesConsumed uint64, err error) {
+	value, bytesConsumed, err = codec.unpackItem("UnpackValue", payloadData)
+	return
+}
+
+func (codec *codecStruct) dumpItem(funcName string, item interface{}) (itemAsString string, err error) {
+	itemAsString, ok := codec.dump(item)
+	if !ok {
+		err = fmt.Errorf("%s.%s() passed unsupported type %T", codec.name, funcName, item)
+		return
+	}
+
+	err = nil
+	return
+}
+
+func (codec *codecStruct) packItem(funcName string, item interface{}) (packedItem []byte, err error) {
+	packedItem, ok := codec.pack(item)
+	if !ok {
+		err = fmt.Errorf("%s.%s() passed unsupported type %T", codec.name, funcName, item)
+		return
+	}
+
+	err = nil
+	return
+}
+
+func (codec *codecStruct) unpackItem(funcName string, payloadData []byte) (item interface{}, bytesConsumed uint64, err error) {
+	item, bytesConsumed, ok := codec.unpack(payloadData)
+	if !ok {
+		err = fmt.Errorf("%s.%s() passed malformed payloadData", codec.name, funcName)
+		return
+	}
+
+	err = nil
+	return
+}
+
+func dumpDefault(item interface{}) (itemAsString string, ok bool) {
+	itemAsString = fmt.Sprintf("%v", item)
+	ok = true
+	return
+}
+
+func packInt(item interface{}) (packedItem []byte, ok bool) {
+	itemInt, ok := item.(int)
+	if ok {
+		packedItem = binary.LittleEndian.AppendUint64(nil, uint64(int64(itemInt)))
+	}
+	return
+}
+
+func unpackInt(payloadData []byte) (item interface{}, bytesConsumed uint64, ok bool) {
+	if 8 > len(payloadData) {
+		ok = false
+		return
+	}
+	item = int(int64(binary.LittleEndian.Uint64(payloadData)))
+	bytesConsumed = 8
+	ok = true
+	return
+}
+
+func packUint16(item interface{}) (packedItem []byte, ok bool) {
+	itemUint16, ok := item.(uint16)
+	if ok {
+		packedItem = binary.LittleEndian.AppendUint16(nil, itemUint16)
+	}
+	return
+}
+
+func unpackUint16(payloadData []byte) (item interface{}, bytesConsumed uint64, ok bool) {
+	if 2 > len(payloadData) {
+		ok = false
+		return
+	}
+	item = binary.LittleEndian.Uint16(payloadData)
+	bytesConsumed = 2
+	ok = true
+	return
+}
+
+func packUint32(item interface{}) (packedItem []byte, ok bool) {
+	itemUint32, ok := item.(uint32)
+	if ok {
+		packedItem = binary.LittleEndian.AppendUint32(nil, itemUint32)
+	}
+	return
+}
+
+func unpackUint32(payloadData []byte) (item interface{}, bytesConsumed uint64, ok bool) {
+	if 4 > len(payloadData) {
+		ok = false
+		return
+	}
+	item = binary.LittleEndian.Uint32(payloadData)
+	bytesConsumed = 4
+	ok = true
+	return
+}
+
+func packUint64(item interface{}) (packedItem []byte, ok bool) {
+	itemUint64, ok := item.(uint64)
+	if ok {
+		packedItem = binary.LittleEndian.AppendUint64(nil, itemUint64)
+	}
+	return
+}
+
+func unpackUint64(payloadData []byte) (item interface{}, bytesConsumed uint64, ok bool) {
+	if 8 > len(payloadData) {
+		ok = false
+		return
+	}
+	item = binary.LittleEndian.Uint64(payloadData)
+	bytesConsumed = 8
+	ok = true
+	return
+}
+
+// unpackLengthPrefixed returns the bytes following a uvarint length at the start of payloadData
+func unpackLengthPrefixed(payloadData []byte) (itemBytes []byte, bytesConsumed uint64, ok bool) {
+	itemLen, itemLenSize := binary.Uvarint(payloadData)
+	if (0 >= itemLenSize) || (itemLen > uint64(len(payloadData)-itemLenSize)) {
+		ok = false
+		return
+	}
+	itemBytes = payloadData[itemLenSize : uint64(itemLenSize)+itemLen]
+	bytesConsumed = uint64(itemLenSize) + itemLen
+	ok = true
+	return
+}
+
+func packString(item interface{}) (packedItem []byte, ok bool) {
+	itemString, ok := item.(string)
+	if ok {
+		packedItem = binary.AppendUvarint(make([]byte, 0, binary.MaxVarintLen64+len(itemString)), uint64(len(itemString)))
+		packedItem = append(packedItem, itemString...)
+	}
+	return
+}
+
+func unpackString(payloadData []byte) (item interface{}, bytesConsumed uint64, ok bool) {
+	itemBytes, bytesConsumed, ok := unpackLengthPrefixed(payloadData)
+	if ok {
+		item = string(itemBytes)
+	}
+	return
+}
+
+func dumpString(item interface{}) (itemAsString string, ok bool) {
+	itemString, ok := item.(string)
+	if ok {
+		itemAsString = fmt.Sprintf("%q", itemString)
+	}
+	return
+}
+
+func packByteSlice(item interface{}) (packedItem []byte, ok bool) {
+	itemByteSlice, ok := item.([]byte)
+	if ok {
+		packedItem = binary.AppendUvarint(make([]byte, 0, binary.MaxVarintLen64+len(itemByteSlice)), uint64(len(itemByteSlice)))
+		packedItem = append(packedItem, itemByteSlice...)
+	}
+	return
+}
+
+func unpackByteSlice(payloadData []byte) (item interface{}, bytesConsumed uint64, ok bool) {
+	itemBytes, bytesConsumed, ok := unpackLengthPrefixed(payloadData)
+	if ok {
+		item = append([]byte{}, itemBytes...) // copy so as not to alias the node's payloadData
+	}
+	return
+}
+
+func dumpByteSlice(item interface{}) (itemAsString string, ok bool) {
+	itemByteSlice, ok := item.([]byte)
+	if ok {
+		itemAsString = fmt.Sprintf("0x%X", itemByteSlice)
+	}
+	return
+}
+
+func packTime(item interface{}) (packedItem []byte, ok bool) {
+	itemTime, ok := item.(time.Time)
+	if !ok {
+		return
+	}
+	itemTimeBinary, err := itemTime.MarshalBinary()
+	if nil != err {
+		ok = false
+		return
+	}
+	packedItem = binary.AppendUvarint(make([]byte, 0, binary.MaxVarintLen64+len(itemTimeBinary)), uint64(len(itemTimeBinary)))
+	packedItem = append(packedItem, itemTimeBinary...)
+	return
+}
+
+func unpackTime(payloadData []byte) (item interface{}, bytesConsumed uint64, ok bool) {
+	var (
+		itemTime time.Time
+	)
+
+	itemBytes, bytesConsumed, ok := unpackLengthPrefixed(payloadData)
+	if !ok {
+		return
+	}
+	if nil != itemTime.UnmarshalBinary(itemBytes) {
+		ok = false
+		return
+	}
+	item = itemTime
+	return
+}
+
+func dumpTime(item interface{}) (itemAsString string, ok bool) {
+	itemTime, ok := item.(time.Time)
+	if ok {
+		itemAsString = itemTime.Format(time.RFC3339Nano)
+	}
+	return
+}
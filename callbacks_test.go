@@ -0,0 +1,243 @@
+// Copyright (c) 2015-2021, NVIDIA CORPORATION.
+// SPDX-License-Identifier: Apache-2.0
+
+package sortedmap
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+)
+
+type callbacksTestNodeStoreStruct struct {
+	lastObjectNumberGenerated uint64
+	objectMap                 map[uint64][]byte // Key == objectNumber (objectOffset always 0)
+}
+
+func (nodeStore *callbacksTestNodeStoreStruct) GetNode(objectNumber uint64, objectOffset uint64, objectLength uint64) (nodeByteSlice []byte, err error) {
+	nodeByteSlice, ok := nodeStore.objectMap[objectNumber]
+	if !ok || (0 != objectOffset) || (uint64(len(nodeByteSlice)) != objectLength) {
+		err = fmt.Errorf("node 0x%016X/0x%016X/0x%016X not found", objectNumber, objectOffset, objectLength)
+		return
+	}
+
+	err = nil
+	return
+}
+
+func (nodeStore *callbacksTestNodeStoreStruct) PutNode(nodeByteSlice []byte) (objectNumber uint64, objectOffset uint64, err error) {
+	nodeStore.lastObjectNumberGenerated++
+	objectNumber = nodeStore.lastObjectNumberGenerated
+	objectOffset = 0
+
+	nodeStore.objectMap[objectNumber] = nodeByteSlice
+
+	err = nil
+	return
+}
+
+func (nodeStore *callbacksTestNodeStoreStruct) DiscardNode(objectNumber uint64, objectOffset uint64, objectLength uint64) (err error) {
+	_, err = nodeStore.GetNode(objectNumber, objectOffset, objectLength)
+	if nil != err {
+		return
+	}
+
+	delete(nodeStore.objectMap, objectNumber)
+
+	err = nil
+	return
+}
+
+type callbacksTestValueLogNodeStoreStruct struct {
+	*callbacksTestNodeStoreStruct
+}
+
+func (nodeStore *callbacksTestValueLogNodeStoreStruct) PutValue(valueByteSlice []byte) (objectNumber uint64, objectOffset uint64, err error) {
+	objectNumber, objectOffset, err = nodeStore.PutNode(valueByteSlice)
+	return
+}
+
+func (nodeStore *callbacksTestValueLogNodeStoreStruct) GetValue(objectNumber uint64, objectOffset uint64, objectLength uint64) (valueByteSlice []byte, err error) {
+	valueByteSlice, err = nodeStore.GetNode(objectNumber, objectOffset, objectLength)
+	return
+}
+
+func (nodeStore *callbacksTestValueLogNodeStoreStruct) DiscardValue(objectNumber uint64, objectOffset uint64, objectLength uint64) (err error) {
+	err = nodeStore.DiscardNode(objectNumber, objectOffset, objectLength)
+	return
+}
+
+func TestCodecs(t *testing.T) {
+	testCases := []struct {
+		codec   Codec
+		compare Compare
+		items   []interface{}
+		badItem interface{}
+	}{
+		{CodecInt, CompareInt, []interface{}{int(-1), int(0), int(1) << 40}, uint32(0)},
+		{CodecUint16, CompareUint16, []interface{}{uint16(0), uint16(0xFFFF)}, int(0)},
+		{CodecUint32, CompareUint32, []interface{}{uint32(0), uint32(0xFFFFFFFF)}, int(0)},
+		{CodecUint64, CompareUint64, []interface{}{uint64(0), uint64(0xFFFFFFFFFFFFFFFF)}, int(0)},
+		{CodecString, CompareString, []interface{}{"", "a", "hello, world"}, []byte{}},
+		{CodecByteSlice, CompareByteSlice, []interface{}{[]byte{}, []byte{0x00, 0xFF}}, ""},
+		{CodecTime, CompareTime, []interface{}{time.Unix(0, 0).UTC(), time.Date(2021, time.March, 4, 5, 6, 7, 8, time.UTC)}, int(0)},
+	}
+
+	for _, testCase := range testCases {
+		for _, item := range testCase.items {
+			packedKey, err := testCase.codec.PackKey(item)
+			if nil != err {
+				t.Fatalf("PackKey(%v) failed: %v", item, err)
+			}
+			packedValue, err := testCase.codec.PackValue(item)
+			if nil != err {
+				t.Fatalf("PackValue(%v) failed: %v", item, err)
+			}
+			if !bytes.Equal(packedKey, packedValue) {
+				t.Fatalf("PackKey(%v) & PackValue(%v) should have matched", item, item)
+			}
+
+			// Trailing bytes (e.g. the next Key or Value in a node) must not be consumed
+
+			key, bytesConsumed, err := testCase.codec.UnpackKey(append(packedKey, 0x5A))
+			if nil != err {
+				t.Fatalf("UnpackKey(PackKey(%v)) failed: %v", item, err)
+			}
+			if uint64(len(packedKey)) != bytesConsumed {
+				t.Fatalf("UnpackKey(PackKey(%v)) should have consumed %v bytes, not %v", item, len(packedKey), bytesConsumed)
+			}
+			result, err := testCase.compare(item, key)
+			if nil != err {
+				t.Fatalf("Compare(%v, UnpackKey(PackKey(%v))) failed: %v", item, item, err)
+			}
+			if 0 != result {
+				t.Fatalf("UnpackKey(PackKey(%v)) returned %v", item, key)
+			}
+
+			value, _, err := testCase.codec.UnpackValue(packedValue)
+			if nil != err {
+				t.Fatalf("UnpackValue(PackValue(%v)) failed: %v", item, err)
+			}
+			result, err = testCase.compare(item, value)
+			if (nil != err) || (0 != result) {
+				t.Fatalf("UnpackValue(PackValue(%v)) returned %v", item, value)
+			}
+
+			_, err = testCase.codec.DumpKey(item)
+			if nil != err {
+				t.Fatalf("DumpKey(%v) failed: %v", item, err)
+			}
+
+			if 0 < len(packedKey) {
+				_, _, err = testCase.codec.UnpackKey(packedKey[:len(packedKey)-1])
+				if nil == err {
+					t.Fatalf("UnpackKey() of truncated PackKey(%v) should have failed", item)
+				}
+			}
+		}
+
+		_, err := testCase.codec.PackKey(testCase.badItem)
+		if nil == err {
+			t.Fatalf("PackKey(%#v) should have failed", testCase.badItem)
+		}
+	}
+}
+
+func TestCallbacks(t *testing.T) {
+	nodeStore := &callbacksTestNodeStoreStruct{lastObjectNumberGenerated: 0, objectMap: make(map[uint64][]byte)}
+
+	callbacks := Callbacks(CodecString, CodecTime, nodeStore)
+
+	if _, ok := callbacks.(BPlusTreeValueLogCallbacks); ok {
+		t.Fatalf("Callbacks() should not have implemented BPlusTreeValueLogCallbacks")
+	}
+
+	btree, err := NewBPlusTreeWithOptions(4, CompareString, callbacks, nil, &BPlusTreeOptions{KeyPrefixCompression: true})
+	if nil != err {
+		t.Fatalf("NewBPlusTreeWithOptions() failed: %v", err)
+	}
+
+	baseTime := time.Date(2021, time.March, 4, 5, 6, 7, 0, time.UTC)
+
+	for i := 0; i < 100; i++ {
+		ok, err := btree.Put(fmt.Sprintf("key-%03d", i), baseTime.Add(time.Duration(i)*time.Second))
+		if nil != err {
+			t.Fatalf("btree.Put(key-%03d,) failed: %v", i, err)
+		}
+		if !ok {
+			t.Fatalf("btree.Put(key-%03d,).ok should have been true", i)
+		}
+	}
+
+	rootObjectNumber, rootObjectOffset, rootObjectLength, err := btree.Flush(false)
+	if nil != err {
+		t.Fatalf("btree.Flush(false) failed: %v", err)
+	}
+
+	btree, err = OldBPlusTree(rootObjectNumber, rootObjectOffset, rootObjectLength, CompareString, callbacks, nil)
+	if nil != err {
+		t.Fatalf("OldBPlusTree() failed: %v", err)
+	}
+
+	valueAsValue, ok, err := btree.GetByKey("key-042")
+	if nil != err {
+		t.Fatalf("btree.GetByKey(key-042) failed: %v", err)
+	}
+	if !ok {
+		t.Fatalf("btree.GetByKey(key-042).ok should have been true")
+	}
+	if !baseTime.Add(42 * time.Second).Equal(valueAsValue.(time.Time)) {
+		t.Fatalf("btree.GetByKey(key-042) returned unexpected value")
+	}
+
+	err = btree.Validate()
+	if nil != err {
+		t.Fatalf("btree.Validate() failed: %v", err)
+	}
+
+	// Optional interfaces implemented by the BPlusTreeNodeStore are exposed
+
+	valueLogCallbacks := Callbacks(CodecUint32, CodecByteSlice, &callbacksTestValueLogNodeStoreStruct{nodeStore})
+
+	if _, ok := valueLogCallbacks.(BPlusTreeValueLogCallbacks); !ok {
+		t.Fatalf("Callbacks() should have implemented BPlusTreeValueLogCallbacks")
+	}
+	if _, ok := valueLogCallbacks.(BPlusTreeNodeLocationCallbacks); ok {
+		t.Fatalf("Callbacks() should not have implemented BPlusTreeNodeLocationCallbacks")
+	}
+
+	btree, err = NewBPlusTreeWithOptions(4, CompareUint32, valueLogCallbacks, nil, &BPlusTreeOptions{ValueLogThreshold: 16})
+	if nil != err {
+		t.Fatalf("NewBPlusTreeWithOptions() failed: %v", err)
+	}
+
+	ok, err = btree.Put(uint32(1), bytes.Repeat([]byte{0x01}, 64))
+	if (nil != err) || !ok {
+		t.Fatalf("btree.Put(1,) failed: %v", err)
+	}
+
+	_, _, _, err = btree.Flush(true)
+	if nil != err {
+		t.Fatalf("btree.Flush(true) failed: %v", err)
+	}
+
+	valueAsValue, ok, err = btree.GetByKey(uint32(1))
+	if (nil != err) || !ok || !bytes.Equal(bytes.Repeat([]byte{0x01}, 64), valueAsValue.([]byte)) {
+		t.Fatalf("btree.GetByKey(1) failed: %v", err)
+	}
+
+	// Codecs also serve as LLRBTreeCallbacks
+
+	llrb := NewLLRBTree(CompareUint64, CodecUint64)
+
+	_, err = llrb.Put(uint64(1), uint64(2))
+	if nil != err {
+		t.Fatalf("llrb.Put() failed: %v", err)
+	}
+
+	err = llrb.Validate()
+	if nil != err {
+		t.Fatalf("llrb.Validate() failed: %v", err)
+	}
+}
@@ -6,9 +6,13 @@ package sortedmap
 
 import (
 	"bytes"
+	"cmp"
 	"fmt"
+	"math/big"
 	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
 type Key interface{}
@@ -28,7 +32,14 @@ func CompareInt(key1 Key, key2 Key) (result int, err error) {
 		return
 	}
 
-	result = key1Int - key2Int
+	if key1Int < key2Int {
+		result = -1
+	} else if key1Int == key2Int {
+		result = 0
+	} else { // key1Int > key2Int
+		result = 1
+	}
+
 	err = nil
 
 	return
@@ -170,6 +181,289 @@ func CompareTime(key1 Key, key2 Key) (result int, err error) {
 	return
 }
 
+func CompareInt32(key1 Key, key2 Key) (result int, err error) {
+	key1Int32, ok := key1.(int32)
+	if !ok {
+		err = fmt.Errorf("CompareInt32(non-int32,) not supported")
+		return
+	}
+	key2Int32, ok := key2.(int32)
+	if !ok {
+		err = fmt.Errorf("CompareInt32(int32, non-int32) not supported")
+		return
+	}
+
+	result = cmp.Compare(key1Int32, key2Int32)
+	err = nil
+
+	return
+}
+
+func CompareInt64(key1 Key, key2 Key) (result int, err error) {
+	key1Int64, ok := key1.(int64)
+	if !ok {
+		err = fmt.Errorf("CompareInt64(non-int64,) not supported")
+		return
+	}
+	key2Int64, ok := key2.(int64)
+	if !ok {
+		err = fmt.Errorf("CompareInt64(int64, non-int64) not supported")
+		return
+	}
+
+	result = cmp.Compare(key1Int64, key2Int64)
+	err = nil
+
+	return
+}
+
+// CompareFloat64 orders NaN before all other values (including -Inf) and treats all NaNs as equal
+//
+// Note that -0.0 and +0.0 are also treated as equal.
+func CompareFloat64(key1 Key, key2 Key) (result int, err error) {
+	key1Float64, ok := key1.(float64)
+	if !ok {
+		err = fmt.Errorf("CompareFloat64(non-float64,) not supported")
+		return
+	}
+	key2Float64, ok := key2.(float64)
+	if !ok {
+		err = fmt.Errorf("CompareFloat64(float64, non-float64) not supported")
+		return
+	}
+
+	result = cmp.Compare(key1Float64, key2Float64)
+	err = nil
+
+	return
+}
+
+// CompareBool orders false before true
+func CompareBool(key1 Key, key2 Key) (result int, err error) {
+	key1Bool, ok := key1.(bool)
+	if !ok {
+		err = fmt.Errorf("CompareBool(non-bool,) not supported")
+		return
+	}
+	key2Bool, ok := key2.(bool)
+	if !ok {
+		err = fmt.Errorf("CompareBool(bool, non-bool) not supported")
+		return
+	}
+
+	if key1Bool == key2Bool {
+		result = 0
+	} else if key2Bool {
+		result = -1
+	} else { // key1Bool
+		result = 1
+	}
+
+	err = nil
+
+	return
+}
+
+func CompareBigInt(key1 Key, key2 Key) (result int, err error) {
+	key1BigInt, ok := key1.(*big.Int)
+	if !ok || (nil == key1BigInt) {
+		err = fmt.Errorf("CompareBigInt(non-*big.Int,) not supported")
+		return
+	}
+	key2BigInt, ok := key2.(*big.Int)
+	if !ok || (nil == key2BigInt) {
+		err = fmt.Errorf("CompareBigInt(*big.Int, non-*big.Int) not supported")
+		return
+	}
+
+	result = key1BigInt.Cmp(key2BigInt)
+	err = nil
+
+	return
+}
+
+// CompareOrdered may be instantiated for any cmp.Ordered type (e.g. CompareOrdered[int16])
+//
+// Floating-point types follow the same NaN policy as CompareFloat64.
+func CompareOrdered[T cmp.Ordered](key1 Key, key2 Key) (result int, err error) {
+	key1T, ok := key1.(T)
+	if !ok {
+		err = fmt.Errorf("CompareOrdered[%T](non-%T,) not supported", key1T, key1T)
+		return
+	}
+	key2T, ok := key2.(T)
+	if !ok {
+		err = fmt.Errorf("CompareOrdered[%T](%T, non-%T) not supported", key2T, key2T, key2T)
+		return
+	}
+
+	result = cmp.Compare(key1T, key2T)
+	err = nil
+
+	return
+}
+
+// CompareStringFold compares strings under Unicode simple case folding
+//
+// As strings differing only in case compare as equal, a SortedMap using CompareStringFold
+// will hold at most one of them.
+func CompareStringFold(key1 Key, key2 Key) (result int, err error) {
+	key1String, ok := key1.(string)
+	if !ok {
+		err = fmt.Errorf("CompareStringFold(non-string,) not supported")
+		return
+	}
+	key2String, ok := key2.(string)
+	if !ok {
+		err = fmt.Errorf("CompareStringFold(string, non-string) not supported")
+		return
+	}
+
+	for (0 < len(key1String)) && (0 < len(key2String)) {
+		rune1, rune1Size := utf8.DecodeRuneInString(key1String)
+		rune2, rune2Size := utf8.DecodeRuneInString(key2String)
+
+		result = cmp.Compare(foldRune(rune1), foldRune(rune2))
+		if 0 != result {
+			err = nil
+			return
+		}
+
+		key1String = key1String[rune1Size:]
+		key2String = key2String[rune2Size:]
+	}
+
+	result = cmp.Compare(len(key1String), len(key2String))
+	err = nil
+
+	return
+}
+
+// foldRune returns the smallest rune in r's case folding orbit (e.g. 'K' for 'k' & the Kelvin sign)
+func foldRune(r rune) (folded rune) {
+	folded = r
+
+	for next := unicode.SimpleFold(r); next != r; next = unicode.SimpleFold(next) {
+		if next < folded {
+			folded = next
+		}
+	}
+
+	return
+}
+
+// CompareStringNatural compares strings such that embedded runs of decimal digits compare numerically
+//
+// For example, "file2" sorts before "file10". Runs of digits having the same numeric value
+// (e.g. "7" and "007") are ordered by the number of leading zeros, and strings otherwise
+// comparing as equal are ordered bytewise, so only identical strings compare as equal.
+func CompareStringNatural(key1 Key, key2 Key) (result int, err error) {
+	key1String, ok := key1.(string)
+	if !ok {
+		err = fmt.Errorf("CompareStringNatural(non-string,) not supported")
+		return
+	}
+	key2String, ok := key2.(string)
+	if !ok {
+		err = fmt.Errorf("CompareStringNatural(string, non-string) not supported")
+		return
+	}
+
+	leadingZerosResult := 0
+
+	i1, i2 := 0, 0
+
+	for (i1 < len(key1String)) && (i2 < len(key2String)) {
+		if isDigit(key1String[i1]) && isDigit(key2String[i2]) {
+			digits1Start, digits2Start := i1, i2
+
+			for (i1 < len(key1String)) && ('0' == key1String[i1]) {
+				i1++
+			}
+			for (i2 < len(key2String)) && ('0' == key2String[i2]) {
+				i2++
+			}
+
+			if 0 == leadingZerosResult {
+				leadingZerosResult = cmp.Compare(i1-digits1Start, i2-digits2Start)
+			}
+
+			significant1Start, significant2Start := i1, i2
+
+			for (i1 < len(key1String)) && isDigit(key1String[i1]) {
+				i1++
+			}
+			for (i2 < len(key2String)) && isDigit(key2String[i2]) {
+				i2++
+			}
+
+			// With leading zeros skipped, the longer run of digits is numerically larger
+
+			result = cmp.Compare(i1-significant1Start, i2-significant2Start)
+			if 0 == result {
+				result = strings.Compare(key1String[significant1Start:i1], key2String[significant2Start:i2])
+			}
+			if 0 != result {
+				err = nil
+				return
+			}
+
+			continue
+		}
+
+		result = cmp.Compare(key1String[i1], key2String[i2])
+		if 0 != result {
+			err = nil
+			return
+		}
+
+		i1++
+		i2++
+	}
+
+	result = cmp.Compare(len(key1String)-i1, len(key2String)-i2)
+	if 0 == result {
+		result = leadingZerosResult
+	}
+	if 0 == result {
+		result = strings.Compare(key1String, key2String)
+	}
+
+	err = nil
+
+	return
+}
+
+func isDigit(b byte) (digit bool) {
+	digit = ('0' <= b) && ('9' >= b)
+	return
+}
+
+// Descending returns a Compare func ordering Keys opposite to compare
+func Descending(compare Compare) (descendingCompare Compare) {
+	descendingCompare = func(key1 Key, key2 Key) (result int, err error) {
+		result, err = compare(key1, key2)
+		if nil != err {
+			return
+		}
+
+		if 0 > result {
+			result = 1
+		} else if 0 < result {
+			result = -1
+		}
+
+		return
+	}
+
+	return
+}
+
+// SortedMap declares the methods common to every sorted map. Capabilities beyond these are
+// declared by small interfaces (AggregatedSortedMap, WeightedSortedMap, PriorityQueue,
+// BPlusTreeCacheStatsReporter, & BPlusTreeCacheTreeStatsReporter) that LLRBTree, BPlusTree,
+// & BPlusTreeCache embed as applicable, so that other SortedMap implementations (e.g.
+// IndexedMap & TTLMap) need only provide those they support.
 type SortedMap interface {
 	BisectLeft(key Key) (index int, found bool, err error)  // Returns index of matching key:value pair or, if no match, index is to key:value just before where this key would go
 	BisectRight(key Key) (index int, found bool, err error) // Returns index of matching key:value pair or, if no match, index is to key:value just after where this key would go
@@ -4,6 +4,8 @@
 package sortedmap
 
 import (
+	"math"
+	"math/big"
 	"testing"
 	"time"
 )
@@ -225,3 +227,56 @@ func TestCompareFunctions(t *testing.T) {
 		t.Fatalf("CompareByteSlice(int(2), []byte{2}) should have failed")
 	}
 }
+
+func TestCompareSuite(t *testing.T) {
+	testCases := []struct {
+		name    string
+		compare Compare
+		keys    []Key // in ascending order
+		badKey  Key
+	}{
+		{"CompareInt", CompareInt, []Key{int(math.MinInt), int(-1), int(0), int(math.MaxInt)}, int32(0)},
+		{"CompareInt32", CompareInt32, []Key{int32(math.MinInt32), int32(-1), int32(0), int32(math.MaxInt32)}, int(0)},
+		{"CompareInt64", CompareInt64, []Key{int64(math.MinInt64), int64(-1), int64(0), int64(math.MaxInt64)}, int(0)},
+		{"CompareFloat64", CompareFloat64, []Key{math.NaN(), math.Inf(-1), float64(-1.5), float64(0), float64(1.5), math.Inf(1)}, float32(0)},
+		{"CompareBool", CompareBool, []Key{false, true}, int(0)},
+		{"CompareBigInt", CompareBigInt, []Key{new(big.Int).Neg(new(big.Int).Lsh(big.NewInt(1), 100)), big.NewInt(0), new(big.Int).Lsh(big.NewInt(1), 100)}, int(0)},
+		{"CompareOrdered[int16]", CompareOrdered[int16], []Key{int16(math.MinInt16), int16(0), int16(math.MaxInt16)}, int(0)},
+		{"CompareOrdered[string]", CompareOrdered[string], []Key{"", "A", "a"}, []byte{}},
+		{"CompareStringFold", CompareStringFold, []Key{"", "a", "AB", "ab\u00e9", "b", "\u212a"}, []byte{}},
+		{"CompareStringNatural", CompareStringNatural, []Key{"", "file", "file1", "file01", "file2", "file10", "file10a", "filea"}, []byte{}},
+		{"Descending(CompareUint32)", Descending(CompareUint32), []Key{uint32(math.MaxUint32), uint32(1), uint32(0)}, int(0)},
+	}
+
+	for _, testCase := range testCases {
+		for i, key1 := range testCase.keys {
+			for j, key2 := range testCase.keys {
+				result, err := testCase.compare(key1, key2)
+				if nil != err {
+					t.Fatalf("%s(%v,%v) should not have failed: %v", testCase.name, key1, key2, err)
+				}
+				if ((i < j) && (0 <= result)) || ((i == j) && (0 != result)) || ((i > j) && (0 >= result)) {
+					t.Fatalf("%s(%v,%v) returned unexpected result %v", testCase.name, key1, key2, result)
+				}
+			}
+		}
+
+		_, err := testCase.compare(testCase.keys[0], testCase.badKey)
+		if nil == err {
+			t.Fatalf("%s(%v,%#v) should have failed", testCase.name, testCase.keys[0], testCase.badKey)
+		}
+		_, err = testCase.compare(testCase.badKey, testCase.keys[0])
+		if nil == err {
+			t.Fatalf("%s(%#v,%v) should have failed", testCase.name, testCase.badKey, testCase.keys[0])
+		}
+	}
+
+	// Keys differing only in case (or only in the Kelvin sign vs. K) are equal under CompareStringFold
+
+	for _, pair := range [][2]string{{"Hello", "hELLO"}, {"\u212a", "k"}} {
+		result, err := CompareStringFold(pair[0], pair[1])
+		if (nil != err) || (0 != result) {
+			t.Fatalf("CompareStringFold(%q,%q) should have been == 0", pair[0], pair[1])
+		}
+	}
+}
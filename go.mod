@@ -1,5 +1,6 @@
 module github.com/NVIDIA/sortedmap
 
-go 1.19
+// go 1.21 is required for the cmp package (CompareOrdered), context.AfterFunc (PopMinWait/PopMaxWait), and log/slog (BPlusTreeOptions.Logger)
+go 1.21
 
 require github.com/NVIDIA/cstruct v0.0.0-20221206222058-cbc877f192d5
@@ -0,0 +1,598 @@
+// Copyright (c) 2015-2021, NVIDIA CORPORATION.
+// SPDX-License-Identifier: Apache-2.0
+
+package sortedmap
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Indexed maps
+//
+// An IndexedMap wraps a primary SortedMap (either an LLRBTree or a BPlusTree) and maintains
+// any number of named secondary indexes over it. Each secondary index is registered with an
+// IndexExtractor that maps a primary Key:Value pair to the secondary Key under which it is
+// indexed (if any). Every mutation made via the IndexedMap updates the primary SortedMap and
+// all secondary indexes while holding a single lock, so the indexes never drift from the
+// primary SortedMap. As such, the primary SortedMap must not be mutated other than via the
+// IndexedMap. Secondary indexes are updated before the primary SortedMap and each completed
+// update is undone should a later one fail (e.g. should an index's Compare reject a
+// secondary Key), so a failed mutation leaves both as they were. Secondary Keys need not be unique, but primary Keys must be (i.e. the primary
+// SortedMap may not be a Multimap).
+//
+// Secondary indexes are held in memory only. Each is an LLRBTree keyed by the Tuple
+// {secondaryKey, primaryKey} that is (re)built from the primary SortedMap by AddIndex().
+
+// IndexExtractor returns the secondary Key under which a primary Key:Value pair is indexed (if indexed is true)
+type IndexExtractor func(key Key, value Value) (secondaryKey Key, indexed bool, err error)
+
+// IndexedMap is a SortedMap maintaining secondary indexes
+//
+// Lookups via a secondary index return matching primary Keys (and their Values) ordered by
+// secondary Key and then by primary Key. RangeBySecondaryKey() returns those whose secondary
+// Key is at least startSecondaryKey but less than endSecondaryKey.
+type IndexedMap interface {
+	SortedMap
+	AddIndex(indexName string, compare Compare, extractor IndexExtractor) (err error)
+	RemoveIndex(indexName string) (err error)
+	GetBySecondaryKey(indexName string, secondaryKey Key) (primaryKeys []Key, values []Value, err error)
+	RangeBySecondaryKey(indexName string, startSecondaryKey Key, endSecondaryKey Key) (primaryKeys []Key, values []Value, err error)
+}
+
+type indexedMapIndexStruct struct {
+	extractor IndexExtractor
+	compare   Compare  // compares Tuple{secondaryKey, primaryKey}
+	llrbTree  LLRBTree // Key == Tuple{secondaryKey, primaryKey}, Value == nil
+}
+
+// indexedMapUndoStruct records an index entry updateIndexEntries() inserted or deleted
+type indexedMapUndoStruct struct {
+	indexName string
+	entryKey  Tuple
+	inserted  bool // if true, entryKey was inserted (so must be deleted to undo); if false, vice versa
+}
+
+type indexedMapStruct struct {
+	sync.Mutex
+	primary SortedMap
+	compare Compare                           // compares primary Keys
+	indexes map[string]*indexedMapIndexStruct // key == indexName
+}
+
+// NewIndexedMap returns an IndexedMap (initially without secondary indexes) wrapping primary
+//
+// The supplied compare must be the Compare func used to order primary's Keys.
+func NewIndexedMap(primary SortedMap, compare Compare) (indexedMap IndexedMap) {
+	indexedMap = &indexedMapStruct{
+		primary: primary,
+		compare: compare,
+		indexes: make(map[string]*indexedMapIndexStruct),
+	}
+	return
+}
+
+// extract returns the secondary Key of key:value in each secondary index in which it is indexed
+func (indexedMap *indexedMapStruct) extract(key Key, value Value) (secondaryKeys map[string]Key, err error) {
+	secondaryKeys = make(map[string]Key)
+
+	for indexName, index := range indexedMap.indexes {
+		secondaryKey, indexed, extractorErr := index.extractor(key, value)
+		if nil != extractorErr {
+			err = fmt.Errorf("IndexExtractor for index %q failed: %v", indexName, extractorErr)
+			return
+		}
+		if indexed {
+			secondaryKeys[indexName] = secondaryKey
+		}
+	}
+
+	err = nil
+	return
+}
+
+// updateIndexEntries inserts the index entries of key at insertSecondaryKeys & deletes those at deleteSecondaryKeys
+//
+// Should any insertion or deletion fail, those completed are undone. Otherwise, undo lists them
+// for undoIndexEntries() should the subsequent update of the primary SortedMap fail.
+func (indexedMap *indexedMapStruct) updateIndexEntries(key Key, insertSecondaryKeys map[string]Key, deleteSecondaryKeys map[string]Key) (undo []indexedMapUndoStruct, err error) {
+	var (
+		ok bool
+	)
+
+	undo = make([]indexedMapUndoStruct, 0, len(insertSecondaryKeys)+len(deleteSecondaryKeys))
+
+	for indexName, secondaryKey := range insertSecondaryKeys {
+		entryKey := Tuple{secondaryKey, key}
+
+		ok, err = indexedMap.indexes[indexName].llrbTree.Put(entryKey, nil)
+		if (nil == err) && !ok {
+			err = fmt.Errorf("Logic error: index %q already contained entry for primary Key", indexName)
+		}
+		if nil != err {
+			err = indexedMap.undoIndexEntries(undo, fmt.Errorf("index %q update failed: %v", indexName, err))
+			undo = nil
+			return
+		}
+
+		undo = append(undo, indexedMapUndoStruct{indexName: indexName, entryKey: entryKey, inserted: true})
+	}
+
+	for indexName, secondaryKey := range deleteSecondaryKeys {
+		entryKey := Tuple{secondaryKey, key}
+
+		ok, err = indexedMap.indexes[indexName].llrbTree.DeleteByKey(entryKey)
+		if (nil == err) && !ok {
+			err = fmt.Errorf("Logic error: index %q missing entry for primary Key", indexName)
+		}
+		if nil != err {
+			err = indexedMap.undoIndexEntries(undo, fmt.Errorf("index %q update failed: %v", indexName, err))
+			undo = nil
+			return
+		}
+
+		undo = append(undo, indexedMapUndoStruct{indexName: indexName, entryKey: entryKey, inserted: false})
+	}
+
+	err = nil
+	return
+}
+
+// undoIndexEntries reverses (in reverse order) the index entry updates listed in undo following failure cause
+//
+// The returned err is cause (if any), noting any failure to undo an update.
+func (indexedMap *indexedMapStruct) undoIndexEntries(undo []indexedMapUndoStruct, cause error) (err error) {
+	var (
+		ok      bool
+		undoErr error
+	)
+
+	for i := len(undo) - 1; i >= 0; i-- {
+		llrbTree := indexedMap.indexes[undo[i].indexName].llrbTree
+
+		if undo[i].inserted {
+			ok, undoErr = llrbTree.DeleteByKey(undo[i].entryKey)
+		} else {
+			ok, undoErr = llrbTree.Put(undo[i].entryKey, nil)
+		}
+		if (nil == undoErr) && !ok {
+			undoErr = fmt.Errorf("Logic error: index %q changed during undo", undo[i].indexName)
+		}
+		if nil != undoErr {
+			break
+		}
+	}
+
+	if nil == undoErr {
+		err = cause
+	} else if nil == cause {
+		err = fmt.Errorf("undoing index updates failed: %v", undoErr)
+	} else {
+		err = fmt.Errorf("%v (and undoing index updates failed: %v)", cause, undoErr)
+	}
+
+	return
+}
+
+// scan returns the primary Keys & Values of index entries in the range [firstIndex,lastIndex]
+func (indexedMap *indexedMapStruct) scan(index *indexedMapIndexStruct, firstIndex int, lastIndex int) (primaryKeys []Key, values []Value, err error) {
+	primaryKeys = make([]Key, 0)
+	values = make([]Value, 0)
+
+	for entryIndex := firstIndex; entryIndex <= lastIndex; entryIndex++ {
+		entryKey, _, ok, getErr := index.llrbTree.GetByIndex(entryIndex)
+		if nil != getErr {
+			err = getErr
+			return
+		}
+		if !ok {
+			err = fmt.Errorf("Logic error: index entry %v not found", entryIndex)
+			return
+		}
+
+		primaryKey := entryKey.(Tuple)[1]
+
+		value, ok, getErr := indexedMap.primary.GetByKey(primaryKey)
+		if nil != getErr {
+			err = getErr
+			return
+		}
+		if !ok {
+			err = fmt.Errorf("Logic error: primary Key of index entry %v not found", entryIndex)
+			return
+		}
+
+		primaryKeys = append(primaryKeys, primaryKey)
+		values = append(values, value)
+	}
+
+	err = nil
+	return
+}
+
+func (indexedMap *indexedMapStruct) AddIndex(indexName string, compare Compare, extractor IndexExtractor) (err error) {
+	indexedMap.Lock()
+	defer indexedMap.Unlock()
+
+	_, ok := indexedMap.indexes[indexName]
+	if ok {
+		err = fmt.Errorf("index %q already exists", indexName)
+		return
+	}
+
+	index := &indexedMapIndexStruct{
+		extractor: extractor,
+		compare:   CompareTuple(compare, indexedMap.compare),
+	}
+
+	index.llrbTree = NewLLRBTree(index.compare, nil)
+
+	numberOfItems, err := indexedMap.primary.Len()
+	if nil != err {
+		return
+	}
+
+	for primaryIndex := 0; primaryIndex < numberOfItems; primaryIndex++ {
+		key, value, _, getErr := indexedMap.primary.GetByIndex(primaryIndex)
+		if nil != getErr {
+			err = getErr
+			return
+		}
+
+		secondaryKey, indexed, extractorErr := extractor(key, value)
+		if nil != extractorErr {
+			err = fmt.Errorf("IndexExtractor for index %q failed: %v", indexName, extractorErr)
+			return
+		}
+		if !indexed {
+			continue
+		}
+
+		_, err = index.llrbTree.Put(Tuple{secondaryKey, key}, nil)
+		if nil != err {
+			return
+		}
+	}
+
+	indexedMap.indexes[indexName] = index
+
+	err = nil
+	return
+}
+
+func (indexedMap *indexedMapStruct) RemoveIndex(indexName string) (err error) {
+	indexedMap.Lock()
+	defer indexedMap.Unlock()
+
+	_, ok := indexedMap.indexes[indexName]
+	if !ok {
+		err = fmt.Errorf("index %q not found", indexName)
+		return
+	}
+
+	delete(indexedMap.indexes, indexName)
+
+	err = nil
+	return
+}
+
+func (indexedMap *indexedMapStruct) GetBySecondaryKey(indexName string, secondaryKey Key) (primaryKeys []Key, values []Value, err error) {
+	indexedMap.Lock()
+	defer indexedMap.Unlock()
+
+	index, ok := indexedMap.indexes[indexName]
+	if !ok {
+		err = fmt.Errorf("index %q not found", indexName)
+		return
+	}
+
+	firstIndex, _, err := index.llrbTree.BisectRight(Tuple{secondaryKey})
+	if nil != err {
+		return
+	}
+	lastIndex, _, err := index.llrbTree.BisectLeft(Tuple{secondaryKey, TupleMax})
+	if nil != err {
+		return
+	}
+
+	primaryKeys, values, err = indexedMap.scan(index, firstIndex, lastIndex)
+
+	return
+}
+
+func (indexedMap *indexedMapStruct) RangeBySecondaryKey(indexName string, startSecondaryKey Key, endSecondaryKey Key) (primaryKeys []Key, values []Value, err error) {
+	indexedMap.Lock()
+	defer indexedMap.Unlock()
+
+	index, ok := indexedMap.indexes[indexName]
+	if !ok {
+		err = fmt.Errorf("index %q not found", indexName)
+		return
+	}
+
+	firstIndex, _, err := index.llrbTree.BisectRight(Tuple{startSecondaryKey})
+	if nil != err {
+		return
+	}
+	lastIndex, _, err := index.llrbTree.BisectLeft(Tuple{endSecondaryKey})
+	if nil != err {
+		return
+	}
+
+	primaryKeys, values, err = indexedMap.scan(index, firstIndex, lastIndex)
+
+	return
+}
+
+// API functions (see api.go)
+
+func (indexedMap *indexedMapStruct) BisectLeft(key Key) (index int, found bool, err error) {
+	indexedMap.Lock()
+	defer indexedMap.Unlock()
+
+	index, found, err = indexedMap.primary.BisectLeft(key)
+
+	return
+}
+
+func (indexedMap *indexedMapStruct) BisectRight(key Key) (index int, found bool, err error) {
+	indexedMap.Lock()
+	defer indexedMap.Unlock()
+
+	index, found, err = indexedMap.primary.BisectRight(key)
+
+	return
+}
+
+func (indexedMap *indexedMapStruct) DeleteByIndex(index int) (ok bool, err error) {
+	indexedMap.Lock()
+	defer indexedMap.Unlock()
+
+	key, value, ok, err := indexedMap.primary.GetByIndex(index)
+	if (nil != err) || !ok {
+		return
+	}
+
+	ok, err = indexedMap.delete(key, value)
+
+	return
+}
+
+func (indexedMap *indexedMapStruct) DeleteByKey(key Key) (ok bool, err error) {
+	indexedMap.Lock()
+	defer indexedMap.Unlock()
+
+	value, ok, err := indexedMap.primary.GetByKey(key)
+	if (nil != err) || !ok {
+		return
+	}
+
+	ok, err = indexedMap.delete(key, value)
+
+	return
+}
+
+func (indexedMap *indexedMapStruct) delete(key Key, value Value) (ok bool, err error) {
+	secondaryKeys, err := indexedMap.extract(key, value)
+	if nil != err {
+		return
+	}
+
+	undo, err := indexedMap.updateIndexEntries(key, nil, secondaryKeys)
+	if nil != err {
+		return
+	}
+
+	ok, err = indexedMap.primary.DeleteByKey(key)
+	if (nil != err) || !ok {
+		err = indexedMap.undoIndexEntries(undo, err)
+	}
+
+	return
+}
+
+func (indexedMap *indexedMapStruct) Dump() (err error) {
+	indexedMap.Lock()
+	defer indexedMap.Unlock()
+
+	err = indexedMap.primary.Dump()
+
+	return
+}
+
+func (indexedMap *indexedMapStruct) GetByIndex(index int) (key Key, value Value, ok bool, err error) {
+	indexedMap.Lock()
+	defer indexedMap.Unlock()
+
+	key, value, ok, err = indexedMap.primary.GetByIndex(index)
+
+	return
+}
+
+func (indexedMap *indexedMapStruct) GetByKey(key Key) (value Value, ok bool, err error) {
+	indexedMap.Lock()
+	defer indexedMap.Unlock()
+
+	value, ok, err = indexedMap.primary.GetByKey(key)
+
+	return
+}
+
+func (indexedMap *indexedMapStruct) Len() (numberOfItems int, err error) {
+	indexedMap.Lock()
+	defer indexedMap.Unlock()
+
+	numberOfItems, err = indexedMap.primary.Len()
+
+	return
+}
+
+func (indexedMap *indexedMapStruct) PatchByIndex(index int, value Value) (ok bool, err error) {
+	indexedMap.Lock()
+	defer indexedMap.Unlock()
+
+	key, oldValue, ok, err := indexedMap.primary.GetByIndex(index)
+	if (nil != err) || !ok {
+		return
+	}
+
+	ok, err = indexedMap.patch(key, oldValue, value)
+
+	return
+}
+
+func (indexedMap *indexedMapStruct) PatchByKey(key Key, value Value) (ok bool, err error) {
+	indexedMap.Lock()
+	defer indexedMap.Unlock()
+
+	oldValue, ok, err := indexedMap.primary.GetByKey(key)
+	if (nil != err) || !ok {
+		return
+	}
+
+	ok, err = indexedMap.patch(key, oldValue, value)
+
+	return
+}
+
+func (indexedMap *indexedMapStruct) patch(key Key, oldValue Value, newValue Value) (ok bool, err error) {
+	oldSecondaryKeys, err := indexedMap.extract(key, oldValue)
+	if nil != err {
+		return
+	}
+	newSecondaryKeys, err := indexedMap.extract(key, newValue)
+	if nil != err {
+		return
+	}
+
+	// Only update the entries of indexes whose secondary Key changed
+
+	for indexName, index := range indexedMap.indexes {
+		oldSecondaryKey, oldIndexed := oldSecondaryKeys[indexName]
+		newSecondaryKey, newIndexed := newSecondaryKeys[indexName]
+
+		if oldIndexed && newIndexed {
+			result, compareErr := index.compare(Tuple{oldSecondaryKey, key}, Tuple{newSecondaryKey, key})
+			if nil != compareErr {
+				err = fmt.Errorf("index %q update failed: %v", indexName, compareErr)
+				return
+			}
+			if 0 == result {
+				delete(oldSecondaryKeys, indexName)
+				delete(newSecondaryKeys, indexName)
+			}
+		}
+	}
+
+	undo, err := indexedMap.updateIndexEntries(key, newSecondaryKeys, oldSecondaryKeys)
+	if nil != err {
+		return
+	}
+
+	ok, err = indexedMap.primary.PatchByKey(key, newValue)
+	if (nil != err) || !ok {
+		err = indexedMap.undoIndexEntries(undo, err)
+	}
+
+	return
+}
+
+func (indexedMap *indexedMapStruct) Put(key Key, value Value) (ok bool, err error) {
+	indexedMap.Lock()
+	defer indexedMap.Unlock()
+
+	_, found, err := indexedMap.primary.GetByKey(key)
+	if nil != err {
+		return
+	}
+	if found {
+		ok = false
+		return
+	}
+
+	secondaryKeys, err := indexedMap.extract(key, value)
+	if nil != err {
+		return
+	}
+
+	undo, err := indexedMap.updateIndexEntries(key, secondaryKeys, nil)
+	if nil != err {
+		return
+	}
+
+	ok, err = indexedMap.primary.Put(key, value)
+	if (nil != err) || !ok {
+		err = indexedMap.undoIndexEntries(undo, err)
+	}
+
+	return
+}
+
+func (indexedMap *indexedMapStruct) Validate() (err error) {
+	indexedMap.Lock()
+	defer indexedMap.Unlock()
+
+	err = indexedMap.primary.Validate()
+	if nil != err {
+		return
+	}
+
+	numberOfItems, err := indexedMap.primary.Len()
+	if nil != err {
+		return
+	}
+
+	for _, index := range indexedMap.indexes {
+		err = index.llrbTree.Validate()
+		if nil != err {
+			return
+		}
+	}
+
+	// Each index must hold exactly the entries extracted from the primary SortedMap
+
+	expectedNumberOfEntries := make(map[string]int)
+
+	for primaryIndex := 0; primaryIndex < numberOfItems; primaryIndex++ {
+		key, value, _, getErr := indexedMap.primary.GetByIndex(primaryIndex)
+		if nil != getErr {
+			err = getErr
+			return
+		}
+
+		secondaryKeys, extractErr := indexedMap.extract(key, value)
+		if nil != extractErr {
+			err = extractErr
+			return
+		}
+
+		for indexName, secondaryKey := range secondaryKeys {
+			_, ok, getErr := indexedMap.indexes[indexName].llrbTree.GetByKey(Tuple{secondaryKey, key})
+			if nil != getErr {
+				err = getErr
+				return
+			}
+			if !ok {
+				err = fmt.Errorf("index %q missing entry for primary item %v", indexName, primaryIndex)
+				return
+			}
+
+			expectedNumberOfEntries[indexName]++
+		}
+	}
+
+	for indexName, index := range indexedMap.indexes {
+		numberOfEntries, lenErr := index.llrbTree.Len()
+		if nil != lenErr {
+			err = lenErr
+			return
+		}
+		if numberOfEntries != expectedNumberOfEntries[indexName] {
+			err = fmt.Errorf("index %q contains %v entries but primary items extract only %v", indexName, numberOfEntries, expectedNumberOfEntries[indexName])
+			return
+		}
+	}
+
+	err = nil
+	return
+}
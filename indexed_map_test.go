@@ -0,0 +1,291 @@
+// Copyright (c) 2015-2021, NVIDIA CORPORATION.
+// SPDX-License-Identifier: Apache-2.0
+
+package sortedmap
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// indexedMapTestExtractInitial indexes a string Value by its first character (if any)
+func indexedMapTestExtractInitial(key Key, value Value) (secondaryKey Key, indexed bool, err error) {
+	valueAsString, ok := value.(string)
+	if !ok {
+		err = fmt.Errorf("indexedMapTestExtractInitial() passed non-string Value")
+		return
+	}
+
+	if 0 == len(valueAsString) {
+		indexed = false
+	} else {
+		secondaryKey = valueAsString[:1]
+		indexed = true
+	}
+
+	err = nil
+	return
+}
+
+// indexedMapTestExtractLength indexes a string Value by its length
+func indexedMapTestExtractLength(key Key, value Value) (secondaryKey Key, indexed bool, err error) {
+	valueAsString, ok := value.(string)
+	if !ok {
+		err = fmt.Errorf("indexedMapTestExtractLength() passed non-string Value")
+		return
+	}
+
+	secondaryKey = len(valueAsString)
+	indexed = true
+	err = nil
+	return
+}
+
+func indexedMapTestExpect(t *testing.T, description string, primaryKeys []Key, values []Value, err error, expectedPrimaryKeys []int) {
+	if nil != err {
+		t.Fatalf("%s failed: %v", description, err)
+	}
+	if len(expectedPrimaryKeys) != len(primaryKeys) {
+		t.Fatalf("%s returned %v but should have returned %v", description, primaryKeys, expectedPrimaryKeys)
+	}
+	if len(values) != len(primaryKeys) {
+		t.Fatalf("%s returned mismatched primaryKeys & values", description)
+	}
+	for i, expectedPrimaryKey := range expectedPrimaryKeys {
+		if expectedPrimaryKey != primaryKeys[i].(int) {
+			t.Fatalf("%s returned %v but should have returned %v", description, primaryKeys, expectedPrimaryKeys)
+		}
+	}
+}
+
+func indexedMapTestRun(t *testing.T, primary SortedMap) {
+	indexedMap := NewIndexedMap(primary, CompareInt)
+
+	for key, value := range []string{"apple", "banana", "avocado", "", "blueberry", "cherry"} {
+		ok, err := indexedMap.Put(key, value)
+		if (nil != err) || !ok {
+			t.Fatalf("indexedMap.Put(%v,) failed: %v", key, err)
+		}
+	}
+
+	// Index added after Puts is built from the existing contents
+
+	err := indexedMap.AddIndex("initial", CompareString, indexedMapTestExtractInitial)
+	if nil != err {
+		t.Fatalf("indexedMap.AddIndex(\"initial\",,) failed: %v", err)
+	}
+	err = indexedMap.AddIndex("initial", CompareString, indexedMapTestExtractInitial)
+	if nil == err {
+		t.Fatalf("indexedMap.AddIndex() of existing index should have failed")
+	}
+	err = indexedMap.AddIndex("length", CompareInt, indexedMapTestExtractLength)
+	if nil != err {
+		t.Fatalf("indexedMap.AddIndex(\"length\",,) failed: %v", err)
+	}
+
+	primaryKeys, values, err := indexedMap.GetBySecondaryKey("initial", "a")
+	indexedMapTestExpect(t, "GetBySecondaryKey(\"initial\", \"a\")", primaryKeys, values, err, []int{0, 2})
+	if "avocado" != values[1].(string) {
+		t.Fatalf("GetBySecondaryKey() returned unexpected Value %v", values[1])
+	}
+
+	primaryKeys, values, err = indexedMap.RangeBySecondaryKey("initial", "a", "c")
+	indexedMapTestExpect(t, "RangeBySecondaryKey(\"initial\", \"a\", \"c\")", primaryKeys, values, err, []int{0, 2, 1, 4})
+
+	primaryKeys, values, err = indexedMap.RangeBySecondaryKey("length", 6, 8)
+	indexedMapTestExpect(t, "RangeBySecondaryKey(\"length\", 6, 8)", primaryKeys, values, err, []int{1, 5, 2})
+
+	// Mutations keep every index current
+
+	ok, err := indexedMap.PatchByKey(0, "cranberry")
+	if (nil != err) || !ok {
+		t.Fatalf("indexedMap.PatchByKey(0,) failed: %v", err)
+	}
+	ok, err = indexedMap.PatchByIndex(3, "apricot")
+	if (nil != err) || !ok {
+		t.Fatalf("indexedMap.PatchByIndex(3,) failed: %v", err)
+	}
+	ok, err = indexedMap.DeleteByKey(1)
+	if (nil != err) || !ok {
+		t.Fatalf("indexedMap.DeleteByKey(1) failed: %v", err)
+	}
+	ok, err = indexedMap.DeleteByIndex(1) // Key 2 ("avocado")
+	if (nil != err) || !ok {
+		t.Fatalf("indexedMap.DeleteByIndex(1) failed: %v", err)
+	}
+	ok, err = indexedMap.Put(4, "duplicate")
+	if (nil != err) || ok {
+		t.Fatalf("indexedMap.Put() of existing Key should have returned ok == false")
+	}
+
+	primaryKeys, values, err = indexedMap.GetBySecondaryKey("initial", "a")
+	indexedMapTestExpect(t, "GetBySecondaryKey(\"initial\", \"a\")", primaryKeys, values, err, []int{3})
+
+	primaryKeys, values, err = indexedMap.GetBySecondaryKey("initial", "c")
+	indexedMapTestExpect(t, "GetBySecondaryKey(\"initial\", \"c\")", primaryKeys, values, err, []int{0, 5})
+	if !strings.HasPrefix(values[0].(string), "cran") {
+		t.Fatalf("GetBySecondaryKey() returned stale Value %v", values[0])
+	}
+
+	primaryKeys, values, err = indexedMap.GetBySecondaryKey("length", 0)
+	indexedMapTestExpect(t, "GetBySecondaryKey(\"length\", 0)", primaryKeys, values, err, []int{})
+
+	// An extractor failure leaves the primary & its indexes untouched
+
+	_, err = indexedMap.Put(9, 9)
+	if nil == err {
+		t.Fatalf("indexedMap.Put() of Value rejected by an IndexExtractor should have failed")
+	}
+	_, ok, err = indexedMap.GetByKey(9)
+	if (nil != err) || ok {
+		t.Fatalf("indexedMap.Put() rejected by an IndexExtractor should not have updated primary")
+	}
+
+	err = indexedMap.Validate()
+	if nil != err {
+		t.Fatalf("indexedMap.Validate() failed: %v", err)
+	}
+
+	err = indexedMap.RemoveIndex("length")
+	if nil != err {
+		t.Fatalf("indexedMap.RemoveIndex() failed: %v", err)
+	}
+	_, _, err = indexedMap.GetBySecondaryKey("length", 7)
+	if nil == err {
+		t.Fatalf("indexedMap.GetBySecondaryKey() of removed index should have failed")
+	}
+}
+
+func TestIndexedMap(t *testing.T) {
+	indexedMapTestRun(t, NewLLRBTree(CompareInt, CodecString))
+
+	nodeStore := &callbacksTestNodeStoreStruct{lastObjectNumberGenerated: 0, objectMap: make(map[uint64][]byte)}
+
+	indexedMapTestRun(t, NewBPlusTree(4, CompareInt, Callbacks(CodecInt, CodecString, nodeStore), nil))
+}
+
+// indexedMapTestExtractBadLength indexes a string Value by its length unless it starts with '!' (yielding a non-int secondary Key)
+func indexedMapTestExtractBadLength(key Key, value Value) (secondaryKey Key, indexed bool, err error) {
+	secondaryKey, indexed, err = indexedMapTestExtractLength(key, value)
+	if (nil == err) && strings.HasPrefix(value.(string), "!") {
+		secondaryKey = value
+	}
+	return
+}
+
+// indexedMapTestFailingPrimaryStruct fails each mutation of the wrapped SortedMap while fail is true
+type indexedMapTestFailingPrimaryStruct struct {
+	SortedMap
+	fail bool
+}
+
+func (primary *indexedMapTestFailingPrimaryStruct) DeleteByKey(key Key) (ok bool, err error) {
+	if primary.fail {
+		err = fmt.Errorf("injected DeleteByKey() failure")
+		return
+	}
+	ok, err = primary.SortedMap.DeleteByKey(key)
+	return
+}
+
+func (primary *indexedMapTestFailingPrimaryStruct) PatchByKey(key Key, value Value) (ok bool, err error) {
+	if primary.fail {
+		err = fmt.Errorf("injected PatchByKey() failure")
+		return
+	}
+	ok, err = primary.SortedMap.PatchByKey(key, value)
+	return
+}
+
+func (primary *indexedMapTestFailingPrimaryStruct) Put(key Key, value Value) (ok bool, err error) {
+	if primary.fail {
+		err = fmt.Errorf("injected Put() failure")
+		return
+	}
+	ok, err = primary.SortedMap.Put(key, value)
+	return
+}
+
+func TestIndexedMapFailedMutation(t *testing.T) {
+	primary := &indexedMapTestFailingPrimaryStruct{SortedMap: NewLLRBTree(CompareInt, CodecString)}
+
+	indexedMap := NewIndexedMap(primary, CompareInt)
+
+	err := indexedMap.AddIndex("initial", CompareString, indexedMapTestExtractInitial)
+	if nil != err {
+		t.Fatalf("indexedMap.AddIndex(\"initial\",,) failed: %v", err)
+	}
+	err = indexedMap.AddIndex("length", CompareInt, indexedMapTestExtractBadLength)
+	if nil != err {
+		t.Fatalf("indexedMap.AddIndex(\"length\",,) failed: %v", err)
+	}
+
+	for key, value := range []string{"apple", "banana"} {
+		ok, err := indexedMap.Put(key, value)
+		if (nil != err) || !ok {
+			t.Fatalf("indexedMap.Put(%v,) failed: %v", key, err)
+		}
+	}
+
+	// expectUnchanged verifies that a failed mutation left the primary SortedMap & every index as they were
+
+	expectUnchanged := func(description string, err error) {
+		if nil == err {
+			t.Fatalf("%s should have failed", description)
+		}
+		err = indexedMap.Validate()
+		if nil != err {
+			t.Fatalf("indexedMap.Validate() after failed %s failed: %v", description, err)
+		}
+		numberOfItems, err := indexedMap.Len()
+		if (nil != err) || (2 != numberOfItems) {
+			t.Fatalf("indexedMap.Len() after failed %s should have returned 2", description)
+		}
+		value, ok, err := indexedMap.GetByKey(0)
+		if (nil != err) || !ok || ("apple" != value.(string)) {
+			t.Fatalf("indexedMap.GetByKey(0) after failed %s should have returned \"apple\"", description)
+		}
+		primaryKeys, values, err := indexedMap.GetBySecondaryKey("initial", "a")
+		indexedMapTestExpect(t, "indexedMap.GetBySecondaryKey(\"initial\", \"a\") after failed "+description, primaryKeys, values, err, []int{0})
+		primaryKeys, values, err = indexedMap.GetBySecondaryKey("initial", "c")
+		indexedMapTestExpect(t, "indexedMap.GetBySecondaryKey(\"initial\", \"c\") after failed "+description, primaryKeys, values, err, []int{})
+		primaryKeys, values, err = indexedMap.GetBySecondaryKey("length", 6)
+		indexedMapTestExpect(t, "indexedMap.GetBySecondaryKey(\"length\", 6) after failed "+description, primaryKeys, values, err, []int{1})
+	}
+
+	// A secondary Key rejected by its index's Compare
+
+	_, err = indexedMap.Put(2, "!cherry")
+	expectUnchanged("Put() of rejected secondary Key", err)
+
+	_, err = indexedMap.PatchByKey(0, "!cherry")
+	expectUnchanged("PatchByKey() to rejected secondary Key", err)
+
+	// A failure of the primary SortedMap itself
+
+	primary.fail = true
+
+	_, err = indexedMap.Put(2, "cherry")
+	expectUnchanged("Put()", err)
+
+	_, err = indexedMap.PatchByKey(0, "cherry")
+	expectUnchanged("PatchByKey()", err)
+
+	_, err = indexedMap.DeleteByKey(1)
+	expectUnchanged("DeleteByKey()", err)
+
+	primary.fail = false
+
+	ok, err := indexedMap.PatchByKey(0, "cherry")
+	if (nil != err) || !ok {
+		t.Fatalf("indexedMap.PatchByKey(0,) failed: %v", err)
+	}
+	primaryKeys, values, err := indexedMap.GetBySecondaryKey("initial", "c")
+	indexedMapTestExpect(t, "indexedMap.GetBySecondaryKey(\"initial\", \"c\")", primaryKeys, values, err, []int{0})
+
+	err = indexedMap.Validate()
+	if nil != err {
+		t.Fatalf("indexedMap.Validate() failed: %v", err)
+	}
+}
@@ -0,0 +1,292 @@
+// Copyright (c) 2015-2021, NVIDIA CORPORATION.
+// SPDX-License-Identifier: Apache-2.0
+
+package sortedmap
+
+import (
+	"fmt"
+)
+
+// Interval trees
+//
+// An IntervalTree is an LLRBTree whose Keys are Intervals, ordered by Start and then by End.
+// Each node is augmented with the maximum End of the Intervals in the tree "rooted" by it
+// (maintained through rotations just as len is). This allows Overlaps() & Stab() to skip
+// every subtree whose Intervals all end at or before the point of interest, so that each
+// visits only O(log n + m) nodes to return m Intervals. Intervals are half-open (i.e. an
+// Interval contains Start but not End) and must be non-empty. Any number of Intervals may
+// share the same Start, but identical Intervals are treated as duplicate Keys. Iteration in
+// Interval order is available via GetByIndex() as for any SortedMap.
+//
+// The Compare func supplied to NewIntervalTree() compares Interval endpoints. Similarly, the
+// DumpKey() callback is passed each endpoint of an Interval rather than the Interval itself.
+
+// Interval is the half-open range [Start, End) serving as the Key of an IntervalTree
+type Interval struct {
+	Start Key
+	End   Key
+}
+
+// IntervalTree is an LLRBTree of Intervals supporting overlap queries
+type IntervalTree interface {
+	LLRBTree
+	Overlaps(start Key, end Key) (intervals []Interval, values []Value, err error) // Returns, in order, all Intervals overlapping a non-empty [start, end)
+	Stab(point Key) (intervals []Interval, values []Value, err error)              // Returns, in order, all Intervals containing point
+}
+
+type intervalTreeStruct struct {
+	*llrbTreeStruct
+	compareEndpoints Compare
+}
+
+type intervalTreeCallbacksStruct struct {
+	LLRBTreeCallbacks
+}
+
+// NewIntervalTree is used to construct an IntervalTree whose Interval endpoints are ordered by compare
+func NewIntervalTree(compare Compare, callbacks LLRBTreeCallbacks) (tree IntervalTree) {
+	intervalTree := &intervalTreeStruct{
+		llrbTreeStruct: &llrbTreeStruct{
+			Compare:           compareIntervals(compare),
+			LLRBTreeCallbacks: &intervalTreeCallbacksStruct{callbacks},
+			root:              nil,
+		},
+		compareEndpoints: compare,
+	}
+
+	intervalTree.llrbTreeStruct.augmentNode = intervalTree.augmentNode
+
+	tree = intervalTree
+
+	return
+}
+
+func compareIntervals(compare Compare) (intervalCompare Compare) {
+	intervalCompare = func(key1 Key, key2 Key) (result int, err error) {
+		interval1, ok := key1.(Interval)
+		if !ok {
+			err = fmt.Errorf("IntervalTree Compare(non-Interval,) not supported")
+			return
+		}
+		interval2, ok := key2.(Interval)
+		if !ok {
+			err = fmt.Errorf("IntervalTree Compare(Interval, non-Interval) not supported")
+			return
+		}
+
+		result, err = compare(interval1.Start, interval2.Start)
+		if (nil != err) || (0 != result) {
+			return
+		}
+
+		result, err = compare(interval1.End, interval2.End)
+
+		return
+	}
+
+	return
+}
+
+func (callbacks *intervalTreeCallbacksStruct) DumpKey(key Key) (keyAsString string, err error) {
+	interval := key.(Interval)
+
+	startAsString, err := callbacks.LLRBTreeCallbacks.DumpKey(interval.Start)
+	if nil != err {
+		return
+	}
+	endAsString, err := callbacks.LLRBTreeCallbacks.DumpKey(interval.End)
+	if nil != err {
+		return
+	}
+
+	keyAsString = fmt.Sprintf("[%s, %s)", startAsString, endAsString)
+
+	err = nil
+	return
+}
+
+// augmentNode sets node.augment to the maximum End of the Intervals in a tree "rooted" by node
+//
+// As each Interval's endpoints were successfully compared when it was Put, compareEndpoints
+// is known not to fail here.
+func (tree *intervalTreeStruct) augmentNode(node *llrbNodeStruct) {
+	maxEnd := node.Key.(Interval).End
+
+	if nil != node.left {
+		if compareResult, _ := tree.compareEndpoints(node.left.augment, maxEnd); 0 < compareResult {
+			maxEnd = node.left.augment
+		}
+	}
+	if nil != node.right {
+		if compareResult, _ := tree.compareEndpoints(node.right.augment, maxEnd); 0 < compareResult {
+			maxEnd = node.right.augment
+		}
+	}
+
+	node.augment = maxEnd
+}
+
+func (tree *intervalTreeStruct) Put(key Key, value Value) (ok bool, err error) {
+	interval, isInterval := key.(Interval)
+	if !isInterval {
+		err = fmt.Errorf("IntervalTree.Put() passed non-Interval Key")
+		return
+	}
+
+	compareResult, err := tree.compareEndpoints(interval.Start, interval.End)
+	if nil != err {
+		return
+	}
+	if 0 <= compareResult {
+		err = fmt.Errorf("IntervalTree.Put() passed empty Interval")
+		return
+	}
+
+	ok, err = tree.llrbTreeStruct.Put(key, value)
+
+	return
+}
+
+func (tree *intervalTreeStruct) Overlaps(start Key, end Key) (intervals []Interval, values []Value, err error) {
+	tree.Lock()
+	defer tree.Unlock()
+
+	intervals = make([]Interval, 0)
+	values = make([]Value, 0)
+
+	compareResult, err := tree.compareEndpoints(start, end)
+	if (nil != err) || (0 <= compareResult) {
+		return // Nothing overlaps an empty [start, end)
+	}
+
+	// An Interval overlaps [start, end) if it starts before end and ends after start
+
+	intervals, values, err = tree.collect(tree.root, intervals, values, end, false, start)
+
+	return
+}
+
+func (tree *intervalTreeStruct) Stab(point Key) (intervals []Interval, values []Value, err error) {
+	tree.Lock()
+	defer tree.Unlock()
+
+	intervals = make([]Interval, 0)
+	values = make([]Value, 0)
+
+	// An Interval contains point if it starts at or before point and ends after point
+
+	intervals, values, err = tree.collect(tree.root, intervals, values, point, true, point)
+
+	return
+}
+
+// collect appends, in order, each Interval in a tree "rooted" by node that starts before
+// (or, if startInclusive, at) startLimit and ends after endLimit
+func (tree *intervalTreeStruct) collect(node *llrbNodeStruct, intervals []Interval, values []Value, startLimit Key, startInclusive bool, endLimit Key) (updatedIntervals []Interval, updatedValues []Value, err error) {
+	updatedIntervals = intervals
+	updatedValues = values
+
+	if nil == node {
+		err = nil
+		return
+	}
+
+	compareResult, err := tree.compareEndpoints(node.augment, endLimit)
+	if nil != err {
+		return
+	}
+	if 0 >= compareResult {
+		// No Interval in a tree "rooted" by node ends after endLimit
+
+		err = nil
+		return
+	}
+
+	updatedIntervals, updatedValues, err = tree.collect(node.left, updatedIntervals, updatedValues, startLimit, startInclusive, endLimit)
+	if nil != err {
+		return
+	}
+
+	interval := node.Key.(Interval)
+
+	compareResult, err = tree.compareEndpoints(interval.Start, startLimit)
+	if nil != err {
+		return
+	}
+	if (0 < compareResult) || ((0 == compareResult) && !startInclusive) {
+		// Neither node nor any Interval in node.right starts early enough
+
+		err = nil
+		return
+	}
+
+	compareResult, err = tree.compareEndpoints(interval.End, endLimit)
+	if nil != err {
+		return
+	}
+	if 0 < compareResult {
+		updatedIntervals = append(updatedIntervals, interval)
+		updatedValues = append(updatedValues, node.Value)
+	}
+
+	updatedIntervals, updatedValues, err = tree.collect(node.right, updatedIntervals, updatedValues, startLimit, startInclusive, endLimit)
+
+	return
+}
+
+func (tree *intervalTreeStruct) Validate() (err error) {
+	err = tree.llrbTreeStruct.Validate()
+	if nil != err {
+		return
+	}
+
+	tree.Lock()
+	defer tree.Unlock()
+
+	_, err = tree.validateAugment(tree.root)
+
+	return
+}
+
+// validateAugment verifies node.augment (if node is non-nil) and returns the maximum End it should hold
+func (tree *intervalTreeStruct) validateAugment(node *llrbNodeStruct) (maxEnd Key, err error) {
+	if nil == node {
+		maxEnd = nil
+		err = nil
+		return
+	}
+
+	maxEnd = node.Key.(Interval).End
+
+	for _, child := range []*llrbNodeStruct{node.left, node.right} {
+		childMaxEnd, childErr := tree.validateAugment(child)
+		if nil != childErr {
+			err = childErr
+			return
+		}
+		if nil == child {
+			continue
+		}
+
+		compareResult, compareErr := tree.compareEndpoints(childMaxEnd, maxEnd)
+		if nil != compareErr {
+			err = compareErr
+			return
+		}
+		if 0 < compareResult {
+			maxEnd = childMaxEnd
+		}
+	}
+
+	compareResult, err := tree.compareEndpoints(maxEnd, node.augment)
+	if nil != err {
+		return
+	}
+	if 0 != compareResult {
+		err = fmt.Errorf("For node.Key == %v, computed maximum End (%v) != node.augment (%v)", node.Key, maxEnd, node.augment)
+		return
+	}
+
+	err = nil
+	return
+}
@@ -0,0 +1,139 @@
+// Copyright (c) 2015-2021, NVIDIA CORPORATION.
+// SPDX-License-Identifier: Apache-2.0
+
+package sortedmap
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// intervalTreeTestExpect verifies intervals (and values) match, in order, those of expectedIntervals
+func intervalTreeTestExpect(t *testing.T, description string, intervals []Interval, values []Value, err error, expectedIntervals []Interval) {
+	if nil != err {
+		t.Fatalf("%s failed: %v", description, err)
+	}
+	if (len(expectedIntervals) != len(intervals)) || (len(intervals) != len(values)) {
+		t.Fatalf("%s returned %v but should have returned %v", description, intervals, expectedIntervals)
+	}
+	for i, expectedInterval := range expectedIntervals {
+		if (expectedInterval != intervals[i]) || (expectedInterval.Start.(int) != values[i].(int)) {
+			t.Fatalf("%s returned %v but should have returned %v", description, intervals, expectedIntervals)
+		}
+	}
+}
+
+func TestIntervalTree(t *testing.T) {
+	tree := NewIntervalTree(CompareInt, CodecInt)
+
+	_, err := tree.Put(Interval{Start: 5, End: 5}, 5)
+	if nil == err {
+		t.Fatalf("tree.Put() of empty Interval should have failed")
+	}
+	_, err = tree.Put(5, 5)
+	if nil == err {
+		t.Fatalf("tree.Put() of non-Interval should have failed")
+	}
+
+	rand.Seed(1)
+
+	intervals := make([]Interval, 0)
+
+	for len(intervals) < 200 {
+		start := rand.Intn(1000)
+		interval := Interval{Start: start, End: start + 1 + rand.Intn(100)}
+
+		ok, err := tree.Put(interval, start)
+		if nil != err {
+			t.Fatalf("tree.Put(%v,) failed: %v", interval, err)
+		}
+		if ok {
+			intervals = append(intervals, interval)
+		}
+	}
+
+	// Delete a quarter of the Intervals to exercise rebalancing on the way down
+
+	for _, interval := range intervals[:50] {
+		ok, err := tree.DeleteByKey(interval)
+		if (nil != err) || !ok {
+			t.Fatalf("tree.DeleteByKey(%v) failed: %v", interval, err)
+		}
+	}
+
+	intervals = intervals[50:]
+
+	err = tree.Validate()
+	if nil != err {
+		t.Fatalf("tree.Validate() failed: %v", err)
+	}
+
+	// Ordered iteration
+
+	numberOfItems, err := tree.Len()
+	if (nil != err) || (len(intervals) != numberOfItems) {
+		t.Fatalf("tree.Len() should have returned %v", len(intervals))
+	}
+
+	orderedIntervals := make([]Interval, 0, numberOfItems)
+
+	for index := 0; index < numberOfItems; index++ {
+		keyAsKey, _, ok, err := tree.GetByIndex(index)
+		if (nil != err) || !ok {
+			t.Fatalf("tree.GetByIndex(%v) failed: %v", index, err)
+		}
+
+		interval := keyAsKey.(Interval)
+
+		if (0 < index) && ((interval.Start.(int) < orderedIntervals[index-1].Start.(int)) || ((interval.Start.(int) == orderedIntervals[index-1].Start.(int)) && (interval.End.(int) <= orderedIntervals[index-1].End.(int)))) {
+			t.Fatalf("tree.GetByIndex(%v) returned %v out of order", index, interval)
+		}
+
+		orderedIntervals = append(orderedIntervals, interval)
+	}
+
+	// Compare Overlaps() & Stab() against brute force
+
+	for query := 0; query < 200; query++ {
+		start := rand.Intn(1100) - 50
+		end := start + 1 + rand.Intn(60)
+
+		expectedOverlaps := make([]Interval, 0)
+		expectedStabs := make([]Interval, 0)
+
+		for _, interval := range orderedIntervals {
+			if (interval.Start.(int) < end) && (interval.End.(int) > start) {
+				expectedOverlaps = append(expectedOverlaps, interval)
+			}
+			if (interval.Start.(int) <= start) && (interval.End.(int) > start) {
+				expectedStabs = append(expectedStabs, interval)
+			}
+		}
+
+		overlaps, values, err := tree.Overlaps(start, end)
+		intervalTreeTestExpect(t, "tree.Overlaps()", overlaps, values, err, expectedOverlaps)
+
+		stabs, values, err := tree.Stab(start)
+		intervalTreeTestExpect(t, "tree.Stab()", stabs, values, err, expectedStabs)
+	}
+
+	overlaps, values, err := tree.Overlaps(10, 10)
+	intervalTreeTestExpect(t, "tree.Overlaps() of empty range", overlaps, values, err, []Interval{})
+
+	// Abutting Intervals do not overlap
+
+	tree.Reset()
+
+	for _, interval := range []Interval{{Start: 0, End: 10}, {Start: 10, End: 20}, {Start: 20, End: 30}} {
+		_, err = tree.Put(interval, interval.Start)
+		if nil != err {
+			t.Fatalf("tree.Put(%v,) failed: %v", interval, err)
+		}
+	}
+
+	stabs, values, err := tree.Stab(10)
+	intervalTreeTestExpect(t, "tree.Stab(10)", stabs, values, err, []Interval{{Start: 10, End: 20}})
+
+	overlaps, values, err = tree.Overlaps(5, 20)
+	intervalTreeTestExpect(t, "tree.Overlaps(5, 20)", overlaps, values, err, []Interval{{Start: 0, End: 10}, {Start: 10, End: 20}})
+}
@@ -0,0 +1,90 @@
+// Copyright (c) 2015-2021, NVIDIA CORPORATION.
+// SPDX-License-Identifier: Apache-2.0
+
+package sortedmap
+
+import "fmt"
+
+// aggregateNode sets node.augment to the Aggregate of a tree "rooted" by node
+func (tree *llrbTreeStruct) aggregateNode(node *llrbNodeStruct) {
+	aggregate := tree.aggregator.Lift(node.Key, node.Value)
+
+	if nil != node.left {
+		aggregate = tree.aggregator.Combine(node.left.augment, aggregate)
+	}
+	if nil != node.right {
+		aggregate = tree.aggregator.Combine(aggregate, node.right.augment)
+	}
+
+	node.augment = aggregate
+}
+
+func (tree *llrbTreeStruct) Aggregate(loKey Key, hiKey Key) (aggregate Aggregate, err error) {
+	tree.Lock()
+	defer tree.Unlock()
+
+	if nil == tree.aggregator {
+		err = fmt.Errorf("Aggregate() requires an Aggregator")
+		return
+	}
+
+	loIndex, _, err := tree.bisectRightWhileLocked(loKey)
+	if nil != err {
+		return
+	}
+	hiIndex, _, err := tree.bisectRightWhileLocked(hiKey)
+	if nil != err {
+		return
+	}
+
+	aggregate = tree.aggregateRange(tree.root, loIndex, hiIndex)
+
+	err = nil
+	return
+}
+
+func (tree *llrbTreeStruct) AggregateByIndex(loIndex int, hiIndex int) (aggregate Aggregate, err error) {
+	tree.Lock()
+	defer tree.Unlock()
+
+	if nil == tree.aggregator {
+		err = fmt.Errorf("AggregateByIndex() requires an Aggregator")
+		return
+	}
+
+	aggregate = tree.aggregateRange(tree.root, loIndex, hiIndex)
+
+	err = nil
+	return
+}
+
+// aggregateRange returns the Aggregate of the nodes at [loIndex,hiIndex) of a tree "rooted" by node
+//
+// Only subtrees straddling loIndex or hiIndex are descended, as the Aggregate of any subtree
+// wholly within [loIndex,hiIndex) is already held in its root's augment field.
+func (tree *llrbTreeStruct) aggregateRange(node *llrbNodeStruct, loIndex int, hiIndex int) (aggregate Aggregate) {
+	if (nil == node) || (loIndex >= hiIndex) || (hiIndex <= 0) || (loIndex >= node.len) {
+		aggregate = tree.aggregator.Identity()
+		return
+	}
+
+	if (loIndex <= 0) && (hiIndex >= node.len) {
+		aggregate = node.augment
+		return
+	}
+
+	nodeIndex := 0 // computed index of node within a tree "rooted" by node
+	if nil != node.left {
+		nodeIndex = node.left.len
+	}
+
+	aggregate = tree.aggregateRange(node.left, loIndex, hiIndex)
+
+	if (loIndex <= nodeIndex) && (nodeIndex < hiIndex) {
+		aggregate = tree.aggregator.Combine(aggregate, tree.aggregator.Lift(node.Key, node.Value))
+	}
+
+	aggregate = tree.aggregator.Combine(aggregate, tree.aggregateRange(node.right, loIndex-nodeIndex-1, hiIndex-nodeIndex-1))
+
+	return
+}
@@ -0,0 +1,57 @@
+// Copyright (c) 2015-2021, NVIDIA CORPORATION.
+// SPDX-License-Identifier: Apache-2.0
+
+package sortedmap
+
+// Bounded LLRB Trees
+//
+// An LLRBTree created with LLRBTreeOptions.MaxItems and/or LLRBTreeOptions.MaxWeight (which
+// requires a Weigher) set is bounded. Whenever a Put() (or, if bounded by weight, a Patch*())
+// leaves the tree holding more than MaxItems Key:Value pairs or weighing more than MaxWeight,
+// Key:Value pairs are evicted from the end of the tree selected by EvictionPolicy until it no
+// longer does. Note that the pair just Put() may itself be evicted (e.g. a Key too small to
+// make a "top N" leaderboard bounded with EvictSmallest). Each evicted Key:Value pair is
+// passed, in order of eviction, to the EvictionCallback (if any). As the tree's lock is held
+// at the time, the EvictionCallback must not call the tree.
+
+// EvictionPolicy selects which end of a bounded LLRBTree Key:Value pairs are evicted from
+type EvictionPolicy int
+
+const (
+	EvictSmallest EvictionPolicy = iota // Evict the Key:Value pair with the smallest Key (e.g. to retain the "top N")
+	EvictLargest                        // Evict the Key:Value pair with the largest Key
+)
+
+// EvictionCallback is passed each Key:Value pair evicted from a bounded LLRBTree
+type EvictionCallback func(key Key, value Value)
+
+// overCapacity returns whether the tree holds more Key:Value pairs (or weight) than permitted
+func (tree *llrbTreeStruct) overCapacity() (overCapacity bool) {
+	if nil == tree.root {
+		overCapacity = false
+		return
+	}
+
+	overCapacity = ((0 < tree.maxItems) && (tree.root.len > tree.maxItems)) ||
+		((0 < tree.maxWeight) && (tree.root.weight > tree.maxWeight))
+
+	return
+}
+
+// evict removes Key:Value pairs (per tree.evictionPolicy) until the tree is no longer over capacity
+func (tree *llrbTreeStruct) evict() (err error) {
+	for tree.overCapacity() {
+		key, value, _, popErr := tree.popWhileLocked(EvictLargest == tree.evictionPolicy)
+		if nil != popErr {
+			err = popErr
+			return
+		}
+
+		if nil != tree.evictionCallback {
+			tree.evictionCallback(key, value)
+		}
+	}
+
+	err = nil
+	return
+}
@@ -0,0 +1,144 @@
+// Copyright (c) 2015-2021, NVIDIA CORPORATION.
+// SPDX-License-Identifier: Apache-2.0
+
+package sortedmap
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func TestLLRBTreeBounded(t *testing.T) {
+	rand.Seed(6)
+
+	for _, evictionPolicy := range []EvictionPolicy{EvictSmallest, EvictLargest} {
+		evictedKeys := make([]int, 0)
+
+		tree := NewLLRBTreeWithOptions(CompareInt, CodecInt, &LLRBTreeOptions{
+			MaxItems:         10,
+			EvictionPolicy:   evictionPolicy,
+			EvictionCallback: func(key Key, value Value) { evictedKeys = append(evictedKeys, key.(int)) },
+		})
+
+		putKeys := rand.Perm(100)
+
+		for _, key := range putKeys {
+			ok, err := tree.Put(key, key)
+			if (nil != err) || !ok {
+				t.Fatalf("tree.Put(%v,) failed: %v", key, err)
+			}
+
+			numberOfItems, _ := tree.Len()
+			if 10 < numberOfItems {
+				t.Fatalf("tree.Len() returned %v but should have been bounded to 10", numberOfItems)
+			}
+		}
+
+		// The retained Keys are the "top" (or "bottom") 10 & all others were evicted exactly once
+
+		for index := 0; index < 10; index++ {
+			expectedKey := index
+			if EvictSmallest == evictionPolicy {
+				expectedKey = 90 + index
+			}
+
+			key, _, ok, err := tree.GetByIndex(index)
+			if (nil != err) || !ok || (expectedKey != key.(int)) {
+				t.Fatalf("tree.GetByIndex(%v) returned %v but should have returned %v", index, key, expectedKey)
+			}
+		}
+
+		if 90 != len(evictedKeys) {
+			t.Fatalf("EvictionCallback called %v times but should have been called 90 times", len(evictedKeys))
+		}
+		sort.Ints(evictedKeys)
+		for i, evictedKey := range evictedKeys {
+			expectedKey := i
+			if EvictLargest == evictionPolicy {
+				expectedKey = 10 + i
+			}
+			if expectedKey != evictedKey {
+				t.Fatalf("EvictionCallback passed unexpected Key %v", evictedKey)
+			}
+		}
+
+		err := tree.Validate()
+		if nil != err {
+			t.Fatalf("tree.Validate() failed: %v", err)
+		}
+	}
+}
+
+func TestLLRBTreeBoundedByWeightWithoutWeigher(t *testing.T) {
+	defer func() {
+		if nil == recover() {
+			t.Fatalf("NewLLRBTreeWithOptions() with MaxWeight but no Weigher should have panicked")
+		}
+	}()
+
+	_ = NewLLRBTreeWithOptions(CompareInt, nil, &LLRBTreeOptions{MaxWeight: 100})
+}
+
+func TestLLRBTreeBoundedByWeight(t *testing.T) {
+	evictedKeys := make([]int, 0)
+
+	tree := NewLLRBTreeWithOptions(CompareInt, CodecInt, &LLRBTreeOptions{
+		Weigher:          weightTestWeigher,
+		MaxWeight:        100,
+		EvictionPolicy:   EvictLargest,
+		EvictionCallback: func(key Key, value Value) { evictedKeys = append(evictedKeys, key.(int)) },
+	})
+
+	for key := 0; key < 10; key++ {
+		_, err := tree.Put(key, 10)
+		if nil != err {
+			t.Fatalf("tree.Put(%v,) failed: %v", key, err)
+		}
+	}
+
+	// Adding weight 25 to a full tree evicts the 3 largest Keys
+
+	_, err := tree.Put(5, 0) // already present, so ignored
+	if nil != err {
+		t.Fatalf("tree.Put(5,) failed: %v", err)
+	}
+	_, err = tree.Put(-1, 25)
+	if nil != err {
+		t.Fatalf("tree.Put(-1,) failed: %v", err)
+	}
+	if (3 != len(evictedKeys)) || (9 != evictedKeys[0]) || (8 != evictedKeys[1]) || (7 != evictedKeys[2]) {
+		t.Fatalf("EvictionCallback passed %v but should have passed [9 8 7]", evictedKeys)
+	}
+
+	// A Key too heavy to fit is itself evicted
+
+	_, err = tree.Put(20, 101)
+	if nil != err {
+		t.Fatalf("tree.Put(20,) failed: %v", err)
+	}
+	if (4 != len(evictedKeys)) || (20 != evictedKeys[3]) {
+		t.Fatalf("EvictionCallback passed %v but should have passed [9 8 7 20]", evictedKeys)
+	}
+
+	// Patching a Value to be heavier also evicts (here Keys 6 & 5)
+
+	ok, err := tree.PatchByKey(0, 35)
+	if (nil != err) || !ok {
+		t.Fatalf("tree.PatchByKey(0,) failed: %v", err)
+	}
+
+	weight, err := tree.WeightBefore(100)
+	if (nil != err) || (100 < weight) {
+		t.Fatalf("tree.WeightBefore(100) returned %v but should have been bounded to 100", weight)
+	}
+	numberOfItems, err := tree.Len()
+	if (nil != err) || (6 != numberOfItems) || (6 != len(evictedKeys)) {
+		t.Fatalf("tree.Len() returned %v but should have returned 6", numberOfItems)
+	}
+
+	err = tree.Validate()
+	if nil != err {
+		t.Fatalf("tree.Validate() failed: %v", err)
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright (c) 2015-2021, NVIDIA CORPORATION.
+// SPDX-License-Identifier: Apache-2.0
+
+package sortedmap
+
+import "context"
+
+// peekWhileLocked returns the Key:Value pair with the smallest (or, if max is true, largest) Key
+func (tree *llrbTreeStruct) peekWhileLocked(max bool) (key Key, value Value, ok bool) {
+	node := tree.root
+
+	if nil == node {
+		ok = false
+		return
+	}
+
+	if max {
+		for nil != node.right {
+			node = node.right
+		}
+	} else {
+		for nil != node.left {
+			node = node.left
+		}
+	}
+
+	key = node.Key
+	value = node.Value
+	ok = true
+
+	return
+}
+
+// popWhileLocked removes the Key:Value pair with the smallest (or, if max is true, largest) Key
+func (tree *llrbTreeStruct) popWhileLocked(max bool) (key Key, value Value, ok bool, err error) {
+	if nil == tree.root {
+		ok = false
+		err = nil
+		return
+	}
+
+	if max {
+		key, value, _ = tree.peekWhileLocked(true)
+
+		_ = tree.preDeleteByIndexAdjustLen(tree.root, tree.root.len-1)
+
+		tree.root, err = tree.delete(tree.root, key)
+		if nil != err {
+			return
+		}
+	} else {
+		tree.root, key, value = tree.deleteMin(tree.root) // deleteMin() maintains len itself
+	}
+
+	if nil != tree.root {
+		tree.root.color = BLACK
+	}
+
+	ok = true
+	err = nil
+
+	return
+}
+
+func (tree *llrbTreeStruct) PeekMin() (key Key, value Value, ok bool, err error) {
+	tree.Lock()
+	defer tree.Unlock()
+
+	key, value, ok = tree.peekWhileLocked(false)
+	err = nil
+
+	return
+}
+
+func (tree *llrbTreeStruct) PeekMax() (key Key, value Value, ok bool, err error) {
+	tree.Lock()
+	defer tree.Unlock()
+
+	key, value, ok = tree.peekWhileLocked(true)
+	err = nil
+
+	return
+}
+
+func (tree *llrbTreeStruct) PopMin() (key Key, value Value, ok bool, err error) {
+	tree.Lock()
+	defer tree.Unlock()
+
+	key, value, ok, err = tree.popWhileLocked(false)
+
+	return
+}
+
+func (tree *llrbTreeStruct) PopMax() (key Key, value Value, ok bool, err error) {
+	tree.Lock()
+	defer tree.Unlock()
+
+	key, value, ok, err = tree.popWhileLocked(true)
+
+	return
+}
+
+func (tree *llrbTreeStruct) PopMinWait(ctx context.Context) (key Key, value Value, err error) {
+	tree.Lock()
+	defer tree.Unlock()
+
+	key, value, err = tree.itemWaiter.wait(ctx, &tree.Mutex, func() (Key, Value, bool, error) { return tree.popWhileLocked(false) })
+
+	return
+}
+
+func (tree *llrbTreeStruct) PopMaxWait(ctx context.Context) (key Key, value Value, err error) {
+	tree.Lock()
+	defer tree.Unlock()
+
+	key, value, err = tree.itemWaiter.wait(ctx, &tree.Mutex, func() (Key, Value, bool, error) { return tree.popWhileLocked(true) })
+
+	return
+}
@@ -24,17 +24,27 @@ const (
 type llrbNodeStruct struct {
 	Key
 	Value
-	left  *llrbNodeStruct // Pointer to Left Child (or nil)
-	right *llrbNodeStruct // Pointer to Right Child (or nil)
-	color bool            // Color of parent link
-	len   int             // Number of nodes (including this node) in a tree "rooted" by this node
+	left    *llrbNodeStruct // Pointer to Left Child (or nil)
+	right   *llrbNodeStruct // Pointer to Right Child (or nil)
+	color   bool            // Color of parent link
+	len     int             // Number of nodes (including this node) in a tree "rooted" by this node
+	weight  uint64          // If tree.weigher != nil, total weight of the nodes in a tree "rooted" by this node
+	augment interface{}     // If tree.augmentNode != nil, summary of a tree "rooted" by this node
 }
 
 type llrbTreeStruct struct {
 	sync.Mutex
 	Compare
 	LLRBTreeCallbacks
-	root *llrbNodeStruct
+	root             *llrbNodeStruct
+	augmentNode      func(node *llrbNodeStruct) // If non-nil, recomputes node.augment from node & its children
+	aggregator       Aggregator                 // If non-nil, node.augment is the Aggregate of a tree "rooted" by node
+	weigher          Weigher                    // If non-nil, node.weight is maintained
+	maxItems         int                        // If non-zero, the tree is bounded to this many nodes (see llrb_bounded.go)
+	maxWeight        uint64                     // If non-zero (and weigher != nil), the tree is bounded to this total weight
+	evictionPolicy   EvictionPolicy             // Selects which node is evicted from a bounded tree
+	evictionCallback EvictionCallback           // If non-nil, passed each evicted Key:Value
+	itemWaiter       itemWaiterStruct           // Wakes Pop*Wait() callers upon Put() (see priority_queue.go)
 }
 
 // API functions (see api.go)
@@ -123,6 +133,12 @@ func (tree *llrbTreeStruct) BisectRight(key Key) (index int, found bool, err err
 	tree.Lock()
 	defer tree.Unlock()
 
+	index, found, err = tree.bisectRightWhileLocked(key)
+
+	return
+}
+
+func (tree *llrbTreeStruct) bisectRightWhileLocked(key Key) (index int, found bool, err error) {
 	node := tree.root
 
 	if nil == node {
@@ -368,6 +384,8 @@ func (tree *llrbTreeStruct) PatchByIndex(index int, value Value) (ok bool, err e
 
 	ok = true // index is within [0,# nodes), so we know we will succeed
 
+	path := tree.appendAugmentPath(nil, node)
+
 	nodeIndex := 0 // computed index of current node
 	if nil != node.left {
 		nodeIndex = node.left.len
@@ -391,10 +409,16 @@ func (tree *llrbTreeStruct) PatchByIndex(index int, value Value) (ok bool, err e
 				nodeIndex = nodeIndex + node.left.len + 1
 			}
 		}
+
+		path = tree.appendAugmentPath(path, node)
 	}
 
 	node.Value = value
 
+	tree.updateAugmentPath(path)
+
+	err = tree.evict() // patching may have increased the tree's weight
+
 	return
 }
 
@@ -404,7 +428,11 @@ func (tree *llrbTreeStruct) PatchByKey(key Key, value Value) (ok bool, err error
 
 	node := tree.root
 
+	var path []*llrbNodeStruct
+
 	for nil != node {
+		path = tree.appendAugmentPath(path, node)
+
 		compareResult, compareErr := tree.Compare(key, node.Key)
 		if nil != compareErr {
 			err = compareErr
@@ -418,8 +446,9 @@ func (tree *llrbTreeStruct) PatchByKey(key Key, value Value) (ok bool, err error
 			node = node.right
 		default: // compareResult == 0 (key == node.Key)
 			node.Value = value
+			tree.updateAugmentPath(path)
 			ok = true
-			err = nil
+			err = tree.evict() // patching may have increased the tree's weight
 
 			return
 		}
@@ -446,6 +475,13 @@ func (tree *llrbTreeStruct) Put(key Key, value Value) (ok bool, err error) {
 		tree.root = updatedRoot
 		tree.postInsertAdjustLen(tree.root, key)
 		tree.root.color = BLACK
+
+		err = tree.evict()
+		if nil != err {
+			return
+		}
+
+		tree.itemWaiter.signal()
 	}
 
 	err = nil
@@ -464,6 +500,7 @@ func (tree *llrbTreeStruct) insert(oldNexusNode *llrbNodeStruct, key Key, value
 		// Add new leaf node - .len will be updated by later call to postInsertAdjustLen()
 
 		newNexusNode = &llrbNodeStruct{Key: key, Value: value, left: nil, right: nil, color: RED, len: 0}
+		tree.updateAugment(newNexusNode)
 		ok = true
 		err = nil
 
@@ -730,6 +767,11 @@ func (tree *llrbTreeStruct) rotateLeft(oldParentNode *llrbNodeStruct) (newParent
 	oldParentNode.len = oldParentNode.len - newParentNode.len + nodesTransferred
 	newParentNode.len = newParentNode.len - nodesTransferred + oldParentNode.len
 
+	// Adjust augment fields (children before parents)
+
+	tree.updateAugment(oldParentNode)
+	tree.updateAugment(newParentNode)
+
 	// Adjust color field
 
 	newParentNode.color = oldParentNode.color
@@ -757,6 +799,11 @@ func (tree *llrbTreeStruct) rotateRight(oldParentNode *llrbNodeStruct) (newParen
 	oldParentNode.len = oldParentNode.len - newParentNode.len + nodesTransferred
 	newParentNode.len = newParentNode.len - nodesTransferred + oldParentNode.len
 
+	// Adjust augment fields (children before parents)
+
+	tree.updateAugment(oldParentNode)
+	tree.updateAugment(newParentNode)
+
 	// Adjust color field
 
 	newParentNode.color = oldParentNode.color
@@ -807,5 +854,34 @@ func (tree *llrbTreeStruct) fixUp(oldNexusNode *llrbNodeStruct) (newNexusNode *l
 		colorFlip(newNexusNode)
 	}
 
+	tree.updateAugment(newNexusNode) // newNexusNode's children or Key:Value may have changed absent a rotation
+
 	return
 }
+
+func (tree *llrbTreeStruct) updateAugment(node *llrbNodeStruct) {
+	if nil != tree.weigher {
+		tree.weighNode(node)
+	}
+	if nil != tree.augmentNode {
+		tree.augmentNode(node)
+	}
+}
+
+// appendAugmentPath records node on the path to a node whose Value is to be patched (if the tree is augmented)
+func (tree *llrbTreeStruct) appendAugmentPath(path []*llrbNodeStruct, node *llrbNodeStruct) (updatedPath []*llrbNodeStruct) {
+	if (nil == tree.augmentNode) && (nil == tree.weigher) {
+		updatedPath = path
+	} else {
+		updatedPath = append(path, node)
+	}
+
+	return
+}
+
+// updateAugmentPath recomputes the augment field of each node on path (from the patched node up)
+func (tree *llrbTreeStruct) updateAugmentPath(path []*llrbNodeStruct) {
+	for i := len(path) - 1; i >= 0; i-- {
+		tree.updateAugment(path[i])
+	}
+}
@@ -3,8 +3,15 @@
 
 package sortedmap
 
+import (
+	"fmt"
+)
+
 type LLRBTree interface {
 	SortedMap
+	AggregatedSortedMap
+	WeightedSortedMap
+	PriorityQueue
 	Reset()
 }
 
@@ -13,7 +20,53 @@ type LLRBTreeCallbacks interface {
 	DumpCallbacks
 }
 
+// LLRBTreeOptions specifies optional augmentations & bounds of an LLRBTree
+type LLRBTreeOptions struct {
+	Aggregator Aggregator // if non-nil, each subtree is summarized by Aggregator (see aggregate.go)
+	Weigher    Weigher    // if non-nil, each subtree's total weight is maintained (see weight.go)
+
+	MaxItems         int              // if non-zero, Key:Value pairs are evicted to hold at most MaxItems (see llrb_bounded.go)
+	MaxWeight        uint64           // if non-zero, Key:Value pairs are evicted to weigh at most MaxWeight (requires a Weigher)
+	EvictionPolicy   EvictionPolicy   // selects which Key:Value pair is evicted (defaults to EvictSmallest)
+	EvictionCallback EvictionCallback // if non-nil, passed each evicted Key:Value pair
+}
+
 func NewLLRBTree(compare Compare, callbacks LLRBTreeCallbacks) (tree LLRBTree) {
 	tree = &llrbTreeStruct{Compare: compare, LLRBTreeCallbacks: callbacks, root: nil}
 	return
 }
+
+// NewLLRBTreeWithOptions is identical to NewLLRBTree() except that it accepts an *LLRBTreeOptions
+//
+// As with NewBPlusTree(), invalid options (i.e. a MaxWeight absent a Weigher) cause a panic.
+func NewLLRBTreeWithOptions(compare Compare, callbacks LLRBTreeCallbacks, options *LLRBTreeOptions) (tree LLRBTree) {
+	llrbTree := &llrbTreeStruct{Compare: compare, LLRBTreeCallbacks: callbacks, root: nil}
+
+	if nil != options {
+		if (0 < options.MaxWeight) && (nil == options.Weigher) {
+			panic(fmt.Errorf("LLRBTreeOptions.MaxWeight requires a Weigher"))
+		}
+
+		if nil != options.Aggregator {
+			llrbTree.aggregator = options.Aggregator
+			llrbTree.augmentNode = llrbTree.aggregateNode
+		}
+
+		llrbTree.weigher = options.Weigher
+
+		llrbTree.maxItems = options.MaxItems
+		llrbTree.maxWeight = options.MaxWeight
+		llrbTree.evictionPolicy = options.EvictionPolicy
+		llrbTree.evictionCallback = options.EvictionCallback
+	}
+
+	tree = llrbTree
+
+	return
+}
+
+// NewLLRBTreeWithAggregator is identical to NewLLRBTree() except that each subtree is summarized by aggregator
+func NewLLRBTreeWithAggregator(compare Compare, callbacks LLRBTreeCallbacks, aggregator Aggregator) (tree LLRBTree) {
+	tree = NewLLRBTreeWithOptions(compare, callbacks, &LLRBTreeOptions{Aggregator: aggregator})
+	return
+}
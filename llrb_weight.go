@@ -0,0 +1,99 @@
+// Copyright (c) 2015-2021, NVIDIA CORPORATION.
+// SPDX-License-Identifier: Apache-2.0
+
+package sortedmap
+
+import "fmt"
+
+// weighNode sets node.weight to the total weight of a tree "rooted" by node
+func (tree *llrbTreeStruct) weighNode(node *llrbNodeStruct) {
+	weight := tree.weigher(node.Key, node.Value)
+
+	if nil != node.left {
+		weight += node.left.weight
+	}
+	if nil != node.right {
+		weight += node.right.weight
+	}
+
+	node.weight = weight
+}
+
+func (tree *llrbTreeStruct) GetByWeight(offset uint64) (key Key, value Value, offsetWithinItem uint64, ok bool, err error) {
+	tree.Lock()
+	defer tree.Unlock()
+
+	if nil == tree.weigher {
+		err = fmt.Errorf("GetByWeight() requires a Weigher")
+		return
+	}
+
+	node := tree.root
+
+	if (nil == node) || (offset >= node.weight) {
+		ok = false
+		err = nil
+		return
+	}
+
+	for nil != node {
+		if (nil != node.left) && (offset < node.left.weight) {
+			node = node.left
+			continue
+		}
+
+		if nil != node.left {
+			offset -= node.left.weight
+		}
+
+		weight := tree.weigher(node.Key, node.Value)
+
+		if offset < weight {
+			key = node.Key
+			value = node.Value
+			offsetWithinItem = offset
+			ok = true
+			err = nil
+			return
+		}
+
+		offset -= weight
+		node = node.right // offset < root.weight, so node.right is non-nil unless the Weigher's answers changed
+	}
+
+	err = fmt.Errorf("Logic error: GetByWeight() ran off the tree (Weigher's answers must not change)")
+	return
+}
+
+func (tree *llrbTreeStruct) WeightBefore(key Key) (weight uint64, err error) {
+	tree.Lock()
+	defer tree.Unlock()
+
+	if nil == tree.weigher {
+		err = fmt.Errorf("WeightBefore() requires a Weigher")
+		return
+	}
+
+	node := tree.root
+
+	for nil != node {
+		compareResult, compareErr := tree.Compare(key, node.Key)
+		if nil != compareErr {
+			err = compareErr
+			return
+		}
+
+		if 0 >= compareResult { // key <= node.Key
+			node = node.left
+		} else { // key > node.Key
+			if nil != node.left {
+				weight += node.left.weight
+			}
+			weight += tree.weigher(node.Key, node.Value)
+			node = node.right
+		}
+	}
+
+	err = nil
+	return
+}
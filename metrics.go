@@ -0,0 +1,84 @@
+// Copyright (c) 2015-2021, NVIDIA CORPORATION.
+// SPDX-License-Identifier: Apache-2.0
+
+package sortedmap
+
+import (
+	"expvar"
+	"sync"
+)
+
+// Metrics
+//
+// A B+Tree reports internal events to the Metrics supplied via BPlusTreeOptions.Metrics and a
+// BPlusTreeCache reports to that supplied to NewBPlusTreeCacheWithMetrics(). Absent either,
+// NoMetrics discards them. Each event either adds to a named counter or records a value in a
+// named histogram (see the Metric* names below). As they are reported while holding the
+// reporting B+Tree's (or BPlusTreeCache's) lock, a Metrics implementation must be cheap, safe
+// for concurrent use, and must not call back into the B+Tree or BPlusTreeCache.
+//
+// Two adapters are provided. NewExpvarMetrics() publishes each metric via package expvar and
+// NewPrometheusMetrics() (see metrics_prometheus.go) renders them in the Prometheus text
+// exposition format. Many trees may share a single Metrics, in which case their metrics are
+// summed.
+
+// Metrics receives counter & histogram updates
+type Metrics interface {
+	AddToCounter(name string, delta uint64)
+	ObserveHistogram(name string, value uint64)
+}
+
+const (
+	MetricNodeLoads             = "btree_node_loads_total"              // counter:   nodes successfully loaded via GetNode()
+	MetricNodePosts             = "btree_node_posts_total"              // counter:   nodes posted via PutNode()
+	MetricGetNodeBytes          = "btree_get_node_bytes"                // histogram: sizes of nodes returned by GetNode()
+	MetricPutNodeBytes          = "btree_put_node_bytes"                // histogram: sizes of nodes passed to PutNode()
+	MetricNodeSplits            = "btree_node_splits_total"             // counter:   nodes split in two
+	MetricNodeMerges            = "btree_node_merges_total"             // counter:   nodes merged into a sibling
+	MetricNodeBorrows           = "btree_node_borrows_total"            // counter:   underfull nodes replenished with Keys from a sibling
+	MetricStaleReferencesQueued = "btree_stale_references_queued_total" // counter:   on-disk node locations queued for DiscardNode() by Prune()
+	MetricStaleReferencesPruned = "btree_stale_references_pruned_total" // counter:   on-disk node locations passed to DiscardNode() by Prune()
+	MetricCacheHits             = "cache_hits_total"                    // counter:   node accesses finding the node loaded
+	MetricCacheMisses           = "cache_misses_total"                  // counter:   node accesses requiring the node be loaded
+	MetricCacheEvictions        = "cache_evictions_total"               // counter:   nodes evicted from the cache (by its drainer or by Purge())
+)
+
+type noMetricsStruct struct{}
+
+// NoMetrics discards every update
+var NoMetrics Metrics = &noMetricsStruct{}
+
+func (metrics *noMetricsStruct) AddToCounter(name string, delta uint64) {}
+
+func (metrics *noMetricsStruct) ObserveHistogram(name string, value uint64) {}
+
+type expvarMetricsStruct struct {
+	sync.Mutex // serializes creation of histograms
+	vars       *expvar.Map
+}
+
+// NewExpvarMetrics returns a Metrics publishing an expvar.Map named name
+//
+// Each counter is an expvar.Int in that map while each histogram is an expvar.Map holding
+// the expvar.Int's "count" & "sum". As with expvar.NewMap(), name must not already be in use.
+func NewExpvarMetrics(name string) (metrics Metrics) {
+	metrics = &expvarMetricsStruct{vars: expvar.NewMap(name)}
+	return
+}
+
+func (metrics *expvarMetricsStruct) AddToCounter(name string, delta uint64) {
+	metrics.vars.Add(name, int64(delta))
+}
+
+func (metrics *expvarMetricsStruct) ObserveHistogram(name string, value uint64) {
+	metrics.Lock()
+	histogram, ok := metrics.vars.Get(name).(*expvar.Map)
+	if !ok {
+		histogram = new(expvar.Map).Init()
+		metrics.vars.Set(name, histogram)
+	}
+	metrics.Unlock()
+
+	histogram.Add("count", 1)
+	histogram.Add("sum", int64(value))
+}
@@ -0,0 +1,176 @@
+// Copyright (c) 2015-2021, NVIDIA CORPORATION.
+// SPDX-License-Identifier: Apache-2.0
+
+package sortedmap
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// PrometheusByteBuckets are the default histogram bucket upper bounds used by NewPrometheusMetrics()
+var PrometheusByteBuckets = []uint64{64, 256, 1024, 4096, 16384, 65536, 262144, 1048576}
+
+// PrometheusMetrics is a Metrics rendered in the Prometheus text exposition format
+//
+// WriteTo() writes every metric reported thus far. ServeHTTP() does the same in response to
+// an HTTP request (e.g. when registered at "/metrics" via http.Handle()).
+type PrometheusMetrics interface {
+	Metrics
+	io.WriterTo
+	http.Handler
+}
+
+type prometheusHistogramStruct struct {
+	bucketCounts []uint64 // bucketCounts[i] == number of observations in (buckets[i-1], buckets[i]]
+	sum          uint64
+	count        uint64
+}
+
+type prometheusMetricsStruct struct {
+	sync.Mutex
+	namespace  string
+	buckets    []uint64
+	counters   map[string]uint64                     // key == name
+	histograms map[string]*prometheusHistogramStruct // key == name
+}
+
+var prometheusHelp = map[string]string{
+	MetricNodeLoads:             "B+Tree nodes loaded via GetNode().",
+	MetricNodePosts:             "B+Tree nodes posted via PutNode().",
+	MetricGetNodeBytes:          "Sizes of B+Tree nodes returned by GetNode().",
+	MetricPutNodeBytes:          "Sizes of B+Tree nodes passed to PutNode().",
+	MetricNodeSplits:            "B+Tree nodes split in two.",
+	MetricNodeMerges:            "B+Tree nodes merged into a sibling.",
+	MetricNodeBorrows:           "Underfull B+Tree nodes replenished with Keys from a sibling.",
+	MetricStaleReferencesQueued: "On-disk B+Tree node locations queued for DiscardNode().",
+	MetricStaleReferencesPruned: "On-disk B+Tree node locations passed to DiscardNode().",
+	MetricCacheHits:             "B+Tree node accesses finding the node loaded.",
+	MetricCacheMisses:           "B+Tree node accesses requiring the node be loaded.",
+	MetricCacheEvictions:        "B+Tree nodes evicted from the cache.",
+}
+
+// NewPrometheusMetrics returns a PrometheusMetrics whose metric names are prefixed by namespace (if non-empty) and "_"
+//
+// Histograms use the supplied (ascending) bucket upper bounds or, if buckets is nil,
+// PrometheusByteBuckets.
+func NewPrometheusMetrics(namespace string, buckets []uint64) (metrics PrometheusMetrics) {
+	if nil == buckets {
+		buckets = PrometheusByteBuckets
+	}
+
+	metrics = &prometheusMetricsStruct{
+		namespace:  namespace,
+		buckets:    buckets,
+		counters:   make(map[string]uint64),
+		histograms: make(map[string]*prometheusHistogramStruct),
+	}
+
+	return
+}
+
+func (metrics *prometheusMetricsStruct) AddToCounter(name string, delta uint64) {
+	metrics.Lock()
+	metrics.counters[name] += delta
+	metrics.Unlock()
+}
+
+func (metrics *prometheusMetricsStruct) ObserveHistogram(name string, value uint64) {
+	metrics.Lock()
+
+	histogram, ok := metrics.histograms[name]
+	if !ok {
+		histogram = &prometheusHistogramStruct{bucketCounts: make([]uint64, len(metrics.buckets))}
+		metrics.histograms[name] = histogram
+	}
+
+	bucketIndex := sort.Search(len(metrics.buckets), func(i int) bool { return value <= metrics.buckets[i] })
+	if bucketIndex < len(metrics.buckets) {
+		histogram.bucketCounts[bucketIndex]++
+	}
+
+	histogram.sum += value
+	histogram.count++
+
+	metrics.Unlock()
+}
+
+// qualifiedName returns name prefixed by metrics.namespace (if any)
+func (metrics *prometheusMetricsStruct) qualifiedName(name string) (qualifiedName string) {
+	if "" == metrics.namespace {
+		qualifiedName = name
+	} else {
+		qualifiedName = metrics.namespace + "_" + name
+	}
+
+	return
+}
+
+// writeHeader writes the HELP (if known) & TYPE lines preceding the samples of a metric
+func (metrics *prometheusMetricsStruct) writeHeader(buf *bytes.Buffer, name string, metricType string) {
+	help, ok := prometheusHelp[name]
+	if ok {
+		fmt.Fprintf(buf, "# HELP %s %s\n", metrics.qualifiedName(name), help)
+	}
+
+	fmt.Fprintf(buf, "# TYPE %s %s\n", metrics.qualifiedName(name), metricType)
+}
+
+func (metrics *prometheusMetricsStruct) WriteTo(w io.Writer) (n int64, err error) {
+	var (
+		buf bytes.Buffer
+	)
+
+	metrics.Lock()
+
+	counterNames := make([]string, 0, len(metrics.counters))
+	for name := range metrics.counters {
+		counterNames = append(counterNames, name)
+	}
+	sort.Strings(counterNames)
+
+	for _, name := range counterNames {
+		metrics.writeHeader(&buf, name, "counter")
+		fmt.Fprintf(&buf, "%s %d\n", metrics.qualifiedName(name), metrics.counters[name])
+	}
+
+	histogramNames := make([]string, 0, len(metrics.histograms))
+	for name := range metrics.histograms {
+		histogramNames = append(histogramNames, name)
+	}
+	sort.Strings(histogramNames)
+
+	for _, name := range histogramNames {
+		histogram := metrics.histograms[name]
+		qualifiedName := metrics.qualifiedName(name)
+
+		metrics.writeHeader(&buf, name, "histogram")
+
+		cumulativeCount := uint64(0)
+
+		for i, bucket := range metrics.buckets {
+			cumulativeCount += histogram.bucketCounts[i]
+			fmt.Fprintf(&buf, "%s_bucket{le=\"%d\"} %d\n", qualifiedName, bucket, cumulativeCount)
+		}
+
+		fmt.Fprintf(&buf, "%s_bucket{le=\"+Inf\"} %d\n", qualifiedName, histogram.count)
+		fmt.Fprintf(&buf, "%s_sum %d\n", qualifiedName, histogram.sum)
+		fmt.Fprintf(&buf, "%s_count %d\n", qualifiedName, histogram.count)
+	}
+
+	metrics.Unlock()
+
+	n, err = buf.WriteTo(w)
+
+	return
+}
+
+func (metrics *prometheusMetricsStruct) ServeHTTP(responseWriter http.ResponseWriter, request *http.Request) {
+	responseWriter.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	_, _ = metrics.WriteTo(responseWriter)
+}
@@ -0,0 +1,211 @@
+// Copyright (c) 2015-2021, NVIDIA CORPORATION.
+// SPDX-License-Identifier: Apache-2.0
+
+package sortedmap
+
+import (
+	"bytes"
+	"expvar"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// metricsTestRecorderStruct is a Metrics recording every update
+type metricsTestRecorderStruct struct {
+	sync.Mutex
+	counters        map[string]uint64
+	histogramCounts map[string]uint64
+	histogramSums   map[string]uint64
+}
+
+func newMetricsTestRecorder() (recorder *metricsTestRecorderStruct) {
+	recorder = &metricsTestRecorderStruct{
+		counters:        make(map[string]uint64),
+		histogramCounts: make(map[string]uint64),
+		histogramSums:   make(map[string]uint64),
+	}
+	return
+}
+
+func (recorder *metricsTestRecorderStruct) AddToCounter(name string, delta uint64) {
+	recorder.Lock()
+	recorder.counters[name] += delta
+	recorder.Unlock()
+}
+
+func (recorder *metricsTestRecorderStruct) ObserveHistogram(name string, value uint64) {
+	recorder.Lock()
+	recorder.histogramCounts[name]++
+	recorder.histogramSums[name] += value
+	recorder.Unlock()
+}
+
+func TestBPlusTreeMetrics(t *testing.T) {
+	nodeStore := &callbacksTestNodeStoreStruct{lastObjectNumberGenerated: 0, objectMap: make(map[uint64][]byte)}
+	callbacks := Callbacks(CodecInt, CodecInt, nodeStore)
+
+	treeMetrics := newMetricsTestRecorder()
+	cacheMetrics := newMetricsTestRecorder()
+
+	bPlusTreeCache := NewBPlusTreeCacheWithMetrics(100, 200, cacheMetrics)
+	options := &BPlusTreeOptions{Metrics: treeMetrics}
+
+	tree, err := NewBPlusTreeWithOptions(4, CompareInt, callbacks, bPlusTreeCache, options)
+	if nil != err {
+		t.Fatalf("NewBPlusTreeWithOptions() failed: %v", err)
+	}
+
+	for key := 0; key < 100; key++ {
+		_, err := tree.Put(key, key)
+		if nil != err {
+			t.Fatalf("tree.Put(%v,) failed: %v", key, err)
+		}
+	}
+
+	if 0 == treeMetrics.counters[MetricNodeSplits] {
+		t.Fatalf("Put()s should have reported node splits")
+	}
+
+	rootObjectNumber, rootObjectOffset, rootObjectLength, err := tree.Flush(false)
+	if nil != err {
+		t.Fatalf("tree.Flush(false) failed: %v", err)
+	}
+
+	dimensionsReport, err := tree.FetchDimensionsReport()
+	if nil != err {
+		t.Fatalf("tree.FetchDimensionsReport() failed: %v", err)
+	}
+	if (dimensionsReport.PostedNodes != treeMetrics.counters[MetricNodePosts]) || (dimensionsReport.PostedNodes != treeMetrics.histogramCounts[MetricPutNodeBytes]) || (dimensionsReport.PostedBytes != treeMetrics.histogramSums[MetricPutNodeBytes]) {
+		t.Fatalf("Flush() reported %v posts of %v bytes but should have reported %v posts of %v bytes", treeMetrics.counters[MetricNodePosts], treeMetrics.histogramSums[MetricPutNodeBytes], dimensionsReport.PostedNodes, dimensionsReport.PostedBytes)
+	}
+
+	// A node that fails to load is not reported
+
+	unsupportedRootByteSlice := append([]byte{}, nodeStore.objectMap[rootObjectNumber]...)
+	unsupportedRootByteSlice[len(onDiskNodeMagic)] = onDiskNodeVersionCurrent + 1
+
+	unsupportedRootObjectNumber, unsupportedRootObjectOffset, err := nodeStore.PutNode(unsupportedRootByteSlice)
+	if nil != err {
+		t.Fatalf("nodeStore.PutNode() failed: %v", err)
+	}
+
+	_, err = OldBPlusTreeWithOptions(unsupportedRootObjectNumber, unsupportedRootObjectOffset, rootObjectLength, CompareInt, callbacks, bPlusTreeCache, options)
+	if nil == err {
+		t.Fatalf("OldBPlusTreeWithOptions() of an unsupported root node should have failed")
+	}
+	if (0 != treeMetrics.counters[MetricNodeLoads]) || (0 != treeMetrics.histogramCounts[MetricGetNodeBytes]) {
+		t.Fatalf("a failed load should not have been reported")
+	}
+
+	// Loads, merges, borrows, & stale references are reported by a reopened B+Tree
+
+	tree, err = OldBPlusTreeWithOptions(rootObjectNumber, rootObjectOffset, rootObjectLength, CompareInt, callbacks, bPlusTreeCache, options)
+	if nil != err {
+		t.Fatalf("OldBPlusTreeWithOptions() failed: %v", err)
+	}
+
+	for key := 0; key < 90; key++ {
+		_, err = tree.DeleteByKey(key)
+		if nil != err {
+			t.Fatalf("tree.DeleteByKey(%v) failed: %v", key, err)
+		}
+	}
+
+	if (0 == treeMetrics.counters[MetricNodeLoads]) || (treeMetrics.counters[MetricNodeLoads] != treeMetrics.histogramCounts[MetricGetNodeBytes]) {
+		t.Fatalf("loads should have been reported as both a counter & a histogram")
+	}
+	if (0 == treeMetrics.counters[MetricNodeMerges]) || (0 == treeMetrics.counters[MetricNodeBorrows]) {
+		t.Fatalf("DeleteByKey()s should have reported node merges & borrows")
+	}
+
+	_, _, _, err = tree.Flush(false)
+	if nil != err {
+		t.Fatalf("tree.Flush(false) failed: %v", err)
+	}
+
+	staleReferencesQueued := treeMetrics.counters[MetricStaleReferencesQueued]
+	if 0 == staleReferencesQueued {
+		t.Fatalf("Flush() should have reported stale references queued")
+	}
+
+	err = tree.Prune()
+	if nil != err {
+		t.Fatalf("tree.Prune() failed: %v", err)
+	}
+
+	if staleReferencesQueued != treeMetrics.counters[MetricStaleReferencesPruned] {
+		t.Fatalf("Prune() reported %v stale references pruned but should have reported %v", treeMetrics.counters[MetricStaleReferencesPruned], staleReferencesQueued)
+	}
+
+	err = tree.Purge(true)
+	if nil != err {
+		t.Fatalf("tree.Purge(true) failed: %v", err)
+	}
+
+	bPlusTreeCacheStats := bPlusTreeCache.Stats()
+	if (bPlusTreeCacheStats.CacheHits != cacheMetrics.counters[MetricCacheHits]) || (bPlusTreeCacheStats.CacheMisses != cacheMetrics.counters[MetricCacheMisses]) {
+		t.Fatalf("cache reported %v hits & %v misses but Stats() returned %v & %v", cacheMetrics.counters[MetricCacheHits], cacheMetrics.counters[MetricCacheMisses], bPlusTreeCacheStats.CacheHits, bPlusTreeCacheStats.CacheMisses)
+	}
+	if 0 == cacheMetrics.counters[MetricCacheEvictions] {
+		t.Fatalf("Purge() should have reported cache evictions")
+	}
+}
+
+func TestPrometheusMetrics(t *testing.T) {
+	metrics := NewPrometheusMetrics("sortedmap", []uint64{100, 1000})
+
+	metrics.AddToCounter(MetricNodeSplits, 2)
+	metrics.AddToCounter(MetricNodeSplits, 3)
+	metrics.ObserveHistogram(MetricPutNodeBytes, 50)
+	metrics.ObserveHistogram(MetricPutNodeBytes, 500)
+	metrics.ObserveHistogram(MetricPutNodeBytes, 5000)
+
+	var buf bytes.Buffer
+
+	_, err := metrics.WriteTo(&buf)
+	if nil != err {
+		t.Fatalf("metrics.WriteTo() failed: %v", err)
+	}
+
+	for _, expectedLine := range []string{
+		"# TYPE sortedmap_btree_node_splits_total counter",
+		"sortedmap_btree_node_splits_total 5",
+		"# TYPE sortedmap_btree_put_node_bytes histogram",
+		"sortedmap_btree_put_node_bytes_bucket{le=\"100\"} 1",
+		"sortedmap_btree_put_node_bytes_bucket{le=\"1000\"} 2",
+		"sortedmap_btree_put_node_bytes_bucket{le=\"+Inf\"} 3",
+		"sortedmap_btree_put_node_bytes_sum 5550",
+		"sortedmap_btree_put_node_bytes_count 3",
+	} {
+		if !strings.Contains(buf.String(), expectedLine+"\n") {
+			t.Fatalf("metrics.WriteTo() should have written %q but wrote:\n%s", expectedLine, buf.String())
+		}
+	}
+
+	responseRecorder := httptest.NewRecorder()
+	metrics.ServeHTTP(responseRecorder, httptest.NewRequest("GET", "/metrics", nil))
+	if buf.String() != responseRecorder.Body.String() {
+		t.Fatalf("metrics.ServeHTTP() should have written the same as metrics.WriteTo()")
+	}
+}
+
+func TestExpvarMetrics(t *testing.T) {
+	metrics := NewExpvarMetrics("sortedmap_test")
+
+	metrics.AddToCounter(MetricNodeLoads, 7)
+	metrics.ObserveHistogram(MetricGetNodeBytes, 10)
+	metrics.ObserveHistogram(MetricGetNodeBytes, 20)
+
+	vars := expvar.Get("sortedmap_test").(*expvar.Map)
+
+	if "7" != vars.Get(MetricNodeLoads).String() {
+		t.Fatalf("expvar %s should have been 7", MetricNodeLoads)
+	}
+
+	histogram := vars.Get(MetricGetNodeBytes).(*expvar.Map)
+	if ("2" != histogram.Get("count").String()) || ("30" != histogram.Get("sum").String()) {
+		t.Fatalf("expvar %s should have been {count: 2, sum: 30}", MetricGetNodeBytes)
+	}
+}
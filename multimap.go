@@ -0,0 +1,772 @@
+// Copyright (c) 2015-2021, NVIDIA CORPORATION.
+// SPDX-License-Identifier: Apache-2.0
+
+package sortedmap
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sync"
+)
+
+// Multimaps
+//
+// A Multimap is a SortedMap permitting any number of Key:Value pairs with equal Keys. Such
+// pairs are ordered by insertion (i.e. Put() appends to the run of equal Keys) and this
+// order survives Flush() & OldBPlusTreeMultimap(). For a Key present in the Multimap:
+//
+//	BisectLeft()         returns the index of the first pair in the run of equal Keys
+//	BisectRight()        returns the index of the last pair in the run of equal Keys
+//	DeleteByKey()        deletes the first pair in the run
+//	DeleteAllByKey()     deletes every pair in the run
+//	GetByKey()           returns the Value of the first pair in the run
+//	PatchByKey()         updates the Value of the first pair in the run
+//	Put()                always succeeds, appending a pair to the end of the run
+//
+// Internally, each Key is paired with a sequence number distinguishing it from equal Keys
+// put before it. For a B+Tree, this sequence number is posted following the packed Key as a
+// uvarint (or, if an OrderedKeyEncoding is supplied, following the encoded Key as 8 bytes
+// big-endian). As such, a B+Tree posted as a Multimap must be re-constructed as one. An
+// Aggregator or Weigher supplied via BPlusTreeOptions is passed each Key without its sequence
+// number.
+
+// Multimap is a SortedMap permitting duplicate Keys
+type Multimap interface {
+	SortedMap
+	DeleteAllByKey(key Key) (numberDeleted int, err error)
+}
+
+// LLRBMultimap is an LLRBTree permitting duplicate Keys
+type LLRBMultimap interface {
+	LLRBTree
+	Multimap
+}
+
+// BPlusTreeMultimap is a BPlusTree permitting duplicate Keys
+type BPlusTreeMultimap interface {
+	BPlusTree
+	Multimap
+}
+
+const (
+	multimapSequenceFirst  = uint64(1)              // sequence number of the first Key of a run
+	multimapSequenceProbeL = uint64(0)              // sorts before every Key of a run
+	multimapSequenceProbeR = uint64(math.MaxUint64) // sorts after every Key of a run
+)
+
+type multimapKeyStruct struct {
+	key      Key
+	sequence uint64
+}
+
+type multimapStruct struct {
+	sync.Mutex // serializes operations composed of multiple calls into tree
+	tree       SortedMap
+}
+
+type llrbMultimapStruct struct {
+	*multimapStruct
+	llrbTree *llrbTreeStruct // rather than LLRBTree so as to reach e.g. AggregatedSortedMap methods
+}
+
+type btreeMultimapStruct struct {
+	*multimapStruct
+	bPlusTree *btreeTreeStruct // rather than BPlusTree so as to reach e.g. AggregatedSortedMap methods
+}
+
+type multimapLLRBTreeCallbacksStruct struct {
+	LLRBTreeCallbacks
+}
+
+type multimapKeyCodecStruct struct {
+	keyCodec KeyCodec
+}
+
+type multimapOrderedKeyEncodingStruct struct {
+	orderedKeyEncoding OrderedKeyEncoding
+}
+
+type multimapAggregatorStruct struct {
+	Aggregator
+}
+
+// NewLLRBMultimap is identical to NewLLRBTree() except that duplicate Keys are permitted
+func NewLLRBMultimap(compare Compare, callbacks LLRBTreeCallbacks) (multimap LLRBMultimap) {
+	llrbTree := NewLLRBTree(multimapCompare(compare), &multimapLLRBTreeCallbacksStruct{callbacks}).(*llrbTreeStruct)
+
+	multimap = &llrbMultimapStruct{
+		multimapStruct: &multimapStruct{tree: llrbTree},
+		llrbTree:       llrbTree,
+	}
+
+	return
+}
+
+// NewBPlusTreeMultimap is identical to NewBPlusTreeWithOptions() except that duplicate Keys are permitted
+func NewBPlusTreeMultimap(maxKeysPerNode uint64, compare Compare, callbacks BPlusTreeCallbacks, bPlusTreeCache BPlusTreeCache, options *BPlusTreeOptions) (multimap BPlusTreeMultimap, err error) {
+	bPlusTree, err := NewBPlusTreeWithOptions(maxKeysPerNode, multimapCompare(compare), multimapCallbacks(callbacks), bPlusTreeCache, multimapOptions(options))
+	if nil != err {
+		return
+	}
+
+	multimap = &btreeMultimapStruct{
+		multimapStruct: &multimapStruct{tree: bPlusTree},
+		bPlusTree:      bPlusTree.(*btreeTreeStruct),
+	}
+
+	err = nil
+	return
+}
+
+// OldBPlusTreeMultimap is used to re-construct a B+Tree previously persisted via NewBPlusTreeMultimap()
+func OldBPlusTreeMultimap(rootObjectNumber uint64, rootObjectOffset uint64, rootObjectLength uint64, compare Compare, callbacks BPlusTreeCallbacks, bPlusTreeCache BPlusTreeCache, options *BPlusTreeOptions) (multimap BPlusTreeMultimap, err error) {
+	bPlusTree, err := OldBPlusTreeWithOptions(rootObjectNumber, rootObjectOffset, rootObjectLength, multimapCompare(compare), multimapCallbacks(callbacks), bPlusTreeCache, multimapOptions(options))
+	if nil != err {
+		return
+	}
+
+	multimap = &btreeMultimapStruct{
+		multimapStruct: &multimapStruct{tree: bPlusTree},
+		bPlusTree:      bPlusTree.(*btreeTreeStruct),
+	}
+
+	err = nil
+	return
+}
+
+func multimapCompare(compare Compare) (sequencedCompare Compare) {
+	if nil == compare {
+		sequencedCompare = nil // e.g. compare is ignored given an OrderedKeyEncoding
+		return
+	}
+
+	sequencedCompare = func(key1 Key, key2 Key) (result int, err error) {
+		multimapKey1 := key1.(multimapKeyStruct)
+		multimapKey2 := key2.(multimapKeyStruct)
+
+		result, err = compare(multimapKey1.key, multimapKey2.key)
+		if (nil != err) || (0 != result) {
+			return
+		}
+
+		if multimapKey1.sequence < multimapKey2.sequence {
+			result = -1
+		} else if multimapKey1.sequence == multimapKey2.sequence {
+			result = 0
+		} else { // multimapKey1.sequence > multimapKey2.sequence
+			result = 1
+		}
+
+		err = nil
+		return
+	}
+
+	return
+}
+
+func multimapCallbacks(callbacks BPlusTreeCallbacks) (sequencedCallbacks BPlusTreeCallbacks) {
+	if nil == callbacks {
+		sequencedCallbacks = nil // B+Tree will reside only in memory
+		return
+	}
+
+	sequencedCallbacks = Callbacks(&multimapKeyCodecStruct{keyCodec: callbacks}, callbacks, callbacks)
+
+	return
+}
+
+func multimapOptions(options *BPlusTreeOptions) (sequencedOptions *BPlusTreeOptions) {
+	if (nil == options) || ((nil == options.OrderedKeyEncoding) && (nil == options.Aggregator) && (nil == options.Weigher)) {
+		sequencedOptions = options
+		return
+	}
+
+	sequencedOptionsCopy := *options
+	if nil != options.OrderedKeyEncoding {
+		sequencedOptionsCopy.OrderedKeyEncoding = &multimapOrderedKeyEncodingStruct{orderedKeyEncoding: options.OrderedKeyEncoding}
+	}
+	if nil != options.Aggregator {
+		sequencedOptionsCopy.Aggregator = &multimapAggregatorStruct{options.Aggregator}
+	}
+	if nil != options.Weigher {
+		sequencedOptionsCopy.Weigher = multimapWeigher(options.Weigher)
+	}
+
+	sequencedOptions = &sequencedOptionsCopy
+
+	return
+}
+
+func dumpMultimapKey(dumpKey func(key Key) (string, error), key Key) (keyAsString string, err error) {
+	multimapKey := key.(multimapKeyStruct)
+
+	keyAsString, err = dumpKey(multimapKey.key)
+	if nil != err {
+		return
+	}
+
+	keyAsString = fmt.Sprintf("%s #%d", keyAsString, multimapKey.sequence)
+
+	err = nil
+	return
+}
+
+func (callbacks *multimapLLRBTreeCallbacksStruct) DumpKey(key Key) (keyAsString string, err error) {
+	keyAsString, err = dumpMultimapKey(callbacks.LLRBTreeCallbacks.DumpKey, key)
+	return
+}
+
+func (codec *multimapKeyCodecStruct) DumpKey(key Key) (keyAsString string, err error) {
+	keyAsString, err = dumpMultimapKey(codec.keyCodec.DumpKey, key)
+	return
+}
+
+func (codec *multimapKeyCodecStruct) PackKey(key Key) (packedKey []byte, err error) {
+	multimapKey := key.(multimapKeyStruct)
+
+	packedKey, err = codec.keyCodec.PackKey(multimapKey.key)
+	if nil != err {
+		return
+	}
+
+	packedKey = binary.AppendUvarint(packedKey, multimapKey.sequence)
+
+	err = nil
+	return
+}
+
+func (codec *multimapKeyCodecStruct) UnpackKey(payloadData []byte) (key Key, bytesConsumed uint64, err error) {
+	unsequencedKey, bytesConsumed, err := codec.keyCodec.UnpackKey(payloadData)
+	if nil != err {
+		return
+	}
+	if bytesConsumed > uint64(len(payloadData)) {
+		err = fmt.Errorf("UnpackKey() consumed %v bytes but only %v were available", bytesConsumed, len(payloadData))
+		return
+	}
+
+	sequence, sequenceBytesConsumed := binary.Uvarint(payloadData[bytesConsumed:])
+	if 0 >= sequenceBytesConsumed {
+		err = fmt.Errorf("unable to unpack Multimap sequence number")
+		return
+	}
+
+	key = multimapKeyStruct{key: unsequencedKey, sequence: sequence}
+	bytesConsumed += uint64(sequenceBytesConsumed)
+
+	err = nil
+	return
+}
+
+func (encoding *multimapOrderedKeyEncodingStruct) EncodeKey(key Key) (encodedKey []byte, err error) {
+	multimapKey := key.(multimapKeyStruct)
+
+	encodedKey, err = encoding.orderedKeyEncoding.EncodeKey(multimapKey.key)
+	if nil != err {
+		return
+	}
+
+	encodedKey = binary.BigEndian.AppendUint64(encodedKey, multimapKey.sequence)
+
+	err = nil
+	return
+}
+
+func (encoding *multimapOrderedKeyEncodingStruct) DecodeKey(encodedKey []byte) (key Key, bytesConsumed uint64, err error) {
+	unsequencedKey, bytesConsumed, err := encoding.orderedKeyEncoding.DecodeKey(encodedKey)
+	if nil != err {
+		return
+	}
+	if (bytesConsumed + 8) > uint64(len(encodedKey)) {
+		err = fmt.Errorf("unable to decode Multimap sequence number")
+		return
+	}
+
+	key = multimapKeyStruct{key: unsequencedKey, sequence: binary.BigEndian.Uint64(encodedKey[bytesConsumed:])}
+	bytesConsumed += 8
+
+	err = nil
+	return
+}
+
+func (aggregator *multimapAggregatorStruct) Lift(key Key, value Value) (aggregate Aggregate) {
+	aggregate = aggregator.Aggregator.Lift(key.(multimapKeyStruct).key, value)
+	return
+}
+
+func multimapWeigher(weigher Weigher) (sequencedWeigher Weigher) {
+	sequencedWeigher = func(key Key, value Value) (weight uint64) {
+		weight = weigher(key.(multimapKeyStruct).key, value)
+		return
+	}
+
+	return
+}
+
+// run returns the indices of the first & last Key:Value pairs whose Keys match key
+//
+// If there are none, lastIndex+1 == firstIndex == the index at which key would go.
+func (multimap *multimapStruct) run(key Key) (firstIndex int, lastIndex int, err error) {
+	firstIndex, _, err = multimap.tree.BisectRight(multimapKeyStruct{key: key, sequence: multimapSequenceProbeL})
+	if nil != err {
+		return
+	}
+
+	lastIndex, _, err = multimap.tree.BisectLeft(multimapKeyStruct{key: key, sequence: multimapSequenceProbeR})
+
+	return
+}
+
+// API functions (see api.go)
+
+// aggregate returns the Aggregate of Key:Value pairs where loKey <= key < hiKey via aggregateByIndex
+func (multimap *multimapStruct) aggregate(aggregateByIndex func(loIndex int, hiIndex int) (Aggregate, error), loKey Key, hiKey Key) (aggregate Aggregate, err error) {
+	multimap.Lock()
+	defer multimap.Unlock()
+
+	loIndex, _, err := multimap.tree.BisectRight(multimapKeyStruct{key: loKey, sequence: multimapSequenceProbeL})
+	if nil != err {
+		return
+	}
+	hiIndex, _, err := multimap.tree.BisectRight(multimapKeyStruct{key: hiKey, sequence: multimapSequenceProbeL})
+	if nil != err {
+		return
+	}
+
+	aggregate, err = aggregateByIndex(loIndex, hiIndex)
+
+	return
+}
+
+// getByWeight returns the Key:Value pair covering offset via getByWeight
+func (multimap *multimapStruct) getByWeight(getByWeight func(offset uint64) (Key, Value, uint64, bool, error), offset uint64) (key Key, value Value, offsetWithinItem uint64, ok bool, err error) {
+	multimap.Lock()
+	defer multimap.Unlock()
+
+	key, value, offsetWithinItem, ok, err = getByWeight(offset)
+	if (nil == err) && ok {
+		key = key.(multimapKeyStruct).key
+	}
+
+	return
+}
+
+// pop returns (& possibly removes) the Key:Value pair at one end via pop
+func (multimap *multimapStruct) pop(pop func() (Key, Value, bool, error)) (key Key, value Value, ok bool, err error) {
+	multimap.Lock()
+	defer multimap.Unlock()
+
+	key, value, ok, err = pop()
+	if (nil == err) && ok {
+		key = key.(multimapKeyStruct).key
+	}
+
+	return
+}
+
+// popWait removes the Key:Value pair at one end via popWait
+//
+// As popWait may block until a Put(), the multimap's lock is not held.
+func (multimap *multimapStruct) popWait(popWait func(ctx context.Context) (Key, Value, error), ctx context.Context) (key Key, value Value, err error) {
+	key, value, err = popWait(ctx)
+	if nil == err {
+		key = key.(multimapKeyStruct).key
+	}
+
+	return
+}
+
+// weightBefore returns the total weight of Key:Value pairs whose Keys precede key via weightBefore
+func (multimap *multimapStruct) weightBefore(weightBefore func(key Key) (uint64, error), key Key) (weight uint64, err error) {
+	multimap.Lock()
+	defer multimap.Unlock()
+
+	weight, err = weightBefore(multimapKeyStruct{key: key, sequence: multimapSequenceProbeL})
+
+	return
+}
+
+func (multimap *multimapStruct) BisectLeft(key Key) (index int, found bool, err error) {
+	multimap.Lock()
+	defer multimap.Unlock()
+
+	firstIndex, lastIndex, err := multimap.run(key)
+	if nil != err {
+		return
+	}
+
+	found = (firstIndex <= lastIndex)
+
+	if found {
+		index = firstIndex
+	} else {
+		index = lastIndex
+	}
+
+	err = nil
+	return
+}
+
+func (multimap *multimapStruct) BisectRight(key Key) (index int, found bool, err error) {
+	multimap.Lock()
+	defer multimap.Unlock()
+
+	firstIndex, lastIndex, err := multimap.run(key)
+	if nil != err {
+		return
+	}
+
+	found = (firstIndex <= lastIndex)
+
+	if found {
+		index = lastIndex
+	} else {
+		index = firstIndex
+	}
+
+	err = nil
+	return
+}
+
+func (multimap *multimapStruct) DeleteByIndex(index int) (ok bool, err error) {
+	multimap.Lock()
+	defer multimap.Unlock()
+
+	ok, err = multimap.tree.DeleteByIndex(index)
+
+	return
+}
+
+func (multimap *multimapStruct) DeleteByKey(key Key) (ok bool, err error) {
+	multimap.Lock()
+	defer multimap.Unlock()
+
+	firstIndex, lastIndex, err := multimap.run(key)
+	if nil != err {
+		return
+	}
+
+	if firstIndex > lastIndex {
+		ok = false
+		err = nil
+		return
+	}
+
+	ok, err = multimap.tree.DeleteByIndex(firstIndex)
+
+	return
+}
+
+func (multimap *multimapStruct) DeleteAllByKey(key Key) (numberDeleted int, err error) {
+	multimap.Lock()
+	defer multimap.Unlock()
+
+	firstIndex, lastIndex, err := multimap.run(key)
+	if nil != err {
+		return
+	}
+
+	for numberDeleted = 0; numberDeleted <= (lastIndex - firstIndex); numberDeleted++ {
+		_, err = multimap.tree.DeleteByIndex(firstIndex)
+		if nil != err {
+			return
+		}
+	}
+
+	err = nil
+	return
+}
+
+func (multimap *multimapStruct) Dump() (err error) {
+	multimap.Lock()
+	defer multimap.Unlock()
+
+	err = multimap.tree.Dump()
+
+	return
+}
+
+func (multimap *multimapStruct) GetByIndex(index int) (key Key, value Value, ok bool, err error) {
+	multimap.Lock()
+	defer multimap.Unlock()
+
+	key, value, ok, err = multimap.tree.GetByIndex(index)
+	if (nil == err) && ok {
+		key = key.(multimapKeyStruct).key
+	}
+
+	return
+}
+
+func (multimap *multimapStruct) GetByKey(key Key) (value Value, ok bool, err error) {
+	multimap.Lock()
+	defer multimap.Unlock()
+
+	firstIndex, lastIndex, err := multimap.run(key)
+	if nil != err {
+		return
+	}
+
+	if firstIndex > lastIndex {
+		value = nil
+		ok = false
+		err = nil
+		return
+	}
+
+	_, value, ok, err = multimap.tree.GetByIndex(firstIndex)
+
+	return
+}
+
+func (multimap *multimapStruct) Len() (numberOfItems int, err error) {
+	multimap.Lock()
+	defer multimap.Unlock()
+
+	numberOfItems, err = multimap.tree.Len()
+
+	return
+}
+
+func (multimap *multimapStruct) PatchByIndex(index int, value Value) (ok bool, err error) {
+	multimap.Lock()
+	defer multimap.Unlock()
+
+	ok, err = multimap.tree.PatchByIndex(index, value)
+
+	return
+}
+
+func (multimap *multimapStruct) PatchByKey(key Key, value Value) (ok bool, err error) {
+	multimap.Lock()
+	defer multimap.Unlock()
+
+	firstIndex, lastIndex, err := multimap.run(key)
+	if nil != err {
+		return
+	}
+
+	if firstIndex > lastIndex {
+		ok = false
+		err = nil
+		return
+	}
+
+	ok, err = multimap.tree.PatchByIndex(firstIndex, value)
+
+	return
+}
+
+func (multimap *multimapStruct) Put(key Key, value Value) (ok bool, err error) {
+	multimap.Lock()
+	defer multimap.Unlock()
+
+	firstIndex, lastIndex, err := multimap.run(key)
+	if nil != err {
+		return
+	}
+
+	sequence := multimapSequenceFirst
+
+	if firstIndex <= lastIndex {
+		lastKey, _, _, nonShadowingErr := multimap.tree.GetByIndex(lastIndex)
+		if nil != nonShadowingErr {
+			err = nonShadowingErr
+			return
+		}
+
+		sequence = lastKey.(multimapKeyStruct).sequence + 1
+
+		if multimapSequenceProbeR == sequence {
+			err = fmt.Errorf("Multimap sequence numbers exhausted for Key")
+			return
+		}
+	}
+
+	ok, err = multimap.tree.Put(multimapKeyStruct{key: key, sequence: sequence}, value)
+	if (nil == err) && !ok {
+		err = fmt.Errorf("Logic error: Put() of sequenced Key into Multimap failed")
+	}
+
+	return
+}
+
+func (multimap *multimapStruct) Validate() (err error) {
+	multimap.Lock()
+	defer multimap.Unlock()
+
+	err = multimap.tree.Validate()
+
+	return
+}
+
+func (multimap *llrbMultimapStruct) Reset() {
+	multimap.Lock()
+	defer multimap.Unlock()
+
+	multimap.llrbTree.Reset()
+}
+
+func (multimap *llrbMultimapStruct) Aggregate(loKey Key, hiKey Key) (aggregate Aggregate, err error) {
+	aggregate, err = multimap.aggregate(multimap.llrbTree.AggregateByIndex, loKey, hiKey)
+	return
+}
+
+func (multimap *llrbMultimapStruct) AggregateByIndex(loIndex int, hiIndex int) (aggregate Aggregate, err error) {
+	multimap.Lock()
+	defer multimap.Unlock()
+
+	aggregate, err = multimap.llrbTree.AggregateByIndex(loIndex, hiIndex)
+
+	return
+}
+
+func (multimap *llrbMultimapStruct) GetByWeight(offset uint64) (key Key, value Value, offsetWithinItem uint64, ok bool, err error) {
+	key, value, offsetWithinItem, ok, err = multimap.getByWeight(multimap.llrbTree.GetByWeight, offset)
+	return
+}
+
+func (multimap *llrbMultimapStruct) WeightBefore(key Key) (weight uint64, err error) {
+	weight, err = multimap.weightBefore(multimap.llrbTree.WeightBefore, key)
+	return
+}
+
+func (multimap *llrbMultimapStruct) PeekMin() (key Key, value Value, ok bool, err error) {
+	key, value, ok, err = multimap.pop(multimap.llrbTree.PeekMin)
+	return
+}
+
+func (multimap *llrbMultimapStruct) PeekMax() (key Key, value Value, ok bool, err error) {
+	key, value, ok, err = multimap.pop(multimap.llrbTree.PeekMax)
+	return
+}
+
+func (multimap *llrbMultimapStruct) PopMin() (key Key, value Value, ok bool, err error) {
+	key, value, ok, err = multimap.pop(multimap.llrbTree.PopMin)
+	return
+}
+
+func (multimap *llrbMultimapStruct) PopMax() (key Key, value Value, ok bool, err error) {
+	key, value, ok, err = multimap.pop(multimap.llrbTree.PopMax)
+	return
+}
+
+func (multimap *llrbMultimapStruct) PopMinWait(ctx context.Context) (key Key, value Value, err error) {
+	key, value, err = multimap.popWait(multimap.llrbTree.PopMinWait, ctx)
+	return
+}
+
+func (multimap *llrbMultimapStruct) PopMaxWait(ctx context.Context) (key Key, value Value, err error) {
+	key, value, err = multimap.popWait(multimap.llrbTree.PopMaxWait, ctx)
+	return
+}
+
+func (multimap *btreeMultimapStruct) FetchLocation() (rootObjectNumber uint64, rootObjectOffset uint64, rootObjectLength uint64) {
+	rootObjectNumber, rootObjectOffset, rootObjectLength = multimap.bPlusTree.FetchLocation()
+	return
+}
+
+func (multimap *btreeMultimapStruct) FetchLayoutReport() (layoutReport LayoutReport, err error) {
+	layoutReport, err = multimap.bPlusTree.FetchLayoutReport()
+	return
+}
+
+func (multimap *btreeMultimapStruct) FetchDimensionsReport() (dimensionsReport DimensionsReport, err error) {
+	dimensionsReport, err = multimap.bPlusTree.FetchDimensionsReport()
+	return
+}
+
+func (multimap *btreeMultimapStruct) Flush(andPurge bool) (rootObjectNumber uint64, rootObjectOffset uint64, rootObjectLength uint64, err error) {
+	rootObjectNumber, rootObjectOffset, rootObjectLength, err = multimap.bPlusTree.Flush(andPurge)
+	return
+}
+
+func (multimap *btreeMultimapStruct) Purge(full bool) (err error) {
+	err = multimap.bPlusTree.Purge(full)
+	return
+}
+
+func (multimap *btreeMultimapStruct) Touch() (err error) {
+	err = multimap.bPlusTree.Touch()
+	return
+}
+
+func (multimap *btreeMultimapStruct) TouchItem(thisItemIndexToTouch uint64) (nextItemIndexToTouch uint64, err error) {
+	nextItemIndexToTouch, err = multimap.bPlusTree.TouchItem(thisItemIndexToTouch)
+	return
+}
+
+func (multimap *btreeMultimapStruct) Prune() (err error) {
+	err = multimap.bPlusTree.Prune()
+	return
+}
+
+func (multimap *btreeMultimapStruct) Discard() (err error) {
+	multimap.Lock()
+	defer multimap.Unlock()
+
+	err = multimap.bPlusTree.Discard()
+
+	return
+}
+
+func (multimap *btreeMultimapStruct) Aggregate(loKey Key, hiKey Key) (aggregate Aggregate, err error) {
+	aggregate, err = multimap.aggregate(multimap.bPlusTree.AggregateByIndex, loKey, hiKey)
+	return
+}
+
+func (multimap *btreeMultimapStruct) AggregateByIndex(loIndex int, hiIndex int) (aggregate Aggregate, err error) {
+	multimap.Lock()
+	defer multimap.Unlock()
+
+	aggregate, err = multimap.bPlusTree.AggregateByIndex(loIndex, hiIndex)
+
+	return
+}
+
+func (multimap *btreeMultimapStruct) GetByWeight(offset uint64) (key Key, value Value, offsetWithinItem uint64, ok bool, err error) {
+	key, value, offsetWithinItem, ok, err = multimap.getByWeight(multimap.bPlusTree.GetByWeight, offset)
+	return
+}
+
+func (multimap *btreeMultimapStruct) WeightBefore(key Key) (weight uint64, err error) {
+	weight, err = multimap.weightBefore(multimap.bPlusTree.WeightBefore, key)
+	return
+}
+
+func (multimap *btreeMultimapStruct) PeekMin() (key Key, value Value, ok bool, err error) {
+	key, value, ok, err = multimap.pop(multimap.bPlusTree.PeekMin)
+	return
+}
+
+func (multimap *btreeMultimapStruct) PeekMax() (key Key, value Value, ok bool, err error) {
+	key, value, ok, err = multimap.pop(multimap.bPlusTree.PeekMax)
+	return
+}
+
+func (multimap *btreeMultimapStruct) PopMin() (key Key, value Value, ok bool, err error) {
+	key, value, ok, err = multimap.pop(multimap.bPlusTree.PopMin)
+	return
+}
+
+func (multimap *btreeMultimapStruct) PopMax() (key Key, value Value, ok bool, err error) {
+	key, value, ok, err = multimap.pop(multimap.bPlusTree.PopMax)
+	return
+}
+
+func (multimap *btreeMultimapStruct) PopMinWait(ctx context.Context) (key Key, value Value, err error) {
+	key, value, err = multimap.popWait(multimap.bPlusTree.PopMinWait, ctx)
+	return
+}
+
+func (multimap *btreeMultimapStruct) PopMaxWait(ctx context.Context) (key Key, value Value, err error) {
+	key, value, err = multimap.popWait(multimap.bPlusTree.PopMaxWait, ctx)
+	return
+}
+
+func (multimap *btreeMultimapStruct) CacheStats() (treeStats *BPlusTreeCacheTreeStats) {
+	treeStats = multimap.bPlusTree.CacheStats()
+	return
+}
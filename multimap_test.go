@@ -0,0 +1,224 @@
+// Copyright (c) 2015-2021, NVIDIA CORPORATION.
+// SPDX-License-Identifier: Apache-2.0
+
+package sortedmap
+
+import (
+	"testing"
+)
+
+// multimapTestPopulate puts Keys 0, 10, 20, & 30 with Key 20 put three times (Values 201, 202, & 203)
+func multimapTestPopulate(t *testing.T, multimap Multimap) {
+	for _, keyValue := range [][2]int{{20, 201}, {10, 100}, {20, 202}, {30, 300}, {0, 0}, {20, 203}} {
+		ok, err := multimap.Put(keyValue[0], keyValue[1])
+		if nil != err {
+			t.Fatalf("multimap.Put(%v,) failed: %v", keyValue[0], err)
+		}
+		if !ok {
+			t.Fatalf("multimap.Put(%v,).ok should have been true", keyValue[0])
+		}
+	}
+}
+
+// multimapTestVerify checks a Multimap populated by multimapTestPopulate()
+func multimapTestVerify(t *testing.T, multimap Multimap) {
+	expectedKeyValues := [][2]int{{0, 0}, {10, 100}, {20, 201}, {20, 202}, {20, 203}, {30, 300}}
+
+	numberOfItems, err := multimap.Len()
+	if nil != err {
+		t.Fatalf("multimap.Len() failed: %v", err)
+	}
+	if len(expectedKeyValues) != numberOfItems {
+		t.Fatalf("multimap.Len() returned %v but should have returned %v", numberOfItems, len(expectedKeyValues))
+	}
+
+	for index, expectedKeyValue := range expectedKeyValues {
+		keyAsKey, valueAsValue, ok, err := multimap.GetByIndex(index)
+		if nil != err {
+			t.Fatalf("multimap.GetByIndex(%v) failed: %v", index, err)
+		}
+		if !ok || (expectedKeyValue[0] != keyAsKey.(int)) || (expectedKeyValue[1] != valueAsValue.(int)) {
+			t.Fatalf("multimap.GetByIndex(%v) should have returned %v:%v", index, expectedKeyValue[0], expectedKeyValue[1])
+		}
+	}
+
+	bisectTestCases := []struct {
+		key                int
+		expectedLeftIndex  int
+		expectedRightIndex int
+		expectedFound      bool
+	}{
+		{-5, -1, 0, false},
+		{0, 0, 0, true},
+		{15, 1, 2, false},
+		{20, 2, 4, true},
+		{25, 4, 5, false},
+		{35, 5, 6, false},
+	}
+
+	for _, bisectTestCase := range bisectTestCases {
+		index, found, err := multimap.BisectLeft(bisectTestCase.key)
+		if nil != err {
+			t.Fatalf("multimap.BisectLeft(%v) failed: %v", bisectTestCase.key, err)
+		}
+		if (bisectTestCase.expectedLeftIndex != index) || (bisectTestCase.expectedFound != found) {
+			t.Fatalf("multimap.BisectLeft(%v) returned %v,%v but should have returned %v,%v", bisectTestCase.key, index, found, bisectTestCase.expectedLeftIndex, bisectTestCase.expectedFound)
+		}
+
+		index, found, err = multimap.BisectRight(bisectTestCase.key)
+		if nil != err {
+			t.Fatalf("multimap.BisectRight(%v) failed: %v", bisectTestCase.key, err)
+		}
+		if (bisectTestCase.expectedRightIndex != index) || (bisectTestCase.expectedFound != found) {
+			t.Fatalf("multimap.BisectRight(%v) returned %v,%v but should have returned %v,%v", bisectTestCase.key, index, found, bisectTestCase.expectedRightIndex, bisectTestCase.expectedFound)
+		}
+	}
+
+	valueAsValue, ok, err := multimap.GetByKey(20)
+	if nil != err {
+		t.Fatalf("multimap.GetByKey(20) failed: %v", err)
+	}
+	if !ok || (201 != valueAsValue.(int)) {
+		t.Fatalf("multimap.GetByKey(20) should have returned the first match")
+	}
+
+	_, ok, err = multimap.GetByKey(15)
+	if nil != err {
+		t.Fatalf("multimap.GetByKey(15) failed: %v", err)
+	}
+	if ok {
+		t.Fatalf("multimap.GetByKey(15).ok should have been false")
+	}
+}
+
+// multimapTestMutate deletes & patches a Multimap verified by multimapTestVerify()
+func multimapTestMutate(t *testing.T, multimap Multimap) {
+	ok, err := multimap.DeleteByKey(20)
+	if nil != err {
+		t.Fatalf("multimap.DeleteByKey(20) failed: %v", err)
+	}
+	if !ok {
+		t.Fatalf("multimap.DeleteByKey(20).ok should have been true")
+	}
+
+	ok, err = multimap.PatchByKey(20, 2020)
+	if nil != err {
+		t.Fatalf("multimap.PatchByKey(20,) failed: %v", err)
+	}
+	if !ok {
+		t.Fatalf("multimap.PatchByKey(20,).ok should have been true")
+	}
+
+	// A further duplicate is appended after the survivors
+
+	ok, err = multimap.Put(20, 204)
+	if (nil != err) || !ok {
+		t.Fatalf("multimap.Put(20,) failed: %v", err)
+	}
+
+	for index, expectedValue := range []int{2020, 203, 204} {
+		_, valueAsValue, ok, err := multimap.GetByIndex(2 + index)
+		if (nil != err) || !ok {
+			t.Fatalf("multimap.GetByIndex(%v) failed: %v", 2+index, err)
+		}
+		if expectedValue != valueAsValue.(int) {
+			t.Fatalf("multimap.GetByIndex(%v) returned %v but should have returned %v", 2+index, valueAsValue.(int), expectedValue)
+		}
+	}
+
+	numberDeleted, err := multimap.DeleteAllByKey(20)
+	if nil != err {
+		t.Fatalf("multimap.DeleteAllByKey(20) failed: %v", err)
+	}
+	if 3 != numberDeleted {
+		t.Fatalf("multimap.DeleteAllByKey(20) returned %v but should have returned 3", numberDeleted)
+	}
+
+	numberDeleted, err = multimap.DeleteAllByKey(20)
+	if nil != err {
+		t.Fatalf("multimap.DeleteAllByKey(20) failed: %v", err)
+	}
+	if 0 != numberDeleted {
+		t.Fatalf("multimap.DeleteAllByKey(20) of absent Key returned %v but should have returned 0", numberDeleted)
+	}
+
+	numberOfItems, err := multimap.Len()
+	if (nil != err) || (3 != numberOfItems) {
+		t.Fatalf("multimap.Len() should have returned 3")
+	}
+
+	err = multimap.Validate()
+	if nil != err {
+		t.Fatalf("multimap.Validate() failed: %v", err)
+	}
+}
+
+func TestLLRBMultimap(t *testing.T) {
+	multimap := NewLLRBMultimap(CompareInt, CodecInt)
+
+	multimapTestPopulate(t, multimap)
+	multimapTestVerify(t, multimap)
+	multimapTestMutate(t, multimap)
+
+	multimap.Reset()
+
+	numberOfItems, err := multimap.Len()
+	if (nil != err) || (0 != numberOfItems) {
+		t.Fatalf("multimap.Len() after multimap.Reset() should have returned 0")
+	}
+}
+
+func TestBPlusTreeMultimap(t *testing.T) {
+	optionsList := []*BPlusTreeOptions{
+		nil,
+		{KeyPrefixCompression: true, VarintEncoding: true},
+		{OrderedKeyEncoding: OrderedInt, KeyPrefixCompression: true},
+	}
+
+	for _, options := range optionsList {
+		nodeStore := &callbacksTestNodeStoreStruct{lastObjectNumberGenerated: 0, objectMap: make(map[uint64][]byte)}
+		callbacks := Callbacks(CodecInt, CodecInt, nodeStore)
+
+		multimap, err := NewBPlusTreeMultimap(4, CompareInt, callbacks, nil, options)
+		if nil != err {
+			t.Fatalf("NewBPlusTreeMultimap() failed: %v", err)
+		}
+
+		multimapTestPopulate(t, multimap)
+
+		// Enough further duplicates of Key 40 to span several nodes
+
+		for value := 0; value < 20; value++ {
+			ok, err := multimap.Put(40, value)
+			if (nil != err) || !ok {
+				t.Fatalf("multimap.Put(40,) failed: %v", err)
+			}
+		}
+
+		rootObjectNumber, rootObjectOffset, rootObjectLength, err := multimap.Flush(false)
+		if nil != err {
+			t.Fatalf("multimap.Flush(false) failed: %v", err)
+		}
+
+		multimap, err = OldBPlusTreeMultimap(rootObjectNumber, rootObjectOffset, rootObjectLength, CompareInt, callbacks, nil, options)
+		if nil != err {
+			t.Fatalf("OldBPlusTreeMultimap() failed: %v", err)
+		}
+
+		err = multimap.Validate()
+		if nil != err {
+			t.Fatalf("multimap.Validate() failed: %v", err)
+		}
+
+		numberDeleted, err := multimap.DeleteAllByKey(40)
+		if nil != err {
+			t.Fatalf("multimap.DeleteAllByKey(40) failed: %v", err)
+		}
+		if 20 != numberDeleted {
+			t.Fatalf("multimap.DeleteAllByKey(40) returned %v but should have returned 20", numberDeleted)
+		}
+
+		multimapTestVerify(t, multimap)
+		multimapTestMutate(t, multimap)
+	}
+}
@@ -0,0 +1,311 @@
+// Copyright (c) 2015-2021, NVIDIA CORPORATION.
+// SPDX-License-Identifier: Apache-2.0
+
+package sortedmap
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// Order-preserving Key encodings
+//
+// An OrderedKeyEncoding maps Keys to []byte slices such that bytes.Compare() of two encoded
+// Keys yields the same result as the correspondingly named Compare func applied to the Keys
+// themselves. Each encoded Key is also self-delimiting, so that encoded Keys may simply be
+// concatenated (see OrderedTuple()). The built-in encodings are:
+//
+//	OrderedInt                  8-byte big-endian with the sign bit flipped
+//	OrderedUint16/32/64         2/4/8-byte big-endian
+//	OrderedString/ByteSlice     bytes with each 0x00 escaped as 0x00 0xFF, terminated by 0x00 0x01
+//	OrderedTime                 OrderedInt of Unix() seconds followed by 4-byte big-endian nanoseconds
+//	OrderedTuple(...)           concatenation of each field's encoding
+//
+// Note that OrderedTime does not preserve a time.Time's Location (decoded values are UTC).
+
+// OrderedKeyEncoding specifies an order-preserving encoding of Keys
+type OrderedKeyEncoding interface {
+	EncodeKey(key Key) (encodedKey []byte, err error)
+	DecodeKey(encodedKey []byte) (key Key, bytesConsumed uint64, err error)
+}
+
+const (
+	orderedEscape     = uint8(0x00)
+	orderedEscaped    = uint8(0xFF) // follows orderedEscape to represent an 0x00 byte
+	orderedTerminator = uint8(0x01) // follows orderedEscape to terminate the encoding
+)
+
+var (
+	OrderedByteSlice OrderedKeyEncoding = &orderedKeyEncodingStruct{name: "OrderedByteSlice", encode: encodeOrderedByteSlice, decode: decodeOrderedByteSlice}
+	OrderedInt       OrderedKeyEncoding = &orderedKeyEncodingStruct{name: "OrderedInt", encode: encodeOrderedInt, decode: decodeOrderedInt}
+	OrderedString    OrderedKeyEncoding = &orderedKeyEncodingStruct{name: "OrderedString", encode: encodeOrderedString, decode: decodeOrderedString}
+	OrderedTime      OrderedKeyEncoding = &orderedKeyEncodingStruct{name: "OrderedTime", encode: encodeOrderedTime, decode: decodeOrderedTime}
+	OrderedUint16    OrderedKeyEncoding = &orderedKeyEncodingStruct{name: "OrderedUint16", encode: encodeOrderedUint16, decode: decodeOrderedUint16}
+	OrderedUint32    OrderedKeyEncoding = &orderedKeyEncodingStruct{name: "OrderedUint32", encode: encodeOrderedUint32, decode: decodeOrderedUint32}
+	OrderedUint64    OrderedKeyEncoding = &orderedKeyEncodingStruct{name: "OrderedUint64", encode: encodeOrderedUint64, decode: decodeOrderedUint64}
+)
+
+// OrderedTuple returns an OrderedKeyEncoding of Tuples whose fields are encoded by fieldEncodings
+//
+// Tuples are ordered by their first field, then their second, and so on (i.e. as if compared
+// by CompareTuple() of each field's ascending Compare func). Only complete Tuples (i.e. not
+// those used solely in prefix queries) may be encoded.
+func OrderedTuple(fieldEncodings ...OrderedKeyEncoding) (tupleEncoding OrderedKeyEncoding) {
+	tupleEncoding = &orderedTupleEncodingStruct{fieldEncodings: fieldEncodings}
+	return
+}
+
+type orderedKeyEncodingStruct struct {
+	name   string
+	encode func(encodedKey []byte, key Key) (updatedEncodedKey []byte, ok bool)
+	decode func(encodedKey []byte) (key Key, bytesConsumed uint64, ok bool)
+}
+
+func (encoding *orderedKeyEncodingStruct) EncodeKey(key Key) (encodedKey []byte, err error) {
+	encodedKey, ok := encoding.encode(nil, key)
+	if !ok {
+		err = fmt.Errorf("%s.EncodeKey() passed unsupported type %T", encoding.name, key)
+		return
+	}
+
+	err = nil
+	return
+}
+
+func (encoding *orderedKeyEncodingStruct) DecodeKey(encodedKey []byte) (key Key, bytesConsumed uint64, err error) {
+	key, bytesConsumed, ok := encoding.decode(encodedKey)
+	if !ok {
+		err = fmt.Errorf("%s.DecodeKey() passed malformed encodedKey", encoding.name)
+		return
+	}
+
+	err = nil
+	return
+}
+
+type orderedTupleEncodingStruct struct {
+	fieldEncodings []OrderedKeyEncoding
+}
+
+func (encoding *orderedTupleEncodingStruct) EncodeKey(key Key) (encodedKey []byte, err error) {
+	fields, ok := key.(Tuple)
+	if !ok {
+		err = fmt.Errorf("OrderedTuple().EncodeKey() passed unsupported type %T", key)
+		return
+	}
+	if len(fields) != len(encoding.fieldEncodings) {
+		err = fmt.Errorf("OrderedTuple().EncodeKey() passed %v fields but expected %v", len(fields), len(encoding.fieldEncodings))
+		return
+	}
+
+	encodedKey = []byte{}
+
+	for i, field := range fields {
+		encodedField, encodeErr := encoding.fieldEncodings[i].EncodeKey(field)
+		if nil != encodeErr {
+			err = encodeErr
+			return
+		}
+
+		encodedKey = append(encodedKey, encodedField...)
+	}
+
+	err = nil
+	return
+}
+
+func (encoding *orderedTupleEncodingStruct) DecodeKey(encodedKey []byte) (key Key, bytesConsumed uint64, err error) {
+	fields := make(Tuple, len(encoding.fieldEncodings))
+
+	for i, fieldEncoding := range encoding.fieldEncodings {
+		field, fieldBytesConsumed, decodeErr := fieldEncoding.DecodeKey(encodedKey[bytesConsumed:])
+		if nil != decodeErr {
+			err = decodeErr
+			return
+		}
+
+		fields[i] = field
+		bytesConsumed += fieldBytesConsumed
+	}
+
+	key = fields
+
+	err = nil
+	return
+}
+
+func encodeOrderedInt(encodedKey []byte, key Key) (updatedEncodedKey []byte, ok bool) {
+	keyInt, ok := key.(int)
+	if ok {
+		updatedEncodedKey = binary.BigEndian.AppendUint64(encodedKey, uint64(int64(keyInt))^(1<<63))
+	}
+	return
+}
+
+func decodeOrderedInt(encodedKey []byte) (key Key, bytesConsumed uint64, ok bool) {
+	if 8 > len(encodedKey) {
+		ok = false
+		return
+	}
+	key = int(int64(binary.BigEndian.Uint64(encodedKey) ^ (1 << 63)))
+	bytesConsumed = 8
+	ok = true
+	return
+}
+
+func encodeOrderedUint16(encodedKey []byte, key Key) (updatedEncodedKey []byte, ok bool) {
+	keyUint16, ok := key.(uint16)
+	if ok {
+		updatedEncodedKey = binary.BigEndian.AppendUint16(encodedKey, keyUint16)
+	}
+	return
+}
+
+func decodeOrderedUint16(encodedKey []byte) (key Key, bytesConsumed uint64, ok bool) {
+	if 2 > len(encodedKey) {
+		ok = false
+		return
+	}
+	key = binary.BigEndian.Uint16(encodedKey)
+	bytesConsumed = 2
+	ok = true
+	return
+}
+
+func encodeOrderedUint32(encodedKey []byte, key Key) (updatedEncodedKey []byte, ok bool) {
+	keyUint32, ok := key.(uint32)
+	if ok {
+		updatedEncodedKey = binary.BigEndian.AppendUint32(encodedKey, keyUint32)
+	}
+	return
+}
+
+func decodeOrderedUint32(encodedKey []byte) (key Key, bytesConsumed uint64, ok bool) {
+	if 4 > len(encodedKey) {
+		ok = false
+		return
+	}
+	key = binary.BigEndian.Uint32(encodedKey)
+	bytesConsumed = 4
+	ok = true
+	return
+}
+
+func encodeOrderedUint64(encodedKey []byte, key Key) (updatedEncodedKey []byte, ok bool) {
+	keyUint64, ok := key.(uint64)
+	if ok {
+		updatedEncodedKey = binary.BigEndian.AppendUint64(encodedKey, keyUint64)
+	}
+	return
+}
+
+func decodeOrderedUint64(encodedKey []byte) (key Key, bytesConsumed uint64, ok bool) {
+	if 8 > len(encodedKey) {
+		ok = false
+		return
+	}
+	key = binary.BigEndian.Uint64(encodedKey)
+	bytesConsumed = 8
+	ok = true
+	return
+}
+
+// appendOrderedBytes appends keyBytes escaped & terminated to encodedKey
+func appendOrderedBytes(encodedKey []byte, keyBytes []byte) (updatedEncodedKey []byte) {
+	updatedEncodedKey = encodedKey
+
+	for _, keyByte := range keyBytes {
+		if orderedEscape == keyByte {
+			updatedEncodedKey = append(updatedEncodedKey, orderedEscape, orderedEscaped)
+		} else {
+			updatedEncodedKey = append(updatedEncodedKey, keyByte)
+		}
+	}
+
+	updatedEncodedKey = append(updatedEncodedKey, orderedEscape, orderedTerminator)
+
+	return
+}
+
+// consumeOrderedBytes returns the unescaped bytes preceding the terminator in encodedKey
+func consumeOrderedBytes(encodedKey []byte) (keyBytes []byte, bytesConsumed uint64, ok bool) {
+	keyBytes = []byte{}
+
+	for i := 0; i < len(encodedKey); i++ {
+		if orderedEscape != encodedKey[i] {
+			keyBytes = append(keyBytes, encodedKey[i])
+			continue
+		}
+
+		i++
+
+		if i == len(encodedKey) {
+			break
+		}
+
+		switch encodedKey[i] {
+		case orderedEscaped:
+			keyBytes = append(keyBytes, orderedEscape)
+		case orderedTerminator:
+			bytesConsumed = uint64(i + 1)
+			ok = true
+			return
+		default:
+			ok = false
+			return
+		}
+	}
+
+	ok = false // terminator not found
+	return
+}
+
+func encodeOrderedString(encodedKey []byte, key Key) (updatedEncodedKey []byte, ok bool) {
+	keyString, ok := key.(string)
+	if ok {
+		updatedEncodedKey = appendOrderedBytes(encodedKey, []byte(keyString))
+	}
+	return
+}
+
+func decodeOrderedString(encodedKey []byte) (key Key, bytesConsumed uint64, ok bool) {
+	keyBytes, bytesConsumed, ok := consumeOrderedBytes(encodedKey)
+	if ok {
+		key = string(keyBytes)
+	}
+	return
+}
+
+func encodeOrderedByteSlice(encodedKey []byte, key Key) (updatedEncodedKey []byte, ok bool) {
+	keyByteSlice, ok := key.([]byte)
+	if ok {
+		updatedEncodedKey = appendOrderedBytes(encodedKey, keyByteSlice)
+	}
+	return
+}
+
+func decodeOrderedByteSlice(encodedKey []byte) (key Key, bytesConsumed uint64, ok bool) {
+	key, bytesConsumed, ok = consumeOrderedBytes(encodedKey)
+	return
+}
+
+func encodeOrderedTime(encodedKey []byte, key Key) (updatedEncodedKey []byte, ok bool) {
+	keyTime, ok := key.(time.Time)
+	if ok {
+		updatedEncodedKey = binary.BigEndian.AppendUint64(encodedKey, uint64(keyTime.Unix())^(1<<63))
+		updatedEncodedKey = binary.BigEndian.AppendUint32(updatedEncodedKey, uint32(keyTime.Nanosecond()))
+	}
+	return
+}
+
+func decodeOrderedTime(encodedKey []byte) (key Key, bytesConsumed uint64, ok bool) {
+	if 12 > len(encodedKey) {
+		ok = false
+		return
+	}
+	key = time.Unix(int64(binary.BigEndian.Uint64(encodedKey)^(1<<63)), int64(binary.BigEndian.Uint32(encodedKey[8:]))).UTC()
+	bytesConsumed = 12
+	ok = true
+	return
+}
@@ -0,0 +1,78 @@
+// Copyright (c) 2015-2021, NVIDIA CORPORATION.
+// SPDX-License-Identifier: Apache-2.0
+
+package sortedmap
+
+import (
+	"context"
+	"sync"
+)
+
+// Priority queues
+//
+// Both LLRBTree and BPlusTree may be used as a (double-ended) priority queue ordered by Key.
+// PeekMin() & PeekMax() return the Key:Value pair with the smallest & largest Key, while
+// PopMin() & PopMax() also remove it. Each does so while holding the tree's lock just once,
+// so that concurrent consumers never observe (let alone remove) the same Key:Value pair.
+//
+// PopMinWait() & PopMaxWait() block until the tree holds a Key:Value pair to remove or ctx is
+// done (in which case ctx.Err() is returned). Waiters are woken by each successful Put().
+
+// PriorityQueue is implemented by each SortedMap usable as a (double-ended) priority queue
+type PriorityQueue interface {
+	SortedMap
+	PeekMin() (key Key, value Value, ok bool, err error)              // Returns the key:value pair with the smallest key
+	PeekMax() (key Key, value Value, ok bool, err error)              // Returns the key:value pair with the largest key
+	PopMin() (key Key, value Value, ok bool, err error)               // Removes & returns the key:value pair with the smallest key
+	PopMax() (key Key, value Value, ok bool, err error)               // Removes & returns the key:value pair with the largest key
+	PopMinWait(ctx context.Context) (key Key, value Value, err error) // Like PopMin() but waits until a key:value pair is available or ctx is done
+	PopMaxWait(ctx context.Context) (key Key, value Value, err error) // Like PopMax() but waits until a key:value pair is available or ctx is done
+}
+
+// itemWaiterStruct is embedded in a tree to let Pop*Wait() wait for a Put()
+//
+// All methods must be called while holding the tree's lock (i.e. locker).
+type itemWaiterStruct struct {
+	itemAvailable *sync.Cond // created by the first wait()
+}
+
+// signal wakes any goroutines blocked in wait()
+func (waiter *itemWaiterStruct) signal() {
+	if nil != waiter.itemAvailable {
+		waiter.itemAvailable.Broadcast()
+	}
+}
+
+// wait calls pop until it returns a Key:Value pair, fails, or ctx is done
+func (waiter *itemWaiterStruct) wait(ctx context.Context, locker sync.Locker, pop func() (Key, Value, bool, error)) (key Key, value Value, err error) {
+	if nil == waiter.itemAvailable {
+		waiter.itemAvailable = sync.NewCond(locker)
+	}
+
+	itemAvailable := waiter.itemAvailable
+
+	stop := context.AfterFunc(ctx, func() {
+		locker.Lock()
+		itemAvailable.Broadcast()
+		locker.Unlock()
+	})
+	defer stop()
+
+	for {
+		var (
+			ok bool
+		)
+
+		key, value, ok, err = pop()
+		if (nil != err) || ok {
+			return
+		}
+
+		err = ctx.Err()
+		if nil != err {
+			return
+		}
+
+		itemAvailable.Wait()
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright (c) 2015-2021, NVIDIA CORPORATION.
+// SPDX-License-Identifier: Apache-2.0
+
+package sortedmap
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+)
+
+func priorityQueueTestRun(t *testing.T, tree PriorityQueue) {
+	for _, peekOrPop := range []func() (Key, Value, bool, error){tree.PeekMin, tree.PeekMax, tree.PopMin, tree.PopMax} {
+		_, _, ok, err := peekOrPop()
+		if (nil != err) || ok {
+			t.Fatalf("Peek*()/Pop*() of empty tree should have returned ok == false")
+		}
+	}
+
+	// Alternately popping from either end returns Keys in order from both ends
+
+	for _, key := range rand.Perm(200) {
+		_, err := tree.Put(key, key*10)
+		if nil != err {
+			t.Fatalf("tree.Put(%v,) failed: %v", key, err)
+		}
+	}
+
+	key, value, ok, err := tree.PeekMin()
+	if (nil != err) || !ok || (0 != key.(int)) || (0 != value.(int)) {
+		t.Fatalf("tree.PeekMin() returned %v:%v but should have returned 0:0", key, value)
+	}
+	key, value, ok, err = tree.PeekMax()
+	if (nil != err) || !ok || (199 != key.(int)) || (1990 != value.(int)) {
+		t.Fatalf("tree.PeekMax() returned %v:%v but should have returned 199:1990", key, value)
+	}
+
+	for i := 0; i < 100; i++ {
+		key, value, ok, err = tree.PopMin()
+		if (nil != err) || !ok || (i != key.(int)) || ((i * 10) != value.(int)) {
+			t.Fatalf("tree.PopMin() returned %v:%v but should have returned %v:%v", key, value, i, i*10)
+		}
+		key, _, ok, err = tree.PopMax()
+		if (nil != err) || !ok || ((199 - i) != key.(int)) {
+			t.Fatalf("tree.PopMax() returned %v but should have returned %v", key, 199-i)
+		}
+
+		if 0 == i%10 {
+			err = tree.Validate()
+			if nil != err {
+				t.Fatalf("tree.Validate() failed: %v", err)
+			}
+		}
+	}
+
+	numberOfItems, err := tree.Len()
+	if (nil != err) || (0 != numberOfItems) {
+		t.Fatalf("tree.Len() returned %v but should have returned 0", numberOfItems)
+	}
+
+	// A waiter gives up once its context is done
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	_, _, err = tree.PopMaxWait(ctx)
+	cancel()
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("tree.PopMaxWait() of empty tree returned %v but should have returned context.DeadlineExceeded", err)
+	}
+
+	// Concurrent waiters each receive distinct Keys as they are Put()
+
+	var (
+		consumers sync.WaitGroup
+		received  sync.Map
+	)
+
+	for consumer := 0; consumer < 4; consumer++ {
+		consumers.Add(1)
+		go func() {
+			defer consumers.Done()
+			for i := 0; i < 25; i++ {
+				key, _, err := tree.PopMinWait(context.Background())
+				if nil != err {
+					t.Errorf("tree.PopMinWait() failed: %v", err)
+					return
+				}
+				_, loaded := received.LoadOrStore(key.(int), struct{}{})
+				if loaded {
+					t.Errorf("tree.PopMinWait() returned Key %v more than once", key)
+				}
+			}
+		}()
+	}
+
+	for key := 0; key < 100; key++ {
+		_, err = tree.Put(key, key*10)
+		if nil != err {
+			t.Fatalf("tree.Put(%v,) failed: %v", key, err)
+		}
+	}
+
+	consumers.Wait()
+
+	for key := 0; key < 100; key++ {
+		_, ok = received.Load(key)
+		if !ok {
+			t.Fatalf("tree.PopMinWait() never returned Key %v", key)
+		}
+	}
+}
+
+func TestPriorityQueue(t *testing.T) {
+	rand.Seed(7)
+
+	priorityQueueTestRun(t, NewLLRBTree(CompareInt, nil))
+	priorityQueueTestRun(t, NewLLRBMultimap(CompareInt, nil))
+
+	nodeStore := &callbacksTestNodeStoreStruct{lastObjectNumberGenerated: 0, objectMap: make(map[uint64][]byte)}
+	callbacks := Callbacks(CodecInt, CodecInt, nodeStore)
+
+	priorityQueueTestRun(t, NewBPlusTree(4, CompareInt, callbacks, nil))
+
+	multimap, err := NewBPlusTreeMultimap(4, CompareInt, callbacks, nil, nil)
+	if nil != err {
+		t.Fatalf("NewBPlusTreeMultimap() failed: %v", err)
+	}
+
+	priorityQueueTestRun(t, multimap)
+}
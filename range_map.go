@@ -0,0 +1,473 @@
+// Copyright (c) 2015-2021, NVIDIA CORPORATION.
+// SPDX-License-Identifier: Apache-2.0
+
+package sortedmap
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Range maps
+//
+// A RangeMap maps non-overlapping, half-open ranges [Start, End) of Keys to Values (e.g. the
+// file offsets covered by an extent to the physical location holding them). It wraps a
+// SortedMap (either an LLRBTree or a BPlusTree) holding, for each range, the Key Start and
+// the Value Tuple{End, Value}. For a BPlusTree, a ValueCodec such as TupleCodec(endCodec,
+// valueCodec) is thus required. The SortedMap must not be mutated other than via the RangeMap.
+//
+// Set() replaces whatever was mapped within [start, end), trimming ranges overlapping either
+// end and splitting any range spanning all of [start, end) in two. Clear() does the same but
+// leaves [start, end) unmapped. Whenever the Start of a range's remainder is advanced, its
+// Value is passed through the RangeTrimmer (if any) so that, e.g., an extent's physical
+// location may be advanced by the same amount. Having done so, Set() offers each range it
+// leaves abutting the newly set range to the RangeCoalescer (if any), merging those it accepts.
+//
+// Each operation is performed while holding a single lock and makes no changes to the
+// SortedMap until every RangeTrimmer & RangeCoalescer call it requires has succeeded.
+
+// Range is the half-open range [Start, End) mapped to Value by a RangeMap
+type Range struct {
+	Start Key
+	End   Key
+	Value Value
+}
+
+// RangeTrimmer returns the Value of what remains of a range [start, ...) mapped to value once its start is advanced to newStart
+type RangeTrimmer func(start Key, value Value, newStart Key) (trimmedValue Value, err error)
+
+// RangeCoalescer returns (if coalesce is true) the Value of the range merging abutting ranges preceding & following
+type RangeCoalescer func(preceding Range, following Range) (coalescedValue Value, coalesce bool, err error)
+
+// RangeMap maps non-overlapping ranges of Keys to Values
+//
+// Get() returns the range covering point. Range() returns, in order, the ranges overlapping
+// [start, end) clipped (via the RangeTrimmer if necessary) to [start, end).
+type RangeMap interface {
+	Set(start Key, end Key, value Value) (err error)
+	Clear(start Key, end Key) (err error)
+	Get(point Key) (rangeCoveringPoint Range, ok bool, err error)
+	Range(start Key, end Key) (ranges []Range, err error)
+	Len() (numberOfRanges int, err error)
+}
+
+type rangeMapStruct struct {
+	sync.Mutex
+	sortedMap SortedMap // Key == Range.Start, Value == Tuple{Range.End, Range.Value}
+	compare   Compare
+	trimmer   RangeTrimmer   // if nil, Values are unchanged when trimmed
+	coalescer RangeCoalescer // if nil, ranges are never coalesced
+}
+
+// NewRangeMap returns a RangeMap holding its ranges in (initially empty) sortedMap
+//
+// The supplied compare must be the Compare func used to order sortedMap's Keys. Either (or
+// both) of trimmer & coalescer may be nil.
+func NewRangeMap(sortedMap SortedMap, compare Compare, trimmer RangeTrimmer, coalescer RangeCoalescer) (rangeMap RangeMap) {
+	rangeMap = &rangeMapStruct{
+		sortedMap: sortedMap,
+		compare:   compare,
+		trimmer:   trimmer,
+		coalescer: coalescer,
+	}
+	return
+}
+
+// getByIndex returns the range at index in sortedMap
+func (rangeMap *rangeMapStruct) getByIndex(index int) (rangeAtIndex Range, ok bool, err error) {
+	key, value, ok, err := rangeMap.sortedMap.GetByIndex(index)
+	if (nil != err) || !ok {
+		return
+	}
+
+	endAndValue, isTuple := value.(Tuple)
+	if !isTuple || (2 != len(endAndValue)) {
+		err = fmt.Errorf("RangeMap found non-Tuple{End, Value} Value for Start %v", key)
+		return
+	}
+
+	rangeAtIndex = Range{Start: key, End: endAndValue[0], Value: endAndValue[1]}
+
+	err = nil
+	return
+}
+
+// trim returns what remains of rangeToTrim once its Start is advanced to newStart
+func (rangeMap *rangeMapStruct) trim(rangeToTrim Range, newStart Key) (trimmedRange Range, err error) {
+	trimmedRange = Range{Start: newStart, End: rangeToTrim.End, Value: rangeToTrim.Value}
+
+	if nil != rangeMap.trimmer {
+		trimmedRange.Value, err = rangeMap.trimmer(rangeToTrim.Start, rangeToTrim.Value, newStart)
+		if nil != err {
+			return
+		}
+	}
+
+	err = nil
+	return
+}
+
+// validateRange returns an error unless start < end
+func (rangeMap *rangeMapStruct) validateRange(funcName string, start Key, end Key) (err error) {
+	compareResult, err := rangeMap.compare(start, end)
+	if nil != err {
+		return
+	}
+	if 0 <= compareResult {
+		err = fmt.Errorf("RangeMap.%s() passed empty range", funcName)
+		return
+	}
+
+	err = nil
+	return
+}
+
+// firstAtOrAfter returns the index of the first range ending after point (or, if abutting is true, at or after point)
+func (rangeMap *rangeMapStruct) firstAtOrAfter(point Key, abutting bool) (index int, err error) {
+	index, found, err := rangeMap.sortedMap.BisectLeft(point)
+	if nil != err {
+		return
+	}
+
+	if found && abutting && (0 < index) {
+		index-- // the preceding range may end at point
+	}
+
+	if 0 > index {
+		index = 0
+		err = nil
+		return
+	}
+
+	rangeAtIndex, _, err := rangeMap.getByIndex(index)
+	if nil != err {
+		return
+	}
+
+	compareResult, err := rangeMap.compare(rangeAtIndex.End, point)
+	if nil != err {
+		return
+	}
+	if (0 > compareResult) || ((0 == compareResult) && !abutting) {
+		index++
+	}
+
+	err = nil
+	return
+}
+
+// carve computes the changes required to unmap [start, end)
+//
+// Ranges overlapping [start, end) are returned in removedRanges while the portions of them
+// lying before start and after end are returned in leftRemainder & rightRemainder. If there
+// is no left (right) remainder, an existing range abutting start (end) is returned instead in
+// leftRemainder (rightRemainder) with leftAbutting (rightAbutting) set.
+func (rangeMap *rangeMapStruct) carve(start Key, end Key) (removedRanges []Range, leftRemainder *Range, leftAbutting bool, rightRemainder *Range, rightAbutting bool, err error) {
+	removedRanges = make([]Range, 0)
+
+	index, err := rangeMap.firstAtOrAfter(start, true)
+	if nil != err {
+		return
+	}
+
+	for {
+		rangeAtIndex, ok, getErr := rangeMap.getByIndex(index)
+		if nil != getErr {
+			err = getErr
+			return
+		}
+		if !ok {
+			break
+		}
+
+		startCompareResult, compareErr := rangeMap.compare(rangeAtIndex.Start, end)
+		if nil != compareErr {
+			err = compareErr
+			return
+		}
+		if 0 < startCompareResult {
+			break // rangeAtIndex (and all that follow) lie beyond end
+		}
+		if 0 == startCompareResult {
+			rightRemainder = &rangeAtIndex
+			rightAbutting = true
+			break
+		}
+
+		endCompareResult, compareErr := rangeMap.compare(rangeAtIndex.End, start)
+		if nil != compareErr {
+			err = compareErr
+			return
+		}
+		if 0 == endCompareResult {
+			leftRemainder = &rangeAtIndex
+			leftAbutting = true
+			index++
+			continue
+		}
+
+		// rangeAtIndex overlaps [start, end)
+
+		removedRanges = append(removedRanges, rangeAtIndex)
+
+		compareResult, compareErr := rangeMap.compare(rangeAtIndex.Start, start)
+		if nil != compareErr {
+			err = compareErr
+			return
+		}
+		if 0 > compareResult {
+			leftRemainder = &Range{Start: rangeAtIndex.Start, End: start, Value: rangeAtIndex.Value}
+			leftAbutting = false
+		}
+
+		compareResult, compareErr = rangeMap.compare(rangeAtIndex.End, end)
+		if nil != compareErr {
+			err = compareErr
+			return
+		}
+		if 0 < compareResult {
+			trimmedRange, trimErr := rangeMap.trim(rangeAtIndex, end)
+			if nil != trimErr {
+				err = trimErr
+				return
+			}
+			rightRemainder = &trimmedRange
+			rightAbutting = false
+			break
+		}
+
+		index++
+	}
+
+	err = nil
+	return
+}
+
+// coalesce returns the merger of preceding & following (if the RangeCoalescer accepts it)
+func (rangeMap *rangeMapStruct) coalesce(preceding Range, following Range) (coalescedRange Range, coalesced bool, err error) {
+	if nil == rangeMap.coalescer {
+		coalesced = false
+		err = nil
+		return
+	}
+
+	coalescedValue, coalesced, err := rangeMap.coalescer(preceding, following)
+	if (nil != err) || !coalesced {
+		return
+	}
+
+	coalescedRange = Range{Start: preceding.Start, End: following.End, Value: coalescedValue}
+
+	err = nil
+	return
+}
+
+// apply deletes removedRanges from sortedMap & then puts addedRanges into it
+func (rangeMap *rangeMapStruct) apply(removedRanges []Range, addedRanges []Range) (err error) {
+	for _, removedRange := range removedRanges {
+		ok, deleteErr := rangeMap.sortedMap.DeleteByKey(removedRange.Start)
+		if nil != deleteErr {
+			err = deleteErr
+			return
+		}
+		if !ok {
+			err = fmt.Errorf("Logic error: RangeMap missing range starting at %v", removedRange.Start)
+			return
+		}
+	}
+
+	for _, addedRange := range addedRanges {
+		ok, putErr := rangeMap.sortedMap.Put(addedRange.Start, Tuple{addedRange.End, addedRange.Value})
+		if nil != putErr {
+			err = putErr
+			return
+		}
+		if !ok {
+			err = fmt.Errorf("Logic error: RangeMap already contained range starting at %v", addedRange.Start)
+			return
+		}
+	}
+
+	err = nil
+	return
+}
+
+func (rangeMap *rangeMapStruct) Set(start Key, end Key, value Value) (err error) {
+	rangeMap.Lock()
+	defer rangeMap.Unlock()
+
+	err = rangeMap.validateRange("Set", start, end)
+	if nil != err {
+		return
+	}
+
+	removedRanges, leftRemainder, leftAbutting, rightRemainder, rightAbutting, err := rangeMap.carve(start, end)
+	if nil != err {
+		return
+	}
+
+	addedRanges := make([]Range, 0, 3)
+	newRange := Range{Start: start, End: end, Value: value}
+
+	if nil != leftRemainder {
+		coalescedRange, coalesced, coalesceErr := rangeMap.coalesce(*leftRemainder, newRange)
+		if nil != coalesceErr {
+			err = coalesceErr
+			return
+		}
+		if coalesced {
+			newRange = coalescedRange
+			if leftAbutting {
+				removedRanges = append(removedRanges, *leftRemainder)
+			}
+		} else if !leftAbutting {
+			addedRanges = append(addedRanges, *leftRemainder)
+		}
+	}
+
+	if nil != rightRemainder {
+		coalescedRange, coalesced, coalesceErr := rangeMap.coalesce(newRange, *rightRemainder)
+		if nil != coalesceErr {
+			err = coalesceErr
+			return
+		}
+		if coalesced {
+			newRange = coalescedRange
+			if rightAbutting {
+				removedRanges = append(removedRanges, *rightRemainder)
+			}
+		} else if !rightAbutting {
+			addedRanges = append(addedRanges, *rightRemainder)
+		}
+	}
+
+	addedRanges = append(addedRanges, newRange)
+
+	err = rangeMap.apply(removedRanges, addedRanges)
+
+	return
+}
+
+func (rangeMap *rangeMapStruct) Clear(start Key, end Key) (err error) {
+	rangeMap.Lock()
+	defer rangeMap.Unlock()
+
+	err = rangeMap.validateRange("Clear", start, end)
+	if nil != err {
+		return
+	}
+
+	removedRanges, leftRemainder, leftAbutting, rightRemainder, rightAbutting, err := rangeMap.carve(start, end)
+	if nil != err {
+		return
+	}
+
+	addedRanges := make([]Range, 0, 2)
+
+	if (nil != leftRemainder) && !leftAbutting {
+		addedRanges = append(addedRanges, *leftRemainder)
+	}
+	if (nil != rightRemainder) && !rightAbutting {
+		addedRanges = append(addedRanges, *rightRemainder)
+	}
+
+	err = rangeMap.apply(removedRanges, addedRanges)
+
+	return
+}
+
+func (rangeMap *rangeMapStruct) Get(point Key) (rangeCoveringPoint Range, ok bool, err error) {
+	rangeMap.Lock()
+	defer rangeMap.Unlock()
+
+	index, err := rangeMap.firstAtOrAfter(point, false)
+	if nil != err {
+		return
+	}
+
+	rangeCoveringPoint, ok, err = rangeMap.getByIndex(index)
+	if (nil != err) || !ok {
+		return
+	}
+
+	compareResult, err := rangeMap.compare(rangeCoveringPoint.Start, point)
+	if nil != err {
+		return
+	}
+
+	ok = (0 >= compareResult)
+
+	err = nil
+	return
+}
+
+func (rangeMap *rangeMapStruct) Range(start Key, end Key) (ranges []Range, err error) {
+	rangeMap.Lock()
+	defer rangeMap.Unlock()
+
+	ranges = make([]Range, 0)
+
+	compareResult, err := rangeMap.compare(start, end)
+	if (nil != err) || (0 <= compareResult) {
+		return // Nothing overlaps an empty [start, end)
+	}
+
+	index, err := rangeMap.firstAtOrAfter(start, false)
+	if nil != err {
+		return
+	}
+
+	for {
+		rangeAtIndex, ok, getErr := rangeMap.getByIndex(index)
+		if nil != getErr {
+			err = getErr
+			return
+		}
+		if !ok {
+			break
+		}
+
+		compareResult, err = rangeMap.compare(rangeAtIndex.Start, end)
+		if nil != err {
+			return
+		}
+		if 0 <= compareResult {
+			break
+		}
+
+		compareResult, err = rangeMap.compare(rangeAtIndex.Start, start)
+		if nil != err {
+			return
+		}
+		if 0 > compareResult {
+			rangeAtIndex, err = rangeMap.trim(rangeAtIndex, start)
+			if nil != err {
+				return
+			}
+		}
+
+		compareResult, err = rangeMap.compare(rangeAtIndex.End, end)
+		if nil != err {
+			return
+		}
+		if 0 < compareResult {
+			rangeAtIndex.End = end
+		}
+
+		ranges = append(ranges, rangeAtIndex)
+
+		index++
+	}
+
+	err = nil
+	return
+}
+
+func (rangeMap *rangeMapStruct) Len() (numberOfRanges int, err error) {
+	rangeMap.Lock()
+	defer rangeMap.Unlock()
+
+	numberOfRanges, err = rangeMap.sortedMap.Len()
+
+	return
+}
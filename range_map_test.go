@@ -0,0 +1,206 @@
+// Copyright (c) 2015-2021, NVIDIA CORPORATION.
+// SPDX-License-Identifier: Apache-2.0
+
+package sortedmap
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// Each range maps the int Keys it covers to consecutive int "physical" locations starting at its Value
+
+const rangeMapTestSpan = 200
+
+func rangeMapTestTrimmer(start Key, value Value, newStart Key) (trimmedValue Value, err error) {
+	trimmedValue = value.(int) + (newStart.(int) - start.(int))
+	err = nil
+	return
+}
+
+func rangeMapTestCoalescer(preceding Range, following Range) (coalescedValue Value, coalesce bool, err error) {
+	coalesce = (preceding.Value.(int) + (preceding.End.(int) - preceding.Start.(int))) == following.Value.(int)
+	coalescedValue = preceding.Value
+	err = nil
+	return
+}
+
+// rangeMapTestVerify compares rangeMap against model (where model[point] < 0 means point is unmapped)
+func rangeMapTestVerify(t *testing.T, rangeMap RangeMap, model []int) {
+	ranges, err := rangeMap.Range(0, rangeMapTestSpan)
+	if nil != err {
+		t.Fatalf("rangeMap.Range(0, %v) failed: %v", rangeMapTestSpan, err)
+	}
+
+	numberOfRanges, err := rangeMap.Len()
+	if (nil != err) || (len(ranges) != numberOfRanges) {
+		t.Fatalf("rangeMap.Len() returned %v but should have returned %v", numberOfRanges, len(ranges))
+	}
+
+	point := 0
+
+	for i, rangeInMap := range ranges {
+		start := rangeInMap.Start.(int)
+		end := rangeInMap.End.(int)
+
+		if (start < point) || (start >= end) {
+			t.Fatalf("rangeMap.Range() returned overlapping or empty range [%v, %v)", start, end)
+		}
+		for ; point < start; point++ {
+			if 0 <= model[point] {
+				t.Fatalf("rangeMap.Range() should have covered point %v", point)
+			}
+		}
+		for ; point < end; point++ {
+			if (rangeInMap.Value.(int) + (point - start)) != model[point] {
+				t.Fatalf("rangeMap.Range() mapped point %v to %v but should have mapped it to %v", point, rangeInMap.Value.(int)+(point-start), model[point])
+			}
+		}
+
+		if (0 < i) && (ranges[i-1].End.(int) == start) {
+			_, coalesce, _ := rangeMapTestCoalescer(ranges[i-1], rangeInMap)
+			if coalesce {
+				t.Fatalf("rangeMap should have coalesced [%v, %v) & [%v, %v)", ranges[i-1].Start, ranges[i-1].End, start, end)
+			}
+		}
+	}
+	for ; point < rangeMapTestSpan; point++ {
+		if 0 <= model[point] {
+			t.Fatalf("rangeMap.Range() should have covered point %v", point)
+		}
+	}
+
+	for query := 0; query < 20; query++ {
+		point = rand.Intn(rangeMapTestSpan)
+
+		rangeCoveringPoint, ok, err := rangeMap.Get(point)
+		if nil != err {
+			t.Fatalf("rangeMap.Get(%v) failed: %v", point, err)
+		}
+		if ok != (0 <= model[point]) {
+			t.Fatalf("rangeMap.Get(%v) returned ok == %v but should not have", point, ok)
+		}
+		if ok {
+			start := rangeCoveringPoint.Start.(int)
+			if (start > point) || (rangeCoveringPoint.End.(int) <= point) || ((rangeCoveringPoint.Value.(int) + (point - start)) != model[point]) {
+				t.Fatalf("rangeMap.Get(%v) returned [%v, %v) -> %v which does not map point to %v", point, start, rangeCoveringPoint.End, rangeCoveringPoint.Value, model[point])
+			}
+		}
+	}
+
+	start := rand.Intn(rangeMapTestSpan)
+	end := start + rand.Intn(rangeMapTestSpan-start) + 1
+
+	ranges, err = rangeMap.Range(start, end)
+	if nil != err {
+		t.Fatalf("rangeMap.Range(%v, %v) failed: %v", start, end, err)
+	}
+	for _, rangeInMap := range ranges {
+		if (rangeInMap.Start.(int) < start) || (rangeInMap.End.(int) > end) {
+			t.Fatalf("rangeMap.Range(%v, %v) should have clipped [%v, %v)", start, end, rangeInMap.Start, rangeInMap.End)
+		}
+		if rangeInMap.Value.(int) != model[rangeInMap.Start.(int)] {
+			t.Fatalf("rangeMap.Range(%v, %v) should have trimmed the Value of [%v, %v)", start, end, rangeInMap.Start, rangeInMap.End)
+		}
+	}
+}
+
+func rangeMapTestRun(t *testing.T, sortedMap SortedMap) {
+	rangeMap := NewRangeMap(sortedMap, CompareInt, rangeMapTestTrimmer, rangeMapTestCoalescer)
+
+	model := make([]int, rangeMapTestSpan)
+	for point := range model {
+		model[point] = -1
+	}
+
+	err := rangeMap.Set(10, 10, 0)
+	if nil == err {
+		t.Fatalf("rangeMap.Set() of empty range should have failed")
+	}
+
+	// Split, trim, & coalesce explicitly
+
+	err = rangeMap.Set(10, 50, 1000)
+	if nil != err {
+		t.Fatalf("rangeMap.Set(10, 50, 1000) failed: %v", err)
+	}
+	err = rangeMap.Set(20, 30, 5000) // splits [10, 50) in two
+	if nil != err {
+		t.Fatalf("rangeMap.Set(20, 30, 5000) failed: %v", err)
+	}
+
+	rangeCoveringPoint, ok, err := rangeMap.Get(40)
+	if (nil != err) || !ok || (30 != rangeCoveringPoint.Start.(int)) || (50 != rangeCoveringPoint.End.(int)) || (1020 != rangeCoveringPoint.Value.(int)) {
+		t.Fatalf("rangeMap.Get(40) returned %v but should have returned [30, 50) -> 1020", rangeCoveringPoint)
+	}
+
+	err = rangeMap.Set(20, 30, 1010) // restores contiguity, so all three coalesce
+	if nil != err {
+		t.Fatalf("rangeMap.Set(20, 30, 1010) failed: %v", err)
+	}
+
+	numberOfRanges, err := rangeMap.Len()
+	if (nil != err) || (1 != numberOfRanges) {
+		t.Fatalf("rangeMap.Len() returned %v but should have returned 1", numberOfRanges)
+	}
+
+	err = rangeMap.Clear(0, rangeMapTestSpan)
+	if nil != err {
+		t.Fatalf("rangeMap.Clear(0, %v) failed: %v", rangeMapTestSpan, err)
+	}
+
+	rangeMapTestVerify(t, rangeMap, model)
+
+	// Compare random Set()s & Clear()s against model
+
+	for op := 0; op < 500; op++ {
+		start := rand.Intn(rangeMapTestSpan)
+		end := start + rand.Intn(rangeMapTestSpan/10) + 1
+		if end > rangeMapTestSpan {
+			end = rangeMapTestSpan
+		}
+
+		if 0 == rand.Intn(4) {
+			err = rangeMap.Clear(start, end)
+			if nil != err {
+				t.Fatalf("rangeMap.Clear(%v, %v) failed: %v", start, end, err)
+			}
+			for point := start; point < end; point++ {
+				model[point] = -1
+			}
+		} else {
+			value := 1000 * rand.Intn(3) // frequently contiguous with neighbors
+			if 0 < start && 0 <= model[start-1] && 0 == rand.Intn(2) {
+				value = model[start-1] + 1
+			}
+			err = rangeMap.Set(start, end, value)
+			if nil != err {
+				t.Fatalf("rangeMap.Set(%v, %v, %v) failed: %v", start, end, value, err)
+			}
+			for point := start; point < end; point++ {
+				model[point] = value + (point - start)
+			}
+		}
+
+		if 0 == op%25 {
+			rangeMapTestVerify(t, rangeMap, model)
+		}
+	}
+
+	rangeMapTestVerify(t, rangeMap, model)
+
+	err = sortedMap.Validate()
+	if nil != err {
+		t.Fatalf("sortedMap.Validate() failed: %v", err)
+	}
+}
+
+func TestRangeMap(t *testing.T) {
+	rand.Seed(5)
+
+	rangeMapTestRun(t, NewLLRBTree(CompareInt, nil))
+
+	nodeStore := &callbacksTestNodeStoreStruct{lastObjectNumberGenerated: 0, objectMap: make(map[uint64][]byte)}
+
+	rangeMapTestRun(t, NewBPlusTree(4, CompareInt, Callbacks(CodecInt, TupleCodec(CodecInt, CodecInt), nodeStore), nil))
+}
@@ -0,0 +1,710 @@
+// Copyright (c) 2015-2021, NVIDIA CORPORATION.
+// SPDX-License-Identifier: Apache-2.0
+
+package sortedmap
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Time-to-live maps
+//
+// A TTLMap wraps a SortedMap (either an LLRBTree or a BPlusTree) whose Key:Value pairs may
+// carry an expiry. The wrapped SortedMap holds, for each Key, the Value Tuple{expiry, Value}
+// where a zero expiry (as recorded by Put()) means the pair never expires. For a BPlusTree, a
+// ValueCodec such as TupleCodec(CodecTime, valueCodec) is thus required. As with IndexedMap,
+// the wrapped SortedMap must not be mutated other than via the TTLMap.
+//
+// Deadlines are tracked in memory by an LLRBTree keyed by the Tuple {expiry, Key}. As the
+// wrapped SortedMap records no separate index of them, NewTTLMap() must visit (and, for a
+// BPlusTree, load) every Key:Value pair to rebuild it (see BenchmarkNewTTLMap).
+//
+// A pair whose expiry is not after the current time (as reported by the supplied Clock) is
+// expired and never visible to Get*(), Bisect*(), or Len(), nor found by Delete*() or
+// Patch*(), while Put() replaces it. Reads merely skip expired pairs: index-based calls
+// count the expired pairs before an index (located via the deadlines) to translate it, so
+// each costs O(log n) per expired pair yet to be removed. Only ExpireNow() and the background
+// goroutine launched by StartExpirer() (every interval) remove them. Removal is simply a
+// DeleteByKey() of the wrapped SortedMap, so a BPlusTree's affected leaf nodes are marked
+// dirty and are written by its next Flush().
+
+// Clock returns the current time (time.Now() if not supplied to NewTTLMap())
+type Clock func() (now time.Time)
+
+// TTLMap is a SortedMap whose Key:Value pairs may expire
+//
+// PutWithExpiry() is identical to Put() except that key:value expires at expiry.
+// PatchExpiry() changes the expiry of an existing Key (a zero expiry meaning never).
+type TTLMap interface {
+	SortedMap
+	PutWithExpiry(key Key, value Value, expiry time.Time) (ok bool, err error)
+	PatchExpiry(key Key, expiry time.Time) (ok bool, err error)
+	GetExpiry(key Key) (expiry time.Time, ok bool, err error)
+	ExpireNow() (numberExpired int, err error)
+	StartExpirer(interval time.Duration) (err error)
+	StopExpirer() (err error) // Returns the first error (if any) encountered by the background goroutine
+}
+
+type ttlMapStruct struct {
+	sync.Mutex
+	sortedMap   SortedMap // Value == Tuple{expiry, Value}
+	clock       Clock
+	deadlines   LLRBTree // Key == Tuple{expiry, Key}, Value == nil
+	expirerStop chan struct{}
+	expirerDone sync.WaitGroup
+	expirerErr  error
+}
+
+// NewTTLMap returns a TTLMap wrapping sortedMap
+//
+// The supplied compare must be the Compare func used to order sortedMap's Keys. If clock is
+// nil, time.Now() is used. Rebuilding the deadlines visits every Key:Value pair of sortedMap,
+// so this is O(n) (loading every node of a BPlusTree).
+func NewTTLMap(sortedMap SortedMap, compare Compare, clock Clock) (ttlMap TTLMap, err error) {
+	if nil == clock {
+		clock = time.Now
+	}
+
+	ttlMapToReturn := &ttlMapStruct{
+		sortedMap: sortedMap,
+		clock:     clock,
+		deadlines: NewLLRBTree(CompareTuple(CompareTime, compare), nil),
+	}
+
+	numberOfItems, err := sortedMap.Len()
+	if nil != err {
+		return
+	}
+
+	for index := 0; index < numberOfItems; index++ {
+		key, storedValue, _, getErr := sortedMap.GetByIndex(index)
+		if nil != getErr {
+			err = getErr
+			return
+		}
+
+		expiry, _, unpackErr := ttlMapToReturn.unpack(key, storedValue)
+		if nil != unpackErr {
+			err = unpackErr
+			return
+		}
+
+		err = ttlMapToReturn.track(key, expiry)
+		if nil != err {
+			return
+		}
+	}
+
+	ttlMap = ttlMapToReturn
+
+	err = nil
+	return
+}
+
+// unpack splits a Value of the wrapped SortedMap into its expiry & the client's Value
+func (ttlMap *ttlMapStruct) unpack(key Key, storedValue Value) (expiry time.Time, value Value, err error) {
+	expiryAndValue, ok := storedValue.(Tuple)
+	if ok && (2 == len(expiryAndValue)) {
+		expiry, ok = expiryAndValue[0].(time.Time)
+	}
+	if !ok {
+		err = fmt.Errorf("TTLMap found non-Tuple{expiry, Value} Value for Key %v", key)
+		return
+	}
+
+	value = expiryAndValue[1]
+
+	err = nil
+	return
+}
+
+// track records the deadline of key (if any)
+func (ttlMap *ttlMapStruct) track(key Key, expiry time.Time) (err error) {
+	if expiry.IsZero() {
+		err = nil
+		return
+	}
+
+	ok, err := ttlMap.deadlines.Put(Tuple{expiry, key}, nil)
+	if nil != err {
+		return
+	}
+	if !ok {
+		err = fmt.Errorf("Logic error: TTLMap already tracking deadline of Key %v", key)
+		return
+	}
+
+	err = nil
+	return
+}
+
+// untrack discards the deadline of key (if any)
+func (ttlMap *ttlMapStruct) untrack(key Key, expiry time.Time) (err error) {
+	if expiry.IsZero() {
+		err = nil
+		return
+	}
+
+	ok, err := ttlMap.deadlines.DeleteByKey(Tuple{expiry, key})
+	if nil != err {
+		return
+	}
+	if !ok {
+		err = fmt.Errorf("Logic error: TTLMap not tracking deadline of Key %v", key)
+		return
+	}
+
+	err = nil
+	return
+}
+
+// isExpired reports whether a Key:Value pair with expiry has expired as of now
+func isExpired(expiry time.Time, now time.Time) bool {
+	return !expiry.IsZero() && !expiry.After(now)
+}
+
+// numberExpired returns the number of Key:Value pairs expired as of now yet to be removed
+func (ttlMap *ttlMapStruct) numberExpired(now time.Time) (numberExpired int, err error) {
+	lastIndex, _, err := ttlMap.deadlines.BisectLeft(Tuple{now, TupleMax})
+	if nil != err {
+		return
+	}
+
+	numberExpired = lastIndex + 1
+
+	err = nil
+	return
+}
+
+// expiredIndices returns the sorted indices (in the wrapped SortedMap) of the Key:Value pairs
+// expired as of now yet to be removed
+func (ttlMap *ttlMapStruct) expiredIndices(now time.Time) (expiredIndices []int, err error) {
+	numberExpired, err := ttlMap.numberExpired(now)
+	if nil != err {
+		return
+	}
+
+	expiredIndices = make([]int, 0, numberExpired)
+
+	for deadlineIndex := 0; deadlineIndex < numberExpired; deadlineIndex++ {
+		deadline, _, _, getErr := ttlMap.deadlines.GetByIndex(deadlineIndex)
+		if nil != getErr {
+			err = getErr
+			return
+		}
+
+		key := deadline.(Tuple)[1]
+
+		index, found, bisectErr := ttlMap.sortedMap.BisectLeft(key)
+		if nil != bisectErr {
+			err = bisectErr
+			return
+		}
+		if !found {
+			err = fmt.Errorf("Logic error: TTLMap tracking deadline of missing Key %v", key)
+			return
+		}
+
+		expiredIndices = append(expiredIndices, index)
+	}
+
+	sort.Ints(expiredIndices)
+
+	err = nil
+	return
+}
+
+// wrappedIndex translates index (counting only unexpired pairs) into the wrapped SortedMap
+func wrappedIndex(index int, expiredIndices []int) int {
+	for _, expiredIndex := range expiredIndices {
+		if expiredIndex > index {
+			break
+		}
+		index++
+	}
+
+	return index
+}
+
+// numberBefore returns the number of expiredIndices less than index
+func numberBefore(index int, expiredIndices []int) int {
+	return sort.SearchInts(expiredIndices, index)
+}
+
+// getByKey returns the expiry & Value of key unless it is missing or expired
+func (ttlMap *ttlMapStruct) getByKey(key Key) (expiry time.Time, value Value, ok bool, err error) {
+	storedValue, ok, err := ttlMap.sortedMap.GetByKey(key)
+	if (nil != err) || !ok {
+		return
+	}
+
+	expiry, value, err = ttlMap.unpack(key, storedValue)
+	if nil != err {
+		return
+	}
+
+	ok = !isExpired(expiry, ttlMap.clock())
+
+	err = nil
+	return
+}
+
+// getByIndex returns the Key, expiry, & Value of the index-th unexpired Key:Value pair
+func (ttlMap *ttlMapStruct) getByIndex(index int) (key Key, expiry time.Time, value Value, ok bool, err error) {
+	expiredIndices, err := ttlMap.expiredIndices(ttlMap.clock())
+	if nil != err {
+		return
+	}
+
+	key, storedValue, ok, err := ttlMap.sortedMap.GetByIndex(wrappedIndex(index, expiredIndices))
+	if (nil != err) || !ok {
+		return
+	}
+
+	expiry, value, err = ttlMap.unpack(key, storedValue)
+
+	return
+}
+
+// expire removes every Key:Value pair whose expiry has been reached
+func (ttlMap *ttlMapStruct) expire() (numberExpired int, err error) {
+	now := ttlMap.clock()
+
+	for {
+		deadline, _, ok, getErr := ttlMap.deadlines.GetByIndex(0)
+		if nil != getErr {
+			err = getErr
+			return
+		}
+		if !ok {
+			break
+		}
+
+		expiry := deadline.(Tuple)[0].(time.Time)
+		if expiry.After(now) {
+			break
+		}
+
+		key := deadline.(Tuple)[1]
+
+		ok, err = ttlMap.sortedMap.DeleteByKey(key)
+		if nil != err {
+			return
+		}
+		if !ok {
+			err = fmt.Errorf("Logic error: TTLMap tracking deadline of missing Key %v", key)
+			return
+		}
+
+		_, err = ttlMap.deadlines.DeleteByIndex(0)
+		if nil != err {
+			return
+		}
+
+		numberExpired++
+	}
+
+	err = nil
+	return
+}
+
+// put inserts key:value unless key is present (replacing an expired pair yet to be removed)
+func (ttlMap *ttlMapStruct) put(key Key, value Value, expiry time.Time) (ok bool, err error) {
+	storedValue, found, err := ttlMap.sortedMap.GetByKey(key)
+	if nil != err {
+		return
+	}
+
+	if !found {
+		ok, err = ttlMap.sortedMap.Put(key, Tuple{expiry, value})
+		if (nil != err) || !ok {
+			return
+		}
+
+		err = ttlMap.track(key, expiry)
+
+		return
+	}
+
+	oldExpiry, _, err := ttlMap.unpack(key, storedValue)
+	if nil != err {
+		return
+	}
+
+	if !isExpired(oldExpiry, ttlMap.clock()) {
+		ok = false
+		err = nil
+		return
+	}
+
+	ok, err = ttlMap.sortedMap.PatchByKey(key, Tuple{expiry, value})
+	if (nil != err) || !ok {
+		return
+	}
+
+	err = ttlMap.untrack(key, oldExpiry)
+	if nil != err {
+		return
+	}
+
+	err = ttlMap.track(key, expiry)
+
+	return
+}
+
+func (ttlMap *ttlMapStruct) PutWithExpiry(key Key, value Value, expiry time.Time) (ok bool, err error) {
+	ttlMap.Lock()
+	defer ttlMap.Unlock()
+
+	ok, err = ttlMap.put(key, value, expiry)
+
+	return
+}
+
+func (ttlMap *ttlMapStruct) PatchExpiry(key Key, expiry time.Time) (ok bool, err error) {
+	ttlMap.Lock()
+	defer ttlMap.Unlock()
+
+	oldExpiry, value, ok, err := ttlMap.getByKey(key)
+	if (nil != err) || !ok {
+		return
+	}
+
+	ok, err = ttlMap.sortedMap.PatchByKey(key, Tuple{expiry, value})
+	if (nil != err) || !ok {
+		return
+	}
+
+	err = ttlMap.untrack(key, oldExpiry)
+	if nil != err {
+		return
+	}
+
+	err = ttlMap.track(key, expiry)
+
+	return
+}
+
+func (ttlMap *ttlMapStruct) GetExpiry(key Key) (expiry time.Time, ok bool, err error) {
+	ttlMap.Lock()
+	defer ttlMap.Unlock()
+
+	expiry, _, ok, err = ttlMap.getByKey(key)
+	if !ok {
+		expiry = time.Time{}
+	}
+
+	return
+}
+
+func (ttlMap *ttlMapStruct) ExpireNow() (numberExpired int, err error) {
+	ttlMap.Lock()
+	defer ttlMap.Unlock()
+
+	numberExpired, err = ttlMap.expire()
+
+	return
+}
+
+func (ttlMap *ttlMapStruct) StartExpirer(interval time.Duration) (err error) {
+	ttlMap.Lock()
+	defer ttlMap.Unlock()
+
+	if nil != ttlMap.expirerStop {
+		err = fmt.Errorf("TTLMap expirer already started")
+		return
+	}
+
+	ttlMap.expirerStop = make(chan struct{})
+	ttlMap.expirerErr = nil
+
+	ttlMap.expirerDone.Add(1)
+	go ttlMap.expirer(interval, ttlMap.expirerStop)
+
+	err = nil
+	return
+}
+
+func (ttlMap *ttlMapStruct) StopExpirer() (err error) {
+	ttlMap.Lock()
+
+	if nil == ttlMap.expirerStop {
+		ttlMap.Unlock()
+		err = fmt.Errorf("TTLMap expirer not started")
+		return
+	}
+
+	close(ttlMap.expirerStop)
+	ttlMap.expirerStop = nil
+
+	ttlMap.Unlock()
+
+	ttlMap.expirerDone.Wait()
+
+	err = ttlMap.expirerErr
+
+	return
+}
+
+// expirer is the background goroutine launched by StartExpirer()
+func (ttlMap *ttlMapStruct) expirer(interval time.Duration, stop chan struct{}) {
+	ticker := time.NewTicker(interval)
+
+	defer ttlMap.expirerDone.Done()
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			ttlMap.Lock()
+			_, err := ttlMap.expire()
+			if (nil != err) && (nil == ttlMap.expirerErr) {
+				ttlMap.expirerErr = err
+			}
+			ttlMap.Unlock()
+		}
+	}
+}
+
+// API functions (see api.go)
+
+func (ttlMap *ttlMapStruct) BisectLeft(key Key) (index int, found bool, err error) {
+	ttlMap.Lock()
+	defer ttlMap.Unlock()
+
+	expiredIndices, err := ttlMap.expiredIndices(ttlMap.clock())
+	if nil != err {
+		return
+	}
+
+	index, found, err = ttlMap.sortedMap.BisectLeft(key)
+	if nil != err {
+		return
+	}
+
+	// Skip index itself if it is expired (as well as every expired pair before it)
+
+	expiredBefore := numberBefore(index+1, expiredIndices)
+
+	found = found && (numberBefore(index, expiredIndices) == expiredBefore)
+	index -= expiredBefore
+
+	return
+}
+
+func (ttlMap *ttlMapStruct) BisectRight(key Key) (index int, found bool, err error) {
+	ttlMap.Lock()
+	defer ttlMap.Unlock()
+
+	expiredIndices, err := ttlMap.expiredIndices(ttlMap.clock())
+	if nil != err {
+		return
+	}
+
+	index, found, err = ttlMap.sortedMap.BisectRight(key)
+	if nil != err {
+		return
+	}
+
+	// An expired index is instead occupied by the next unexpired pair
+
+	expiredBefore := numberBefore(index, expiredIndices)
+
+	found = found && (numberBefore(index+1, expiredIndices) == expiredBefore)
+	index -= expiredBefore
+
+	return
+}
+
+func (ttlMap *ttlMapStruct) DeleteByIndex(index int) (ok bool, err error) {
+	ttlMap.Lock()
+	defer ttlMap.Unlock()
+
+	key, expiry, _, ok, err := ttlMap.getByIndex(index)
+	if (nil != err) || !ok {
+		return
+	}
+
+	ok, err = ttlMap.delete(key, expiry)
+
+	return
+}
+
+func (ttlMap *ttlMapStruct) DeleteByKey(key Key) (ok bool, err error) {
+	ttlMap.Lock()
+	defer ttlMap.Unlock()
+
+	expiry, _, ok, err := ttlMap.getByKey(key)
+	if (nil != err) || !ok {
+		return
+	}
+
+	ok, err = ttlMap.delete(key, expiry)
+
+	return
+}
+
+func (ttlMap *ttlMapStruct) delete(key Key, expiry time.Time) (ok bool, err error) {
+	ok, err = ttlMap.sortedMap.DeleteByKey(key)
+	if (nil != err) || !ok {
+		return
+	}
+
+	err = ttlMap.untrack(key, expiry)
+
+	return
+}
+
+func (ttlMap *ttlMapStruct) Dump() (err error) {
+	ttlMap.Lock()
+	defer ttlMap.Unlock()
+
+	err = ttlMap.sortedMap.Dump()
+
+	return
+}
+
+func (ttlMap *ttlMapStruct) GetByIndex(index int) (key Key, value Value, ok bool, err error) {
+	ttlMap.Lock()
+	defer ttlMap.Unlock()
+
+	key, _, value, ok, err = ttlMap.getByIndex(index)
+
+	return
+}
+
+func (ttlMap *ttlMapStruct) GetByKey(key Key) (value Value, ok bool, err error) {
+	ttlMap.Lock()
+	defer ttlMap.Unlock()
+
+	_, value, ok, err = ttlMap.getByKey(key)
+	if !ok {
+		value = nil
+	}
+
+	return
+}
+
+func (ttlMap *ttlMapStruct) Len() (numberOfItems int, err error) {
+	ttlMap.Lock()
+	defer ttlMap.Unlock()
+
+	numberExpired, err := ttlMap.numberExpired(ttlMap.clock())
+	if nil != err {
+		return
+	}
+
+	numberOfItems, err = ttlMap.sortedMap.Len()
+	if nil != err {
+		return
+	}
+
+	numberOfItems -= numberExpired
+
+	return
+}
+
+func (ttlMap *ttlMapStruct) PatchByIndex(index int, value Value) (ok bool, err error) {
+	ttlMap.Lock()
+	defer ttlMap.Unlock()
+
+	key, expiry, _, ok, err := ttlMap.getByIndex(index)
+	if (nil != err) || !ok {
+		return
+	}
+
+	ok, err = ttlMap.sortedMap.PatchByKey(key, Tuple{expiry, value})
+
+	return
+}
+
+func (ttlMap *ttlMapStruct) PatchByKey(key Key, value Value) (ok bool, err error) {
+	ttlMap.Lock()
+	defer ttlMap.Unlock()
+
+	expiry, _, ok, err := ttlMap.getByKey(key)
+	if (nil != err) || !ok {
+		return
+	}
+
+	ok, err = ttlMap.sortedMap.PatchByKey(key, Tuple{expiry, value})
+
+	return
+}
+
+func (ttlMap *ttlMapStruct) Put(key Key, value Value) (ok bool, err error) {
+	ttlMap.Lock()
+	defer ttlMap.Unlock()
+
+	ok, err = ttlMap.put(key, value, time.Time{})
+
+	return
+}
+
+func (ttlMap *ttlMapStruct) Validate() (err error) {
+	ttlMap.Lock()
+	defer ttlMap.Unlock()
+
+	err = ttlMap.sortedMap.Validate()
+	if nil != err {
+		return
+	}
+
+	err = ttlMap.deadlines.Validate()
+	if nil != err {
+		return
+	}
+
+	numberOfItems, err := ttlMap.sortedMap.Len()
+	if nil != err {
+		return
+	}
+
+	numberOfExpiringItems := 0
+
+	for index := 0; index < numberOfItems; index++ {
+		key, storedValue, _, getErr := ttlMap.sortedMap.GetByIndex(index)
+		if nil != getErr {
+			err = getErr
+			return
+		}
+
+		expiry, _, unpackErr := ttlMap.unpack(key, storedValue)
+		if nil != unpackErr {
+			err = unpackErr
+			return
+		}
+		if expiry.IsZero() {
+			continue
+		}
+
+		_, ok, getErr := ttlMap.deadlines.GetByKey(Tuple{expiry, key})
+		if nil != getErr {
+			err = getErr
+			return
+		}
+		if !ok {
+			err = fmt.Errorf("TTLMap not tracking deadline of Key %v", key)
+			return
+		}
+
+		numberOfExpiringItems++
+	}
+
+	numberOfDeadlines, err := ttlMap.deadlines.Len()
+	if nil != err {
+		return
+	}
+	if numberOfDeadlines != numberOfExpiringItems {
+		err = fmt.Errorf("TTLMap tracking %v deadlines but only %v Keys expire", numberOfDeadlines, numberOfExpiringItems)
+		return
+	}
+
+	err = nil
+	return
+}
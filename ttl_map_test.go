@@ -0,0 +1,335 @@
+// Copyright (c) 2015-2021, NVIDIA CORPORATION.
+// SPDX-License-Identifier: Apache-2.0
+
+package sortedmap
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// ttlMapTestClockStruct is a Clock that only advances when told to
+type ttlMapTestClockStruct struct {
+	sync.Mutex
+	now time.Time
+}
+
+func (clock *ttlMapTestClockStruct) Now() (now time.Time) {
+	clock.Lock()
+	now = clock.now
+	clock.Unlock()
+	return
+}
+
+func (clock *ttlMapTestClockStruct) advance(duration time.Duration) {
+	clock.Lock()
+	clock.now = clock.now.Add(duration)
+	clock.Unlock()
+}
+
+func ttlMapTestExpectKeys(t *testing.T, ttlMap TTLMap, expectedKeys []int) {
+	numberOfItems, err := ttlMap.Len()
+	if (nil != err) || (len(expectedKeys) != numberOfItems) {
+		t.Fatalf("ttlMap.Len() returned %v but should have returned %v", numberOfItems, len(expectedKeys))
+	}
+
+	for index, expectedKey := range expectedKeys {
+		key, value, ok, err := ttlMap.GetByIndex(index)
+		if (nil != err) || !ok || (expectedKey != key.(int)) || ((expectedKey * 10) != value.(int)) {
+			t.Fatalf("ttlMap.GetByIndex(%v) returned %v:%v but should have returned %v:%v", index, key, value, expectedKey, expectedKey*10)
+		}
+	}
+
+	err = ttlMap.Validate()
+	if nil != err {
+		t.Fatalf("ttlMap.Validate() failed: %v", err)
+	}
+}
+
+func ttlMapTestRun(t *testing.T, sortedMap SortedMap, clock *ttlMapTestClockStruct) (ttlMap TTLMap) {
+	ttlMap, err := NewTTLMap(sortedMap, CompareInt, clock.Now)
+	if nil != err {
+		t.Fatalf("NewTTLMap() failed: %v", err)
+	}
+
+	// Keys 1..5 expire after that many seconds, Key 6 never does
+
+	for key := 1; key <= 5; key++ {
+		ok, err := ttlMap.PutWithExpiry(key, key*10, clock.Now().Add(time.Duration(key)*time.Second))
+		if (nil != err) || !ok {
+			t.Fatalf("ttlMap.PutWithExpiry(%v,,) failed: %v", key, err)
+		}
+	}
+	ok, err := ttlMap.Put(6, 60)
+	if (nil != err) || !ok {
+		t.Fatalf("ttlMap.Put(6,) failed: %v", err)
+	}
+
+	ttlMapTestExpectKeys(t, ttlMap, []int{1, 2, 3, 4, 5, 6})
+
+	clock.advance(2 * time.Second)
+
+	_, ok, err = ttlMap.GetByKey(2)
+	if (nil != err) || ok {
+		t.Fatalf("ttlMap.GetByKey(2) of expired Key should have returned ok == false")
+	}
+	index, found, err := ttlMap.BisectLeft(2)
+	if (nil != err) || found || (-1 != index) {
+		t.Fatalf("ttlMap.BisectLeft(2) returned %v,%v but should have returned -1,false", index, found)
+	}
+	index, found, err = ttlMap.BisectRight(1)
+	if (nil != err) || found || (0 != index) {
+		t.Fatalf("ttlMap.BisectRight(1) returned %v,%v but should have returned 0,false", index, found)
+	}
+	index, found, err = ttlMap.BisectLeft(4)
+	if (nil != err) || !found || (1 != index) {
+		t.Fatalf("ttlMap.BisectLeft(4) returned %v,%v but should have returned 1,true", index, found)
+	}
+	index, found, err = ttlMap.BisectRight(4)
+	if (nil != err) || !found || (1 != index) {
+		t.Fatalf("ttlMap.BisectRight(4) returned %v,%v but should have returned 1,true", index, found)
+	}
+	ok, err = ttlMap.DeleteByKey(1)
+	if (nil != err) || ok {
+		t.Fatalf("ttlMap.DeleteByKey(1) of expired Key should have returned ok == false")
+	}
+	ok, err = ttlMap.PatchByKey(2, 20)
+	if (nil != err) || ok {
+		t.Fatalf("ttlMap.PatchByKey(2,) of expired Key should have returned ok == false")
+	}
+	_, ok, err = ttlMap.GetExpiry(2)
+	if (nil != err) || ok {
+		t.Fatalf("ttlMap.GetExpiry(2) of expired Key should have returned ok == false")
+	}
+
+	ttlMapTestExpectKeys(t, ttlMap, []int{3, 4, 5, 6})
+
+	// Reads skip expired Keys 1 & 2 without removing them
+
+	numberOfItems, err := sortedMap.Len()
+	if (nil != err) || (6 != numberOfItems) {
+		t.Fatalf("sortedMap.Len() returned %v but should have returned 6", numberOfItems)
+	}
+
+	// Extending a deadline, patching a Value, & discarding a deadline
+
+	ok, err = ttlMap.PatchExpiry(3, clock.Now().Add(time.Hour))
+	if (nil != err) || !ok {
+		t.Fatalf("ttlMap.PatchExpiry(3,) failed: %v", err)
+	}
+	ok, err = ttlMap.PatchByKey(4, 40)
+	if (nil != err) || !ok {
+		t.Fatalf("ttlMap.PatchByKey(4,) failed: %v", err)
+	}
+	ok, err = ttlMap.PatchExpiry(6, clock.Now().Add(time.Minute))
+	if (nil != err) || !ok {
+		t.Fatalf("ttlMap.PatchExpiry(6,) failed: %v", err)
+	}
+	ok, err = ttlMap.PatchExpiry(6, time.Time{})
+	if (nil != err) || !ok {
+		t.Fatalf("ttlMap.PatchExpiry(6,) failed: %v", err)
+	}
+
+	expiry, ok, err := ttlMap.GetExpiry(6)
+	if (nil != err) || !ok || !expiry.IsZero() {
+		t.Fatalf("ttlMap.GetExpiry(6) returned %v but should have returned zero expiry", expiry)
+	}
+
+	clock.advance(3 * time.Second)
+
+	ttlMapTestExpectKeys(t, ttlMap, []int{3, 6})
+
+	numberExpired, err := ttlMap.ExpireNow()
+	if (nil != err) || (4 != numberExpired) {
+		t.Fatalf("ttlMap.ExpireNow() returned %v but should have returned 4", numberExpired)
+	}
+
+	ttlMapTestExpectKeys(t, ttlMap, []int{3, 6})
+
+	ok, err = ttlMap.PutWithExpiry(7, 70, clock.Now().Add(time.Minute))
+	if (nil != err) || !ok {
+		t.Fatalf("ttlMap.PutWithExpiry(7,,) failed: %v", err)
+	}
+
+	return
+}
+
+func TestTTLMap(t *testing.T) {
+	clock := &ttlMapTestClockStruct{now: time.Unix(1000000, 0)}
+
+	ttlMap := ttlMapTestRun(t, NewLLRBTree(CompareInt, nil), clock)
+
+	// The background expirer removes Key 7 once the clock passes its deadline
+
+	err := ttlMap.StartExpirer(time.Millisecond)
+	if nil != err {
+		t.Fatalf("ttlMap.StartExpirer() failed: %v", err)
+	}
+	err = ttlMap.StartExpirer(time.Millisecond)
+	if nil == err {
+		t.Fatalf("ttlMap.StartExpirer() of started expirer should have failed")
+	}
+
+	clock.advance(time.Hour)
+
+	for waited := time.Duration(0); ; waited += time.Millisecond {
+		ttlMap.(*ttlMapStruct).Lock()
+		numberOfItems, _ := ttlMap.(*ttlMapStruct).sortedMap.Len()
+		ttlMap.(*ttlMapStruct).Unlock()
+		if 1 == numberOfItems {
+			break
+		}
+		if waited > 10*time.Second {
+			t.Fatalf("ttlMap expirer should have expired Keys 3 & 7")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	err = ttlMap.StopExpirer()
+	if nil != err {
+		t.Fatalf("ttlMap.StopExpirer() failed: %v", err)
+	}
+	err = ttlMap.StopExpirer()
+	if nil == err {
+		t.Fatalf("ttlMap.StopExpirer() of stopped expirer should have failed")
+	}
+}
+
+func TestTTLMapReplaceExpired(t *testing.T) {
+	clock := &ttlMapTestClockStruct{now: time.Unix(1000000, 0)}
+
+	ttlMap, err := NewTTLMap(NewLLRBTree(CompareInt, nil), CompareInt, clock.Now)
+	if nil != err {
+		t.Fatalf("NewTTLMap() failed: %v", err)
+	}
+
+	for key := 1; key <= 3; key++ {
+		ok, err := ttlMap.PutWithExpiry(key, key*10, clock.Now().Add(time.Second))
+		if (nil != err) || !ok {
+			t.Fatalf("ttlMap.PutWithExpiry(%v,,) failed: %v", key, err)
+		}
+	}
+
+	ok, err := ttlMap.Put(2, 20)
+	if (nil != err) || ok {
+		t.Fatalf("ttlMap.Put(2,) of unexpired Key should have returned ok == false")
+	}
+
+	clock.advance(time.Second)
+
+	// Put() replaces an expired Key not yet removed
+
+	ok, err = ttlMap.Put(2, 20)
+	if (nil != err) || !ok {
+		t.Fatalf("ttlMap.Put(2,) of expired Key failed: %v", err)
+	}
+
+	ttlMapTestExpectKeys(t, ttlMap, []int{2})
+
+	ok, err = ttlMap.PatchByIndex(0, 20)
+	if (nil != err) || !ok {
+		t.Fatalf("ttlMap.PatchByIndex(0,) failed: %v", err)
+	}
+
+	numberExpired, err := ttlMap.ExpireNow()
+	if (nil != err) || (2 != numberExpired) {
+		t.Fatalf("ttlMap.ExpireNow() returned %v but should have returned 2", numberExpired)
+	}
+
+	ok, err = ttlMap.DeleteByIndex(0)
+	if (nil != err) || !ok {
+		t.Fatalf("ttlMap.DeleteByIndex(0) failed: %v", err)
+	}
+
+	ttlMapTestExpectKeys(t, ttlMap, []int{})
+}
+
+func TestBPlusTreeTTLMap(t *testing.T) {
+	clock := &ttlMapTestClockStruct{now: time.Unix(1000000, 0)}
+
+	nodeStore := &callbacksTestNodeStoreStruct{lastObjectNumberGenerated: 0, objectMap: make(map[uint64][]byte)}
+	callbacks := Callbacks(CodecInt, TupleCodec(CodecTime, CodecInt), nodeStore)
+
+	tree := NewBPlusTree(4, CompareInt, callbacks, nil)
+
+	ttlMapTestRun(t, tree, clock)
+
+	rootObjectNumber, rootObjectOffset, rootObjectLength, err := tree.Flush(false)
+	if nil != err {
+		t.Fatalf("tree.Flush(false) failed: %v", err)
+	}
+
+	// Deadlines are rebuilt from a reopened B+Tree & expirations are written by the next Flush()
+
+	tree, err = OldBPlusTree(rootObjectNumber, rootObjectOffset, rootObjectLength, CompareInt, callbacks, nil)
+	if nil != err {
+		t.Fatalf("OldBPlusTree() failed: %v", err)
+	}
+
+	ttlMap, err := NewTTLMap(tree, CompareInt, clock.Now)
+	if nil != err {
+		t.Fatalf("NewTTLMap() failed: %v", err)
+	}
+
+	ttlMapTestExpectKeys(t, ttlMap, []int{3, 6, 7})
+
+	clock.advance(2 * time.Minute)
+
+	numberExpired, err := ttlMap.ExpireNow()
+	if (nil != err) || (1 != numberExpired) {
+		t.Fatalf("ttlMap.ExpireNow() returned %v but should have returned 1", numberExpired)
+	}
+
+	rootObjectNumber, rootObjectOffset, rootObjectLength, err = tree.Flush(false)
+	if nil != err {
+		t.Fatalf("tree.Flush(false) failed: %v", err)
+	}
+
+	tree, err = OldBPlusTree(rootObjectNumber, rootObjectOffset, rootObjectLength, CompareInt, callbacks, nil)
+	if nil != err {
+		t.Fatalf("OldBPlusTree() failed: %v", err)
+	}
+
+	numberOfItems, err := tree.Len()
+	if (nil != err) || (2 != numberOfItems) {
+		t.Fatalf("tree.Len() after expiration & Flush() returned %v but should have returned 2", numberOfItems)
+	}
+}
+
+func BenchmarkNewTTLMap(b *testing.B) {
+	nodeStore := &callbacksTestNodeStoreStruct{lastObjectNumberGenerated: 0, objectMap: make(map[uint64][]byte)}
+	callbacks := Callbacks(CodecInt, TupleCodec(CodecTime, CodecInt), nodeStore)
+
+	tree := NewBPlusTree(commonBPlusTreeTestNumKeysMaxTypical, CompareInt, callbacks, nil)
+
+	expiry := time.Unix(1000000, 0)
+
+	for key := 0; key < commonBPlusTreeBenchmarkNumKeys; key++ {
+		ok, err := tree.Put(key, Tuple{expiry.Add(time.Duration(key) * time.Second), key})
+		if (nil != err) || !ok {
+			b.Fatalf("tree.Put(%v,) failed: %v", key, err)
+		}
+	}
+
+	rootObjectNumber, rootObjectOffset, rootObjectLength, err := tree.Flush(false)
+	if nil != err {
+		b.Fatalf("tree.Flush(false) failed: %v", err)
+	}
+
+	b.ResetTimer()
+
+	// Each NewTTLMap() loads every node of a freshly reopened B+Tree to rebuild its deadlines
+
+	for i := 0; i < b.N; i++ {
+		tree, err = OldBPlusTree(rootObjectNumber, rootObjectOffset, rootObjectLength, CompareInt, callbacks, nil)
+		if nil != err {
+			b.Fatalf("OldBPlusTree() failed: %v", err)
+		}
+
+		_, err = NewTTLMap(tree, CompareInt, nil)
+		if nil != err {
+			b.Fatalf("NewTTLMap() failed: %v", err)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+// Copyright (c) 2015-2021, NVIDIA CORPORATION.
+// SPDX-License-Identifier: Apache-2.0
+
+package sortedmap
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Tuple Keys
+//
+// A Tuple is a Key composed of a number of fields, each compared by its own Compare func.
+// Tuples compare field by field, with the first unequal field deciding. A Tuple supplying
+// fewer fields than expected (a prefix) sorts before every Tuple that begins with it. As
+// such, BisectRight(prefix) yields the index of the first Key beginning with prefix. To
+// find the last such Key, append TupleMax to prefix and call BisectLeft(). TupleMax, which
+// sorts after any value of a field regardless of that field's direction, may be supplied
+// in place of any field of a Tuple used in a query but must not be stored in the map.
+
+// Tuple is a Key composed of an ordered list of fields
+type Tuple []Key
+
+type tupleMaxStruct struct{}
+
+// TupleMax is a field value sorting after all others (only useful in prefix queries)
+var TupleMax Key = &tupleMaxStruct{}
+
+// CompareTuple returns a Compare func for Tuples whose fields are compared by fieldCompares
+//
+// Wrap a field's Compare func with Descending() to sort that field in descending order.
+func CompareTuple(fieldCompares ...Compare) (compare Compare) {
+	compare = func(key1 Key, key2 Key) (result int, err error) {
+		key1Tuple, ok := key1.(Tuple)
+		if !ok {
+			err = fmt.Errorf("CompareTuple(non-Tuple,) not supported")
+			return
+		}
+		key2Tuple, ok := key2.(Tuple)
+		if !ok {
+			err = fmt.Errorf("CompareTuple(Tuple, non-Tuple) not supported")
+			return
+		}
+
+		if (len(key1Tuple) > len(fieldCompares)) || (len(key2Tuple) > len(fieldCompares)) {
+			err = fmt.Errorf("CompareTuple() passed Tuple with more than %v fields", len(fieldCompares))
+			return
+		}
+
+		for i := 0; (i < len(key1Tuple)) && (i < len(key2Tuple)); i++ {
+			_, field1IsMax := key1Tuple[i].(*tupleMaxStruct)
+			_, field2IsMax := key2Tuple[i].(*tupleMaxStruct)
+
+			if field1IsMax || field2IsMax {
+				if !field2IsMax {
+					result = 1
+					err = nil
+					return
+				}
+				if !field1IsMax {
+					result = -1
+					err = nil
+					return
+				}
+				continue
+			}
+
+			result, err = fieldCompares[i](key1Tuple[i], key2Tuple[i])
+			if nil != err {
+				return
+			}
+			if 0 != result {
+				err = nil
+				return
+			}
+		}
+
+		if len(key1Tuple) < len(key2Tuple) {
+			result = -1
+		} else if len(key1Tuple) == len(key2Tuple) {
+			result = 0
+		} else { // len(key1Tuple) > len(key2Tuple)
+			result = 1
+		}
+
+		err = nil
+
+		return
+	}
+
+	return
+}
+
+// TupleCodec returns a Codec for Tuples whose fields are packed by fieldCodecs
+//
+// A packed Tuple is simply the concatenation of its packed fields. As such, each of the
+// fieldCodecs must consume exactly what it produced (as do each of the built-in Codecs).
+// Only complete Tuples (i.e. not those used solely in prefix queries) may be packed.
+func TupleCodec(fieldCodecs ...Codec) (codec Codec) {
+	codec = &tupleCodecStruct{fieldCodecs: fieldCodecs}
+	return
+}
+
+type tupleCodecStruct struct {
+	fieldCodecs []Codec
+}
+
+func (codec *tupleCodecStruct) DumpKey(key Key) (keyAsString string, err error) {
+	keyAsString, err = codec.dumpTuple(key, func(fieldCodec Codec, field Key) (string, error) { return fieldCodec.DumpKey(field) })
+	return
+}
+
+func (codec *tupleCodecStruct) PackKey(key Key) (packedKey []byte, err error) {
+	packedKey, err = codec.packTuple(key, func(fieldCodec Codec, field Key) ([]byte, error) { return fieldCodec.PackKey(field) })
+	return
+}
+
+func (codec *tupleCodecStruct) UnpackKey(payloadData []byte) (key Key, bytesConsumed uint64, err error) {
+	key, bytesConsumed, err = codec.unpackTuple(payloadData, func(fieldCodec Codec, payloadData []byte) (Key, uint64, error) {
+		return fieldCodec.UnpackKey(payloadData)
+	})
+	return
+}
+
+func (codec *tupleCodecStruct) DumpValue(value Value) (valueAsString string, err error) {
+	valueAsString, err = codec.dumpTuple(value, func(fieldCodec Codec, field Key) (string, error) { return fieldCodec.DumpValue(field) })
+	return
+}
+
+func (codec *tupleCodecStruct) PackValue(value Value) (packedValue []byte, err error) {
+	packedValue, err = codec.packTuple(value, func(fieldCodec Codec, field Key) ([]byte, error) { return fieldCodec.PackValue(field) })
+	return
+}
+
+func (codec *tupleCodecStruct) UnpackValue(payloadData []byte) (value Value, bytesConsumed uint64, err error) {
+	value, bytesConsumed, err = codec.unpackTuple(payloadData, func(fieldCodec Codec, payloadData []byte) (Key, uint64, error) {
+		return fieldCodec.UnpackValue(payloadData)
+	})
+	return
+}
+
+func (codec *tupleCodecStruct) dumpTuple(item interface{}, dumpField func(fieldCodec Codec, field Key) (string, error)) (itemAsString string, err error) {
+	tuple, ok := item.(Tuple)
+	if !ok {
+		err = fmt.Errorf("TupleCodec() passed unsupported type %T", item)
+		return
+	}
+
+	fieldsAsStrings := make([]string, len(tuple))
+
+	for i, field := range tuple {
+		if i >= len(codec.fieldCodecs) {
+			err = fmt.Errorf("TupleCodec() passed Tuple with more than %v fields", len(codec.fieldCodecs))
+			return
+		}
+		if _, fieldIsMax := field.(*tupleMaxStruct); fieldIsMax {
+			fieldsAsStrings[i] = "TupleMax"
+			continue
+		}
+
+		fieldsAsStrings[i], err = dumpField(codec.fieldCodecs[i], field)
+		if nil != err {
+			return
+		}
+	}
+
+	itemAsString = "(" + strings.Join(fieldsAsStrings, ", ") + ")"
+
+	err = nil
+	return
+}
+
+func (codec *tupleCodecStruct) packTuple(item interface{}, packField func(fieldCodec Codec, field Key) ([]byte, error)) (packedItem []byte, err error) {
+	tuple, ok := item.(Tuple)
+	if !ok {
+		err = fmt.Errorf("TupleCodec() passed unsupported type %T", item)
+		return
+	}
+	if len(tuple) != len(codec.fieldCodecs) {
+		err = fmt.Errorf("TupleCodec() passed Tuple with %v fields but expected %v", len(tuple), len(codec.fieldCodecs))
+		return
+	}
+
+	packedItem = []byte{}
+
+	for i, field := range tuple {
+		packedField, packFieldErr := packField(codec.fieldCodecs[i], field)
+		if nil != packFieldErr {
+			err = packFieldErr
+			return
+		}
+
+		packedItem = append(packedItem, packedField...)
+	}
+
+	err = nil
+	return
+}
+
+func (codec *tupleCodecStruct) unpackTuple(payloadData []byte, unpackField func(fieldCodec Codec, payloadData []byte) (Key, uint64, error)) (item interface{}, bytesConsumed uint64, err error) {
+	tuple := make(Tuple, len(codec.fieldCodecs))
+
+	for i, fieldCodec := range codec.fieldCodecs {
+		field, fieldBytesConsumed, unpackFieldErr := unpackField(fieldCodec, payloadData[bytesConsumed:])
+		if nil != unpackFieldErr {
+			err = unpackFieldErr
+			return
+		}
+
+		tuple[i] = field
+		bytesConsumed += fieldBytesConsumed
+	}
+
+	item = tuple
+
+	err = nil
+	return
+}
@@ -0,0 +1,115 @@
+// Copyright (c) 2015-2021, NVIDIA CORPORATION.
+// SPDX-License-Identifier: Apache-2.0
+
+package sortedmap
+
+import (
+	"testing"
+)
+
+func TestCompareTuple(t *testing.T) {
+	compare := CompareTuple(CompareString, Descending(CompareUint64))
+
+	orderedKeys := []Key{
+		Tuple{"a"},
+		Tuple{"a", uint64(2)},
+		Tuple{"a", uint64(1)},
+		Tuple{"a", TupleMax},
+		Tuple{"b"},
+		Tuple{"b", uint64(9)},
+		Tuple{TupleMax},
+	}
+
+	for i, key1 := range orderedKeys {
+		for j, key2 := range orderedKeys {
+			result, err := compare(key1, key2)
+			if nil != err {
+				t.Fatalf("compare(%v, %v) failed: %v", key1, key2, err)
+			}
+			if ((i < j) && (0 <= result)) || ((i == j) && (0 != result)) || ((i > j) && (0 >= result)) {
+				t.Fatalf("compare(%v, %v) returned unexpected result %v", key1, key2, result)
+			}
+		}
+	}
+
+	_, err := compare(Tuple{"a", uint64(1), uint64(2)}, Tuple{"a"})
+	if nil == err {
+		t.Fatalf("compare() of Tuple with too many fields should have failed")
+	}
+	_, err = compare(Tuple{"a"}, "a")
+	if nil == err {
+		t.Fatalf("compare() of non-Tuple should have failed")
+	}
+}
+
+func TestTuplePrefixQueries(t *testing.T) {
+	nodeStore := &callbacksTestNodeStoreStruct{lastObjectNumberGenerated: 0, objectMap: make(map[uint64][]byte)}
+	codec := TupleCodec(CodecUint64, CodecUint64)
+	callbacks := Callbacks(codec, CodecString, nodeStore)
+
+	// (inode, offset) Keys with offsets descending within each inode
+
+	compare := CompareTuple(CompareUint64, Descending(CompareUint64))
+
+	btree := NewBPlusTree(4, compare, callbacks, nil)
+
+	for inode := uint64(1); inode <= 5; inode++ {
+		for offset := uint64(0); offset < 10; offset++ {
+			ok, err := btree.Put(Tuple{inode, offset * 4096}, "extent")
+			if nil != err {
+				t.Fatalf("btree.Put() failed: %v", err)
+			}
+			if !ok {
+				t.Fatalf("btree.Put().ok should have been true")
+			}
+		}
+	}
+
+	rootObjectNumber, rootObjectOffset, rootObjectLength, err := btree.Flush(false)
+	if nil != err {
+		t.Fatalf("btree.Flush(false) failed: %v", err)
+	}
+
+	btree, err = OldBPlusTree(rootObjectNumber, rootObjectOffset, rootObjectLength, compare, callbacks, nil)
+	if nil != err {
+		t.Fatalf("OldBPlusTree() failed: %v", err)
+	}
+
+	firstIndex, found, err := btree.BisectRight(Tuple{uint64(3)})
+	if nil != err {
+		t.Fatalf("btree.BisectRight() failed: %v", err)
+	}
+	if found || (20 != firstIndex) {
+		t.Fatalf("btree.BisectRight(Tuple{3}) returned %v,%v but should have returned 20,false", firstIndex, found)
+	}
+
+	lastIndex, found, err := btree.BisectLeft(Tuple{uint64(3), TupleMax})
+	if nil != err {
+		t.Fatalf("btree.BisectLeft() failed: %v", err)
+	}
+	if found || (29 != lastIndex) {
+		t.Fatalf("btree.BisectLeft(Tuple{3,TupleMax}) returned %v,%v but should have returned 29,false", lastIndex, found)
+	}
+
+	keyAsKey, _, _, err := btree.GetByIndex(firstIndex)
+	if nil != err {
+		t.Fatalf("btree.GetByIndex() failed: %v", err)
+	}
+	result, err := compare(keyAsKey, Tuple{uint64(3), uint64(9 * 4096)})
+	if (nil != err) || (0 != result) {
+		t.Fatalf("btree.GetByIndex(%v) returned unexpected Key %v", firstIndex, keyAsKey)
+	}
+
+	keyAsString, err := codec.DumpKey(keyAsKey)
+	if nil != err {
+		t.Fatalf("codec.DumpKey() failed: %v", err)
+	}
+	if "(3, 36864)" != keyAsString {
+		t.Fatalf("codec.DumpKey() returned unexpected %v", keyAsString)
+	}
+
+	err = btree.Validate()
+	if nil != err {
+		t.Fatalf("btree.Validate() failed: %v", err)
+	}
+}
@@ -0,0 +1,31 @@
+// Copyright (c) 2015-2021, NVIDIA CORPORATION.
+// SPDX-License-Identifier: Apache-2.0
+
+package sortedmap
+
+// Weighted items
+//
+// A Weigher assigns each Key:Value pair a weight (e.g. the length of the extent a Value
+// describes). Laying items end to end in Key order, each item covers the half-open range of
+// offsets [WeightBefore(key), WeightBefore(key)+weight). GetByWeight() returns the item
+// covering a given offset (along with the offset within that item) and WeightBefore()
+// returns the sum of the weights of all items whose Keys precede a given Key. Items of zero
+// weight cover no offsets and are thus never returned by GetByWeight().
+//
+// When supplied with a Weigher, an LLRBTree maintains the total weight of each subtree in its
+// nodes (through rotations just as len is) and a BPlusTree maintains the total weight of each
+// node (recording it alongside Items in each child reference it posts) as well as a prefix
+// sum of weights alongside prefixSumItems. Both queries then visit only O(log n) nodes. Absent
+// a Weigher, both return an error.
+//
+// A Weigher must not fail for any Key:Value pair stored in the tree.
+
+// Weigher returns the weight of a Key:Value pair
+type Weigher func(key Key, value Value) (weight uint64)
+
+// WeightedSortedMap is implemented by each SortedMap able to be supplied a Weigher
+type WeightedSortedMap interface {
+	SortedMap
+	GetByWeight(offset uint64) (key Key, value Value, offsetWithinItem uint64, ok bool, err error) // Returns the key:value pair covering offset
+	WeightBefore(key Key) (weight uint64, err error)                                               // Returns the total weight of key:value pairs whose keys precede key
+}
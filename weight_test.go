@@ -0,0 +1,258 @@
+// Copyright (c) 2015-2021, NVIDIA CORPORATION.
+// SPDX-License-Identifier: Apache-2.0
+
+package sortedmap
+
+import (
+	"errors"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+// weightTestWeigher weighs each item by its int Value (which may be zero)
+func weightTestWeigher(key Key, value Value) (weight uint64) {
+	return uint64(value.(int))
+}
+
+// weightTestVerify compares GetByWeight() & WeightBefore() against model
+func weightTestVerify(t *testing.T, tree WeightedSortedMap, model map[int]int) {
+	keys := make([]int, 0, len(model))
+	for key := range model {
+		keys = append(keys, key)
+	}
+	sort.Ints(keys)
+
+	weightsBefore := make([]uint64, len(keys)+1) // weightsBefore[i] == total weight of keys[:i]
+	for i, key := range keys {
+		weightsBefore[i+1] = weightsBefore[i] + uint64(model[key])
+	}
+
+	totalWeight := weightsBefore[len(keys)]
+
+	for offset := uint64(0); offset <= totalWeight; offset += 1 + uint64(rand.Intn(20)) {
+		key, value, offsetWithinItem, ok, err := tree.GetByWeight(offset)
+		if nil != err {
+			t.Fatalf("tree.GetByWeight(%v) failed: %v", offset, err)
+		}
+
+		i := sort.Search(len(keys), func(i int) bool { return weightsBefore[i+1] > offset })
+
+		if len(keys) == i {
+			if ok {
+				t.Fatalf("tree.GetByWeight(%v) beyond total weight (%v) should have returned ok == false", offset, totalWeight)
+			}
+			continue
+		}
+
+		if !ok || (keys[i] != key.(int)) || (model[keys[i]] != value.(int)) || ((offset - weightsBefore[i]) != offsetWithinItem) {
+			t.Fatalf("tree.GetByWeight(%v) returned %v:%v @ %v but should have returned %v:%v @ %v", offset, key, value, offsetWithinItem, keys[i], model[keys[i]], offset-weightsBefore[i])
+		}
+	}
+
+	_, _, _, ok, err := tree.GetByWeight(totalWeight)
+	if (nil != err) || ok {
+		t.Fatalf("tree.GetByWeight(%v) should have returned ok == false", totalWeight)
+	}
+
+	for query := 0; query < 100; query++ {
+		key := rand.Intn(1100) - 50
+
+		weight, err := tree.WeightBefore(key)
+		if nil != err {
+			t.Fatalf("tree.WeightBefore(%v) failed: %v", key, err)
+		}
+		if weightsBefore[sort.SearchInts(keys, key)] != weight {
+			t.Fatalf("tree.WeightBefore(%v) returned %v but should have returned %v", key, weight, weightsBefore[sort.SearchInts(keys, key)])
+		}
+	}
+}
+
+func TestLLRBTreeWeight(t *testing.T) {
+	_, _, _, _, err := NewLLRBTree(CompareInt, CodecInt).GetByWeight(0)
+	if nil == err {
+		t.Fatalf("GetByWeight() without a Weigher should have failed")
+	}
+
+	rand.Seed(3)
+
+	tree := NewLLRBTreeWithOptions(CompareInt, CodecInt, &LLRBTreeOptions{Weigher: weightTestWeigher})
+	model := make(map[int]int)
+
+	weightTestVerify(t, tree, model)
+
+	for round := 0; round < 4; round++ {
+		aggregateTestMutate(t, tree, model, 500)
+		weightTestVerify(t, tree, model)
+	}
+
+	for index := 0; index < 50; index++ {
+		_, err = tree.PatchByIndex(index, index)
+		if nil != err {
+			t.Fatalf("tree.PatchByIndex(%v,) failed: %v", index, err)
+		}
+		key, _, _, _ := tree.GetByIndex(index)
+		model[key.(int)] = index
+	}
+
+	weightTestVerify(t, tree, model)
+}
+
+func TestLLRBTreeWeightChanged(t *testing.T) {
+	shrink := false
+	weigher := func(key Key, value Value) (weight uint64) {
+		if shrink {
+			return 0
+		}
+		return 1
+	}
+
+	tree := NewLLRBTreeWithOptions(CompareInt, CodecInt, &LLRBTreeOptions{Weigher: weigher})
+
+	for key := 0; key < 10; key++ {
+		ok, err := tree.Put(key, key)
+		if (nil != err) || !ok {
+			t.Fatalf("tree.Put(%d,) failed: %v", key, err)
+		}
+	}
+
+	// Once the Weigher's answers change, GetByWeight() reports rather than panics
+
+	shrink = true
+
+	_, _, _, _, err := tree.GetByWeight(5)
+	if nil == err {
+		t.Fatalf("GetByWeight() should have failed once the Weigher's answers changed")
+	}
+}
+
+func TestBPlusTreeWeight(t *testing.T) {
+	nodeStore := &callbacksTestNodeStoreStruct{lastObjectNumberGenerated: 0, objectMap: make(map[uint64][]byte)}
+	callbacks := Callbacks(CodecInt, CodecInt, nodeStore)
+
+	_, err := NewBPlusTree(4, CompareInt, callbacks, nil).WeightBefore(0)
+	if nil == err {
+		t.Fatalf("WeightBefore() without a Weigher should have failed")
+	}
+
+	rand.Seed(4)
+
+	for _, options := range []*BPlusTreeOptions{
+		{Weigher: weightTestWeigher},
+		{Weigher: weightTestWeigher, VarintEncoding: true, Aggregator: &aggregateTestSumStruct{}, AggregateCodec: CodecInt},
+		{Weigher: weightTestWeigher, OrderedKeyEncoding: OrderedInt, MaxNodeBytes: 64},
+	} {
+		tree, err := NewBPlusTreeWithOptions(4, CompareInt, callbacks, nil, options)
+		if nil != err {
+			t.Fatalf("NewBPlusTreeWithOptions() failed: %v", err)
+		}
+		model := make(map[int]int)
+
+		aggregateTestMutate(t, tree, model, 500)
+		weightTestVerify(t, tree, model)
+
+		_, _, _, err = tree.Flush(true)
+		if nil != err {
+			t.Fatalf("tree.Flush(true) failed: %v", err)
+		}
+
+		weightTestVerify(t, tree, model)
+		aggregateTestMutate(t, tree, model, 500)
+		weightTestVerify(t, tree, model)
+
+		rootObjectNumber, rootObjectOffset, rootObjectLength, err := tree.Flush(false)
+		if nil != err {
+			t.Fatalf("tree.Flush(false) failed: %v", err)
+		}
+
+		bPlusTreeCache := NewBPlusTreeCache(1000, 2000)
+
+		tree, err = OldBPlusTreeWithOptions(rootObjectNumber, rootObjectOffset, rootObjectLength, CompareInt, callbacks, bPlusTreeCache, options)
+		if nil != err {
+			t.Fatalf("OldBPlusTreeWithOptions() failed: %v", err)
+		}
+
+		// Only the nodes on the path to the item covering offset are loaded
+
+		dimensionsReport, err := tree.FetchDimensionsReport()
+		if nil != err {
+			t.Fatalf("tree.FetchDimensionsReport() failed: %v", err)
+		}
+
+		cacheMisses := bPlusTreeCache.Stats().CacheMisses
+
+		_, _, _, _, err = tree.GetByWeight(0)
+		if nil != err {
+			t.Fatalf("tree.GetByWeight(0) failed: %v", err)
+		}
+		if (cacheMisses + dimensionsReport.Height - 1) < bPlusTreeCache.Stats().CacheMisses {
+			t.Fatalf("tree.GetByWeight(0) should have loaded at most %v nodes", dimensionsReport.Height-1)
+		}
+
+		weightTestVerify(t, tree, model)
+		aggregateTestMutate(t, tree, model, 500)
+		weightTestVerify(t, tree, model)
+
+		err = tree.Validate()
+		if nil != err {
+			t.Fatalf("tree.Validate() failed: %v", err)
+		}
+	}
+}
+
+func TestBPlusTreeWeightOptionMismatch(t *testing.T) {
+	nodeStore := &callbacksTestNodeStoreStruct{lastObjectNumberGenerated: 0, objectMap: make(map[uint64][]byte)}
+	callbacks := Callbacks(CodecInt, CodecInt, nodeStore)
+	weightedOptions := &BPlusTreeOptions{Weigher: weightTestWeigher}
+
+	for _, postOptions := range []*BPlusTreeOptions{nil, weightedOptions} {
+		tree, err := NewBPlusTreeWithOptions(4, CompareInt, callbacks, nil, postOptions)
+		if nil != err {
+			t.Fatalf("NewBPlusTreeWithOptions() failed: %v", err)
+		}
+
+		for i := 0; i < 100; i++ {
+			_, err := tree.Put(i, i)
+			if nil != err {
+				t.Fatalf("tree.Put() failed: %v", err)
+			}
+		}
+
+		rootObjectNumber, rootObjectOffset, rootObjectLength, err := tree.Flush(false)
+		if nil != err {
+			t.Fatalf("tree.Flush(false) failed: %v", err)
+		}
+
+		for _, loadOptions := range []*BPlusTreeOptions{nil, weightedOptions} {
+			_, err = OldBPlusTreeWithOptions(rootObjectNumber, rootObjectOffset, rootObjectLength, CompareInt, callbacks, nil, loadOptions)
+			if (nil == postOptions) == (nil == loadOptions) {
+				if nil != err {
+					t.Fatalf("OldBPlusTreeWithOptions() with matching Weigher failed: %v", err)
+				}
+			} else if !errors.Is(err, ErrOptionMismatch) {
+				t.Fatalf("OldBPlusTreeWithOptions() with mismatched Weigher should have failed with ErrOptionMismatch (got %v)", err)
+			}
+		}
+	}
+}
+
+func TestBPlusTreeMultimapWeight(t *testing.T) {
+	nodeStore := &callbacksTestNodeStoreStruct{lastObjectNumberGenerated: 0, objectMap: make(map[uint64][]byte)}
+
+	multimap, err := NewBPlusTreeMultimap(4, CompareInt, Callbacks(CodecInt, CodecInt, nodeStore), nil, &BPlusTreeOptions{Weigher: weightTestWeigher})
+	if nil != err {
+		t.Fatalf("NewBPlusTreeMultimap() failed: %v", err)
+	}
+
+	multimapTestPopulate(t, multimap) // weights (in Key order) are 0, 100, 201, 202, 203, & 300
+
+	key, value, offsetWithinItem, ok, err := multimap.GetByWeight(400)
+	if (nil != err) || !ok || (20 != key.(int)) || (202 != value.(int)) || (99 != offsetWithinItem) {
+		t.Fatalf("multimap.GetByWeight(400) returned %v:%v @ %v but should have returned 20:202 @ 99", key, value, offsetWithinItem)
+	}
+
+	weight, err := multimap.WeightBefore(30)
+	if (nil != err) || (706 != weight) {
+		t.Fatalf("multimap.WeightBefore(30) returned %v but should have returned 706", weight)
+	}
+}